@@ -0,0 +1,111 @@
+package build
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/manapointer/xi/cmd/xi/internal/load"
+	"github.com/manapointer/xi/pkg/codegen/amd64"
+	"github.com/manapointer/xi/pkg/opt"
+	"github.com/spf13/cobra"
+)
+
+type buildOptions struct {
+	output   string
+	libpath  string
+	optimize bool
+	passes   string
+	dumpOpt  bool
+}
+
+func NewBuildCmd() *cobra.Command {
+	opts := &buildOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "build [build flags] file.xi",
+		Short: "Build compiles a Xi program to x86-64 assembly.",
+		Long: `Build compiles a Xi program to x86-64 assembly. The output is linked with
+the run-time system in the runtime directory of the Xi repository:
+
+	xi build -o prog.s prog.xi
+	gcc -o prog prog.s runtime/xi.c
+
+With -O, the program is optimized by all passes of the optimizer; --opt
+selects passes by name instead:
+
+	cf           constant folding
+	cp           copy and constant propagation
+	lcse         common subexpression elimination within blocks
+	cse          common subexpression elimination across blocks
+	dce          dead code elimination
+	unreachable  unreachable block removal`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.output, "output", "o", "", "Output file; defaults to the name of the source file with the extension .s")
+	flags.StringVar(&opts.libpath, "libpath", "", "Directories to search for interface files, separated by the OS list separator")
+	flags.BoolVarP(&opts.optimize, "optimize", "O", false, "Enable all optimizations")
+	flags.StringVar(&opts.passes, "opt", "", "Comma-separated list of optimization passes to enable")
+	flags.BoolVar(&opts.dumpOpt, "dump-opt", false, "Write each function after every optimization pass to the output file with the extension .opt")
+
+	return cmd
+}
+
+func (opts *buildOptions) run(file string) error {
+	config, err := opts.optConfig()
+	if err != nil {
+		return err
+	}
+
+	prog, err := load.File(file, filepath.SplitList(opts.libpath), os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	output := opts.output
+	if output == "" {
+		output = strings.TrimSuffix(file, filepath.Ext(file)) + ".s"
+	}
+
+	if config != nil && opts.dumpOpt {
+		dump, err := os.Create(strings.TrimSuffix(output, filepath.Ext(output)) + ".opt")
+		if err != nil {
+			return err
+		}
+		defer dump.Close()
+		w := bufio.NewWriter(dump)
+		defer w.Flush()
+		config.Dump = w
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = amd64.Compile(f, name, prog.File, prog.Info, &amd64.Options{Optimize: config})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// optConfig returns the configuration of the optimizer, or nil if no
+// passes are enabled.
+func (opts *buildOptions) optConfig() (*opt.Config, error) {
+	names := opts.passes
+	if names == "" && opts.optimize {
+		names = "all"
+	}
+	passes, err := opt.Lookup(names)
+	if err != nil || len(passes) == 0 {
+		return nil, err
+	}
+	return &opt.Config{Passes: passes}, nil
+}
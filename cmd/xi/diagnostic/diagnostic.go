@@ -4,15 +4,24 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	goAst "go/ast"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/manapointer/xi/cmd/xi/internal/load"
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/ast/sexp"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/ir/sim"
+	"github.com/manapointer/xi/pkg/lit"
+	"github.com/manapointer/xi/pkg/opt"
 	"github.com/manapointer/xi/pkg/parser"
 	"github.com/manapointer/xi/pkg/scanner"
+	"github.com/manapointer/xi/pkg/ssa"
+	"github.com/manapointer/xi/pkg/stdlib"
 	"github.com/manapointer/xi/pkg/token"
 	"github.com/spf13/cobra"
 )
@@ -20,7 +29,11 @@ import (
 type diagnosticOptions struct {
 	lex   bool
 	parse bool
+	irgen bool
+	irrun bool
+	ssa   bool
 	trace bool
+	width int
 }
 
 func NewDiagnosticCmd() *cobra.Command {
@@ -37,7 +50,11 @@ func NewDiagnosticCmd() *cobra.Command {
 	flags := cmd.Flags()
 	flags.BoolVar(&opts.lex, "lex", false, "Output lexing information")
 	flags.BoolVar(&opts.parse, "parse", false, "Output parsing information")
+	flags.BoolVar(&opts.irgen, "irgen", false, "Output the intermediate representation")
+	flags.BoolVar(&opts.irrun, "irrun", false, "Run the lowered intermediate representation in the IR simulator")
+	flags.BoolVar(&opts.ssa, "ssa", false, "Output the functions in static single assignment form")
 	flags.BoolVar(&opts.trace, "trace", false, "Trace parsing")
+	flags.IntVar(&opts.width, "width", 80, "Line width for parsing output; 0 puts each file on one line")
 
 	return cmd
 }
@@ -48,6 +65,12 @@ func (opts *diagnosticOptions) run(files []string) error {
 		return opts.runLex(files)
 	case opts.parse:
 		return opts.runParse(files)
+	case opts.irgen:
+		return opts.runIRGen(files)
+	case opts.irrun:
+		return opts.runIRRun(files)
+	case opts.ssa:
+		return opts.runSSA(files)
 	}
 
 	return nil
@@ -69,9 +92,10 @@ func (opts *diagnosticOptions) runLex(files []string) error {
 			return err
 		}
 
-		s := scanner.NewScanner(src, nil)
+		fset := token.NewFileSet()
+		s := scanner.NewScanner(fset.AddFile(file, -1, len(src)), src, nil, 0)
 		for tok := s.Scan(); tok.Typ != token.Eof; tok = s.Scan() {
-			fprintTokenDiagnostic(w, tok)
+			fprintTokenDiagnostic(w, fset.Position(tok.Pos), tok)
 			if tok.Typ == token.Error {
 				return errors.New(tok.Lit)
 			}
@@ -99,16 +123,99 @@ func (opts *diagnosticOptions) runParse(files []string) error {
 		}
 		defer f.Close()
 
-		astf, err := parser.ParseFile(file, nil, opts.mode())
+		// Interface files have a grammar of their own.
+		var astf ast.Node
+		if path.Ext(file) == importer.Ext {
+			astf, err = parser.ParseInterface(token.NewFileSet(), file, nil, opts.mode())
+		} else {
+			astf, err = parser.ParseFile(token.NewFileSet(), file, nil, opts.mode())
+		}
+		if err != nil {
+			scanner.PrintError(f, err)
+			return err
+		}
+
+		if err := sexp.Fprint(f, astf, opts.width); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (opts *diagnosticOptions) runIRGen(files []string) error {
+	for _, file := range files {
+		prog, err := load.File(file, nil, os.Stderr)
 		if err != nil {
-			fmt.Fprint(f, err)
 			return err
 		}
 
-		err = goAst.Fprint(f, nil, astf, nil)
+		f, err := openDiagnosticFile(file, ".ir")
 		if err != nil {
 			return err
 		}
+		defer f.Close()
+
+		name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		if err := ir.Fprint(f, ir.Translate(name, prog.File, prog.Info)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runIRRun runs the main function of each file with no arguments, using
+// the process's standard input and output.
+func (opts *diagnosticOptions) runIRRun(files []string) error {
+	for _, file := range files {
+		prog, err := load.File(file, nil, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		s := sim.New(ir.Lower(ir.Translate(name, prog.File, prog.Info)))
+		rt := stdlib.NewRuntime(os.Stdin, os.Stdout)
+		for name, fn := range rt.Funcs() {
+			s.Bind(name, fn)
+		}
+
+		err = s.Main(nil)
+		if ferr := rt.Flush(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (opts *diagnosticOptions) runSSA(files []string) error {
+	for _, file := range files {
+		prog, err := load.File(file, nil, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		f, err := openDiagnosticFile(file, ".ssa")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		for _, fn := range ir.Lower(ir.Translate(name, prog.File, prog.Info)).Funcs {
+			g := opt.Build(fn)
+			ssa.Construct(g)
+			fmt.Fprintln(w, g)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -127,19 +234,27 @@ func openDiagnosticFile(filename, suffix string) (*os.File, error) {
 	return f, nil
 }
 
-func fprintTokenDiagnostic(w io.Writer, tok token.Token) {
+func fprintTokenDiagnostic(w io.Writer, pos token.Position, tok token.Token) {
 	repr := tok.Lit
 
 	switch tok.Typ {
 	case token.Integer:
 		repr = "integer " + tok.Lit
 	case token.String:
-		repr = "string " + tok.Lit[1:len(tok.Lit)-1]
+		if s, err := lit.Unquote(tok.Lit); err == nil {
+			repr = "string " + unquoted(lit.Quote(s))
+		}
 	case token.Char:
-		repr = "character " + tok.Lit[1:len(tok.Lit)-1]
+		if c, err := lit.UnquoteChar(tok.Lit); err == nil {
+			repr = "character " + unquoted(lit.QuoteChar(rune(c)))
+		}
 	case token.Ident:
 		repr = "id " + tok.Lit
 	}
 
-	fmt.Fprintf(w, "%d:%d %s\n", tok.Pos.Line, tok.Pos.Column, repr)
+	fmt.Fprintf(w, "%d:%d %s\n", pos.Line, pos.Column, repr)
 }
+
+// unquoted strips the quotes from a literal in canonical form, so that
+// \x{41} and A are listed the same.
+func unquoted(s string) string { return s[1 : len(s)-1] }
@@ -0,0 +1,142 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines around each change in a diff.
+const context = 3
+
+// An edit is a line of a diff: ' ' for a line of both versions, '-' for a
+// line only of the old one and '+' for a line only of the new one.
+type edit struct {
+	op   byte
+	line string
+}
+
+// diff returns the differences between the old and new contents of file
+// in unified format.
+func diff(file string, old, new []byte) string {
+	a, b := lines(old), lines(new)
+	edits := lcsEdits(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", file, file)
+
+	// Group the edits into hunks of changes less than 2*context unchanged
+	// lines apart.
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		// Line numbers of the hunk in both versions.
+		oldLine, newLine := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				oldLine++
+			}
+			if e.op != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, e := range edits[start:stop] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, e := range edits[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func lines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// lcsEdits returns the edits turning a into b that keep a longest common
+// subsequence of their lines.
+func lcsEdits(a, b []string) []edit {
+	// n[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	n := make([][]int, len(a)+1)
+	for i := range n {
+		n[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				n[i][j] = n[i+1][j+1] + 1
+			case n[i+1][j] >= n[i][j+1]:
+				n[i][j] = n[i+1][j]
+			default:
+				n[i][j] = n[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case n[i+1][j] >= n[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
@@ -0,0 +1,93 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/manapointer/xi/pkg/printer"
+	"github.com/spf13/cobra"
+)
+
+type formatOptions struct {
+	write bool
+	diff  bool
+}
+
+func NewFormatCmd() *cobra.Command {
+	opts := &formatOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "fmt [fmt flags] [files]",
+		Short: "Fmt formats Xi source and interface files in the canonical layout.",
+		Long: `Fmt formats Xi source and interface files in the canonical layout.
+
+By default, the formatted files are written to standard output. With no
+files, fmt formats standard input.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(args)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&opts.write, "write", "w", false, "Write the result to the files instead of standard output")
+	flags.BoolVarP(&opts.diff, "diff", "d", false, "Print diffs between the files and their formatted versions instead")
+
+	return cmd
+}
+
+func (opts *formatOptions) run(files []string) error {
+	if len(files) == 0 {
+		if opts.write {
+			return fmt.Errorf("cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return opts.format("<standard input>", src, os.Stdout)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := opts.format(file, src, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (opts *formatOptions) format(file string, src []byte, w io.Writer) error {
+	res, err := printer.Source(file, src)
+	if err != nil {
+		return err
+	}
+
+	if opts.diff {
+		if !bytes.Equal(src, res) {
+			fmt.Fprint(w, diff(file, src, res))
+		}
+	}
+
+	if opts.write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, res, info.Mode().Perm())
+	}
+
+	if !opts.diff {
+		_, err = w.Write(res)
+	}
+	return err
+}
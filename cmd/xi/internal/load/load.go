@@ -0,0 +1,55 @@
+// Package load runs the front end shared by the xi commands that consume
+// a whole program: parsing, resolving use declarations and type checking.
+package load
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/scanner"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// A Program is a parsed and type-checked source file.
+type Program struct {
+	Fset *token.FileSet
+	File *ast.File
+	Info *types.Info
+}
+
+// File parses and checks filename. Interface files are looked up next to
+// it, then in libPath. Errors are printed to stderr; the returned error
+// only summarizes them.
+func File(filename string, libPath []string, stderr io.Writer) (*Program, error) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, filename, nil, 0)
+	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			scanner.PrintError(stderr, err)
+			return nil, fmt.Errorf("%s: %d syntax error(s)", filename, len(list))
+		}
+		return nil, err
+	}
+
+	path := append([]string{filepath.Dir(filename)}, libPath...)
+	conf := &types.Config{Importer: importer.New(fset, path)}
+
+	info, err := types.Check(fset, []*ast.File{f}, conf)
+	if err != nil {
+		list := err.(types.ErrorList)
+		for _, e := range list {
+			fmt.Fprintln(stderr, e)
+		}
+		return nil, fmt.Errorf("%s: %d type error(s)", filename, len(list))
+	}
+
+	return &Program{Fset: fset, File: f, Info: info}, nil
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 
+	"github.com/manapointer/xi/cmd/xi/build"
 	"github.com/manapointer/xi/cmd/xi/diagnostic"
+	"github.com/manapointer/xi/cmd/xi/format"
+	"github.com/manapointer/xi/cmd/xi/run"
 	"github.com/manapointer/xi/cmd/xi/xls"
 	"github.com/spf13/cobra"
 )
@@ -24,7 +27,10 @@ func newRootCommand() *cobra.Command {
 	}
 
 	cmd.AddCommand(
+		build.NewBuildCmd(),
 		diagnostic.NewDiagnosticCmd(),
+		format.NewFormatCmd(),
+		run.NewRunCmd(),
 		xls.NewXlsCommand(),
 	)
 
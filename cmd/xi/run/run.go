@@ -0,0 +1,52 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/manapointer/xi/cmd/xi/internal/load"
+	"github.com/manapointer/xi/pkg/interp"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/spf13/cobra"
+)
+
+type runOptions struct {
+	libpath string
+}
+
+func NewRunCmd() *cobra.Command {
+	opts := &runOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "run [run flags] file.xi [arguments]",
+		Short: "Run interprets a Xi program, passing the arguments to its main function.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(args[0], args[1:])
+		},
+	}
+
+	flags := cmd.Flags()
+	// Flags after the file name belong to the program.
+	flags.SetInterspersed(false)
+	flags.StringVar(&opts.libpath, "libpath", "", "Directories to search for interface files, separated by the OS list separator")
+
+	return cmd
+}
+
+func (opts *runOptions) run(file string, args []string) error {
+	prog, err := load.File(file, filepath.SplitList(opts.libpath), os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	in := interp.New(prog.Fset, prog.File)
+	rt := stdlib.NewRuntime(os.Stdin, os.Stdout)
+	rt.Bind(in)
+
+	err = in.Main(args)
+	if ferr := rt.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -1,12 +1,21 @@
 package xls
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
 
+	"github.com/manapointer/xi/pkg/jsonrpc2"
+	"github.com/manapointer/xi/pkg/lsp"
 	"github.com/spf13/cobra"
 )
 
-type xlsOptions struct{}
+type xlsOptions struct {
+	logfile string
+	libpath string
+}
 
 func NewXlsCommand() *cobra.Command {
 	opts := &xlsOptions{}
@@ -19,10 +28,27 @@ func NewXlsCommand() *cobra.Command {
 		},
 	}
 
+	flags := cmd.Flags()
+	flags.StringVar(&opts.logfile, "logfile", "", "Write server logs to this file instead of stderr")
+	flags.StringVar(&opts.libpath, "libpath", "", "Directories to search for interface files, separated by the OS list separator")
+
 	return cmd
 }
 
 func (opts *xlsOptions) run() error {
-	fmt.Println("Welcome to xls, the Xi language server!")
-	return nil
+	// stdout carries the protocol, so logs must never go there.
+	var out io.Writer = os.Stderr
+	if opts.logfile != "" {
+		f, err := os.OpenFile(opts.logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	logger := log.New(out, "xls: ", log.LstdFlags)
+	server := lsp.NewServer(jsonrpc2.NewHeaderStream(os.Stdin, os.Stdout), logger)
+	server.LibPath = filepath.SplitList(opts.libpath)
+	return server.Serve(context.Background())
 }
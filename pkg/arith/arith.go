@@ -0,0 +1,21 @@
+// Package arith implements the Xi integer operations that Go lacks, so that
+// the interpreter, the IR and the optimizer compute them the same way.
+package arith
+
+import "math/bits"
+
+// HighMul returns the upper 64 bits of the signed 128-bit product of x and
+// y, the value of x *>> y.
+func HighMul(x, y int64) int64 {
+	hi, _ := bits.Mul64(uint64(x), uint64(y))
+	// The unsigned product exceeds the signed one by y<<64 if x is
+	// negative, and by x<<64 if y is.
+	h := int64(hi)
+	if x < 0 {
+		h -= y
+	}
+	if y < 0 {
+		h -= x
+	}
+	return h
+}
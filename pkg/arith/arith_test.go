@@ -0,0 +1,20 @@
+package arith
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestHighMul(t *testing.T) {
+	values := []int64{0, 1, -1, 2, -2, 3, 1 << 32, -1 << 32, math.MaxInt64, math.MinInt64, 0x123456789abcdef, -0x123456789abcdef}
+	for _, x := range values {
+		for _, y := range values {
+			p := new(big.Int).Mul(big.NewInt(x), big.NewInt(y))
+			want := p.Rsh(p, 64).Int64()
+			if got := HighMul(x, y); got != want {
+				t.Errorf("HighMul(%d, %d) = %d, expected %d", x, y, got, want)
+			}
+		}
+	}
+}
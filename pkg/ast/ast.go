@@ -1,8 +1,56 @@
 package ast
 
-import "github.com/manapointer/xi/pkg/token"
+import (
+	"strings"
 
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// All node types implement the Node interface.
 type Node interface {
+	Pos() token.Pos // position of first character belonging to the node
+	End() token.Pos // position of first character immediately after the node
+}
+
+// A Comment is a single // comment.
+type Comment struct {
+	Slash token.Pos // position of the first "/"
+	Text  string    // from the // to the end of the line, excluding the line break
+}
+
+func (c *Comment) Pos() token.Pos { return c.Slash }
+func (c *Comment) End() token.Pos { return c.Slash + token.Pos(len(c.Text)) }
+
+// A CommentGroup is a sequence of comments on consecutive lines, with no
+// tokens in between. A comment at the end of a line with other tokens is
+// a group of its own.
+type CommentGroup struct {
+	List []*Comment // len(List) > 0
+}
+
+func (g *CommentGroup) Pos() token.Pos { return g.List[0].Pos() }
+func (g *CommentGroup) End() token.Pos { return g.List[len(g.List)-1].End() }
+
+// Text returns the text of the comments in g without the comment markers
+// and at most one space after them, one line per comment. Trailing blank
+// lines are removed. Text is nil-safe and returns "" for a nil group.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	lines := make([]string, len(g.List))
+	for i, c := range g.List {
+		text := strings.TrimPrefix(c.Text, "//")
+		text = strings.TrimPrefix(text, " ")
+		lines[i] = strings.TrimRight(text, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
 }
 
 type Decl interface {
@@ -21,64 +69,116 @@ type Stmt interface {
 }
 
 type Type interface {
+	Node
 	typeNode()
 }
 
 type (
 	PrimitiveType struct {
-		Kind token.TokenType
+		KindPos token.Pos // position of "int" or "bool"
+		Kind    token.TokenType
 	}
 
 	ArrayType struct {
-		Elt  Type
-		Size Expr
+		Elt    Type
+		Lbrack token.Pos
+		Size   Expr // nil for unsized dimensions
+		Rbrack token.Pos
 	}
 )
 
+func (t *PrimitiveType) Pos() token.Pos { return t.KindPos }
+func (t *ArrayType) Pos() token.Pos     { return t.Elt.Pos() }
+
+func (t *PrimitiveType) End() token.Pos { return t.KindPos + token.Pos(len(t.Kind.String())) }
+func (t *ArrayType) End() token.Pos     { return t.Rbrack + 1 }
+
 func (*PrimitiveType) typeNode() {}
 func (*ArrayType) typeNode()     {}
 
 type (
+	// A BadExpr node is a placeholder for an expression containing syntax
+	// errors for which a correct expression node cannot be created. It also
+	// stands in for malformed types and assignables.
+	BadExpr struct {
+		From, To token.Pos // position range of bad expression
+	}
+
 	Ident struct {
-		Name string
+		NamePos token.Pos
+		Name    string
 	}
 
 	BasicLit struct {
-		Kind  token.TokenType
-		Value string
+		ValuePos token.Pos
+		Kind     token.TokenType
+		Value    string
 	}
 
 	ArrayLit struct {
-		Elts []Expr
+		Lbrace token.Pos
+		Elts   []Expr
+		Rbrace token.Pos
 	}
 
 	CallExpr struct {
-		Func *Ident
-		Args []Expr
+		Func   *Ident
+		Lparen token.Pos
+		Args   []Expr
+		Rparen token.Pos
 	}
 
 	LengthExpr struct {
-		Tok token.TokenType
-		Arg Expr
+		TokPos token.Pos // position of "length"
+		Tok    token.TokenType
+		Lparen token.Pos
+		Arg    Expr
+		Rparen token.Pos
 	}
 
 	SubscriptExpr struct {
 		Lhs       Expr
+		Lbrack    token.Pos
 		Subscript Expr
+		Rbrack    token.Pos
 	}
 
 	UnaryExpr struct {
-		Op  token.TokenType
-		Rhs Expr
+		OpPos token.Pos
+		Op    token.TokenType
+		Rhs   Expr
 	}
 
 	BinaryExpr struct {
-		Op  token.TokenType
-		Lhs Expr
-		Rhs Expr
+		Lhs   Expr
+		OpPos token.Pos
+		Op    token.TokenType
+		Rhs   Expr
 	}
 )
 
+func (x *BadExpr) Pos() token.Pos       { return x.From }
+func (x *Ident) Pos() token.Pos         { return x.NamePos }
+func (x *BasicLit) Pos() token.Pos      { return x.ValuePos }
+func (x *ArrayLit) Pos() token.Pos      { return x.Lbrace }
+func (x *CallExpr) Pos() token.Pos      { return x.Func.Pos() }
+func (x *LengthExpr) Pos() token.Pos    { return x.TokPos }
+func (x *SubscriptExpr) Pos() token.Pos { return x.Lhs.Pos() }
+func (x *UnaryExpr) Pos() token.Pos     { return x.OpPos }
+func (x *BinaryExpr) Pos() token.Pos    { return x.Lhs.Pos() }
+
+func (x *BadExpr) End() token.Pos       { return x.To }
+func (x *Ident) End() token.Pos         { return x.NamePos + token.Pos(len(x.Name)) }
+func (x *BasicLit) End() token.Pos      { return x.ValuePos + token.Pos(len(x.Value)) }
+func (x *ArrayLit) End() token.Pos      { return x.Rbrace + 1 }
+func (x *CallExpr) End() token.Pos      { return x.Rparen + 1 }
+func (x *LengthExpr) End() token.Pos    { return x.Rparen + 1 }
+func (x *SubscriptExpr) End() token.Pos { return x.Rbrack + 1 }
+func (x *UnaryExpr) End() token.Pos     { return x.Rhs.End() }
+func (x *BinaryExpr) End() token.Pos    { return x.Rhs.End() }
+
+func (*BadExpr) exprNode()       {}
+func (*BadExpr) typeNode()       {}
 func (*Ident) exprNode()         {}
 func (*BasicLit) exprNode()      {}
 func (*ArrayLit) exprNode()      {}
@@ -102,39 +202,61 @@ type Assignable interface {
 	assignableNode()
 }
 
-type Discard struct{}
+type Discard struct {
+	Underscore token.Pos
+}
 
 type Spec struct {
 	Name *Ident
 	Type Type
 }
 
+func (d *Discard) Pos() token.Pos { return d.Underscore }
+func (s *Spec) Pos() token.Pos    { return s.Name.Pos() }
+
+func (d *Discard) End() token.Pos { return d.Underscore + 1 }
+func (s *Spec) End() token.Pos    { return s.Type.End() }
+
+func (*BadExpr) assignableNode() {}
 func (*Discard) assignableNode() {}
 func (*Spec) assignableNode()    {}
 
 type (
+	// A BadStmt node is a placeholder for statements containing syntax
+	// errors for which no correct statement nodes can be created.
+	BadStmt struct {
+		From, To token.Pos // position range of bad statement
+	}
+
 	AssignStmt struct {
-		Lhs Lvalue
-		Rhs Expr
+		Lhs    Lvalue
+		Assign token.Pos
+		Rhs    Expr
 	}
 
 	IfStmt struct {
-		Cond Expr
-		Then Stmt
-		Else Stmt
+		If      token.Pos
+		Cond    Expr
+		Then    Stmt
+		ElsePos token.Pos // position of "else", if any
+		Else    Stmt
 	}
 
 	WhileStmt struct {
-		Cond Expr
-		Body Stmt
+		While token.Pos
+		Cond  Expr
+		Body  Stmt
 	}
 
 	ReturnStmt struct {
+		Return token.Pos
 		Values []Expr
 	}
 
 	BlockStmt struct {
-		List []Stmt
+		Lbrace token.Pos
+		List   []Stmt
+		Rbrace token.Pos
 	}
 
 	SingleDeclStmt struct {
@@ -144,10 +266,45 @@ type (
 
 	MultiDeclStmt struct {
 		Assignables []Assignable
+		Assign      token.Pos
 		Init        *CallExpr
 	}
 )
 
+func (s *BadStmt) Pos() token.Pos        { return s.From }
+func (s *AssignStmt) Pos() token.Pos     { return s.Lhs.Pos() }
+func (s *IfStmt) Pos() token.Pos         { return s.If }
+func (s *WhileStmt) Pos() token.Pos      { return s.While }
+func (s *ReturnStmt) Pos() token.Pos     { return s.Return }
+func (s *BlockStmt) Pos() token.Pos      { return s.Lbrace }
+func (s *SingleDeclStmt) Pos() token.Pos { return s.Spec.Pos() }
+func (s *MultiDeclStmt) Pos() token.Pos  { return s.Assignables[0].Pos() }
+
+func (s *BadStmt) End() token.Pos    { return s.To }
+func (s *AssignStmt) End() token.Pos { return s.Rhs.End() }
+func (s *IfStmt) End() token.Pos {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Then.End()
+}
+func (s *WhileStmt) End() token.Pos { return s.Body.End() }
+func (s *ReturnStmt) End() token.Pos {
+	if n := len(s.Values); n > 0 {
+		return s.Values[n-1].End()
+	}
+	return s.Return + token.Pos(len("return"))
+}
+func (s *BlockStmt) End() token.Pos { return s.Rbrace + 1 }
+func (s *SingleDeclStmt) End() token.Pos {
+	if s.Init != nil {
+		return s.Init.End()
+	}
+	return s.Spec.End()
+}
+func (s *MultiDeclStmt) End() token.Pos { return s.Init.End() }
+
+func (*BadStmt) stmtNode()        {}
 func (*AssignStmt) stmtNode()     {}
 func (*IfStmt) stmtNode()         {}
 func (*WhileStmt) stmtNode()      {}
@@ -157,22 +314,103 @@ func (*SingleDeclStmt) stmtNode() {}
 func (*MultiDeclStmt) stmtNode()  {}
 
 type (
+	// A BadDecl node is a placeholder for a declaration containing syntax
+	// errors for which a correct declaration node cannot be created.
+	BadDecl struct {
+		From, To token.Pos // position range of bad declaration
+	}
+
 	FuncDecl struct {
+		Doc     *CommentGroup // comment on the lines right before, or nil
 		Name    *Ident
+		Lparen  token.Pos
 		Args    []*Spec
-		Body    *BlockStmt
+		Rparen  token.Pos
+		Body    *BlockStmt // nil in interface files
 		Results []Type
 	}
 
 	UseDecl struct {
+		Doc *CommentGroup // comment on the lines right before, or nil
+		Use token.Pos
 		Lib *Ident
 	}
 )
 
+func (d *BadDecl) Pos() token.Pos  { return d.From }
+func (d *FuncDecl) Pos() token.Pos { return d.Name.Pos() }
+func (d *UseDecl) Pos() token.Pos  { return d.Use }
+
+func (d *BadDecl) End() token.Pos { return d.To }
+func (d *UseDecl) End() token.Pos { return d.Lib.End() }
+
+func (d *FuncDecl) End() token.Pos {
+	switch {
+	case d.Body != nil:
+		return d.Body.End()
+	case len(d.Results) > 0:
+		return d.Results[len(d.Results)-1].End()
+	}
+	return d.Rparen + 1
+}
+
+func (*BadDecl) declNode()  {}
 func (*FuncDecl) declNode() {}
 func (*UseDecl) declNode()  {}
 
 type File struct {
 	FuncDecls []*FuncDecl
 	UseDecls  []*UseDecl
+	BadDecls  []*BadDecl      // top-level fragments the parser could not make sense of
+	Comments  []*CommentGroup // all comments in source order, if parsed with them
+}
+
+// Pos returns the position of the first declaration in the file, or NoPos
+// if the file is empty.
+func (f *File) Pos() token.Pos {
+	switch {
+	case len(f.UseDecls) > 0:
+		return f.UseDecls[0].Pos()
+	case len(f.FuncDecls) > 0:
+		return f.FuncDecls[0].Pos()
+	}
+	return token.NoPos
+}
+
+// End returns the position immediately after the last declaration in the
+// file, or NoPos if the file is empty.
+func (f *File) End() token.Pos {
+	switch {
+	case len(f.FuncDecls) > 0:
+		return f.FuncDecls[len(f.FuncDecls)-1].End()
+	case len(f.UseDecls) > 0:
+		return f.UseDecls[len(f.UseDecls)-1].End()
+	}
+	return token.NoPos
+}
+
+// An Interface is the syntax tree of an interface (.ixi) file, which
+// declares the signatures of the functions a library provides.
+type Interface struct {
+	FuncDecls []*FuncDecl // without bodies
+	BadDecls  []*BadDecl
+	Comments  []*CommentGroup // all comments in source order, if parsed with them
+}
+
+// Pos returns the position of the first declaration in the interface, or
+// NoPos if it is empty.
+func (i *Interface) Pos() token.Pos {
+	if len(i.FuncDecls) > 0 {
+		return i.FuncDecls[0].Pos()
+	}
+	return token.NoPos
+}
+
+// End returns the position immediately after the last declaration in the
+// interface, or NoPos if it is empty.
+func (i *Interface) End() token.Pos {
+	if len(i.FuncDecls) > 0 {
+		return i.FuncDecls[len(i.FuncDecls)-1].End()
+	}
+	return token.NoPos
 }
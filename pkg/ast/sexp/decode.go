@@ -0,0 +1,451 @@
+package sexp
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// An Error is a malformed S-expression or one that does not denote a
+// syntax tree. Offset is the byte offset in the input it was found at.
+type Error struct {
+	Offset int
+	Msg    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("sexp: offset %d: %s", err.Offset, err.Msg)
+}
+
+// Parse reads a file written by Fprint. The nodes of the returned tree
+// have no positions.
+func Parse(src []byte) (f *ast.File, err error) {
+	defer catch(&err)
+
+	d := newDecoder(src)
+	f = d.file(d.read())
+	d.eof()
+	return f, nil
+}
+
+// ParseInterface reads an interface written by Fprint. The nodes of the
+// returned tree have no positions.
+func ParseInterface(src []byte) (i *ast.Interface, err error) {
+	defer catch(&err)
+
+	d := newDecoder(src)
+	i = d.iface(d.read())
+	d.eof()
+	return i, nil
+}
+
+func catch(err *error) {
+	if e := recover(); e != nil {
+		serr, ok := e.(*Error)
+		if !ok {
+			panic(e)
+		}
+		*err = serr
+	}
+}
+
+// A node is an S-expression together with the offset it starts at, for
+// error reporting.
+type node struct {
+	offset int
+	atom   string // if elems is nil
+	elems  []*node
+}
+
+func (n *node) isAtom(s string) bool { return n.elems == nil && n.atom == s }
+
+type decoder struct {
+	src []byte
+	off int
+}
+
+func newDecoder(src []byte) *decoder { return &decoder{src: src} }
+
+func (d *decoder) errorf(offset int, format string, args ...interface{}) {
+	panic(&Error{Offset: offset, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (d *decoder) skipSpace() {
+	for d.off < len(d.src) && unicode.IsSpace(rune(d.src[d.off])) {
+		d.off++
+	}
+}
+
+func (d *decoder) eof() {
+	if d.skipSpace(); d.off < len(d.src) {
+		d.errorf(d.off, "unexpected %q after end of expression", d.src[d.off])
+	}
+}
+
+// read reads the next S-expression.
+func (d *decoder) read() *node {
+	d.skipSpace()
+	start := d.off
+	if d.off == len(d.src) {
+		d.errorf(d.off, "unexpected end of input")
+	}
+
+	switch c := d.src[d.off]; c {
+	case '(':
+		d.off++
+		n := &node{offset: start, elems: []*node{}}
+		for {
+			d.skipSpace()
+			if d.off < len(d.src) && d.src[d.off] == ')' {
+				d.off++
+				return n
+			}
+			n.elems = append(n.elems, d.read())
+		}
+	case ')':
+		d.errorf(d.off, "unexpected )")
+	case '"', '\'':
+		// Quoted atoms may contain spaces and parentheses; skip over
+		// escaped quotes to find the end.
+		d.off++
+		for d.off < len(d.src) && d.src[d.off] != c {
+			if d.src[d.off] == '\\' {
+				d.off++
+			}
+			d.off++
+		}
+		if d.off >= len(d.src) {
+			d.errorf(start, "unterminated literal")
+		}
+		d.off++
+	default:
+		for d.off < len(d.src) && !unicode.IsSpace(rune(d.src[d.off])) && d.src[d.off] != '(' && d.src[d.off] != ')' {
+			d.off++
+		}
+	}
+	return &node{offset: start, atom: string(d.src[start:d.off])}
+}
+
+func (d *decoder) list(n *node, what string) []*node {
+	if n.elems == nil {
+		d.errorf(n.offset, "expected %s, found %s", what, n.atom)
+	}
+	return n.elems
+}
+
+func (d *decoder) listOf(n *node, what string, length int) []*node {
+	elems := d.list(n, what)
+	if len(elems) != length {
+		d.errorf(n.offset, "expected %s with %d elements, found %d", what, length, len(elems))
+	}
+	return elems
+}
+
+func isIdent(s string) bool {
+	if s == "" || !unicode.IsLetter(rune(s[0])) || keywords[s] {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '\'' {
+			return false
+		}
+	}
+	return true
+}
+
+var keywords = map[string]bool{
+	"if":     true,
+	"else":   true,
+	"while":  true,
+	"return": true,
+	"length": true,
+	"use":    true,
+	"int":    true,
+	"bool":   true,
+	"true":   true,
+	"false":  true,
+}
+
+func (d *decoder) ident(n *node) *ast.Ident {
+	if n.elems != nil || !isIdent(n.atom) {
+		d.errorf(n.offset, "expected identifier, found %s", d.describe(n))
+	}
+	return &ast.Ident{Name: n.atom}
+}
+
+func (d *decoder) describe(n *node) string {
+	if n.elems != nil {
+		return "list"
+	}
+	return n.atom
+}
+
+func (d *decoder) file(n *node) *ast.File {
+	elems := d.list(n, "file")
+	if len(elems) == 0 {
+		d.errorf(n.offset, "expected list of functions")
+	}
+
+	f := &ast.File{}
+	for _, use := range elems[:len(elems)-1] {
+		f.UseDecls = append(f.UseDecls, d.useDecl(use))
+	}
+	for _, decl := range d.list(elems[len(elems)-1], "list of functions") {
+		if decl.isAtom(bad) {
+			f.BadDecls = append(f.BadDecls, &ast.BadDecl{})
+			continue
+		}
+		f.FuncDecls = append(f.FuncDecls, d.funcDecl(decl, false))
+	}
+	return f
+}
+
+func (d *decoder) iface(n *node) *ast.Interface {
+	i := &ast.Interface{}
+	for _, decl := range d.list(n, "list of functions") {
+		if decl.isAtom(bad) {
+			i.BadDecls = append(i.BadDecls, &ast.BadDecl{})
+			continue
+		}
+		i.FuncDecls = append(i.FuncDecls, d.funcDecl(decl, true))
+	}
+	return i
+}
+
+func (d *decoder) useDecl(n *node) *ast.UseDecl {
+	elems := d.listOf(n, "use declaration", 2)
+	if !elems[0].isAtom("use") {
+		d.errorf(n.offset, "expected use declaration")
+	}
+	return &ast.UseDecl{Lib: d.ident(elems[1])}
+}
+
+func (d *decoder) funcDecl(n *node, interfaceFile bool) *ast.FuncDecl {
+	length := 4
+	if interfaceFile {
+		length = 3
+	}
+	elems := d.listOf(n, "function", length)
+
+	decl := &ast.FuncDecl{Name: d.ident(elems[0])}
+	for _, arg := range d.list(elems[1], "parameters") {
+		decl.Args = append(decl.Args, d.spec(arg))
+	}
+	for _, result := range d.list(elems[2], "results") {
+		decl.Results = append(decl.Results, d.typ(result))
+	}
+	if !interfaceFile {
+		decl.Body = d.block(elems[3])
+	}
+	return decl
+}
+
+func (d *decoder) spec(n *node) *ast.Spec {
+	elems := d.listOf(n, "declaration", 2)
+	return &ast.Spec{Name: d.ident(elems[0]), Type: d.typ(elems[1])}
+}
+
+// isType reports whether n is a type rather than a subscript expression,
+// which is also headed by [].
+func isType(n *node) bool {
+	if n.elems == nil {
+		return n.atom == "int" || n.atom == "bool" || n.atom == bad
+	}
+	return len(n.elems) >= 2 && n.elems[0].isAtom("[]") && isType(n.elems[1])
+}
+
+func (d *decoder) typ(n *node) ast.Type {
+	switch {
+	case n.isAtom("int"):
+		return &ast.PrimitiveType{Kind: token.Int}
+	case n.isAtom("bool"):
+		return &ast.PrimitiveType{Kind: token.Bool}
+	case n.isAtom(bad):
+		return &ast.BadExpr{}
+	case !isType(n) || len(n.elems) > 3:
+		d.errorf(n.offset, "expected type, found %s", d.describe(n))
+	}
+
+	t := &ast.ArrayType{Elt: d.typ(n.elems[1])}
+	if len(n.elems) == 3 {
+		t.Size = d.expr(n.elems[2])
+	}
+	return t
+}
+
+func (d *decoder) block(n *node) *ast.BlockStmt {
+	b := &ast.BlockStmt{List: []ast.Stmt{}}
+	for _, s := range d.list(n, "block") {
+		b.List = append(b.List, d.stmt(s))
+	}
+	return b
+}
+
+func (d *decoder) stmt(n *node) ast.Stmt {
+	if n.isAtom(bad) {
+		return &ast.BadStmt{}
+	}
+	elems := d.list(n, "statement")
+	if len(elems) == 0 || elems[0].elems != nil {
+		return d.block(n)
+	}
+
+	switch head := elems[0].atom; head {
+	case "=":
+		elems = d.listOf(n, "assignment", 3)
+		lhs, rhs := elems[1], elems[2]
+		switch {
+		case lhs.elems == nil, len(lhs.elems) > 0 && lhs.elems[0].isAtom("[]"):
+			return &ast.AssignStmt{Lhs: d.lvalue(lhs), Rhs: d.expr(rhs)}
+		case len(lhs.elems) == 2 && isIdent(lhs.elems[0].atom) && isType(lhs.elems[1]):
+			return &ast.SingleDeclStmt{Spec: d.spec(lhs), Init: d.expr(rhs)}
+		}
+		s := &ast.MultiDeclStmt{}
+		for _, a := range d.list(lhs, "declarations") {
+			switch {
+			case a.isAtom("_"):
+				s.Assignables = append(s.Assignables, &ast.Discard{})
+			case a.isAtom(bad):
+				s.Assignables = append(s.Assignables, &ast.BadExpr{})
+			default:
+				s.Assignables = append(s.Assignables, d.spec(a))
+			}
+		}
+		if len(s.Assignables) == 0 {
+			d.errorf(lhs.offset, "expected declarations")
+		}
+		s.Init = d.call(rhs)
+		return s
+
+	case "if":
+		if len(elems) != 3 && len(elems) != 4 {
+			d.errorf(n.offset, "expected if statement with 3 or 4 elements, found %d", len(elems))
+		}
+		s := &ast.IfStmt{Cond: d.expr(elems[1]), Then: d.stmt(elems[2])}
+		if len(elems) == 4 {
+			s.Else = d.stmt(elems[3])
+		}
+		return s
+
+	case "while":
+		elems = d.listOf(n, "while statement", 3)
+		return &ast.WhileStmt{Cond: d.expr(elems[1]), Body: d.stmt(elems[2])}
+
+	case "return":
+		s := &ast.ReturnStmt{}
+		for _, value := range elems[1:] {
+			s.Values = append(s.Values, d.expr(value))
+		}
+		return s
+	}
+
+	if len(elems) == 2 && isType(elems[1]) {
+		return &ast.SingleDeclStmt{Spec: d.spec(n)}
+	}
+	return d.call(n)
+}
+
+func (d *decoder) lvalue(n *node) ast.Lvalue {
+	if n.elems == nil {
+		return d.ident(n)
+	}
+	elems := d.listOf(n, "subscript", 3)
+	return &ast.SubscriptExpr{Lhs: d.expr(elems[1]), Subscript: d.expr(elems[2])}
+}
+
+func (d *decoder) call(n *node) *ast.CallExpr {
+	elems := d.list(n, "call")
+	if len(elems) == 0 {
+		d.errorf(n.offset, "expected call, found ()")
+	}
+	call := &ast.CallExpr{Func: d.ident(elems[0])}
+	for _, arg := range elems[1:] {
+		call.Args = append(call.Args, d.expr(arg))
+	}
+	return call
+}
+
+var unops = map[string]token.TokenType{
+	"-": token.Sub,
+	"!": token.Not,
+}
+
+var binops = map[string]token.TokenType{
+	"+":   token.Add,
+	"-":   token.Sub,
+	"*":   token.Mul,
+	"*>>": token.HMul,
+	"/":   token.Div,
+	"%":   token.Rem,
+	"==":  token.Eq,
+	"!=":  token.Neq,
+	"<":   token.Lt,
+	"<=":  token.Le,
+	">":   token.Gt,
+	">=":  token.Ge,
+	"&":   token.And,
+	"|":   token.Or,
+}
+
+func (d *decoder) expr(n *node) ast.Expr {
+	if n.elems == nil {
+		return d.atom(n)
+	}
+
+	elems := n.elems
+	if len(elems) == 0 {
+		d.errorf(n.offset, "expected expression, found ()")
+	}
+	head := elems[0].atom
+	if elems[0].elems != nil {
+		d.errorf(elems[0].offset, "expected operator or function name, found list")
+	}
+
+	switch {
+	case head == "{}":
+		lit := &ast.ArrayLit{}
+		for _, elt := range elems[1:] {
+			lit.Elts = append(lit.Elts, d.expr(elt))
+		}
+		return lit
+	case head == "[]":
+		elems = d.listOf(n, "subscript", 3)
+		return &ast.SubscriptExpr{Lhs: d.expr(elems[1]), Subscript: d.expr(elems[2])}
+	case head == "length":
+		elems = d.listOf(n, "length expression", 2)
+		return &ast.LengthExpr{Tok: token.Length, Arg: d.expr(elems[1])}
+	case len(elems) == 2 && unops[head] != 0:
+		return &ast.UnaryExpr{Op: unops[head], Rhs: d.expr(elems[1])}
+	case len(elems) == 3 && binops[head] != 0:
+		return &ast.BinaryExpr{Op: binops[head], Lhs: d.expr(elems[1]), Rhs: d.expr(elems[2])}
+	case unops[head] != 0 || binops[head] != 0:
+		d.errorf(n.offset, "wrong number of operands for %s", head)
+	}
+	return d.call(n)
+}
+
+func (d *decoder) atom(n *node) ast.Expr {
+	s := n.atom
+	switch {
+	case s == bad:
+		return &ast.BadExpr{}
+	case s == "true":
+		return &ast.BasicLit{Kind: token.True, Value: s}
+	case s == "false":
+		return &ast.BasicLit{Kind: token.False, Value: s}
+	case s[0] == '"':
+		return &ast.BasicLit{Kind: token.String, Value: s}
+	case s[0] == '\'':
+		return &ast.BasicLit{Kind: token.Char, Value: s}
+	case '0' <= s[0] && s[0] <= '9':
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				d.errorf(n.offset, "malformed integer %s", s)
+			}
+		}
+		return &ast.BasicLit{Kind: token.Integer, Value: s}
+	}
+	return d.ident(n)
+}
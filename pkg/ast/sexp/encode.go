@@ -0,0 +1,204 @@
+package sexp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/manapointer/xi/pkg/ast"
+)
+
+// Fprint writes node to w, which may be an *ast.File, an *ast.Interface,
+// or a single declaration, statement, expression or type. Lines are broken
+// to fit in width columns where possible; a width of 0 or less writes
+// everything on one line.
+func Fprint(w io.Writer, node ast.Node, width int) error {
+	x, err := encode(node)
+	if err != nil {
+		return err
+	}
+	return fprint(w, x, width)
+}
+
+// Sprint returns node written on one line.
+func Sprint(node ast.Node) (string, error) {
+	x, err := encode(node)
+	if err != nil {
+		return "", err
+	}
+	return flat(x), nil
+}
+
+func encode(node ast.Node) (sexpr, error) {
+	switch n := node.(type) {
+	case *ast.File:
+		return file(n), nil
+	case *ast.Interface:
+		return iface(n), nil
+	case *ast.FuncDecl:
+		return funcDecl(n), nil
+	case *ast.UseDecl:
+		return useDecl(n), nil
+	case ast.Stmt:
+		return stmt(n), nil
+	case ast.Expr:
+		return expr(n), nil
+	case ast.Type:
+		return typ(n), nil
+	}
+	return nil, fmt.Errorf("sexp: cannot encode %T", node)
+}
+
+const bad = "<bad>"
+
+func list(elems ...sexpr) []sexpr { return append([]sexpr{}, elems...) }
+
+func file(f *ast.File) sexpr {
+	x := list()
+	for _, decl := range f.UseDecls {
+		x = append(x, useDecl(decl))
+	}
+
+	funcs := list()
+	for _, decl := range f.FuncDecls {
+		funcs = append(funcs, funcDecl(decl))
+	}
+	for range f.BadDecls {
+		funcs = append(funcs, bad)
+	}
+	return append(x, funcs)
+}
+
+func iface(i *ast.Interface) sexpr {
+	funcs := list()
+	for _, decl := range i.FuncDecls {
+		funcs = append(funcs, funcDecl(decl))
+	}
+	for range i.BadDecls {
+		funcs = append(funcs, bad)
+	}
+	return funcs
+}
+
+func useDecl(decl *ast.UseDecl) sexpr {
+	return list("use", decl.Lib.Name)
+}
+
+func funcDecl(decl *ast.FuncDecl) sexpr {
+	params := list()
+	for _, arg := range decl.Args {
+		params = append(params, spec(arg))
+	}
+	results := list()
+	for _, result := range decl.Results {
+		results = append(results, typ(result))
+	}
+
+	x := list(decl.Name.Name, params, results)
+	if decl.Body != nil {
+		x = append(x, stmt(decl.Body))
+	}
+	return x
+}
+
+func spec(s *ast.Spec) sexpr {
+	return list(s.Name.Name, typ(s.Type))
+}
+
+func typ(t ast.Type) sexpr {
+	switch t := t.(type) {
+	case *ast.PrimitiveType:
+		return t.Kind.String()
+	case *ast.ArrayType:
+		x := list("[]", typ(t.Elt))
+		if t.Size != nil {
+			x = append(x, expr(t.Size))
+		}
+		return x
+	}
+	return bad
+}
+
+func stmt(s ast.Stmt) sexpr {
+	switch s := s.(type) {
+	case *ast.SingleDeclStmt:
+		if s.Init == nil {
+			return spec(s.Spec)
+		}
+		return list("=", spec(s.Spec), expr(s.Init))
+
+	case *ast.MultiDeclStmt:
+		lhs := list()
+		for _, assignable := range s.Assignables {
+			switch a := assignable.(type) {
+			case *ast.Spec:
+				lhs = append(lhs, spec(a))
+			case *ast.Discard:
+				lhs = append(lhs, "_")
+			default:
+				lhs = append(lhs, bad)
+			}
+		}
+		return list("=", lhs, expr(s.Init))
+
+	case *ast.AssignStmt:
+		return list("=", expr(s.Lhs.(ast.Expr)), expr(s.Rhs))
+
+	case *ast.CallExpr:
+		return expr(s)
+
+	case *ast.IfStmt:
+		x := list("if", expr(s.Cond), stmt(s.Then))
+		if s.Else != nil {
+			x = append(x, stmt(s.Else))
+		}
+		return x
+
+	case *ast.WhileStmt:
+		return list("while", expr(s.Cond), stmt(s.Body))
+
+	case *ast.ReturnStmt:
+		x := list("return")
+		for _, value := range s.Values {
+			x = append(x, expr(value))
+		}
+		return x
+
+	case *ast.BlockStmt:
+		x := list()
+		for _, s := range s.List {
+			x = append(x, stmt(s))
+		}
+		return x
+	}
+	return bad
+}
+
+func expr(e ast.Expr) sexpr {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.BasicLit:
+		return e.Value
+	case *ast.ArrayLit:
+		x := list("{}")
+		for _, elt := range e.Elts {
+			x = append(x, expr(elt))
+		}
+		return x
+	case *ast.CallExpr:
+		x := list(e.Func.Name)
+		for _, arg := range e.Args {
+			x = append(x, expr(arg))
+		}
+		return x
+	case *ast.LengthExpr:
+		return list("length", expr(e.Arg))
+	case *ast.SubscriptExpr:
+		return list("[]", expr(e.Lhs), expr(e.Subscript))
+	case *ast.UnaryExpr:
+		return list(e.Op.String(), expr(e.Rhs))
+	case *ast.BinaryExpr:
+		return list(e.Op.String(), expr(e.Lhs), expr(e.Rhs))
+	}
+	return bad
+}
@@ -0,0 +1,96 @@
+// Package sexp converts Xi syntax trees to and from S-expressions.
+//
+// The format is canonical: a tree has exactly one rendering up to line
+// breaks, and reading a rendering back yields the same tree, except for
+// positions. A file is written as
+//
+//	((use io) ((main ((args ([] ([] int)))) () ((println "hi")))))
+//
+// that is, a list of its use declarations followed by the list of its
+// functions. A function is (name (params) (results) body), and types are
+// int, bool and ([] elem) or ([] elem size) for arrays. Statements and
+// expressions are prefix lists headed by their keyword or operator, except
+// calls, which are headed by the function name, blocks, which are plain
+// lists of statements, and array literals, which are headed by {}.
+// Subscripts are ([] array index) and an uninitialized declaration is
+// (name type). Syntax the parser could not make sense of is written as
+// <bad>.
+package sexp
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// An sexpr is an atom (a string) or a list ([]sexpr).
+type sexpr interface{}
+
+// write writes x on a single line.
+func write(b *strings.Builder, x sexpr) {
+	switch x := x.(type) {
+	case string:
+		b.WriteString(x)
+	case []sexpr:
+		b.WriteByte('(')
+		for i, elem := range x {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			write(b, elem)
+		}
+		b.WriteByte(')')
+	}
+}
+
+func flat(x sexpr) string {
+	var b strings.Builder
+	write(&b, x)
+	return b.String()
+}
+
+// A printer lays out S-expressions in lines of at most width columns
+// where possible. A list that does not fit is broken after its first
+// element, and the remaining elements are aligned under it.
+type printer struct {
+	w     *bufio.Writer
+	width int
+	col   int
+}
+
+func (p *printer) print(s string) {
+	p.w.WriteString(s)
+	p.col += len(s)
+}
+
+func (p *printer) newline(indent int) {
+	p.w.WriteByte('\n')
+	p.w.WriteString(strings.Repeat(" ", indent))
+	p.col = indent
+}
+
+func (p *printer) sexpr(x sexpr) {
+	s := flat(x)
+	list, ok := x.([]sexpr)
+	if !ok || len(list) == 0 || p.width <= 0 || p.col+len(s) <= p.width {
+		p.print(s)
+		return
+	}
+
+	p.print("(")
+	indent := p.col
+	for i, elem := range list {
+		if i > 0 {
+			p.newline(indent)
+		}
+		p.sexpr(elem)
+	}
+	p.print(")")
+}
+
+func fprint(w io.Writer, x sexpr, width int) error {
+	p := &printer{w: bufio.NewWriter(w), width: width}
+	p.sexpr(x)
+	p.print("\n")
+	return p.w.Flush()
+}
@@ -0,0 +1,213 @@
+package sexp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+const program = `use io
+use conv
+
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+
+divmod(a: int, b: int): int, int {
+	return a / b, a % b
+}
+
+main(args: int[][]) {
+	q:int, _ = divmod(7, 2)
+	_, r:int = divmod(7, 2)
+	_ = divmod(1, 1)
+	s:int[] = "a (string) with \"quotes\"" + {'a', '\x{29}', ')'}
+	m:int[][] = {}
+	m = {{}, {1, 2}}
+	g:bool[3][q][]
+	b:bool = s == {} | !(q != -r) & true
+	if (b) println(s) else { }
+	if (length(m[0]) >= 9223372036854775808) return
+	print(unparseInt(m[1][0] * 2 *>> q))
+}
+`
+
+// equal reports whether two trees are the same, ignoring positions.
+func equal(x, y interface{}) bool {
+	return reflect.DeepEqual(strip(reflect.ValueOf(x)), strip(reflect.ValueOf(y)))
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// strip returns a copy of v with all positions zeroed and empty slices
+// made nil.
+func strip(v reflect.Value) interface{} {
+	c := reflect.New(v.Type()).Elem()
+	stripInto(c, v)
+	return c.Interface()
+}
+
+func stripInto(dst, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			p := reflect.New(v.Type().Elem())
+			stripInto(p.Elem(), v.Elem())
+			dst.Set(p)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			e := reflect.New(v.Elem().Type()).Elem()
+			stripInto(e, v.Elem())
+			dst.Set(e)
+		}
+	case reflect.Slice:
+		if v.Len() > 0 {
+			s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				stripInto(s.Index(i), v.Index(i))
+			}
+			dst.Set(s)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			stripInto(dst.Field(i), v.Field(i))
+		}
+	default:
+		if v.Type() != posType {
+			dst.Set(v)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "test.xi", program, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, width := range []int{0, 20, 80} {
+		var b strings.Builder
+		if err := Fprint(&b, f, width); err != nil {
+			t.Fatal(err)
+		}
+
+		g, err := Parse([]byte(b.String()))
+		if err != nil {
+			t.Fatalf("width %d: %v\n%s", width, err, b.String())
+		}
+		if !equal(f, g) {
+			t.Errorf("width %d: tree changed by round trip through\n%s", width, b.String())
+		}
+
+		var again strings.Builder
+		Fprint(&again, g, width)
+		if again.String() != b.String() {
+			t.Errorf("width %d: got\n%s\nafter round trip, expected\n%s", width, again.String(), b.String())
+		}
+	}
+}
+
+func TestRoundTripInterface(t *testing.T) {
+	src := "print(str: int[])\nparseInt(str: int[]): int, bool\nmatrix(): int[][]\n"
+	i, err := parser.ParseInterface(token.NewFileSet(), "test.ixi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Sprint(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "((print ((str ([] int))) ()) (parseInt ((str ([] int))) (int bool)) (matrix () (([] ([] int)))))"
+	if s != want {
+		t.Errorf("got %s, expected %s", s, want)
+	}
+
+	j, err := ParseInterface([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(i, j) {
+		t.Errorf("tree changed by round trip")
+	}
+}
+
+func TestSprint(t *testing.T) {
+	src := `use io
+main(args: int[][]) {
+	a:int[2][]
+	x:int, _ = f(a[0][1], {1, 2})
+	_ = f(-x, {})
+}
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Sprint(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "((use io) ((main ((args ([] ([] int)))) () ((a ([] ([] int 2))) (= ((x int) _) (f ([] ([] a 0) 1) ({} 1 2))) (= (_) (f (- x) ({})))))))"
+	if s != want {
+		t.Errorf("got\n\t%s\nexpected\n\t%s", s, want)
+	}
+}
+
+func TestFprintWidth(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "test.xi", "f(a: int): int { return a + 1 }", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	Fprint(&b, f, 30)
+	want := `(((f
+   ((a int))
+   (int)
+   ((return (+ a 1))))))
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nexpected\n%s", b.String(), want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		src, err string
+	}{
+		{"", "offset 0: unexpected end of input"},
+		{"(()", "offset 3: unexpected end of input"},
+		{"(()) x", "offset 5: unexpected 'x' after end of expression"},
+		{"()", "offset 0: expected list of functions"},
+		{"(((f () ())))", "offset 2: expected function with 4 elements, found 3"},
+		{"(((f () () ((return \"x)))))))", "offset 20: unterminated literal"},
+		{"(((if () () ())))", "offset 3: expected identifier, found if"},
+		{"(((f ((x ([] int 1 2))) () ())))", "offset 9: expected type, found list"},
+		{"(((f () () ((return (+ 1)))))))", "offset 20: wrong number of operands for +"},
+		{"(((f () () ((return 12a))))))", "offset 20: malformed integer 12a"},
+	} {
+		_, err := Parse([]byte(test.src))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, expected %s", test.src, err, test.err)
+		}
+	}
+}
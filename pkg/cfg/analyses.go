@@ -0,0 +1,200 @@
+package cfg
+
+import (
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// Liveness returns the variables live before and after each node of g:
+// those whose current value may be read later. Facts are Sets of names.
+func Liveness(g *Graph) *Result {
+	return Solve(g, &Problem{
+		Lattice:   &SetLattice{},
+		Direction: Backward,
+		Boundary:  Set{},
+		Transfer: func(i int, out Fact) Fact {
+			n := g.Nodes[i]
+			live := out.(Set).Copy()
+			for _, v := range g.Defs(n) {
+				delete(live, v)
+			}
+			for _, v := range g.Uses(n) {
+				live[v] = true
+			}
+			return live
+		},
+	})
+}
+
+// A Def is a definition of a variable by a node.
+type Def struct {
+	Var  string
+	Node *Node
+}
+
+// ReachingDefs returns the definitions reaching each node of g: those
+// that may not have been overwritten when control gets there. Facts are
+// Sets of Defs; the parameters are defined by the entry node.
+func ReachingDefs(g *Graph) *Result {
+	return Solve(g, &Problem{
+		Lattice:   &SetLattice{},
+		Direction: Forward,
+		Boundary:  Set{},
+		Transfer: func(i int, in Fact) Fact {
+			n := g.Nodes[i]
+			defs := g.Defs(n)
+			if len(defs) == 0 {
+				return in
+			}
+			killed := make(map[string]bool)
+			for _, v := range defs {
+				killed[v] = true
+			}
+			out := make(Set)
+			for d := range in.(Set) {
+				if !killed[d.(Def).Var] {
+					out[d] = true
+				}
+			}
+			for _, v := range defs {
+				out[Def{v, n}] = true
+			}
+			return out
+		},
+	})
+}
+
+// AvailableExprs returns the expressions available before and after each
+// node of g: those computed on every path to it whose operands have not
+// been assigned since. Facts are Sets of the canonical S-expression
+// renderings of the expressions.
+//
+// Only unary and binary operations on variables, integer, character and
+// boolean literals and other such operations are considered; expressions
+// involving calls, array literals or subscripts, which may allocate or
+// read memory, are not. Note that a + b concatenates a and b if they are
+// arrays, so its value is a new array each time it is computed.
+func AvailableExprs(g *Graph) *Result {
+	gen := make([][]string, len(g.Nodes))
+	reads := make(map[string][]string) // the variables each expression reads
+	universe := make(Set)
+	for _, n := range g.Nodes {
+		for _, x := range computed(n) {
+			s := exprString(x)
+			gen[n.Index] = append(gen[n.Index], s)
+			if !universe[s] {
+				universe[s] = true
+				idents(x, func(id *ast.Ident) { reads[s] = append(reads[s], id.Name) })
+			}
+		}
+	}
+
+	return Solve(g, &Problem{
+		Lattice:   &SetLattice{Must: true, Universe: universe},
+		Direction: Forward,
+		Boundary:  Set{},
+		Transfer: func(i int, in Fact) Fact {
+			defs := g.Defs(g.Nodes[i])
+			if len(gen[i]) == 0 && len(defs) == 0 {
+				return in
+			}
+			out := in.(Set).Copy()
+			for _, s := range gen[i] {
+				out[s] = true
+			}
+			// Assigning a variable kills the expressions reading it,
+			// including any computed by the assignment itself.
+			for _, v := range defs {
+				for s := range out {
+					for _, r := range reads[s.(string)] {
+						if r == v {
+							delete(out, s)
+							break
+						}
+					}
+				}
+			}
+			return out
+		},
+	})
+}
+
+// computed returns the candidate expressions evaluated by n, innermost
+// first.
+func computed(n *Node) []ast.Expr {
+	var list []ast.Expr
+	var visit func(x ast.Expr)
+	visit = func(x ast.Expr) {
+		switch x := x.(type) {
+		case *ast.ArrayLit:
+			for _, elt := range x.Elts {
+				visit(elt)
+			}
+		case *ast.CallExpr:
+			for _, arg := range x.Args {
+				visit(arg)
+			}
+		case *ast.LengthExpr:
+			visit(x.Arg)
+		case *ast.SubscriptExpr:
+			visit(x.Lhs)
+			visit(x.Subscript)
+		case *ast.UnaryExpr:
+			visit(x.Rhs)
+		case *ast.BinaryExpr:
+			// The right operand of & and | is not always evaluated.
+			visit(x.Lhs)
+			if x.Op != token.And && x.Op != token.Or {
+				visit(x.Rhs)
+			}
+		}
+		if candidate(x) {
+			list = append(list, x)
+		}
+	}
+
+	switch s := n.Stmt.(type) {
+	case *ast.AssignStmt:
+		if x, ok := s.Lhs.(*ast.SubscriptExpr); ok {
+			visit(x)
+		}
+		visit(s.Rhs)
+	case *ast.SingleDeclStmt:
+		visit(s.Init)
+	case *ast.MultiDeclStmt:
+		visit(s.Init)
+	case *ast.CallExpr:
+		visit(s)
+	case *ast.ReturnStmt:
+		for _, v := range s.Values {
+			visit(v)
+		}
+	case *ast.IfStmt:
+		visit(s.Cond)
+	case *ast.WhileStmt:
+		visit(s.Cond)
+	}
+	return list
+}
+
+// candidate reports whether x is an operation on variables and literals
+// that AvailableExprs tracks.
+func candidate(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.UnaryExpr:
+		return operand(x.Rhs)
+	case *ast.BinaryExpr:
+		return operand(x.Lhs) && operand(x.Rhs)
+	}
+	return false
+}
+
+func operand(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.BasicLit:
+		return x.Kind != token.String
+	}
+	return candidate(x)
+}
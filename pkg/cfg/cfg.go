@@ -0,0 +1,193 @@
+// Package cfg builds statement-level control-flow graphs of Xi functions
+// and solves dataflow problems over them.
+//
+// Each node of a graph is a simple statement or the test of an if or
+// while statement; blocks have no nodes of their own. Every graph has an
+// entry node, which defines the parameters, and an exit node, which every
+// return statement and the end of the body lead to. Variables are
+// identified by name, which is unambiguous because Xi forbids shadowing.
+//
+// Solve computes the fixed point of a dataflow problem given by a lattice,
+// a direction and a transfer function. Liveness, ReachingDefs,
+// AvailableExprs and ConstProp are built on it.
+package cfg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/ast"
+)
+
+// A Graph is the control-flow graph of a function body.
+type Graph struct {
+	Func  *ast.FuncDecl
+	Entry *Node
+	Exit  *Node
+	Nodes []*Node // in source order, Entry first and Exit last
+}
+
+// A Node is a statement of a control-flow graph.
+type Node struct {
+	Index int // position in Graph.Nodes
+
+	// Stmt is an *ast.AssignStmt, *ast.SingleDeclStmt, *ast.MultiDeclStmt,
+	// *ast.CallExpr, *ast.ReturnStmt or *ast.BadStmt, or the *ast.IfStmt or
+	// *ast.WhileStmt whose condition the node tests. It is nil for the
+	// entry and exit nodes.
+	Stmt ast.Stmt
+
+	// A node testing a condition has two successors, taken when the
+	// condition is true and false respectively; they may be the same node.
+	// Other nodes have at most one successor.
+	Succs []*Node
+	Preds []*Node
+}
+
+// Cond returns the condition the node tests, or nil if it is not an if or
+// while node.
+func (n *Node) Cond() ast.Expr {
+	switch s := n.Stmt.(type) {
+	case *ast.IfStmt:
+		return s.Cond
+	case *ast.WhileStmt:
+		return s.Cond
+	}
+	return nil
+}
+
+// New returns the control-flow graph of decl, which must have a body.
+func New(decl *ast.FuncDecl) *Graph {
+	g := &Graph{Func: decl}
+	g.Entry = g.newNode(nil)
+	exit := &Node{}
+	g.Exit = exit
+
+	b := &builder{g: g}
+	for _, n := range b.stmt(decl.Body, []*Node{g.Entry}) {
+		addEdge(n, exit)
+	}
+
+	exit.Index = len(g.Nodes)
+	g.Nodes = append(g.Nodes, exit)
+	return g
+}
+
+func (g *Graph) newNode(stmt ast.Stmt) *Node {
+	n := &Node{Index: len(g.Nodes), Stmt: stmt}
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+func addEdge(from, to *Node) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+type builder struct {
+	g *Graph
+}
+
+// stmt adds the nodes of s, which control enters from each of preds, and
+// returns the nodes from which control leaves s for the next statement.
+func (b *builder) stmt(s ast.Stmt, preds []*Node) []*Node {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		for _, stmt := range s.List {
+			preds = b.stmt(stmt, preds)
+		}
+		return preds
+
+	case *ast.IfStmt:
+		n := b.node(s, preds)
+		out := b.stmt(s.Then, []*Node{n})
+		if s.Else == nil {
+			return append(out, n)
+		}
+		return append(out, b.stmt(s.Else, []*Node{n})...)
+
+	case *ast.WhileStmt:
+		n := b.node(s, preds)
+		for _, p := range b.stmt(s.Body, []*Node{n}) {
+			addEdge(p, n)
+		}
+		return []*Node{n}
+
+	case *ast.ReturnStmt:
+		addEdge(b.node(s, preds), b.g.Exit)
+		return nil
+	}
+	return []*Node{b.node(s, preds)}
+}
+
+func (b *builder) node(s ast.Stmt, preds []*Node) *Node {
+	n := b.g.newNode(s)
+	for _, p := range preds {
+		addEdge(p, n)
+	}
+	return n
+}
+
+// Reachable reports, for each node, whether control can reach it from the
+// entry. Conditions are not evaluated.
+func (g *Graph) Reachable() []bool {
+	seen := make([]bool, len(g.Nodes))
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if seen[n.Index] {
+			return
+		}
+		seen[n.Index] = true
+		for _, s := range n.Succs {
+			visit(s)
+		}
+	}
+	visit(g.Entry)
+	return seen
+}
+
+// FallsOff reports whether control can reach the end of the body without
+// executing a return statement, which is an error in a function with
+// results.
+func (g *Graph) FallsOff() bool {
+	reachable := g.Reachable()
+	for _, p := range g.Exit.Preds {
+		if _, ok := p.Stmt.(*ast.ReturnStmt); !ok && reachable[p.Index] {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a listing of the graph, one node per line with the
+// indices of its successors.
+func (g *Graph) String() string {
+	var b strings.Builder
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "%d: %s", n.Index, g.label(n))
+		if len(n.Succs) > 0 {
+			b.WriteString(" ->")
+			for _, s := range n.Succs {
+				fmt.Fprintf(&b, " %d", s.Index)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (g *Graph) label(n *Node) string {
+	switch {
+	case n == g.Entry:
+		return "entry"
+	case n == g.Exit:
+		return "exit"
+	}
+	switch s := n.Stmt.(type) {
+	case *ast.IfStmt:
+		return "if " + exprString(s.Cond)
+	case *ast.WhileStmt:
+		return "while " + exprString(s.Cond)
+	}
+	return nodeString(n.Stmt)
+}
@@ -0,0 +1,241 @@
+package cfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// build returns the graph of the first function in src.
+func build(t *testing.T, src string) *Graph {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(f.FuncDecls[0])
+}
+
+// node returns the first node of g whose listing begins with prefix.
+func node(t *testing.T, g *Graph, prefix string) *Node {
+	t.Helper()
+	for _, n := range g.Nodes {
+		if strings.HasPrefix(g.label(n), prefix) {
+			return n
+		}
+	}
+	t.Fatalf("no node %q in\n%s", prefix, g)
+	return nil
+}
+
+// elems returns the elements of s, sorted.
+func elems(s Fact) string {
+	var list []string
+	for x := range s.(Set) {
+		switch x := x.(type) {
+		case Def:
+			list = append(list, fmt.Sprintf("%s@%d", x.Var, x.Node.Index))
+		default:
+			list = append(list, fmt.Sprint(x))
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+const loop = `
+f(n: int): int {
+	s:int = 0
+	i:int = 0
+	while (i < n) {
+		if (i % 2 == 0) {
+			s = s + i
+		} else {
+			s = s - 1
+		}
+		i = i + 1
+	}
+	return s
+}
+`
+
+func TestNew(t *testing.T) {
+	g := build(t, loop)
+	want := `0: entry -> 1
+1: (= (s int) 0) -> 2
+2: (= (i int) 0) -> 3
+3: while (< i n) -> 4 8
+4: if (== (% i 2) 0) -> 5 6
+5: (= s (+ s i)) -> 7
+6: (= s (- s 1)) -> 7
+7: (= i (+ i 1)) -> 3
+8: (return s) -> 9
+9: exit
+`
+	if got := g.String(); got != want {
+		t.Errorf("got\n%s\nexpected\n%s", got, want)
+	}
+	if g.FallsOff() {
+		t.Error("FallsOff reported true for a function ending in return")
+	}
+}
+
+func TestFallsOff(t *testing.T) {
+	for _, test := range []struct {
+		body string
+		want bool
+	}{
+		{"", true},
+		{"return 1", false},
+		{"if (x > 0) return 1", true},
+		{"if (x > 0) return 1 else return 2", false},
+		{"if (x > 0) { x = 1 } else { return 2 }", true},
+		{"while (x > 0) { return 1 }", true},
+		{"{ { return 1 } }", false},
+		{"return 1 x = 2", false},
+	} {
+		g := build(t, "f(x: int): int {\n"+test.body+"\n}")
+		if got := g.FallsOff(); got != test.want {
+			t.Errorf("%q: FallsOff() = %t, expected %t", test.body, got, test.want)
+		}
+	}
+
+	g := build(t, "f(x: int): int {\nreturn 1 x = 2\n}")
+	if reachable := g.Reachable(); reachable[node(t, g, "(= x 2)").Index] {
+		t.Error("statement after return is reachable")
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	g := build(t, loop)
+	res := Liveness(g)
+	for _, test := range []struct {
+		node    string
+		in, out string
+	}{
+		{node: "entry", in: "", out: "n"},
+		{node: "(= (s int) 0)", in: "n", out: "n s"},
+		{node: "while", in: "i n s", out: "i n s"},
+		{node: "(= s (- s 1))", in: "i n s", out: "i n s"},
+		{node: "(return s)", in: "s", out: ""},
+	} {
+		n := node(t, g, test.node)
+		if got := elems(res.In[n.Index]); got != test.in {
+			t.Errorf("live before %s: got %q, expected %q", test.node, got, test.in)
+		}
+		if got := elems(res.Out[n.Index]); got != test.out {
+			t.Errorf("live after %s: got %q, expected %q", test.node, got, test.out)
+		}
+	}
+}
+
+func TestReachingDefs(t *testing.T) {
+	g := build(t, loop)
+	res := ReachingDefs(g)
+	for _, test := range []struct {
+		node, in string
+	}{
+		{"(= (s int) 0)", "n@0"},
+		{"while", "i@2 i@7 n@0 s@1 s@5 s@6"},
+		{"(= i (+ i 1))", "i@2 i@7 n@0 s@5 s@6"},
+		{"(return s)", "i@2 i@7 n@0 s@1 s@5 s@6"},
+	} {
+		n := node(t, g, test.node)
+		if got := elems(res.In[n.Index]); got != test.in {
+			t.Errorf("reaching %s: got %q, expected %q", test.node, got, test.in)
+		}
+	}
+}
+
+func TestAvailableExprs(t *testing.T) {
+	g := build(t, `
+f(a: int, b: int, c: bool): int {
+	x:int = a + b
+	if (c) {
+		y:int = a * b
+		b = 1
+	} else {
+		z:int = a * b + 1
+	}
+	w:int = -x
+	return a + b
+}
+`)
+	res := AvailableExprs(g)
+	for _, test := range []struct {
+		node, in string
+	}{
+		{"entry", ""},
+		{"if", "(+ a b)"},
+		{"(= b 1)", "(* a b) (+ a b)"},
+		{"(= (z int)", "(+ a b)"},
+		{"(= (w int)", ""},
+	} {
+		n := node(t, g, test.node)
+		if got := elems(res.In[n.Index]); got != test.in {
+			t.Errorf("available before %s: got %q, expected %q", test.node, got, test.in)
+		}
+	}
+
+	// Neither a + b, whose operand b was assigned on one path, nor x, a
+	// variable, is available at the return.
+	n := node(t, g, "(return")
+	if got, want := elems(res.In[n.Index]), "(- x)"; got != want {
+		t.Errorf("available before return: got %q, expected %q", got, want)
+	}
+}
+
+func TestConstProp(t *testing.T) {
+	g := build(t, `
+f(n: int): int {
+	a:int = 6 * 7
+	b:bool
+	k:int = 'A' + 1
+	h:int = -9223372036854775808 *>> 3
+	c:int = a / 2 + n
+	d:int = a / 0
+	if (n > 0) {
+		e:int = 1
+		a = 42
+	} else {
+		e:int = 2
+	}
+	x:int = a - 2
+	i:int = 0
+	while (i < n) {
+		i = i + 1
+	}
+	return x
+}
+`)
+	res := ConstProp(g)
+	for _, test := range []struct {
+		node, name string
+		want       string
+	}{
+		{"(= (c int)", "a", "42"},
+		{"(= (c int)", "b", "0"},
+		{"(= (c int)", "k", "66"},
+		{"(= (c int)", "h", "-2"},
+		{"(= (d int)", "c", "varying"},
+		{"(= (d int)", "n", "varying"},
+		{"if", "d", "varying"},
+		{"(= (x int)", "a", "42"},
+		{"(= (x int)", "e", "varying"},
+		{"(= (i int) 0)", "x", "40"},
+		{"while", "i", "varying"},
+		{"(return", "x", "40"},
+		{"(return", "y", "undefined"},
+	} {
+		n := node(t, g, test.node)
+		if got := res.In[n.Index].(Consts)[test.name].String(); got != test.want {
+			t.Errorf("%s before %s: got %s, expected %s", test.name, test.node, got, test.want)
+		}
+	}
+}
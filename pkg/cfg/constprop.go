@@ -0,0 +1,256 @@
+package cfg
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/manapointer/xi/pkg/arith"
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/lit"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// A Level is the position of a Value in the constant propagation lattice.
+type Level int
+
+const (
+	Undefined Level = iota // no assignment seen yet; the top element
+	Constant               // always the same value
+	Varying                // not known to be constant; the bottom element
+)
+
+// A Value is what constant propagation knows about a variable. Booleans
+// are represented as 0 and 1.
+type Value struct {
+	Level Level
+	Const int64 // for Constant
+}
+
+func (v Value) String() string {
+	switch v.Level {
+	case Undefined:
+		return "undefined"
+	case Constant:
+		return strconv.FormatInt(v.Const, 10)
+	}
+	return "varying"
+}
+
+// Meet returns the greatest lower bound of v and w.
+func (v Value) Meet(w Value) Value {
+	switch {
+	case v.Level == Undefined:
+		return w
+	case w.Level == Undefined:
+		return v
+	case v == w:
+		return v
+	}
+	return Value{Level: Varying}
+}
+
+// Consts maps variables to their values. Missing variables are Undefined.
+type Consts map[string]Value
+
+type constLattice struct{}
+
+func (constLattice) Top() Fact { return Consts{} }
+
+func (constLattice) Meet(x, y Fact) Fact {
+	c, d := x.(Consts), y.(Consts)
+	m := make(Consts, len(c))
+	for name, v := range c {
+		m[name] = v.Meet(d[name])
+	}
+	for name, v := range d {
+		if _, ok := c[name]; !ok {
+			m[name] = v
+		}
+	}
+	return m
+}
+
+func (constLattice) Equal(x, y Fact) bool {
+	c, d := x.(Consts), y.(Consts)
+	for name, v := range c {
+		if d[name] != v {
+			return false
+		}
+	}
+	for name, v := range d {
+		if c[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ConstProp returns the values of the variables before and after each
+// node of g. Facts are Consts. Only int and bool variables are tracked;
+// the parameters, results of calls, array elements and lengths vary.
+func ConstProp(g *Graph) *Result {
+	entry := make(Consts)
+	for _, arg := range g.Func.Args {
+		entry[arg.Name.Name] = Value{Level: Varying}
+	}
+
+	return Solve(g, &Problem{
+		Lattice:   constLattice{},
+		Direction: Forward,
+		Boundary:  entry,
+		Transfer: func(i int, in Fact) Fact {
+			n := g.Nodes[i]
+			c := in.(Consts)
+			set := func(name string, v Value) {
+				out := make(Consts, len(c)+1)
+				for k, v := range c {
+					out[k] = v
+				}
+				out[name] = v
+				c = out
+			}
+
+			switch s := n.Stmt.(type) {
+			case *ast.AssignStmt:
+				if id, ok := s.Lhs.(*ast.Ident); ok {
+					set(id.Name, Eval(s.Rhs, c))
+				}
+			case *ast.SingleDeclStmt:
+				switch {
+				case s.Init != nil:
+					set(s.Spec.Name.Name, Eval(s.Init, c))
+				case isPrimitive(s.Spec.Type):
+					// Declared variables start out as zero or false.
+					set(s.Spec.Name.Name, Value{Level: Constant})
+				default:
+					set(s.Spec.Name.Name, Value{Level: Varying})
+				}
+			case *ast.MultiDeclStmt:
+				for _, name := range g.Defs(n) {
+					set(name, Value{Level: Varying})
+				}
+			}
+			return c
+		},
+	})
+}
+
+func isPrimitive(typ ast.Type) bool {
+	_, ok := typ.(*ast.PrimitiveType)
+	return ok
+}
+
+// Eval returns the value of x given the values of variables in c. If any
+// operand varies, so does the result; otherwise, if any is undefined, so
+// is the result.
+func Eval(x ast.Expr, c Consts) Value {
+	constant := func(n int64) Value { return Value{Level: Constant, Const: n} }
+	varying := Value{Level: Varying}
+
+	switch x := x.(type) {
+	case *ast.Ident:
+		return c[x.Name]
+
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.Integer:
+			n, err := lit.ParseInt(x.Value)
+			if err != nil {
+				return varying
+			}
+			return constant(n)
+		case token.Char:
+			c, err := lit.UnquoteChar(x.Value)
+			if err != nil {
+				return varying
+			}
+			return constant(c)
+		case token.True:
+			return constant(1)
+		case token.False:
+			return constant(0)
+		}
+		return varying
+
+	case *ast.UnaryExpr:
+		v := Eval(x.Rhs, c)
+		if v.Level != Constant {
+			return v
+		}
+		switch x.Op {
+		case token.Sub:
+			return constant(-v.Const)
+		case token.Not:
+			return constant(1 - v.Const)
+		}
+		return varying
+
+	case *ast.BinaryExpr:
+		l := Eval(x.Lhs, c)
+		// false & y and true | y do not evaluate y.
+		if l.Level == Constant && (x.Op == token.And && l.Const == 0 || x.Op == token.Or && l.Const == 1) {
+			return l
+		}
+		r := Eval(x.Rhs, c)
+		switch {
+		case l.Level == Varying || r.Level == Varying:
+			return varying
+		case l.Level == Undefined || r.Level == Undefined:
+			return Value{}
+		}
+		if n, ok := fold(x.Op, l.Const, r.Const); ok {
+			return constant(n)
+		}
+		return varying
+	}
+	return varying
+}
+
+// fold returns the result of applying op to integer or boolean operands,
+// or false if it cannot be computed, as when dividing by zero.
+func fold(op token.TokenType, l, r int64) (int64, bool) {
+	b := func(cond bool) int64 {
+		if cond {
+			return 1
+		}
+		return 0
+	}
+
+	switch op {
+	case token.Add:
+		return l + r, true
+	case token.Sub:
+		return l - r, true
+	case token.Mul:
+		return l * r, true
+	case token.HMul:
+		return arith.HighMul(l, r), true
+	case token.Div:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case token.Rem:
+		if r == 0 {
+			return 0, false
+		}
+		return l % r, true
+	case token.Eq:
+		return b(l == r), true
+	case token.Neq:
+		return b(l != r), true
+	case token.Lt:
+		return b(l < r), true
+	case token.Le:
+		return b(l <= r), true
+	case token.Gt:
+		return b(l > r), true
+	case token.Ge:
+		return b(l >= r), true
+	case token.And:
+		return l & r, true
+	case token.Or:
+		return l | r, true
+	}
+	panic(fmt.Sprintf("cfg: unexpected operator %s", op))
+}
@@ -0,0 +1,197 @@
+package cfg
+
+// A Fact is an element of the lattice of a dataflow problem.
+type Fact interface{}
+
+// A Lattice is a meet semilattice of facts of finite height.
+type Lattice interface {
+	// Top returns the greatest element, the identity of Meet. It is the
+	// initial fact of every node.
+	Top() Fact
+
+	// Meet returns the greatest lower bound of x and y. It must not modify
+	// its arguments.
+	Meet(x, y Fact) Fact
+
+	// Equal reports whether x and y are the same element.
+	Equal(x, y Fact) bool
+}
+
+// A Direction is the direction in which facts flow.
+type Direction int
+
+const (
+	Forward  Direction = iota // from the entry along edges
+	Backward                  // from the exit against edges
+)
+
+// A Flow is a directed graph that dataflow problems are solved on. Its
+// nodes are numbered from 0, which is the entry. A *Graph is a Flow, and so
+// are the graphs of the optimizer's basic blocks.
+type Flow interface {
+	NumNodes() int
+	Succs(i int) []int
+	Preds(i int) []int
+}
+
+// A Problem is a dataflow problem.
+type Problem struct {
+	Lattice   Lattice
+	Direction Direction
+
+	// Boundary is the fact flowing into the entry of a forward problem, or
+	// into the nodes without successors of a backward one.
+	Boundary Fact
+
+	// Transfer returns the fact flowing out of node i given the fact
+	// flowing into it, in the direction of the problem. It must be
+	// monotone and must not modify in.
+	Transfer func(i int, in Fact) Fact
+}
+
+// A Result is the solution of a dataflow problem. Both slices are indexed
+// by node. In holds the facts before each node and Out those after it, in
+// the order of execution whatever the direction of the problem.
+type Result struct {
+	In, Out []Fact
+}
+
+// Solve returns the maximal fixed point of p on g, computed with a
+// worklist.
+func Solve(g Flow, p *Problem) *Result {
+	n := g.NumNodes()
+	in := make([]Fact, n)  // flowing into each node in the direction of p
+	out := make([]Fact, n) // flowing out of each node
+	for i := range out {
+		out[i] = p.Lattice.Top()
+	}
+
+	preds, succs := g.Preds, g.Succs
+	if p.Direction == Backward {
+		preds, succs = g.Succs, g.Preds
+	}
+
+	// Nodes are usually numbered in source order, which visits most
+	// predecessors before their successors; backward problems visit them
+	// in reverse.
+	queued := make([]bool, n)
+	var work []int
+	push := func(i int) {
+		if !queued[i] {
+			queued[i] = true
+			work = append(work, i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if p.Direction == Forward {
+			push(i)
+		} else {
+			push(n - 1 - i)
+		}
+	}
+
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+		queued[i] = false
+
+		fact := p.Lattice.Top()
+		ps := preds(i)
+		if p.Direction == Forward && i == 0 || p.Direction == Backward && len(ps) == 0 {
+			fact = p.Boundary
+		}
+		for _, j := range ps {
+			fact = p.Lattice.Meet(fact, out[j])
+		}
+		in[i] = fact
+
+		if fact := p.Transfer(i, fact); !p.Lattice.Equal(fact, out[i]) {
+			out[i] = fact
+			for _, j := range succs(i) {
+				push(j)
+			}
+		}
+	}
+
+	if p.Direction == Backward {
+		in, out = out, in
+	}
+	return &Result{In: in, Out: out}
+}
+
+// NumNodes, Succs and Preds make g a Flow.
+func (g *Graph) NumNodes() int { return len(g.Nodes) }
+
+func (g *Graph) Succs(i int) []int { return indices(g.Nodes[i].Succs) }
+func (g *Graph) Preds(i int) []int { return indices(g.Nodes[i].Preds) }
+
+func indices(nodes []*Node) []int {
+	list := make([]int, len(nodes))
+	for i, n := range nodes {
+		list[i] = n.Index
+	}
+	return list
+}
+
+// A Set is a finite set of comparable values.
+type Set map[interface{}]bool
+
+// Copy returns a copy of s.
+func (s Set) Copy() Set {
+	t := make(Set, len(s))
+	for x := range s {
+		t[x] = true
+	}
+	return t
+}
+
+// Equal reports whether s and t have the same elements.
+func (s Set) Equal(t Set) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for x := range s {
+		if !t[x] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLattice is the lattice of subsets of a universe. A may problem, whose
+// facts hold on some path, meets by union and starts from the empty set;
+// a must problem, whose facts hold on every path, meets by intersection
+// and starts from the universe.
+type SetLattice struct {
+	Must     bool
+	Universe Set // only needed by must problems
+}
+
+func (l *SetLattice) Top() Fact {
+	if l.Must {
+		return l.Universe.Copy()
+	}
+	return Set{}
+}
+
+func (l *SetLattice) Meet(x, y Fact) Fact {
+	s, t := x.(Set), y.(Set)
+	u := make(Set)
+	if l.Must {
+		for e := range s {
+			if t[e] {
+				u[e] = true
+			}
+		}
+		return u
+	}
+	for e := range s {
+		u[e] = true
+	}
+	for e := range t {
+		u[e] = true
+	}
+	return u
+}
+
+func (l *SetLattice) Equal(x, y Fact) bool { return x.(Set).Equal(y.(Set)) }
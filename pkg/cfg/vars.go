@@ -0,0 +1,122 @@
+package cfg
+
+import (
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/ast/sexp"
+)
+
+// Defs returns the variables n assigns: the parameters for the entry node,
+// and the variable declared or assigned by a statement. Stores into array
+// elements assign no variable.
+func (g *Graph) Defs(n *Node) []string {
+	var defs []string
+	if n == g.Entry {
+		for _, arg := range g.Func.Args {
+			defs = append(defs, arg.Name.Name)
+		}
+		return defs
+	}
+
+	switch s := n.Stmt.(type) {
+	case *ast.AssignStmt:
+		if id, ok := s.Lhs.(*ast.Ident); ok {
+			defs = append(defs, id.Name)
+		}
+	case *ast.SingleDeclStmt:
+		defs = append(defs, s.Spec.Name.Name)
+	case *ast.MultiDeclStmt:
+		for _, a := range s.Assignables {
+			if spec, ok := a.(*ast.Spec); ok {
+				defs = append(defs, spec.Name.Name)
+			}
+		}
+	}
+	return defs
+}
+
+// Uses returns the variables n reads, each once, in order of appearance.
+func (g *Graph) Uses(n *Node) []string {
+	var uses []string
+	seen := make(map[string]bool)
+	use := func(id *ast.Ident) {
+		if !seen[id.Name] {
+			seen[id.Name] = true
+			uses = append(uses, id.Name)
+		}
+	}
+
+	switch s := n.Stmt.(type) {
+	case *ast.AssignStmt:
+		if x, ok := s.Lhs.(*ast.SubscriptExpr); ok {
+			idents(x, use)
+		}
+		idents(s.Rhs, use)
+	case *ast.SingleDeclStmt:
+		sizes(s.Spec.Type, use)
+		idents(s.Init, use)
+	case *ast.MultiDeclStmt:
+		idents(s.Init, use)
+	case *ast.CallExpr:
+		idents(s, use)
+	case *ast.ReturnStmt:
+		for _, v := range s.Values {
+			idents(v, use)
+		}
+	case *ast.IfStmt:
+		idents(s.Cond, use)
+	case *ast.WhileStmt:
+		idents(s.Cond, use)
+	}
+	return uses
+}
+
+// idents calls f for each variable read by x, in order. Function names
+// are not variables.
+func idents(x ast.Expr, f func(*ast.Ident)) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		f(x)
+	case *ast.ArrayLit:
+		for _, elt := range x.Elts {
+			idents(elt, f)
+		}
+	case *ast.CallExpr:
+		for _, arg := range x.Args {
+			idents(arg, f)
+		}
+	case *ast.LengthExpr:
+		idents(x.Arg, f)
+	case *ast.SubscriptExpr:
+		idents(x.Lhs, f)
+		idents(x.Subscript, f)
+	case *ast.UnaryExpr:
+		idents(x.Rhs, f)
+	case *ast.BinaryExpr:
+		idents(x.Lhs, f)
+		idents(x.Rhs, f)
+	}
+}
+
+// sizes calls f for each variable read by the array sizes of typ.
+func sizes(typ ast.Type, f func(*ast.Ident)) {
+	if t, ok := typ.(*ast.ArrayType); ok {
+		sizes(t.Elt, f)
+		if t.Size != nil {
+			idents(t.Size, f)
+		}
+	}
+}
+
+// exprString returns the canonical rendering of x, which is the same for
+// structurally equal expressions.
+func exprString(x ast.Expr) string {
+	return nodeString(x)
+}
+
+func nodeString(node ast.Node) string {
+	s, err := sexp.Sprint(node)
+	if err != nil {
+		return "<bad>"
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+// Package amd64 generates x86-64 assembly for the GNU assembler from
+// lowered IR, following the System V calling convention. The output is
+// linked with the run-time system in the repository's runtime directory,
+// which provides the functions ir.AllocFunc and ir.OutOfBoundsFunc and the
+// standard library.
+package amd64
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/codegen/asm"
+	"github.com/manapointer/xi/pkg/codegen/regalloc"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/opt"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// Options configure code generation. The zero value is the default.
+type Options struct {
+	// SpillAll keeps every temporary on the stack instead of allocating
+	// registers.
+	SpillAll bool
+
+	// If Optimize is not nil, Compile optimizes the lowered IR with it.
+	Optimize *opt.Config
+}
+
+// Compile translates file, which must have been type-checked without
+// errors yielding info, and writes its assembly to w. opts may be nil.
+func Compile(w io.Writer, name string, file *ast.File, info *types.Info, opts *Options) error {
+	cu := ir.Lower(ir.Translate(name, file, info))
+	if opts != nil && opts.Optimize != nil {
+		cu = opt.Optimize(cu, opts.Optimize)
+	}
+	return Generate(w, cu, opts)
+}
+
+// Generate writes the assembly of cu, which must be lowered, to w. opts
+// may be nil.
+func Generate(w io.Writer, cu *ir.CompUnit, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\t.file %q\n", cu.Name)
+	b.WriteString("\t.text\n")
+	for _, decl := range cu.Funcs {
+		fn := selectFunc(decl)
+		if opts.SpillAll {
+			regalloc.SpillAll(fn)
+		} else {
+			regalloc.Allocate(fn)
+		}
+		writeFunc(&b, fn)
+	}
+	// The stack need not be executable.
+	b.WriteString("\t.section .note.GNU-stack,\"\",@progbits\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeFunc writes an allocated function with its prologue and epilogue.
+func writeFunc(b *strings.Builder, fn *asm.Func) {
+	name := asm.Quote(fn.Name)
+	fmt.Fprintf(b, "\n\t.globl %s\n\t.type %s, @function\n%s:\n", name, name, name)
+	b.WriteString("\tpushq %rbp\n\tmovq %rsp, %rbp\n")
+
+	// Calls require %rsp to be a multiple of 16, which it is after
+	// pushing %rbp.
+	frame := (fn.Slots + fn.Frame) * asm.WordSize
+	frame = (frame + 15) &^ 15
+	if frame > 0 {
+		fmt.Fprintf(b, "\tsubq $%d, %%rsp\n", frame)
+	}
+
+	for _, in := range fn.Body {
+		switch {
+		case in.IsLabel():
+			fmt.Fprintf(b, "%s\n", in)
+		case in.Op == "ret":
+			b.WriteString("\tleave\n\tret\n")
+		default:
+			fmt.Fprintf(b, "\t%s\n", in)
+		}
+	}
+	fmt.Fprintf(b, "\t.size %s, .-%s\n", name, name)
+}
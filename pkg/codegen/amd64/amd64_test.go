@@ -0,0 +1,248 @@
+package amd64
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/interp"
+	"github.com/manapointer/xi/pkg/opt"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+var programs = []struct {
+	name  string
+	src   string
+	stdin string
+	args  []string
+}{
+	{"hello", `use io
+greet'(s: int[]) { println(s) }
+main(args: int[][]) {
+	greet'("Hello, wörld\x{21}")
+}
+`, "", nil},
+	{"recursion", `use io
+use conv
+fib(n: int): int {
+	if (n < 2) return n
+	return fib(n - 1) + fib(n - 2)
+}
+main(args: int[][]) {
+	println(unparseInt(fib(20)))
+}
+`, "", nil},
+	{"arrays", `use io
+use conv
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+main(args: int[][]) {
+	a:int[] = {5, -3, 8, 0, 2} + {7} + {}
+	sort(a)
+	i:int = 0
+	while (i < length(a)) {
+		print(unparseInt(a[i]) + " ")
+		i = i + 1
+	}
+	m:int[3][4]
+	m[2][3] = 9
+	println(unparseInt(length(m) * 10 + length(m[1]) + m[2][3]))
+	println(unparseInt(length(args[1])) + args[0])
+}
+`, "", []string{"one", "thrée"}},
+	{"stack arguments and results", `use io
+use conv
+f(a: int, b: int, c: int, d: int, e: int, k: int, m: int, h: int): int, int, int, bool {
+	return a - b - c - d, e * k - m * h, h, m > k
+}
+g(n: int): int, int, int {
+	if (n == 0) return 1, 2, 3
+	x:int, y:int, z:int = g(n - 1)
+	return z, x + y, y * z
+}
+main(args: int[][]) {
+	w:int, x:int, y:int, z:bool = f(1, 2, 3, 4, 5, 6, 7, 8)
+	_, _, _, z2:bool = f(1, 2, 3, 4, 5, 9, 7, 8)
+	a:int, b:int, c:int = g(5)
+	if (z & !z2) println(unparseInt(w) + " " + unparseInt(x) + " " + unparseInt(y) + " " + unparseInt(a + b + c))
+}
+`, "", nil},
+	{"arithmetic", `use io
+use conv
+show(n: int) { print(unparseInt(n) + " ") }
+main(args: int[][]) {
+	min:int = -9223372036854775808
+	minus:int = length(args) - 1
+	show(min - 1) show(min / minus) show(min % minus) show(-min)
+	show(9223372036854775807 * 3)
+	show(-17 / 5) show(-17 % 5) show(17 / -5) show(17 % -5)
+	show(123456789012 * 1000 / 7)
+	b:bool = 3 <= 3 & 4 >= 5 | 2 != 2 | -1 < 0
+	if (b) println("") else println("!")
+}
+`, "", nil},
+	{"high multiply", `use io
+use conv
+show(n: int) { print(unparseInt(n) + " ") }
+main(args: int[][]) {
+	min:int = -9223372036854775808
+	max:int = 9223372036854775807
+	one:int = length(args) + 1
+	show(min *>> min) show(min *>> -one) show(min *>> one) show(max *>> max) show(min *>> max)
+	show(-one *>> one) show(-one *>> -one) show(3 *>> -2) show(4294967296 *>> 4294967296)
+	show(123456789012345 *>> -987654321098765 * one)
+	println("")
+}
+`, "", nil},
+	{"short circuit", `use io
+t(s: int[]): bool { print(s) return true }
+f(s: int[]): bool { print(s) return false }
+main(args: int[][]) {
+	b:bool = f("a") & t("b") | t("c") & !f("d")
+	if (b) println("!")
+	while (f("e") | f("f")) {}
+	a:int[] = {}
+	if (length(a) > 0 & a[0] == 1) println("unreachable")
+	println("")
+}
+`, "", nil},
+	{"input", `use io
+use conv
+main(args: int[][]) {
+	sum:int = 0
+	while (!eof()) {
+		n:int, ok:bool = parseInt(readln())
+		if (ok) sum = sum + n
+		else print("bad ")
+	}
+	println(unparseInt(sum))
+	println(unparseInt(getchar()))
+}
+`, "1\n-2\nx\n9223372036854775808\n-9223372036854775808\n39\r\n", nil},
+	{"getchar", `use io
+use conv
+main(args: int[][]) {
+	c:int = getchar()
+	while (c != -1) {
+		print(unparseInt(c) + " ")
+		c = getchar()
+	}
+	println(readln())
+}
+`, "aé\xff€\xe2\x82", nil},
+	{"out of bounds", `use io
+main(args: int[][]) {
+	a:int[] = "ab"
+	println(a)
+	a[-1] = 0
+	println("unreachable")
+}
+`, "", nil},
+	{"division by zero", `use io
+use conv
+main(args: int[][]) {
+	zero:int = length(args)
+	println("before")
+	println(unparseInt(1 % zero))
+}
+`, "", nil},
+}
+
+// interpret runs a program in the interpreter and returns its output and
+// whether it failed.
+func interpret(fset *token.FileSet, f *ast.File, stdin string, args []string) (string, bool) {
+	var stdout bytes.Buffer
+	in := interp.New(fset, f)
+	rt := stdlib.NewRuntime(strings.NewReader(stdin), &stdout)
+	rt.Bind(in)
+	err := in.Main(args)
+	rt.Flush()
+	return stdout.String(), err != nil
+}
+
+func TestPrograms(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("requires linux/amd64")
+	}
+	gcc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("requires gcc")
+	}
+	rt, err := filepath.Abs("../../../runtime/xi.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, "test.xi", test.src, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := &types.Config{Importer: importer.New(fset, nil)}
+			info, err := types.Check(fset, []*ast.File{f}, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, failed := interpret(fset, f, test.stdin, test.args)
+			for _, opts := range []*Options{{}, {SpillAll: true}, {Optimize: &opt.Config{Passes: opt.Passes}}} {
+				out, err := run(t, gcc, rt, f, info, opts, test.stdin, test.args)
+				if string(out) != want {
+					t.Errorf("%+v: got output %q, expected %q", *opts, out, want)
+				}
+				if failed != (err != nil) {
+					t.Errorf("%+v: got error %v, expected failure: %v", *opts, err, failed)
+				}
+			}
+		})
+	}
+}
+
+// run compiles a program, links it with the run-time system rt and runs
+// it.
+func run(t *testing.T, gcc, rt string, f *ast.File, info *types.Info, opts *Options, stdin string, args []string) ([]byte, error) {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := Compile(&b, "test", f, info, opts); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "test.s")
+	if err := os.WriteFile(src, b.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "test")
+	if out, err := exec.Command(gcc, "-o", bin, src, rt).CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s\n%s", err, out, b.String())
+	}
+
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.Output()
+}
@@ -0,0 +1,471 @@
+package amd64
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/codegen/asm"
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// A selector chooses the instructions of one lowered function.
+//
+// Functions follow the System V calling convention. A function with more
+// than two results takes a hidden first argument, the address of a block
+// of the caller's frame where it stores the results after the second.
+// Each function saves the callee-saved registers in temporaries, so that
+// register allocation only keeps them where they are not needed.
+type selector struct {
+	fn     *ir.FuncDecl
+	out    []*asm.Instr
+	temps  int
+	labels int
+
+	retPtr    asm.Temp // address for results after the second, if any
+	stackArgs int      // words of outgoing arguments on the stack
+	retArea   int      // words for results of calls after the second
+}
+
+func selectFunc(fn *ir.FuncDecl) *asm.Func {
+	s := &selector{fn: fn}
+
+	// The outgoing arguments and results of every call in the function
+	// share the bottom of its frame.
+	for _, stmt := range fn.Body.(*ir.Seq).List {
+		var call *ir.Call
+		switch stmt := stmt.(type) {
+		case *ir.Move:
+			call, _ = stmt.Src.(*ir.Call)
+		case *ir.Exp:
+			call, _ = stmt.X.(*ir.Call)
+		}
+		if call == nil {
+			continue
+		}
+		nargs, nrets := len(call.Args), s.results(call)
+		if nrets > 2 {
+			nargs++
+			s.retArea = max(s.retArea, nrets-2)
+		}
+		s.stackArgs = max(s.stackArgs, nargs-len(asm.ArgRegs))
+	}
+
+	s.prologue()
+	for _, stmt := range fn.Body.(*ir.Seq).List {
+		s.stmt(stmt)
+	}
+
+	return &asm.Func{Name: fn.Name, Body: s.out, Frame: s.stackArgs + s.retArea}
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func (s *selector) emit(op string, args ...asm.Operand) *asm.Instr {
+	in := asm.New(op, args...)
+	s.out = append(s.out, in)
+	return in
+}
+
+func (s *selector) newTemp() asm.Temp {
+	s.temps++
+	return asm.Temp(fmt.Sprintf("_s%d", s.temps))
+}
+
+// label returns the assembly label of an IR label. Labels are global in
+// the output, so they are qualified by the function's name.
+func (s *selector) label(name string) string {
+	return ".L" + s.fn.Name + "_" + name
+}
+
+func (s *selector) newLabel() string {
+	s.labels++
+	return s.label(fmt.Sprintf("_s%d", s.labels))
+}
+
+func temp(t *ir.Temp) asm.Temp { return asm.Temp(t.Name) }
+
+// saved returns the temporary holding the caller's value of the
+// callee-saved register r.
+func saved(r asm.Reg) asm.Temp { return asm.Temp("_" + r.String()[1:]) }
+
+// param returns the location of the i'th parameter of a function,
+// counting the hidden one.
+func param(i int) asm.Operand {
+	if i < len(asm.ArgRegs) {
+		return asm.ArgRegs[i]
+	}
+	// Above the saved %rbp and the return address.
+	return &asm.Mem{Base: asm.RBP, Disp: int64(2+i-len(asm.ArgRegs)) * asm.WordSize}
+}
+
+func (s *selector) prologue() {
+	for _, r := range asm.CalleeSaved {
+		s.emit("movq", r, saved(r))
+	}
+
+	hidden := 0
+	if s.fn.NumRets > 2 {
+		s.retPtr = s.newTemp()
+		s.emit("movq", param(0), s.retPtr)
+		hidden = 1
+	}
+	for i := 0; i < s.fn.NumArgs; i++ {
+		s.emit("movq", param(i+hidden), temp(ir.Arg(i)))
+	}
+}
+
+func (s *selector) stmt(stmt ir.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ir.Move:
+		switch dst := stmt.Dst.(type) {
+		case *ir.Temp:
+			if call, ok := stmt.Src.(*ir.Call); ok {
+				s.call(call)
+				s.emit("movq", temp(ir.Ret(0)), temp(dst))
+				return
+			}
+			s.emit("movq", s.expr(stmt.Src), temp(dst))
+		case *ir.Mem:
+			addr := s.address(dst.Addr)
+			s.emit("movq", s.operand(stmt.Src), addr)
+		default:
+			panic(fmt.Sprintf("amd64: cannot move to %s", ir.Sprint(dst)))
+		}
+
+	case *ir.Exp:
+		call, ok := stmt.X.(*ir.Call)
+		if !ok {
+			panic(fmt.Sprintf("amd64: %s is not lowered", ir.Sprint(stmt)))
+		}
+		s.call(call)
+
+	case *ir.Jump:
+		name, ok := stmt.Target.(*ir.Name)
+		if !ok {
+			panic(fmt.Sprintf("amd64: cannot jump to %s", ir.Sprint(stmt.Target)))
+		}
+		s.emit("jmp", asm.Sym(s.label(name.Name)))
+
+	case *ir.CJump:
+		if stmt.False != "" {
+			panic(fmt.Sprintf("amd64: %s is not lowered", ir.Sprint(stmt)))
+		}
+		s.cjump(stmt.Cond, s.label(stmt.True))
+
+	case *ir.Label:
+		s.out = append(s.out, asm.NewLabel(s.label(stmt.Name)))
+
+	case *ir.Return:
+		s.ret(stmt.Results)
+
+	default:
+		panic(fmt.Sprintf("amd64: %s is not lowered", ir.Sprint(stmt)))
+	}
+}
+
+// results returns the number of results of the function called by call.
+func (s *selector) results(call *ir.Call) int {
+	name, ok := call.Target.(*ir.Name)
+	if !ok {
+		panic(fmt.Sprintf("amd64: cannot call %s", ir.Sprint(call.Target)))
+	}
+	n, ok := ir.NumResults(name.Name)
+	if !ok {
+		panic(fmt.Sprintf("amd64: cannot call %s, which is not a mangled name", name.Name))
+	}
+	return n
+}
+
+func (s *selector) call(call *ir.Call) {
+	nrets := s.results(call)
+
+	var args []asm.Operand
+	if nrets > 2 {
+		args = append(args, nil) // the hidden argument, set below
+	}
+	for _, arg := range call.Args {
+		args = append(args, s.expr(arg))
+	}
+
+	// Store the arguments on the stack before setting the registers,
+	// which address computations might need.
+	for i := len(asm.ArgRegs); i < len(args); i++ {
+		slot := &asm.Mem{Base: asm.RSP, Disp: int64(i-len(asm.ArgRegs)) * asm.WordSize}
+		arg := args[i]
+		if _, ok := arg.(*asm.Mem); ok {
+			arg = s.reg(arg)
+		}
+		s.emit("movq", arg, slot)
+	}
+	n := 0
+	for i, arg := range args {
+		if i == len(asm.ArgRegs) {
+			break
+		}
+		if arg == nil {
+			s.emit("leaq", s.retSlot(0), asm.ArgRegs[i])
+		} else {
+			s.emit("movq", arg, asm.ArgRegs[i])
+		}
+		n++
+	}
+
+	in := s.emit("call", asm.Sym(call.Target.(*ir.Name).Name))
+	in.N = n
+
+	for i := 0; i < nrets; i++ {
+		if i < len(asm.RetRegs) {
+			s.emit("movq", asm.RetRegs[i], temp(ir.Ret(i)))
+		} else {
+			s.emit("movq", s.retSlot(i-len(asm.RetRegs)), temp(ir.Ret(i)))
+		}
+	}
+}
+
+// retSlot returns the location in the caller's frame of the i'th result
+// after the second.
+func (s *selector) retSlot(i int) *asm.Mem {
+	return &asm.Mem{Base: asm.RSP, Disp: int64(s.stackArgs+i) * asm.WordSize}
+}
+
+func (s *selector) ret(results []ir.Expr) {
+	ops := make([]asm.Operand, len(results))
+	for i, result := range results {
+		ops[i] = s.expr(result)
+	}
+
+	for i := len(asm.RetRegs); i < len(ops); i++ {
+		dst := &asm.Mem{Base: s.retPtr, Disp: int64(i-len(asm.RetRegs)) * asm.WordSize}
+		s.emit("movq", s.reg(ops[i]), dst)
+	}
+	n := 0
+	for i, op := range ops {
+		if i == len(asm.RetRegs) {
+			break
+		}
+		s.emit("movq", op, asm.RetRegs[i])
+		n++
+	}
+	for _, r := range asm.CalleeSaved {
+		s.emit("movq", saved(r), r)
+	}
+	s.emit("ret").N = n
+}
+
+// expr returns an operand holding the value of e: a Temp, an Imm that
+// fits in 32 bits or a Mem.
+func (s *selector) expr(e ir.Expr) asm.Operand {
+	switch e := e.(type) {
+	case *ir.Const:
+		if int64(int32(e.Value)) == e.Value {
+			return asm.Imm(e.Value)
+		}
+		t := s.newTemp()
+		s.emit("movabsq", asm.Imm(e.Value), t)
+		return t
+
+	case *ir.Temp:
+		return temp(e)
+
+	case *ir.Mem:
+		return s.address(e.Addr)
+
+	case *ir.BinOp:
+		return s.binOp(e)
+	}
+
+	panic(fmt.Sprintf("amd64: cannot select %s", ir.Sprint(e)))
+}
+
+// operand returns an operand holding the value of e that is not a Mem.
+func (s *selector) operand(e ir.Expr) asm.Operand {
+	op := s.expr(e)
+	if _, ok := op.(*asm.Mem); ok {
+		return s.reg(op)
+	}
+	return op
+}
+
+// reg returns a temporary holding the value of op, which is op itself if
+// it is one.
+func (s *selector) reg(op asm.Operand) asm.Temp {
+	if t, ok := op.(asm.Temp); ok {
+		return t
+	}
+	t := s.newTemp()
+	s.emit("movq", op, t)
+	return t
+}
+
+// address returns the memory operand at the address e, using the
+// addressing modes of the array accesses that translation produces.
+func (s *selector) address(e ir.Expr) *asm.Mem {
+	if x, ok := e.(*ir.BinOp); ok && (x.Op == ir.Add || x.Op == ir.Sub) {
+		if c, ok := x.Y.(*ir.Const); ok {
+			disp := c.Value
+			if x.Op == ir.Sub {
+				disp = -disp
+			}
+			if int64(int32(disp)) == disp {
+				return &asm.Mem{Base: s.reg(s.expr(x.X)), Disp: disp}
+			}
+		}
+		if y, ok := x.Y.(*ir.BinOp); ok && x.Op == ir.Add && y.Op == ir.Mul {
+			if c, ok := y.Y.(*ir.Const); ok && (c.Value == 1 || c.Value == 2 || c.Value == 4 || c.Value == 8) {
+				return &asm.Mem{Base: s.reg(s.expr(x.X)), Index: s.reg(s.expr(y.X)), Scale: c.Value}
+			}
+		}
+	}
+	return &asm.Mem{Base: s.reg(s.expr(e))}
+}
+
+var conditions = map[ir.Op]string{
+	ir.Eq:  "e",
+	ir.Neq: "ne",
+	ir.Lt:  "l",
+	ir.Gt:  "g",
+	ir.Leq: "le",
+	ir.Geq: "ge",
+	ir.ULt: "b",
+}
+
+var arith = map[ir.Op]string{
+	ir.Add:     "addq",
+	ir.Sub:     "subq",
+	ir.Mul:     "imulq",
+	ir.And:     "andq",
+	ir.Or:      "orq",
+	ir.Xor:     "xorq",
+	ir.LShift:  "salq",
+	ir.RShift:  "shrq",
+	ir.ARShift: "sarq",
+}
+
+func (s *selector) binOp(e *ir.BinOp) asm.Operand {
+	t := s.newTemp()
+
+	switch e.Op {
+	case ir.Add, ir.Sub, ir.Mul, ir.And, ir.Or, ir.Xor:
+		x := s.expr(e.X)
+		y := s.expr(e.Y)
+		s.emit("movq", x, t)
+		s.emit(arith[e.Op], y, t)
+
+	case ir.LShift, ir.RShift, ir.ARShift:
+		x := s.expr(e.X)
+		y := s.expr(e.Y)
+		s.emit("movq", x, t)
+		if _, ok := y.(asm.Imm); ok {
+			s.emit(arith[e.Op], y, t)
+		} else {
+			s.emit("movq", y, asm.RCX)
+			s.emit(arith[e.Op], asm.RCX, t)
+		}
+
+	case ir.Div, ir.Mod:
+		s.divide(e, t)
+
+	case ir.HMul:
+		x := s.expr(e.X)
+		y := s.expr(e.Y)
+		if _, ok := y.(asm.Imm); ok {
+			y = s.reg(y)
+		}
+		s.emit("movq", x, asm.RAX)
+		s.emit("imulq", y)
+		s.emit("movq", asm.RDX, t)
+
+	default:
+		cc, ok := conditions[e.Op]
+		if !ok {
+			panic(fmt.Sprintf("amd64: unknown operator %s", e.Op))
+		}
+		s.compare(e)
+		s.emit("set"+cc, t)
+		s.emit("movzbq", t, t)
+	}
+
+	return t
+}
+
+// divide computes the quotient or remainder e into t. Dividing the most
+// negative integer by -1 overflows, which traps, so division by -1 is
+// negation instead. Division by zero traps, and the run-time system
+// reports it.
+func (s *selector) divide(e *ir.BinOp, t asm.Temp) {
+	x := s.expr(e.X)
+	y := s.expr(e.Y)
+
+	negation := func() {
+		if e.Op == ir.Div {
+			s.emit("movq", x, t)
+			s.emit("negq", t)
+		} else {
+			s.emit("movq", asm.Imm(0), t)
+		}
+	}
+	division := func() {
+		s.emit("movq", x, asm.RAX)
+		s.emit("cqto")
+		s.emit("idivq", s.reg(y))
+		if e.Op == ir.Div {
+			s.emit("movq", asm.RAX, t)
+		} else {
+			s.emit("movq", asm.RDX, t)
+		}
+	}
+
+	if c, ok := y.(asm.Imm); ok {
+		if c == -1 {
+			negation()
+		} else {
+			division()
+		}
+		return
+	}
+
+	divide, done := s.newLabel(), s.newLabel()
+	s.emit("cmpq", asm.Imm(-1), y)
+	s.emit("jne", asm.Sym(divide))
+	negation()
+	s.emit("jmp", asm.Sym(done))
+	s.out = append(s.out, asm.NewLabel(divide))
+	division()
+	s.out = append(s.out, asm.NewLabel(done))
+}
+
+// compare sets the flags for the comparison e.
+func (s *selector) compare(e *ir.BinOp) {
+	x := s.expr(e.X)
+	y := s.expr(e.Y)
+	_, xmem := x.(*asm.Mem)
+	_, ymem := y.(*asm.Mem)
+	if _, ok := x.(asm.Imm); ok || xmem && ymem {
+		x = s.reg(x)
+	}
+	s.emit("cmpq", y, x)
+}
+
+func (s *selector) cjump(cond ir.Expr, label string) {
+	if x, ok := cond.(*ir.BinOp); ok {
+		if cc, ok := conditions[x.Op]; ok {
+			s.compare(x)
+			s.emit("j"+cc, asm.Sym(label))
+			return
+		}
+	}
+
+	c := s.expr(cond)
+	if imm, ok := c.(asm.Imm); ok {
+		if imm != 0 {
+			s.emit("jmp", asm.Sym(label))
+		}
+		return
+	}
+	s.emit("cmpq", asm.Imm(0), c)
+	s.emit("jne", asm.Sym(label))
+}
@@ -0,0 +1,337 @@
+// Package asm defines abstract x86-64 assembly: instructions in AT&T
+// syntax whose operands may name temporaries as well as machine
+// registers. Instruction selection produces it, and register allocation
+// replaces the temporaries with registers and stack slots, after which it
+// can be printed as input to the system assembler.
+//
+// Functions print in a textual form that Parse reads back:
+//
+//	_Idouble_ii:
+//		movq %rdi, {x}
+//		addq {x}, {x}
+//		cmpq $0, {x}
+//		jl .L_Idouble_ii_neg
+//		movq {x}, %rax
+//		ret # 1
+//	.L_Idouble_ii_neg:
+//		movq $0, %rax
+//		ret # 1
+//
+// Temporaries are written in braces. Labels within a function are local
+// labels, whose names begin with .L; any other label begins a function.
+// The comment after a call or ret gives the number of argument or result
+// registers it reads, so the text is also valid input to the assembler.
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Reg is a machine register.
+type Reg int
+
+const (
+	RAX Reg = iota
+	RBX
+	RCX
+	RDX
+	RSI
+	RDI
+	RBP
+	RSP
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
+)
+
+var regs = [...]string{"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "rsp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+
+var byteRegs = [...]string{"al", "bl", "cl", "dl", "sil", "dil", "bpl", "spl", "r8b", "r9b", "r10b", "r11b", "r12b", "r13b", "r14b", "r15b"}
+
+func (r Reg) String() string {
+	if 0 <= r && int(r) < len(regs) {
+		return "%" + regs[r]
+	}
+	return fmt.Sprintf("Reg(%d)", int(r))
+}
+
+// Byte returns the name of the low byte of r.
+func (r Reg) Byte() string { return "%" + byteRegs[r] }
+
+// The registers of the System V calling convention.
+var (
+	// ArgRegs hold the first six arguments of a call, in order.
+	ArgRegs = []Reg{RDI, RSI, RDX, RCX, R8, R9}
+
+	// RetRegs hold the first two results of a call, in order.
+	RetRegs = []Reg{RAX, RDX}
+
+	// CallerSaved are the registers that a call may overwrite.
+	CallerSaved = []Reg{RAX, RCX, RDX, RSI, RDI, R8, R9, R10, R11}
+
+	// CalleeSaved are the registers that a function must preserve,
+	// besides %rbp and %rsp.
+	CalleeSaved = []Reg{RBX, R12, R13, R14, R15}
+)
+
+// An Operand is an operand of an instruction: a Reg, Temp, Imm, Mem or
+// Sym.
+type Operand interface {
+	String() string
+}
+
+// A Temp is a variable of the function it appears in, which register
+// allocation assigns to a register or a stack slot. Temporaries are
+// printed in braces, so that their names cannot be confused with those of
+// registers or symbols.
+type Temp string
+
+func (t Temp) String() string { return "{" + string(t) + "}" }
+
+// An Imm is an immediate operand.
+type Imm int64
+
+func (i Imm) String() string { return fmt.Sprintf("$%d", int64(i)) }
+
+// A Mem is the memory word at address Disp + Base + Index*Scale. Base and
+// Index are a Reg, a Temp or nil.
+type Mem struct {
+	Base  Operand
+	Index Operand
+	Scale int64
+	Disp  int64
+}
+
+func (m *Mem) String() string {
+	var b strings.Builder
+	if m.Disp != 0 || m.Base == nil && m.Index == nil {
+		fmt.Fprint(&b, m.Disp)
+	}
+	if m.Base != nil || m.Index != nil {
+		b.WriteByte('(')
+		if m.Base != nil {
+			b.WriteString(m.Base.String())
+		}
+		if m.Index != nil {
+			fmt.Fprintf(&b, ",%s,%d", m.Index, m.Scale)
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// A Sym is a symbol: a function name or a label, used as the target of a
+// call or jump.
+type Sym string
+
+func (s Sym) String() string { return Quote(string(s)) }
+
+// Quote returns name in a form the assembler accepts as a symbol. Mangled
+// names may contain primes, which must be quoted.
+func Quote(name string) string {
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.' || c == '$') {
+			return `"` + name + `"`
+		}
+	}
+	return name
+}
+
+// An Instr is an instruction or a label.
+type Instr struct {
+	// Op is the mnemonic, such as "addq", or "" for a label.
+	Op string
+
+	// Args are the operands in AT&T order, sources before the
+	// destination.
+	Args []Operand
+
+	// Label is the name of a label.
+	Label string
+
+	// N is the number of argument registers read by a call, or of
+	// result registers read by a ret.
+	N int
+}
+
+// NewLabel returns a label.
+func NewLabel(name string) *Instr { return &Instr{Label: name} }
+
+// New returns an instruction.
+func New(op string, args ...Operand) *Instr { return &Instr{Op: op, Args: args} }
+
+// IsLabel reports whether in is a label.
+func (in *Instr) IsLabel() bool { return in.Op == "" }
+
+// IsJump reports whether in is a conditional or unconditional jump, whose
+// target is its Sym operand.
+func (in *Instr) IsJump() bool { return strings.HasPrefix(in.Op, "j") }
+
+// FallsThrough reports whether control can continue with the instruction
+// after in.
+func (in *Instr) FallsThrough() bool { return in.Op != "jmp" && in.Op != "ret" }
+
+func (in *Instr) String() string {
+	if in.IsLabel() {
+		return Quote(in.Label) + ":"
+	}
+
+	var b strings.Builder
+	b.WriteString(in.Op)
+	for i, arg := range in.Args {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		if r, ok := arg.(Reg); ok && i == 0 && in.bytes() {
+			b.WriteString(r.Byte())
+		} else {
+			b.WriteString(arg.String())
+		}
+	}
+	if in.N > 0 {
+		fmt.Fprintf(&b, " # %d", in.N)
+	}
+	return b.String()
+}
+
+// bytes reports whether the first operand of in is a byte, so that a
+// register is named by its low byte. The shifts take their count in %cl.
+func (in *Instr) bytes() bool {
+	switch in.Op {
+	case "movzbq", "salq", "sarq", "shrq":
+		return true
+	}
+	return strings.HasPrefix(in.Op, "set")
+}
+
+// Uses returns the registers and temporaries that in reads.
+func (in *Instr) Uses() []Operand {
+	var uses []Operand
+	var use func(args ...Operand)
+	use = func(args ...Operand) {
+		for _, arg := range args {
+			switch x := arg.(type) {
+			case Reg, Temp:
+				uses = append(uses, x)
+			case *Mem:
+				use(x.Base, x.Index)
+			}
+		}
+	}
+	// Operands that are written rather than read still read the
+	// registers of their address.
+	address := func(arg Operand) {
+		if m, ok := arg.(*Mem); ok {
+			use(m)
+		}
+	}
+
+	switch in.Op {
+	case "", "jmp":
+	case "movq", "movabsq", "movzbq", "leaq":
+		use(in.Args[0])
+		address(in.Args[1])
+	case "call":
+		for _, r := range ArgRegs[:in.N] {
+			use(r)
+		}
+	case "ret":
+		for _, r := range RetRegs[:in.N] {
+			use(r)
+		}
+		// The callee-saved registers hold the caller's values again.
+		for _, r := range CalleeSaved {
+			use(r)
+		}
+	case "cqto":
+		use(RAX)
+	case "idivq":
+		use(RAX, RDX, in.Args[0])
+	default:
+		if strings.HasPrefix(in.Op, "set") {
+			address(in.Args[0])
+			break
+		}
+		if in.Op == "imulq" && len(in.Args) == 1 {
+			use(RAX)
+		}
+		use(in.Args...)
+	}
+	return uses
+}
+
+// Defs returns the registers and temporaries that in writes.
+func (in *Instr) Defs() []Operand {
+	var defs []Operand
+	def := func(args ...Operand) {
+		for _, arg := range args {
+			switch x := arg.(type) {
+			case Reg, Temp:
+				defs = append(defs, x)
+			}
+		}
+	}
+
+	switch in.Op {
+	case "", "jmp", "ret", "cmpq", "testq":
+	case "call":
+		for _, r := range CallerSaved {
+			def(r)
+		}
+	case "cqto":
+		def(RDX)
+	case "idivq":
+		def(RAX, RDX)
+	default:
+		if strings.HasPrefix(in.Op, "j") {
+			break
+		}
+		if in.Op == "imulq" && len(in.Args) == 1 {
+			def(RAX, RDX)
+			break
+		}
+		def(in.Args[len(in.Args)-1])
+	}
+	return defs
+}
+
+// A Func is a function in abstract assembly.
+type Func struct {
+	Name string
+	Body []*Instr
+
+	// Frame is the number of words at the bottom of the stack frame,
+	// addressed from %rsp, that calls use for arguments and results.
+	Frame int
+
+	// Slots is the number of words below %rbp that register allocation
+	// uses for temporaries it could not keep in registers.
+	Slots int
+}
+
+// Slot returns the i'th stack slot of a function.
+func Slot(i int) *Mem { return &Mem{Base: RBP, Disp: -WordSize * int64(i+1)} }
+
+// WordSize is the size in bytes of a register.
+const WordSize = 8
+
+func (fn *Func) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", Quote(fn.Name))
+	for _, in := range fn.Body {
+		if !in.IsLabel() {
+			b.WriteByte('\t')
+		}
+		b.WriteString(in.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package asm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInstr(t *testing.T) {
+	for _, test := range []struct {
+		in         *Instr
+		s          string
+		uses, defs string
+	}{
+		{New("movq", Temp("x"), &Mem{Base: Temp("a"), Index: Temp("i"), Scale: 8}), "movq {x}, ({a},{i},8)", "[{x} {a} {i}]", "[]"},
+		{New("movq", &Mem{Base: RBP, Disp: -8}, RAX), "movq -8(%rbp), %rax", "[%rbp]", "[%rax]"},
+		{New("addq", Imm(-1), Temp("x")), "addq $-1, {x}", "[{x}]", "[{x}]"},
+		{New("sete", RCX), "sete %cl", "[]", "[%rcx]"},
+		{New("salq", RCX, Temp("x")), "salq %cl, {x}", "[%rcx {x}]", "[{x}]"},
+		{New("cmpq", Imm(0), Temp("x")), "cmpq $0, {x}", "[{x}]", "[]"},
+		{New("idivq", Temp("y")), "idivq {y}", "[%rax %rdx {y}]", "[%rax %rdx]"},
+		{New("imulq", Temp("y")), "imulq {y}", "[%rax {y}]", "[%rax %rdx]"},
+		{New("jne", Sym(".Lf'_l1")), `jne ".Lf'_l1"`, "[]", "[]"},
+		{&Instr{Op: "call", Args: []Operand{Sym("_If_pii")}, N: 2}, "call _If_pii # 2", "[%rdi %rsi]", "[%rax %rcx %rdx %rsi %rdi %r8 %r9 %r10 %r11]"},
+		{&Instr{Op: "ret", N: 1}, "ret # 1", "[%rax %rbx %r12 %r13 %r14 %r15]", "[]"},
+		{NewLabel("_l1"), "_l1:", "[]", "[]"},
+	} {
+		if s := test.in.String(); s != test.s {
+			t.Errorf("got %s, expected %s", s, test.s)
+		}
+		if uses := fmt.Sprint(test.in.Uses()); uses != test.uses {
+			t.Errorf("%s: got uses %s, expected %s", test.s, uses, test.uses)
+		}
+		if defs := fmt.Sprint(test.in.Defs()); defs != test.defs {
+			t.Errorf("%s: got defs %s, expected %s", test.s, defs, test.defs)
+		}
+	}
+}
+
+const text = `_Idouble_ii:
+	movq %rdi, {x}
+	addq {x}, {x}
+	movq -8({x},{i},8), 16(%rsp)
+	sete %al
+	cmpq $0, {x}
+	jl ".L_If'_p_neg"
+	call _xi_alloc # 1
+	movq {x}, %rax
+	ret # 1
+".L_If'_p_neg":
+	movq $0, %rax
+	ret # 1
+"_If'_p":
+	leaq (%rsp), %rdi
+	ret
+`
+
+func TestParse(t *testing.T) {
+	funcs, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funcs) != 2 {
+		t.Fatalf("got %d functions, expected 2", len(funcs))
+	}
+	if s := funcs[0].String() + funcs[1].String(); s != text {
+		t.Errorf("got\n%s\nexpected\n%s", s, text)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		src, err string
+	}{
+		{"\tmovq %rax, %rbx", "line 1: instruction outside a function"},
+		{".L1:", "line 1: label .L1 outside a function"},
+		{"f:\n\tmovq %rax", "line 2: movq takes 2 operands, found 1"},
+		{"f:\n\tfrob %rax", "line 2: unknown instruction frob"},
+		{"f:\n\tmovq %foo, %rax", "line 2: unknown register %foo"},
+		{"f:\n\tmovq {}, %rax", "line 2: malformed temporary {}"},
+		{"f:\n\tmovq $x, %rax", "line 2: malformed immediate $x"},
+		{"f:\n\tmovq 8(%rax,%rbx,3), %rax", "line 2: malformed scale in 8(%rax,%rbx,3)"},
+		{"f:\n\tcall f # 7", `line 2: malformed register count "7"`},
+	} {
+		_, err := Parse(test.src)
+		if err == nil || err.Error() != "asm: "+test.err {
+			t.Errorf("%q: got error %v, expected %s", test.src, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An Error is a syntax error in abstract assembly.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("asm: line %d: %s", err.Line, err.Msg)
+}
+
+// arity is the number of operands of each instruction. Conditional jumps
+// and set instructions take one.
+var arity = map[string]int{
+	"movq":    2,
+	"movabsq": 2,
+	"movzbq":  2,
+	"leaq":    2,
+	"addq":    2,
+	"subq":    2,
+	"andq":    2,
+	"orq":     2,
+	"xorq":    2,
+	"salq":    2,
+	"sarq":    2,
+	"shrq":    2,
+	"cmpq":    2,
+	"testq":   2,
+	"negq":    1,
+	"notq":    1,
+	"idivq":   1,
+	"jmp":     1,
+	"call":    1,
+	"cqto":    0,
+	"ret":     0,
+}
+
+var conds = []string{"e", "ne", "l", "g", "le", "ge", "b", "a", "be", "ae"}
+
+func init() {
+	for _, cc := range conds {
+		arity["j"+cc] = 1
+		arity["set"+cc] = 1
+	}
+}
+
+// Parse reads functions in the form that Func.String writes.
+func Parse(src string) ([]*Func, error) {
+	var funcs []*Func
+	for i, line := range strings.Split(src, "\n") {
+		errorf := func(format string, args ...interface{}) error {
+			return &Error{Line: i + 1, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		line, comment := splitComment(line)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasSuffix(line, ":") {
+			name, err := unquote(line[:len(line)-1])
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			if !strings.HasPrefix(name, ".L") {
+				funcs = append(funcs, &Func{Name: name})
+				continue
+			}
+			if len(funcs) == 0 {
+				return nil, errorf("label %s outside a function", name)
+			}
+			fn := funcs[len(funcs)-1]
+			fn.Body = append(fn.Body, NewLabel(name))
+			continue
+		}
+
+		if len(funcs) == 0 {
+			return nil, errorf("instruction outside a function")
+		}
+		in, err := parseInstr(line, comment)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		fn := funcs[len(funcs)-1]
+		fn.Body = append(fn.Body, in)
+	}
+	return funcs, nil
+}
+
+// splitComment splits line at the comment character, if it is not quoted.
+func splitComment(line string) (string, string) {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '#' && !quoted:
+			return line[:i], line[i+1:]
+		}
+	}
+	return line, ""
+}
+
+func unquote(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+			return "", fmt.Errorf("unterminated symbol %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	}
+	if s == "" || strings.ContainsAny(s, " \t,()$%{}") {
+		return "", fmt.Errorf("malformed symbol %q", s)
+	}
+	return s, nil
+}
+
+func parseInstr(line, comment string) (*Instr, error) {
+	op, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		op, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+
+	in := &Instr{Op: op}
+	for _, s := range splitOperands(rest) {
+		arg, err := parseOperand(s)
+		if err != nil {
+			return nil, err
+		}
+		in.Args = append(in.Args, arg)
+	}
+
+	n, ok := arity[op]
+	if op == "imulq" {
+		n, ok = len(in.Args), len(in.Args) == 1 || len(in.Args) == 2
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %s", op)
+	}
+	if len(in.Args) != n {
+		return nil, fmt.Errorf("%s takes %d operands, found %d", op, n, len(in.Args))
+	}
+
+	if comment = strings.TrimSpace(comment); comment != "" && (op == "call" || op == "ret") {
+		n, err := strconv.Atoi(comment)
+		max := len(ArgRegs)
+		if op == "ret" {
+			max = len(RetRegs)
+		}
+		if err != nil || n < 0 || n > max {
+			return nil, fmt.Errorf("malformed register count %q", comment)
+		}
+		in.N = n
+	}
+	return in, nil
+}
+
+// splitOperands splits s at the commas outside parentheses and quotes.
+func splitOperands(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var list []string
+	depth, quoted, start := 0, false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			list = append(list, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(list, strings.TrimSpace(s[start:]))
+}
+
+// registers maps the names of registers and of their low bytes to them.
+var registers = make(map[string]Reg)
+
+func init() {
+	for r := range regs {
+		registers["%"+regs[r]] = Reg(r)
+		registers["%"+byteRegs[r]] = Reg(r)
+	}
+}
+
+func parseOperand(s string) (Operand, error) {
+	switch {
+	case strings.HasPrefix(s, "%"):
+		r, ok := registers[s]
+		if !ok {
+			return nil, fmt.Errorf("unknown register %s", s)
+		}
+		return r, nil
+
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") || len(s) == 2 {
+			return nil, fmt.Errorf("malformed temporary %s", s)
+		}
+		return Temp(s[1 : len(s)-1]), nil
+
+	case strings.HasPrefix(s, "$"):
+		n, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed immediate %s", s)
+		}
+		return Imm(n), nil
+
+	case s != "" && (s[0] == '-' || '0' <= s[0] && s[0] <= '9' || s[0] == '('):
+		return parseMem(s)
+	}
+
+	name, err := unquote(s)
+	if err != nil {
+		return nil, err
+	}
+	return Sym(name), nil
+}
+
+func parseMem(s string) (Operand, error) {
+	m := &Mem{}
+	disp, addr := s, ""
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return nil, fmt.Errorf("malformed memory operand %s", s)
+		}
+		disp, addr = s[:i], s[i+1:len(s)-1]
+	}
+	if disp != "" {
+		n, err := strconv.ParseInt(disp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed displacement in %s", s)
+		}
+		m.Disp = n
+	}
+	if addr == "" {
+		return m, nil
+	}
+
+	parts := strings.Split(addr, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, fmt.Errorf("malformed memory operand %s", s)
+	}
+	reg := func(s string) (Operand, error) {
+		op, err := parseOperand(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		switch op.(type) {
+		case Reg, Temp:
+			return op, nil
+		}
+		return nil, fmt.Errorf("malformed memory operand %s", s)
+	}
+
+	if strings.TrimSpace(parts[0]) != "" {
+		base, err := reg(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		m.Base = base
+	}
+	if len(parts) == 3 {
+		index, err := reg(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		m.Index = index
+		scale, err := strconv.ParseInt(strings.TrimSpace(parts[2]), 10, 64)
+		if err != nil || scale != 1 && scale != 2 && scale != 4 && scale != 8 {
+			return nil, fmt.Errorf("malformed scale in %s", s)
+		}
+		m.Scale = scale
+	}
+	return m, nil
+}
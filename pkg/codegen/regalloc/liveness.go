@@ -0,0 +1,144 @@
+package regalloc
+
+import (
+	"math/bits"
+
+	"github.com/manapointer/xi/pkg/codegen/asm"
+)
+
+// A bitset is a set of node numbers.
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (s bitset) has(i int) bool { return s[i/64]&(1<<(uint(i)%64)) != 0 }
+func (s bitset) add(i int)      { s[i/64] |= 1 << (uint(i) % 64) }
+func (s bitset) remove(i int)   { s[i/64] &^= 1 << (uint(i) % 64) }
+
+// union adds the elements of t to s and reports whether s changed.
+func (s bitset) union(t bitset) bool {
+	changed := false
+	for i, w := range t {
+		if s[i]|w != s[i] {
+			s[i] |= w
+			changed = true
+		}
+	}
+	return changed
+}
+
+// each calls f for each element of s in increasing order.
+func (s bitset) each(f func(int)) {
+	for i, w := range s {
+		for w != 0 {
+			b := bits.TrailingZeros64(w)
+			f(i*64 + b)
+			w &^= 1 << uint(b)
+		}
+	}
+}
+
+// nodes numbers the registers and temporaries of a function. The
+// allocatable registers come first, in the order of Colors.
+type nodes struct {
+	list  []asm.Operand
+	index map[asm.Operand]int
+}
+
+func newNodes(fn *asm.Func) *nodes {
+	ns := &nodes{index: make(map[asm.Operand]int)}
+	for _, r := range Colors {
+		ns.add(r)
+	}
+	for _, in := range fn.Body {
+		for _, op := range append(in.Uses(), in.Defs()...) {
+			if t, ok := op.(asm.Temp); ok {
+				ns.add(t)
+			}
+		}
+	}
+	return ns
+}
+
+func (ns *nodes) add(op asm.Operand) {
+	if _, ok := ns.index[op]; !ok {
+		ns.index[op] = len(ns.list)
+		ns.list = append(ns.list, op)
+	}
+}
+
+// ids returns the numbers of the allocatable registers and temporaries
+// among ops. %rsp and %rbp are not allocated.
+func (ns *nodes) ids(ops []asm.Operand) []int {
+	var ids []int
+	for _, op := range ops {
+		if i, ok := ns.index[op]; ok {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+// successors returns the indices of the instructions that may execute
+// after each instruction of fn.
+func successors(fn *asm.Func) [][]int {
+	labels := make(map[string]int)
+	for i, in := range fn.Body {
+		if in.IsLabel() {
+			labels[in.Label] = i
+		}
+	}
+
+	succ := make([][]int, len(fn.Body))
+	for i, in := range fn.Body {
+		if in.IsJump() {
+			if sym, ok := in.Args[0].(asm.Sym); ok {
+				if j, ok := labels[string(sym)]; ok {
+					succ[i] = append(succ[i], j)
+				}
+			}
+		}
+		if in.FallsThrough() && i+1 < len(fn.Body) {
+			succ[i] = append(succ[i], i+1)
+		}
+	}
+	return succ
+}
+
+// liveness returns the set of nodes live after each instruction of fn.
+func liveness(fn *asm.Func, ns *nodes) []bitset {
+	n := len(fn.Body)
+	succ := successors(fn)
+	uses := make([][]int, n)
+	defs := make([][]int, n)
+	in := make([]bitset, n)
+	out := make([]bitset, n)
+	for i, instr := range fn.Body {
+		uses[i] = ns.ids(instr.Uses())
+		defs[i] = ns.ids(instr.Defs())
+		in[i] = newBitset(len(ns.list))
+		out[i] = newBitset(len(ns.list))
+	}
+
+	// Iterate backwards, which visits most successors first.
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			for _, j := range succ[i] {
+				out[i].union(in[j])
+			}
+			live := newBitset(len(ns.list))
+			live.union(out[i])
+			for _, d := range defs[i] {
+				live.remove(d)
+			}
+			for _, u := range uses[i] {
+				live.add(u)
+			}
+			if in[i].union(live) {
+				changed = true
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,590 @@
+// Package regalloc assigns the temporaries of functions in abstract
+// assembly to the registers of the x86-64 and to stack slots.
+//
+// Allocate colors the interference graph built from liveness by iterated
+// register coalescing (George and Appel), which combines Chaitin's
+// simplification, Briggs's and George's conservative coalescing tests and
+// optimistic spilling. SpillAll is the trivial alternative that keeps
+// every temporary on the stack.
+package regalloc
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/codegen/asm"
+)
+
+// Colors are the allocatable registers, in order of preference. The
+// caller-saved registers come first, so that temporaries not live across
+// calls leave the callee-saved ones alone.
+var Colors = []asm.Reg{
+	asm.RAX, asm.RCX, asm.RDX, asm.RSI, asm.RDI, asm.R8, asm.R9, asm.R10, asm.R11,
+	asm.RBX, asm.R12, asm.R13, asm.R14, asm.R15,
+}
+
+// K is the number of colors.
+var K = len(Colors)
+
+// Allocate replaces the temporaries of fn with registers, rewriting those
+// that must be spilled to use stack slots, and removes the moves that
+// coalescing made redundant. fn.Slots counts the slots used.
+func Allocate(fn *asm.Func) {
+	// Temporaries introduced by spilling have tiny live ranges, and
+	// spilling them again would not help.
+	unspillable := make(map[asm.Temp]bool)
+	for {
+		a := newAllocator(fn, unspillable)
+		a.build()
+		a.makeWorklist()
+		for {
+			switch {
+			case a.any(simplifyList):
+				a.simplify()
+				continue
+			case len(a.worklistMoves()) > 0:
+				a.coalesce()
+				continue
+			case a.any(freezeList):
+				a.freeze()
+				continue
+			case a.any(spillList):
+				a.selectSpill()
+				continue
+			}
+			break
+		}
+		a.assignColors()
+
+		if spilled := a.spilled(); len(spilled) > 0 {
+			rewrite(fn, spilled, unspillable)
+			continue
+		}
+		a.apply()
+		return
+	}
+}
+
+// Node states. Each temporary is in exactly one of the worklists or sets
+// of the algorithm.
+const (
+	precolored = iota
+	initialList
+	simplifyList
+	freezeList
+	spillList
+	spilledNodes
+	coalescedNodes
+	coloredNodes
+	selectStack
+)
+
+// Move states.
+const (
+	worklistMoves = iota
+	activeMoves
+	coalescedMoves
+	constrainedMoves
+	frozenMoves
+)
+
+// A move is a register-to-register move instruction, a candidate for
+// coalescing.
+type move struct {
+	dst, src int
+	state    int
+}
+
+type allocator struct {
+	fn          *asm.Func
+	ns          *nodes
+	unspillable map[asm.Temp]bool
+
+	adjSet   map[[2]int]bool
+	adjList  [][]int
+	degree   []int
+	state    []int
+	moveList [][]int // indices into moves
+	moves    []*move
+	alias    []int
+	color    []int
+	cost     []int // number of occurrences, for choosing spills
+	stack    []int
+}
+
+func newAllocator(fn *asm.Func, unspillable map[asm.Temp]bool) *allocator {
+	ns := newNodes(fn)
+	n := len(ns.list)
+	a := &allocator{
+		fn:          fn,
+		ns:          ns,
+		unspillable: unspillable,
+		adjSet:      make(map[[2]int]bool),
+		adjList:     make([][]int, n),
+		degree:      make([]int, n),
+		state:       make([]int, n),
+		moveList:    make([][]int, n),
+		alias:       make([]int, n),
+		color:       make([]int, n),
+		cost:        make([]int, n),
+	}
+	for i := range a.state {
+		a.alias[i] = i
+		a.color[i] = -1
+		if i < K {
+			a.state[i] = precolored
+			a.color[i] = i
+			// Precolored nodes have infinite degree.
+			a.degree[i] = 1 << 30
+		} else {
+			a.state[i] = initialList
+		}
+	}
+	return a
+}
+
+func (a *allocator) isPrecolored(n int) bool { return n < K }
+
+func (a *allocator) any(state int) bool {
+	for _, s := range a.state {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the first node in the given state.
+func (a *allocator) first(state int) int {
+	for n, s := range a.state {
+		if s == state {
+			return n
+		}
+	}
+	panic("regalloc: empty worklist")
+}
+
+func (a *allocator) worklistMoves() []int {
+	var list []int
+	for i, m := range a.moves {
+		if m.state == worklistMoves {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// isMove reports whether in copies one register or temporary to another.
+func isMove(in *asm.Instr) bool {
+	if in.Op != "movq" {
+		return false
+	}
+	for _, arg := range in.Args {
+		switch arg.(type) {
+		case asm.Reg, asm.Temp:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (a *allocator) addEdge(u, v int) {
+	if u == v || a.adjSet[[2]int{u, v}] {
+		return
+	}
+	a.adjSet[[2]int{u, v}] = true
+	a.adjSet[[2]int{v, u}] = true
+	if !a.isPrecolored(u) {
+		a.adjList[u] = append(a.adjList[u], v)
+		a.degree[u]++
+	}
+	if !a.isPrecolored(v) {
+		a.adjList[v] = append(a.adjList[v], u)
+		a.degree[v]++
+	}
+}
+
+func (a *allocator) build() {
+	liveOut := liveness(a.fn, a.ns)
+	for i := len(a.fn.Body) - 1; i >= 0; i-- {
+		in := a.fn.Body[i]
+		uses := a.ns.ids(in.Uses())
+		defs := a.ns.ids(in.Defs())
+		for _, n := range append(uses, defs...) {
+			a.cost[n]++
+		}
+
+		live := newBitset(len(a.ns.list))
+		live.union(liveOut[i])
+		if isMove(in) && len(uses) == 1 && len(defs) == 1 {
+			// The source and destination of a move need not interfere.
+			live.remove(uses[0])
+			m := len(a.moves)
+			a.moves = append(a.moves, &move{dst: defs[0], src: uses[0]})
+			a.moveList[uses[0]] = append(a.moveList[uses[0]], m)
+			if defs[0] != uses[0] {
+				a.moveList[defs[0]] = append(a.moveList[defs[0]], m)
+			}
+		}
+		for _, d := range defs {
+			live.add(d)
+		}
+		for _, d := range defs {
+			live.each(func(l int) { a.addEdge(l, d) })
+		}
+	}
+}
+
+// adjacent returns the current neighbours of n.
+func (a *allocator) adjacent(n int) []int {
+	var list []int
+	for _, m := range a.adjList[n] {
+		if a.state[m] != selectStack && a.state[m] != coalescedNodes {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// nodeMoves returns the moves of n that may still be coalesced.
+func (a *allocator) nodeMoves(n int) []int {
+	var list []int
+	for _, m := range a.moveList[n] {
+		if s := a.moves[m].state; s == activeMoves || s == worklistMoves {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+func (a *allocator) moveRelated(n int) bool { return len(a.nodeMoves(n)) > 0 }
+
+func (a *allocator) makeWorklist() {
+	for n, s := range a.state {
+		if s != initialList {
+			continue
+		}
+		switch {
+		case a.degree[n] >= K:
+			a.state[n] = spillList
+		case a.moveRelated(n):
+			a.state[n] = freezeList
+		default:
+			a.state[n] = simplifyList
+		}
+	}
+}
+
+func (a *allocator) simplify() {
+	n := a.first(simplifyList)
+	a.state[n] = selectStack
+	a.stack = append(a.stack, n)
+	for _, m := range a.adjacent(n) {
+		a.decrementDegree(m)
+	}
+}
+
+func (a *allocator) decrementDegree(m int) {
+	if a.isPrecolored(m) {
+		return
+	}
+	d := a.degree[m]
+	a.degree[m]--
+	if d == K {
+		a.enableMoves(append(a.adjacent(m), m))
+		if a.state[m] == spillList {
+			if a.moveRelated(m) {
+				a.state[m] = freezeList
+			} else {
+				a.state[m] = simplifyList
+			}
+		}
+	}
+}
+
+func (a *allocator) enableMoves(nodes []int) {
+	for _, n := range nodes {
+		for _, m := range a.nodeMoves(n) {
+			if a.moves[m].state == activeMoves {
+				a.moves[m].state = worklistMoves
+			}
+		}
+	}
+}
+
+func (a *allocator) coalesce() {
+	m := a.moves[a.worklistMoves()[0]]
+	x, y := a.getAlias(m.dst), a.getAlias(m.src)
+	u, v := x, y
+	if a.isPrecolored(y) {
+		u, v = y, x
+	}
+
+	switch {
+	case u == v:
+		m.state = coalescedMoves
+		a.addWorklist(u)
+	case a.isPrecolored(v) || a.adjSet[[2]int{u, v}]:
+		m.state = constrainedMoves
+		a.addWorklist(u)
+		a.addWorklist(v)
+	case a.isPrecolored(u) && a.george(u, v) || !a.isPrecolored(u) && a.briggs(u, v):
+		m.state = coalescedMoves
+		a.combine(u, v)
+		a.addWorklist(u)
+	default:
+		m.state = activeMoves
+	}
+}
+
+func (a *allocator) addWorklist(u int) {
+	if !a.isPrecolored(u) && !a.moveRelated(u) && a.degree[u] < K {
+		a.state[u] = simplifyList
+	}
+}
+
+// george reports whether every neighbour of v already interferes with the
+// precolored node u or is of insignificant degree.
+func (a *allocator) george(u, v int) bool {
+	for _, t := range a.adjacent(v) {
+		if !(a.degree[t] < K || a.isPrecolored(t) || a.adjSet[[2]int{t, u}]) {
+			return false
+		}
+	}
+	return true
+}
+
+// briggs reports whether the node combining u and v would have fewer than
+// K neighbours of significant degree.
+func (a *allocator) briggs(u, v int) bool {
+	seen := make(map[int]bool)
+	k := 0
+	for _, t := range append(a.adjacent(u), a.adjacent(v)...) {
+		if !seen[t] {
+			seen[t] = true
+			if a.degree[t] >= K {
+				k++
+			}
+		}
+	}
+	return k < K
+}
+
+func (a *allocator) getAlias(n int) int {
+	for a.state[n] == coalescedNodes {
+		n = a.alias[n]
+	}
+	return n
+}
+
+func (a *allocator) combine(u, v int) {
+	a.state[v] = coalescedNodes
+	a.alias[v] = u
+	a.moveList[u] = append(a.moveList[u], a.moveList[v]...)
+	a.enableMoves([]int{v})
+	for _, t := range a.adjacent(v) {
+		a.addEdge(t, u)
+		a.decrementDegree(t)
+	}
+	if a.degree[u] >= K && a.state[u] == freezeList {
+		a.state[u] = spillList
+	}
+}
+
+func (a *allocator) freeze() {
+	u := a.first(freezeList)
+	a.state[u] = simplifyList
+	a.freezeMoves(u)
+}
+
+func (a *allocator) freezeMoves(u int) {
+	for _, i := range a.nodeMoves(u) {
+		m := a.moves[i]
+		v := a.getAlias(m.src)
+		if v == a.getAlias(u) {
+			v = a.getAlias(m.dst)
+		}
+		m.state = frozenMoves
+		if a.state[v] == freezeList && !a.moveRelated(v) {
+			a.state[v] = simplifyList
+		}
+	}
+}
+
+// selectSpill chooses a node to spill optimistically: the one with the
+// fewest occurrences per neighbour that did not come from spilling.
+func (a *allocator) selectSpill() {
+	best := -1
+	for n, s := range a.state {
+		if s != spillList {
+			continue
+		}
+		if best < 0 || a.spillable(n) && !a.spillable(best) ||
+			a.spillable(n) == a.spillable(best) && a.cost[n]*a.degree[best] < a.cost[best]*a.degree[n] {
+			best = n
+		}
+	}
+	a.state[best] = simplifyList
+	a.freezeMoves(best)
+}
+
+func (a *allocator) spillable(n int) bool {
+	return !a.unspillable[a.ns.list[n].(asm.Temp)]
+}
+
+func (a *allocator) assignColors() {
+	for len(a.stack) > 0 {
+		n := a.stack[len(a.stack)-1]
+		a.stack = a.stack[:len(a.stack)-1]
+
+		ok := make([]bool, K)
+		for i := range ok {
+			ok[i] = true
+		}
+		for _, w := range a.adjList[n] {
+			w = a.getAlias(w)
+			if s := a.state[w]; s == coloredNodes || s == precolored {
+				ok[a.color[w]] = false
+			}
+		}
+
+		a.state[n] = spilledNodes
+		for c := range ok {
+			if ok[c] {
+				a.state[n] = coloredNodes
+				a.color[n] = c
+				break
+			}
+		}
+	}
+	for n, s := range a.state {
+		if s == coalescedNodes {
+			a.color[n] = a.color[a.getAlias(n)]
+		}
+	}
+}
+
+func (a *allocator) spilled() []asm.Temp {
+	var list []asm.Temp
+	for n, s := range a.state {
+		if s == spilledNodes {
+			list = append(list, a.ns.list[n].(asm.Temp))
+		}
+	}
+	return list
+}
+
+// apply replaces the temporaries of the function with their colors.
+func (a *allocator) apply() {
+	reg := func(op asm.Operand) asm.Operand {
+		if t, ok := op.(asm.Temp); ok {
+			return Colors[a.color[a.ns.index[t]]]
+		}
+		return op
+	}
+
+	var body []*asm.Instr
+	for _, in := range a.fn.Body {
+		in = replace(in, reg)
+		if isMove(in) && in.Args[0] == in.Args[1] {
+			continue
+		}
+		body = append(body, in)
+	}
+	a.fn.Body = body
+}
+
+// replace returns a copy of in with f applied to its registers and
+// temporaries, including those of its memory operands.
+func replace(in *asm.Instr, f func(asm.Operand) asm.Operand) *asm.Instr {
+	out := &asm.Instr{Op: in.Op, Label: in.Label, N: in.N}
+	for _, arg := range in.Args {
+		switch x := arg.(type) {
+		case *asm.Mem:
+			m := *x
+			if m.Base != nil {
+				m.Base = f(m.Base)
+			}
+			if m.Index != nil {
+				m.Index = f(m.Index)
+			}
+			arg = &m
+		case asm.Reg, asm.Temp:
+			arg = f(x)
+		}
+		out.Args = append(out.Args, arg)
+	}
+	return out
+}
+
+// rewrite gives each spilled temporary a stack slot. Instructions that
+// use or define one go through a new temporary, which is loaded before
+// them and stored after; moves access the slot directly.
+func rewrite(fn *asm.Func, spilled []asm.Temp, unspillable map[asm.Temp]bool) {
+	slots := make(map[asm.Temp]*asm.Mem)
+	for _, t := range spilled {
+		slots[t] = asm.Slot(fn.Slots)
+		fn.Slots++
+	}
+
+	var body []*asm.Instr
+	n := 0
+	for _, in := range fn.Body {
+		if in.Op == "movq" {
+			if slot, ok := spilledOperand(in.Args[0], slots); ok {
+				if _, mem := in.Args[1].(*asm.Mem); !mem {
+					in = asm.New("movq", slot, in.Args[1])
+				}
+			}
+			if slot, ok := spilledOperand(in.Args[1], slots); ok {
+				if _, mem := in.Args[0].(*asm.Mem); !mem {
+					in = asm.New("movq", in.Args[0], slot)
+				}
+			}
+		}
+
+		// orig maps the new temporaries of the instruction to the ones
+		// they replace.
+		orig := make(map[asm.Temp]asm.Temp)
+		temps := make(map[asm.Temp]asm.Temp)
+		in = replace(in, func(op asm.Operand) asm.Operand {
+			t, ok := op.(asm.Temp)
+			if _, spilled := slots[t]; !ok || !spilled {
+				return op
+			}
+			if _, ok := temps[t]; !ok {
+				n++
+				temps[t] = asm.Temp(fmt.Sprintf("%s.%d", t, n))
+				orig[temps[t]] = t
+				unspillable[temps[t]] = true
+			}
+			return temps[t]
+		})
+
+		loaded := make(map[asm.Temp]bool)
+		for _, op := range in.Uses() {
+			if t, ok := op.(asm.Temp); ok && orig[t] != "" && !loaded[t] {
+				loaded[t] = true
+				body = append(body, asm.New("movq", slots[orig[t]], t))
+			}
+		}
+		body = append(body, in)
+		stored := make(map[asm.Temp]bool)
+		for _, op := range in.Defs() {
+			if t, ok := op.(asm.Temp); ok && orig[t] != "" && !stored[t] {
+				stored[t] = true
+				body = append(body, asm.New("movq", t, slots[orig[t]]))
+			}
+		}
+	}
+	fn.Body = body
+}
+
+func spilledOperand(op asm.Operand, slots map[asm.Temp]*asm.Mem) (*asm.Mem, bool) {
+	t, ok := op.(asm.Temp)
+	if !ok {
+		return nil, false
+	}
+	slot, ok := slots[t]
+	return slot, ok
+}
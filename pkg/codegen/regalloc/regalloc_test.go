@@ -0,0 +1,328 @@
+package regalloc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/codegen/asm"
+)
+
+// A machine executes abstract assembly, temporaries included, so that a
+// function can be run before and after allocation. The only function it
+// calls is "add", which returns the sum of its two arguments and
+// overwrites the other caller-saved registers.
+type machine struct {
+	regs  [16]int64
+	temps map[asm.Temp]int64
+	mem   map[int64]int64
+	cmp   [2]int64 // operands of the last cmpq
+	steps int
+}
+
+func (m *machine) addr(x *asm.Mem) int64 {
+	a := x.Disp
+	if x.Base != nil {
+		a += m.get(x.Base)
+	}
+	if x.Index != nil {
+		a += m.get(x.Index) * x.Scale
+	}
+	return a
+}
+
+func (m *machine) get(op asm.Operand) int64 {
+	switch x := op.(type) {
+	case asm.Reg:
+		return m.regs[x]
+	case asm.Temp:
+		return m.temps[x]
+	case asm.Imm:
+		return int64(x)
+	case *asm.Mem:
+		return m.mem[m.addr(x)]
+	}
+	panic(fmt.Sprintf("cannot read %s", op))
+}
+
+func (m *machine) set(op asm.Operand, v int64) {
+	switch x := op.(type) {
+	case asm.Reg:
+		m.regs[x] = v
+	case asm.Temp:
+		m.temps[x] = v
+	case *asm.Mem:
+		m.mem[m.addr(x)] = v
+	default:
+		panic(fmt.Sprintf("cannot write %s", op))
+	}
+}
+
+// run calls fn with the given arguments and returns %rax. The
+// callee-saved registers hold distinctive values, which must survive.
+func run(t *testing.T, fn *asm.Func, args ...int64) int64 {
+	t.Helper()
+
+	m := &machine{temps: make(map[asm.Temp]int64), mem: make(map[int64]int64)}
+	for i, r := range asm.CalleeSaved {
+		m.regs[r] = int64(1000 + i)
+	}
+	for i, arg := range args {
+		m.regs[asm.ArgRegs[i]] = arg
+	}
+	// The frame of the function, as set up by its prologue.
+	m.regs[asm.RBP] = 1 << 20
+	m.regs[asm.RSP] = m.regs[asm.RBP] - int64(fn.Slots+fn.Frame)*asm.WordSize
+
+	labels := make(map[string]int)
+	for i, in := range fn.Body {
+		if in.IsLabel() {
+			labels[in.Label] = i
+		}
+	}
+
+	for pc := 0; pc < len(fn.Body); pc++ {
+		if m.steps++; m.steps > 100000 {
+			t.Fatalf("%s does not return", fn.Name)
+		}
+		in := fn.Body[pc]
+		jump := func(cond bool) {
+			if cond {
+				pc = labels[string(in.Args[0].(asm.Sym))]
+			}
+		}
+
+		switch in.Op {
+		case "":
+		case "movq":
+			m.set(in.Args[1], m.get(in.Args[0]))
+		case "addq":
+			m.set(in.Args[1], m.get(in.Args[1])+m.get(in.Args[0]))
+		case "subq":
+			m.set(in.Args[1], m.get(in.Args[1])-m.get(in.Args[0]))
+		case "imulq":
+			m.set(in.Args[1], m.get(in.Args[1])*m.get(in.Args[0]))
+		case "cmpq":
+			m.cmp = [2]int64{m.get(in.Args[1]), m.get(in.Args[0])}
+		case "jmp":
+			jump(true)
+		case "jl":
+			jump(m.cmp[0] < m.cmp[1])
+		case "jne":
+			jump(m.cmp[0] != m.cmp[1])
+		case "call":
+			sum := m.regs[asm.RDI] + m.regs[asm.RSI]
+			for _, r := range asm.CallerSaved {
+				m.regs[r] = -1
+			}
+			m.regs[asm.RAX] = sum
+		case "ret":
+			for i, r := range asm.CalleeSaved {
+				if m.regs[r] != int64(1000+i) {
+					t.Errorf("%s does not preserve %s", fn.Name, r)
+				}
+			}
+			return m.regs[asm.RAX]
+		default:
+			t.Fatalf("cannot execute %s", in)
+		}
+	}
+	t.Fatalf("%s does not return", fn.Name)
+	return 0
+}
+
+// prologue saves the callee-saved registers in temporaries, as
+// instruction selection does, and epilogue restores them.
+func prologue() string {
+	var b strings.Builder
+	for _, r := range asm.CalleeSaved {
+		fmt.Fprintf(&b, "\tmovq %s, {save%s}\n", r, r.String()[1:])
+	}
+	return b.String()
+}
+
+func epilogue() string {
+	var b strings.Builder
+	for _, r := range asm.CalleeSaved {
+		fmt.Fprintf(&b, "\tmovq {save%s}, %s\n", r.String()[1:], r)
+	}
+	b.WriteString("\tret # 1\n")
+	return b.String()
+}
+
+// pressure returns a function that keeps n temporaries live at once.
+func pressure(n int) string {
+	var b strings.Builder
+	b.WriteString("pressure:\n" + prologue())
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "\tmovq %%rdi, {x%d}\n\taddq $%d, {x%d}\n", i, i, i)
+	}
+	b.WriteString("\tmovq $0, {sum}\n")
+	for i := n - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "\timulq $3, {sum}\n\taddq {x%d}, {sum}\n", i)
+	}
+	b.WriteString("\tmovq {sum}, %rax\n" + epilogue())
+	return b.String()
+}
+
+// pressureSum returns the result of pressure(n) for the argument x.
+func pressureSum(n int, x int64) int64 {
+	sum := int64(0)
+	for i := n - 1; i >= 0; i-- {
+		sum = sum*3 + x + int64(i)
+	}
+	return sum
+}
+
+var programs = []struct {
+	name string
+	src  string
+	args []int64
+	want int64
+}{
+	{"coalesce", `f:
+	movq %rdi, {a}
+	movq {a}, {b}
+	addq $1, {b}
+	movq {b}, %rax
+	ret # 1
+`, []int64{41}, 42},
+	{"loop", "sum:\n" + prologue() + `	movq %rdi, {n}
+	movq $0, {s}
+	movq $0, {i}
+.Lloop:
+	cmpq {n}, {i}
+	jl .Lbody
+	jmp .Ldone
+.Lbody:
+	movq {s}, {t}
+	addq {i}, {t}
+	movq {t}, {s}
+	movq {i}, {j}
+	addq $1, {j}
+	movq {j}, {i}
+	jmp .Lloop
+.Ldone:
+	movq {s}, %rax
+` + epilogue(), []int64{10}, 45},
+	{"calls", "calls:\n" + prologue() + `	movq %rdi, {a}
+	movq %rsi, {b}
+	movq {a}, %rdi
+	movq {b}, %rsi
+	call add # 2
+	movq %rax, {c}
+	movq {c}, %rdi
+	movq {a}, %rsi
+	call add # 2
+	movq %rax, {d}
+	movq {d}, {e}
+	subq {b}, {e}
+	movq {e}, %rax
+` + epilogue(), []int64{5, 7}, 10},
+	{"low pressure", pressure(5), []int64{1}, pressureSum(5, 1)},
+	{"high pressure", pressure(30), []int64{-7}, pressureSum(30, -7)},
+}
+
+func parse(t *testing.T, src string) *asm.Func {
+	t.Helper()
+	funcs, err := asm.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return funcs[0]
+}
+
+func TestPrograms(t *testing.T) {
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			want := test.want
+			if got := run(t, parse(t, test.src), test.args...); got != want {
+				t.Fatalf("before allocation: got %d, expected %d", got, want)
+			}
+
+			for _, alloc := range []struct {
+				name string
+				f    func(*asm.Func)
+			}{{"Allocate", Allocate}, {"SpillAll", SpillAll}} {
+				fn := parse(t, test.src)
+				alloc.f(fn)
+				for _, in := range fn.Body {
+					for _, op := range append(in.Uses(), in.Defs()...) {
+						if _, ok := op.(asm.Temp); ok {
+							t.Fatalf("%s: temporary %s remains in %s", alloc.name, op, in)
+						}
+					}
+				}
+				if got := run(t, fn, test.args...); got != want {
+					t.Errorf("%s: got %d, expected %d in\n%s", alloc.name, got, want, fn)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	fn := parse(t, programs[0].src)
+	Allocate(fn)
+	want := `f:
+	movq %rdi, %rax
+	addq $1, %rax
+	ret # 1
+`
+	if fn.String() != want {
+		t.Errorf("got\n%s\nexpected\n%s", fn, want)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	for _, n := range []int{5, 8, 9, 13, 30} {
+		// The temporaries live at once are the n values, their sum and
+		// the saved callee-saved registers.
+		live := n + 1 + len(asm.CalleeSaved)
+		want := 0
+		if live > K {
+			want = live - K
+		}
+
+		fn := parse(t, pressure(n))
+		Allocate(fn)
+		if fn.Slots != want {
+			t.Errorf("%d live temporaries: got %d slots, expected %d", live, fn.Slots, want)
+		}
+
+		// Spilling everything costs a slot per temporary.
+		all := parse(t, pressure(n))
+		SpillAll(all)
+		if all.Slots != live {
+			t.Errorf("%d live temporaries: SpillAll used %d slots", live, all.Slots)
+		}
+		if len(all.Body) <= len(fn.Body) {
+			t.Errorf("%d live temporaries: SpillAll gave %d instructions, Allocate %d", live, len(all.Body), len(fn.Body))
+		}
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	fn := parse(t, programs[1].src)
+	ns := newNodes(fn)
+	out := liveness(fn, ns)
+
+	names := func(s bitset) string {
+		var list []string
+		s.each(func(i int) {
+			if _, ok := ns.list[i].(asm.Temp); ok {
+				list = append(list, ns.list[i].String())
+			}
+		})
+		return strings.Join(list, " ")
+	}
+	for i, in := range fn.Body {
+		if in.IsLabel() && in.Label == ".Lbody" {
+			// Live after the label: everything but the dead copies.
+			want := "{saverbx} {saver12} {saver13} {saver14} {saver15} {n} {s} {i}"
+			if got := names(out[i]); got != want {
+				t.Errorf("live after .Lbody: got %s, expected %s", got, want)
+			}
+		}
+	}
+}
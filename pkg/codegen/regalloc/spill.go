@@ -0,0 +1,83 @@
+package regalloc
+
+import "github.com/manapointer/xi/pkg/codegen/asm"
+
+// scratch are the registers that hold spilled temporaries for the
+// duration of one instruction.
+var scratch = []asm.Reg{asm.R10, asm.R11, asm.RBX}
+
+// SpillAll allocates every temporary of fn to its own stack slot. Each
+// instruction loads the temporaries it uses into scratch registers and
+// stores those it defines afterwards.
+//
+// An instruction may refer to at most three temporaries, and values may
+// not be kept in the scratch registers %r10, %r11 and %rbx from one
+// instruction to the next, except that %rbx may be saved in a temporary
+// on entry and restored before returning.
+func SpillAll(fn *asm.Func) {
+	slots := make(map[asm.Temp]int)
+	slot := func(t asm.Temp) *asm.Mem {
+		i, ok := slots[t]
+		if !ok {
+			i = fn.Slots + len(slots)
+			slots[t] = i
+		}
+		return asm.Slot(i)
+	}
+
+	var out []*asm.Instr
+	for _, in := range fn.Body {
+		regs := make(map[asm.Temp]asm.Reg)
+		free := freeScratch(in)
+		assign := func(op asm.Operand) asm.Operand {
+			t, ok := op.(asm.Temp)
+			if !ok {
+				return op
+			}
+			r, ok := regs[t]
+			if !ok {
+				r = free[len(regs)]
+				regs[t] = r
+			}
+			return r
+		}
+
+		rewritten := replace(in, assign)
+
+		loaded := make(map[asm.Temp]bool)
+		for _, op := range in.Uses() {
+			if t, ok := op.(asm.Temp); ok && !loaded[t] {
+				loaded[t] = true
+				out = append(out, asm.New("movq", slot(t), regs[t]))
+			}
+		}
+		out = append(out, rewritten)
+		stored := make(map[asm.Temp]bool)
+		for _, op := range in.Defs() {
+			if t, ok := op.(asm.Temp); ok && !stored[t] {
+				stored[t] = true
+				out = append(out, asm.New("movq", regs[t], slot(t)))
+			}
+		}
+	}
+
+	fn.Body = out
+	fn.Slots += len(slots)
+}
+
+// freeScratch returns the scratch registers that in does not name.
+func freeScratch(in *asm.Instr) []asm.Reg {
+	named := make(map[asm.Reg]bool)
+	for _, op := range append(in.Uses(), in.Defs()...) {
+		if r, ok := op.(asm.Reg); ok {
+			named[r] = true
+		}
+	}
+	var free []asm.Reg
+	for _, r := range scratch {
+		if !named[r] {
+			free = append(free, r)
+		}
+	}
+	return free
+}
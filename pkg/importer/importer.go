@@ -0,0 +1,87 @@
+// Package importer implements types.Importer by loading interface (.ixi)
+// files from a list of directories and from the bundled standard library.
+package importer
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// Ext is the file name extension of interface files.
+const Ext = ".ixi"
+
+// An Importer resolves use declarations against a library path. Each
+// library is loaded at most once; later imports return the same
+// *types.Library, or the same error.
+type Importer struct {
+	fset *token.FileSet
+	path []string
+
+	libs map[string]*result
+}
+
+type result struct {
+	lib *types.Library
+	err error
+}
+
+// New returns an importer that looks for a library named foo in the file
+// foo.ixi in each directory of path, in order, and finally among the
+// interfaces bundled with the standard library. Interface files are parsed
+// into fset, so that positions of imported functions can be resolved.
+func New(fset *token.FileSet, path []string) *Importer {
+	return &Importer{fset: fset, path: path, libs: make(map[string]*result)}
+}
+
+// Import implements types.Importer.
+func (imp *Importer) Import(name string) (*types.Library, error) {
+	if r, ok := imp.libs[name]; ok {
+		return r.lib, r.err
+	}
+
+	lib, err := imp.load(name)
+	imp.libs[name] = &result{lib, err}
+	return lib, err
+}
+
+func (imp *Importer) load(name string) (*types.Library, error) {
+	filename, src, err := imp.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	iface, err := parser.ParseInterface(imp.fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return types.CheckInterface(imp.fset, name, iface, nil)
+}
+
+// StdlibDir is the directory name recorded in positions for the interface
+// files of the standard library, which do not exist on disk.
+const StdlibDir = "<stdlib>"
+
+// find returns the name and content of the first interface file for name
+// on the library path.
+func (imp *Importer) find(name string) (string, []byte, error) {
+	for _, dir := range imp.path {
+		filename := filepath.Join(dir, name+Ext)
+		if src, err := ioutil.ReadFile(filename); err == nil {
+			return filename, src, nil
+		}
+	}
+
+	if src, err := fs.ReadFile(stdlib.Interfaces, name+Ext); err == nil {
+		return StdlibDir + "/" + name + Ext, src, nil
+	}
+
+	return "", nil, fmt.Errorf("cannot find %s%s in library path [%s]", name, Ext, strings.Join(imp.path, string(filepath.ListSeparator)))
+}
@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+func TestImport(t *testing.T) {
+	fset := token.NewFileSet()
+	imp := New(fset, []string{filepath.Join("testdata", "lib")})
+
+	lib, err := imp.Import("conv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var funcs []string
+	for _, fn := range lib.Funcs() {
+		funcs = append(funcs, fn.Name()+" "+types.TypeString(fn.Type())+" "+fset.Position(fn.Position()).String())
+	}
+	want := "parseInt function (int[]) (int,bool) testdata/lib/conv.ixi:1:1, " +
+		"unparseInt function (int) (int[]) testdata/lib/conv.ixi:2:1"
+	if got := strings.Join(funcs, ", "); got != want {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+
+	if again, _ := imp.Import("conv"); again != lib {
+		t.Error("library was loaded twice")
+	}
+}
+
+func TestImportPathOrder(t *testing.T) {
+	imp := New(token.NewFileSet(), []string{filepath.Join("testdata", "override"), filepath.Join("testdata", "lib")})
+
+	lib, err := imp.Import("io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lib.Scope().Names(); len(got) != 1 || got[0] != "print" {
+		t.Errorf("got %v, expected the override directory to win", got)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	imp := New(token.NewFileSet(), []string{filepath.Join("testdata", "lib")})
+
+	if _, err := imp.Import("missing"); err == nil || !strings.Contains(err.Error(), "cannot find missing.ixi") {
+		t.Errorf("got %v, expected a not found error", err)
+	}
+	if _, err := imp.Import("broken"); err == nil || !strings.Contains(err.Error(), "array size not permitted here") {
+		t.Errorf("got %v, expected a type error from the interface", err)
+	}
+}
+
+func TestCheckWithImporter(t *testing.T) {
+	src := `use io
+use conv
+
+main(args: int[][]) {
+	n:int, ok:bool = parseInt(readln())
+	if (ok) println(unparseInt(n + 1))
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &types.Config{Importer: New(fset, []string{filepath.Join("testdata", "lib")})}
+	if _, err := types.Check(fset, []*ast.File{f}, conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportStdlib(t *testing.T) {
+	fset := token.NewFileSet()
+	imp := New(fset, nil)
+
+	lib, err := imp.Import("io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"eof", "getchar", "print", "println", "readln"}
+	if got := lib.Scope().Names(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+	if got := fset.Position(lib.Scope().Lookup("print").Position()).Filename; got != StdlibDir+"/io.ixi" {
+		t.Errorf("got filename %s, expected %s/io.ixi", got, StdlibDir)
+	}
+}
@@ -0,0 +1,406 @@
+// Package interp implements a tree-walking interpreter for Xi programs. It
+// evaluates the syntax tree directly and serves as the reference semantics
+// for the compiler backends.
+package interp
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/arith"
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/lit"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// An Extern implements a function that is declared in an interface file
+// rather than in the program.
+type Extern func(args []Value) ([]Value, error)
+
+// A RuntimeError is an error that aborts execution, such as an array index
+// out of bounds.
+type RuntimeError struct {
+	Pos token.Position
+	Msg string
+}
+
+func (err *RuntimeError) Error() string {
+	if err.Pos.IsValid() {
+		return fmt.Sprintf("%s: runtime error: %s", err.Pos, err.Msg)
+	}
+	return "runtime error: " + err.Msg
+}
+
+// An Interpreter executes the functions of a single Xi file. The file must
+// have been type-checked without errors; the interpreter relies on it.
+type Interpreter struct {
+	fset    *token.FileSet
+	funcs   map[string]*ast.FuncDecl
+	externs map[string]Extern
+}
+
+// New returns an interpreter for file, which must have been parsed using
+// fset.
+func New(fset *token.FileSet, file *ast.File) *Interpreter {
+	in := &Interpreter{
+		fset:    fset,
+		funcs:   make(map[string]*ast.FuncDecl),
+		externs: make(map[string]Extern),
+	}
+	for _, decl := range file.FuncDecls {
+		in.funcs[decl.Name.Name] = decl
+	}
+	return in
+}
+
+// Bind provides the implementation of an imported function. Functions
+// declared in the file take precedence over bound ones.
+func (in *Interpreter) Bind(name string, fn Extern) {
+	in.externs[name] = fn
+}
+
+// Call calls the named function and returns its results. Run-time errors
+// are returned as a *RuntimeError.
+func (in *Interpreter) Call(name string, args ...Value) (results []Value, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			rerr, ok := e.(*RuntimeError)
+			if !ok {
+				panic(e)
+			}
+			err = rerr
+		}
+	}()
+
+	return in.call(token.NoPos, name, args), nil
+}
+
+// Main calls main(args: int[][]) with the given command-line arguments.
+// It reports an error if main is declared with a different signature.
+func (in *Interpreter) Main(args []string) error {
+	if decl, ok := in.funcs["main"]; ok && !isMainSignature(decl) {
+		return fmt.Errorf("%s: main must be declared as main(args: int[][])", in.fset.Position(decl.Pos()))
+	}
+
+	argv := &Array{Elems: make([]Value, len(args))}
+	for i, arg := range args {
+		argv.Elems[i] = NewString(arg)
+	}
+
+	_, err := in.Call("main", argv)
+	return err
+}
+
+func isMainSignature(decl *ast.FuncDecl) bool {
+	if len(decl.Args) != 1 || len(decl.Results) != 0 {
+		return false
+	}
+	outer, ok := decl.Args[0].Type.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	inner, ok := outer.Elt.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	elt, ok := inner.Elt.(*ast.PrimitiveType)
+	return ok && elt.Kind == token.Int
+}
+
+func (in *Interpreter) errorf(pos token.Pos, format string, args ...interface{}) {
+	panic(&RuntimeError{Pos: in.fset.Position(pos), Msg: fmt.Sprintf(format, args...)})
+}
+
+// A frame holds the variables of one function activation. Xi forbids
+// shadowing, so a single map suffices: a name can only be reused after the
+// block declaring it has ended, and then the old variable is dead.
+type frame map[string]Value
+
+func (in *Interpreter) call(pos token.Pos, name string, args []Value) []Value {
+	decl, ok := in.funcs[name]
+	if !ok {
+		if fn, ok := in.externs[name]; ok {
+			results, err := fn(args)
+			if err != nil {
+				in.errorf(pos, "%s: %v", name, err)
+			}
+			return results
+		}
+		in.errorf(pos, "function %s has no implementation", name)
+	}
+
+	f := make(frame)
+	for i, arg := range decl.Args {
+		f[arg.Name.Name] = args[i]
+	}
+
+	if results, returned := in.block(f, decl.Body.List); returned {
+		return results
+	}
+	return nil
+}
+
+// block executes a list of statements. If one of them returns, block
+// reports the returned values.
+func (in *Interpreter) block(f frame, list []ast.Stmt) ([]Value, bool) {
+	for _, stmt := range list {
+		if results, returned := in.stmt(f, stmt); returned {
+			return results, true
+		}
+	}
+	return nil, false
+}
+
+func (in *Interpreter) stmt(f frame, stmt ast.Stmt) ([]Value, bool) {
+	switch s := stmt.(type) {
+	case *ast.SingleDeclStmt:
+		if s.Init != nil {
+			f[s.Spec.Name.Name] = in.expr(f, s.Init)
+		} else {
+			f[s.Spec.Name.Name] = in.zero(f, s.Spec.Type)
+		}
+	case *ast.MultiDeclStmt:
+		results := in.callExpr(f, s.Init)
+		for i, assignable := range s.Assignables {
+			if spec, ok := assignable.(*ast.Spec); ok {
+				f[spec.Name.Name] = results[i]
+			}
+		}
+	case *ast.AssignStmt:
+		in.assign(f, s)
+	case *ast.CallExpr:
+		in.callExpr(f, s)
+	case *ast.IfStmt:
+		if in.expr(f, s.Cond).(bool) {
+			return in.stmt(f, s.Then)
+		} else if s.Else != nil {
+			return in.stmt(f, s.Else)
+		}
+	case *ast.WhileStmt:
+		for in.expr(f, s.Cond).(bool) {
+			if results, returned := in.stmt(f, s.Body); returned {
+				return results, true
+			}
+		}
+	case *ast.ReturnStmt:
+		results := make([]Value, len(s.Values))
+		for i, value := range s.Values {
+			results[i] = in.expr(f, value)
+		}
+		return results, true
+	case *ast.BlockStmt:
+		return in.block(f, s.List)
+	default:
+		in.errorf(stmt.Pos(), "cannot execute %T", stmt)
+	}
+	return nil, false
+}
+
+func (in *Interpreter) assign(f frame, s *ast.AssignStmt) {
+	switch lhs := s.Lhs.(type) {
+	case *ast.Ident:
+		f[lhs.Name] = in.expr(f, s.Rhs)
+	case *ast.SubscriptExpr:
+		// The array and index are evaluated before the right-hand side.
+		arr := in.expr(f, lhs.Lhs).(*Array)
+		index := in.expr(f, lhs.Subscript).(int64)
+		value := in.expr(f, s.Rhs)
+		arr.Elems[in.checkIndex(lhs, arr, index)] = value
+	default:
+		in.errorf(s.Pos(), "cannot assign to %T", s.Lhs)
+	}
+}
+
+// zero returns the initial value of a variable declared with typ and no
+// initializer. Sized dimensions are allocated; everything else starts as 0,
+// false or an empty array.
+func (in *Interpreter) zero(f frame, typ ast.Type) Value {
+	// Brackets nest innermost-first in the syntax tree, so the dimensions
+	// are collected and reversed to get them in source order. The sizes
+	// are evaluated once each, from left to right.
+	var sizes []ast.Expr
+	for {
+		t, ok := typ.(*ast.ArrayType)
+		if !ok {
+			break
+		}
+		sizes = append(sizes, t.Size)
+		typ = t.Elt
+	}
+
+	var dims []int64
+	for i := len(sizes) - 1; i >= 0 && sizes[i] != nil; i-- {
+		n := in.expr(f, sizes[i]).(int64)
+		if n < 0 {
+			in.errorf(sizes[i].Pos(), "negative array size %d", n)
+		}
+		dims = append(dims, n)
+	}
+
+	var elem Value = int64(0)
+	if t, ok := typ.(*ast.PrimitiveType); ok && t.Kind == token.Bool {
+		elem = false
+	}
+	return alloc(dims, len(sizes), elem)
+}
+
+// alloc allocates an array with n dimensions, the first len(dims) of which
+// are sized.
+func alloc(dims []int64, n int, elem Value) Value {
+	switch {
+	case n == 0:
+		return elem
+	case len(dims) == 0:
+		return &Array{}
+	}
+
+	arr := &Array{Elems: make([]Value, dims[0])}
+	for i := range arr.Elems {
+		arr.Elems[i] = alloc(dims[1:], n-1, elem)
+	}
+	return arr
+}
+
+func (in *Interpreter) checkIndex(x *ast.SubscriptExpr, arr *Array, index int64) int64 {
+	if index < 0 || index >= int64(len(arr.Elems)) {
+		in.errorf(x.Lbrack, "array index %d out of bounds [0:%d]", index, len(arr.Elems))
+	}
+	return index
+}
+
+func (in *Interpreter) callExpr(f frame, call *ast.CallExpr) []Value {
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = in.expr(f, arg)
+	}
+	return in.call(call.Pos(), call.Func.Name, args)
+}
+
+func (in *Interpreter) expr(f frame, expr ast.Expr) Value {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		return in.basicLit(x)
+	case *ast.Ident:
+		return f[x.Name]
+	case *ast.ArrayLit:
+		arr := &Array{Elems: make([]Value, len(x.Elts))}
+		for i, elt := range x.Elts {
+			arr.Elems[i] = in.expr(f, elt)
+		}
+		return arr
+	case *ast.CallExpr:
+		return in.callExpr(f, x)[0]
+	case *ast.LengthExpr:
+		return int64(len(in.expr(f, x.Arg).(*Array).Elems))
+	case *ast.SubscriptExpr:
+		arr := in.expr(f, x.Lhs).(*Array)
+		index := in.expr(f, x.Subscript).(int64)
+		return arr.Elems[in.checkIndex(x, arr, index)]
+	case *ast.UnaryExpr:
+		switch v := in.expr(f, x.Rhs).(type) {
+		case int64:
+			return -v
+		case bool:
+			return !v
+		}
+	case *ast.BinaryExpr:
+		return in.binaryExpr(f, x)
+	}
+	in.errorf(expr.Pos(), "cannot evaluate %T", expr)
+	return nil
+}
+
+func (in *Interpreter) basicLit(x *ast.BasicLit) Value {
+	switch x.Kind {
+	case token.Integer:
+		n, err := lit.ParseInt(x.Value)
+		if err != nil {
+			in.errorf(x.Pos(), "invalid integer literal %s: %v", x.Value, err)
+		}
+		return n
+	case token.Char:
+		c, err := lit.UnquoteChar(x.Value)
+		if err != nil {
+			in.errorf(x.Pos(), "invalid character literal %s: %v", x.Value, err)
+		}
+		return c
+	case token.String:
+		s, err := lit.Unquote(x.Value)
+		if err != nil {
+			in.errorf(x.Pos(), "invalid string literal %s: %v", x.Value, err)
+		}
+		arr := &Array{Elems: make([]Value, len(s))}
+		for i, r := range s {
+			arr.Elems[i] = int64(r)
+		}
+		return arr
+	case token.True:
+		return true
+	case token.False:
+		return false
+	}
+	in.errorf(x.Pos(), "invalid literal %s", x.Value)
+	return nil
+}
+
+func (in *Interpreter) binaryExpr(f frame, x *ast.BinaryExpr) Value {
+	// & and | short-circuit.
+	switch x.Op {
+	case token.And:
+		return in.expr(f, x.Lhs).(bool) && in.expr(f, x.Rhs).(bool)
+	case token.Or:
+		return in.expr(f, x.Lhs).(bool) || in.expr(f, x.Rhs).(bool)
+	}
+
+	lhs, rhs := in.expr(f, x.Lhs), in.expr(f, x.Rhs)
+
+	switch x.Op {
+	case token.Eq:
+		return lhs == rhs // arrays compare by reference
+	case token.Neq:
+		return lhs != rhs
+	}
+
+	switch l := lhs.(type) {
+	case int64:
+		r := rhs.(int64)
+		switch x.Op {
+		case token.Add:
+			return l + r
+		case token.Sub:
+			return l - r
+		case token.Mul:
+			return l * r
+		case token.HMul:
+			return arith.HighMul(l, r)
+		case token.Div:
+			if r == 0 {
+				in.errorf(x.OpPos, "integer division by zero")
+			}
+			return l / r
+		case token.Rem:
+			if r == 0 {
+				in.errorf(x.OpPos, "integer division by zero")
+			}
+			return l % r
+		case token.Lt:
+			return l < r
+		case token.Le:
+			return l <= r
+		case token.Gt:
+			return l > r
+		case token.Ge:
+			return l >= r
+		}
+	case *Array:
+		if x.Op == token.Add {
+			r := rhs.(*Array)
+			elems := make([]Value, 0, len(l.Elems)+len(r.Elems))
+			elems = append(elems, l.Elems...)
+			elems = append(elems, r.Elems...)
+			return &Array{Elems: elems}
+		}
+	}
+
+	in.errorf(x.OpPos, "invalid operation %s on %T", x.Op, lhs)
+	return nil
+}
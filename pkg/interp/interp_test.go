@@ -0,0 +1,239 @@
+package interp
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+func load(t *testing.T, src string) *Interpreter {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := types.Check(fset, []*ast.File{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return New(fset, f)
+}
+
+const programs = `
+fib(n: int): int {
+	if (n < 2) return n
+	return fib(n - 1) + fib(n - 2)
+}
+
+divmod(a: int, b: int): int, int {
+	return a / b, a % b
+}
+
+sumdiv(a: int, b: int): int {
+	q:int, r:int = divmod(a, b)
+	_, r2:int = divmod(r, 2)
+	return q + r + r2
+}
+
+reverse(a: int[]): int[] {
+	n:int = length(a)
+	r:int[n]
+	i:int = 0
+	while (i < n) {
+		r[n - 1 - i] = a[i]
+		i = i + 1
+	}
+	return r
+}
+
+greeting(): int[] {
+	return "héllo" + ", " + {'w', 'o', 'r', 'l', 'd', '\x{21}'}
+}
+
+grid(n: int, m: int): int {
+	g:int[n][m]
+	i:int = 0
+	while (i < n) {
+		j:int = 0
+		while (j < m) {
+			g[i][j] = i * m + j
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return g[n - 1][m - 1] + length(g) * 100 + length(g[0]) * 1000
+}
+
+ragged(): int {
+	a:int[2][]
+	a[1] = {1, 2, 3}
+	return length(a[0]) * 10 + length(a[1])
+}
+
+shortCircuit(a: int[]): bool {
+	return length(a) > 0 & a[0] == 1 | length(a) == 0
+}
+
+aliasing(): int {
+	a:int[] = {1, 2}
+	b:int[] = a
+	b[0] = 10
+	c:int[] = a + {}
+	c[1] = 20
+	return a[0] + a[1]
+}
+
+same(): bool, bool {
+	a:int[] = {1}
+	b:int[] = {1}
+	return a == a, a == b
+}
+
+minInt(): int {
+	return -9223372036854775808
+}
+
+overflow(): int {
+	return 9223372036854775807 + 1
+}
+
+index(a: int[], i: int): int {
+	return a[i]
+}
+
+divide(a: int, b: int): int {
+	return a / b
+}
+
+negativeSize(n: int): int {
+	a:int[n]
+	return length(a)
+}
+
+hmul(a: int, b: int): int {
+	return a *>> b
+}
+
+main(args: int[][]) {
+}
+`
+
+func TestCall(t *testing.T) {
+	in := load(t, programs)
+
+	for _, test := range []struct {
+		fn   string
+		args []Value
+		want string
+	}{
+		{"fib", []Value{int64(20)}, "[6765]"},
+		{"divmod", []Value{int64(-7), int64(2)}, "[-3 -1]"},
+		{"sumdiv", []Value{int64(17), int64(5)}, "[5]"},
+		{"reverse", []Value{&Array{Elems: []Value{int64(1), int64(2), int64(3)}}}, "[{3, 2, 1}]"},
+		{"greeting", nil, `["héllo, world!"]`},
+		{"grid", []Value{int64(3), int64(4)}, "[4311]"},
+		{"ragged", nil, "[3]"},
+		{"shortCircuit", []Value{&Array{}}, "[true]"},
+		{"shortCircuit", []Value{&Array{Elems: []Value{int64(2)}}}, "[false]"},
+		{"aliasing", nil, "[12]"},
+		{"same", nil, "[true false]"},
+		{"minInt", nil, "[-9223372036854775808]"},
+		{"overflow", nil, "[-9223372036854775808]"},
+		{"hmul", []Value{int64(1 << 40), int64(1 << 40)}, "[65536]"},
+		{"hmul", []Value{int64(-1), int64(1)}, "[-1]"},
+		{"hmul", []Value{int64(-1), int64(-1)}, "[0]"},
+		{"hmul", []Value{int64(3), int64(-2)}, "[-1]"},
+		{"hmul", []Value{int64(math.MaxInt64), int64(math.MaxInt64)}, "[4611686018427387903]"},
+		{"hmul", []Value{int64(math.MinInt64), int64(math.MinInt64)}, "[4611686018427387904]"},
+		{"hmul", []Value{int64(math.MinInt64), int64(-1)}, "[0]"},
+		{"hmul", []Value{int64(math.MinInt64), int64(1)}, "[-1]"},
+		{"hmul", []Value{int64(math.MinInt64), int64(math.MaxInt64)}, "[-4611686018427387904]"},
+	} {
+		results, err := in.Call(test.fn, test.args...)
+		if err != nil {
+			t.Errorf("%s: %v", test.fn, err)
+			continue
+		}
+
+		var got []string
+		for _, r := range results {
+			if arr, ok := r.(*Array); ok && test.fn == "greeting" {
+				got = append(got, `"`+arr.String()+`"`)
+				continue
+			}
+			got = append(got, Format(r))
+		}
+		if s := "[" + strings.Join(got, " ") + "]"; s != test.want {
+			t.Errorf("%s: got %s, expected %s", test.fn, s, test.want)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	in := load(t, programs)
+
+	for _, test := range []struct {
+		fn   string
+		args []Value
+		want string
+	}{
+		{"index", []Value{&Array{Elems: []Value{int64(1)}}, int64(1)}, "test.xi:80:10: runtime error: array index 1 out of bounds [0:1]"},
+		{"index", []Value{&Array{}, int64(-1)}, "array index -1 out of bounds [0:0]"},
+		{"divide", []Value{int64(1), int64(0)}, "test.xi:84:11: runtime error: integer division by zero"},
+		{"negativeSize", []Value{int64(-2)}, "negative array size -2"},
+		{"missing", nil, "runtime error: function missing has no implementation"},
+	} {
+		_, err := in.Call(test.fn, test.args...)
+		if _, ok := err.(*RuntimeError); !ok || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, expected %q", test.fn, err, test.want)
+		}
+	}
+}
+
+func TestExtern(t *testing.T) {
+	src := `use lib
+main(args: int[][]) {
+	record(length(args), args[1])
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "extern.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	in := New(fset, f)
+	in.Bind("record", func(args []Value) ([]Value, error) {
+		got = append(got, Format(args[0]), args[1].(*Array).String())
+		return nil, nil
+	})
+
+	if err := in.Main([]string{"a", "βc"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != "2 βc" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestMainSignature(t *testing.T) {
+	for _, src := range []string{
+		"main() {}",
+		"main(args: int[]) {}",
+		"main(args: int[][], n: int) {}",
+		"main(args: int[][]): int { return 0 }",
+	} {
+		in := load(t, src)
+		err := in.Main([]string{"a"})
+		if err == nil || !strings.Contains(err.Error(), "test.xi:1:1: main must be declared as main(args: int[][])") {
+			t.Errorf("%s: got %v, expected a signature error", src, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Value is the run-time representation of a Xi value: an int64, a bool
+// or an *Array.
+type Value interface{}
+
+// An Array is a Xi array. Arrays have reference semantics: assigning an
+// array or passing it to a function shares its elements.
+type Array struct {
+	Elems []Value
+}
+
+// NewString returns the Xi representation of s, an int[] of its code
+// points.
+func NewString(s string) *Array {
+	a := &Array{}
+	for _, r := range s {
+		a.Elems = append(a.Elems, int64(r))
+	}
+	return a
+}
+
+// String interprets a as a string of code points. It panics if a holds
+// anything but ints.
+func (a *Array) String() string {
+	var b strings.Builder
+	for _, elem := range a.Elems {
+		b.WriteRune(rune(elem.(int64)))
+	}
+	return b.String()
+}
+
+// Format returns a readable rendering of v, e.g. {1, {true}}.
+func Format(v Value) string {
+	switch v := v.(type) {
+	case int64:
+		return fmt.Sprint(v)
+	case bool:
+		return fmt.Sprint(v)
+	case *Array:
+		elems := make([]string, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = Format(elem)
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	}
+	return fmt.Sprintf("%v", v)
+}
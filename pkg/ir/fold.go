@@ -0,0 +1,62 @@
+package ir
+
+import "github.com/manapointer/xi/pkg/arith"
+
+// Fold returns the value of x op y as the simulator and the code
+// generators compute it: arithmetic wraps around, shift counts are taken
+// modulo 64 and comparisons yield 0 or 1. It reports false for division
+// by zero, which traps at run time.
+func Fold(op Op, x, y int64) (int64, bool) {
+	switch op {
+	case Add:
+		return x + y, true
+	case Sub:
+		return x - y, true
+	case Mul:
+		return x * y, true
+	case HMul:
+		return arith.HighMul(x, y), true
+	case Div, Mod:
+		if y == 0 {
+			return 0, false
+		}
+		if op == Div {
+			return x / y, true
+		}
+		return x % y, true
+	case And:
+		return x & y, true
+	case Or:
+		return x | y, true
+	case Xor:
+		return x ^ y, true
+	case LShift:
+		return x << (uint64(y) & 63), true
+	case RShift:
+		return int64(uint64(x) >> (uint64(y) & 63)), true
+	case ARShift:
+		return x >> (uint64(y) & 63), true
+	case Eq:
+		return b2i(x == y), true
+	case Neq:
+		return b2i(x != y), true
+	case Lt:
+		return b2i(x < y), true
+	case Gt:
+		return b2i(x > y), true
+	case Leq:
+		return b2i(x <= y), true
+	case Geq:
+		return b2i(x >= y), true
+	case ULt:
+		return b2i(uint64(x) < uint64(y)), true
+	}
+	return 0, false
+}
+
+func b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+// Package ir defines the tree intermediate representation that Xi programs
+// are translated to before code generation.
+//
+// The IR is a small language of expressions, which compute 64-bit words,
+// and statements, which have effects. Memory is addressed in bytes and
+// every value occupies one word. Booleans are 0 or 1.
+//
+// Functions take their arguments in the temporaries _ARG0, _ARG1, ... and
+// a call leaves its results in _RET0, _RET1, ... The value of a Call
+// expression is its first result.
+package ir
+
+import "fmt"
+
+// WordSize is the size in bytes of every IR value.
+const WordSize = 8
+
+// Names of the functions of the run-time system that translated programs
+// call.
+const (
+	// AllocFunc returns a pointer to a fresh, zeroed block of the given
+	// number of bytes.
+	AllocFunc = "_xi_alloc"
+
+	// OutOfBoundsFunc aborts the program after an array index out of
+	// bounds. It does not return.
+	OutOfBoundsFunc = "_xi_out_of_bounds"
+)
+
+// Arg returns the temporary holding the i'th argument of a function on
+// entry.
+func Arg(i int) *Temp { return &Temp{Name: fmt.Sprintf("_ARG%d", i)} }
+
+// Ret returns the temporary holding the i'th result of the last call.
+func Ret(i int) *Temp { return &Temp{Name: fmt.Sprintf("_RET%d", i)} }
+
+// All IR nodes implement the Node interface.
+type Node interface {
+	node()
+}
+
+type Expr interface {
+	Node
+	exprNode()
+}
+
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// An Op is a binary operator.
+type Op int
+
+const (
+	Add Op = iota
+	Sub
+	Mul
+	HMul // upper 64 bits of the signed 128-bit product
+	Div
+	Mod
+	And
+	Or
+	Xor
+	LShift
+	RShift  // logical
+	ARShift // arithmetic
+	Eq
+	Neq
+	Lt
+	Gt
+	Leq
+	Geq
+	ULt // unsigned
+)
+
+var ops = [...]string{
+	Add:     "ADD",
+	Sub:     "SUB",
+	Mul:     "MUL",
+	HMul:    "HMUL",
+	Div:     "DIV",
+	Mod:     "MOD",
+	And:     "AND",
+	Or:      "OR",
+	Xor:     "XOR",
+	LShift:  "LSHIFT",
+	RShift:  "RSHIFT",
+	ARShift: "ARSHIFT",
+	Eq:      "EQ",
+	Neq:     "NEQ",
+	Lt:      "LT",
+	Gt:      "GT",
+	Leq:     "LEQ",
+	Geq:     "GEQ",
+	ULt:     "ULT",
+}
+
+func (op Op) String() string {
+	if 0 <= op && int(op) < len(ops) {
+		return ops[op]
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// IsComparison reports whether op yields a boolean.
+func (op Op) IsComparison() bool { return op >= Eq }
+
+type (
+	Const struct {
+		Value int64
+	}
+
+	// A Temp is a variable of the function it appears in. There is an
+	// unlimited supply of them.
+	Temp struct {
+		Name string
+	}
+
+	// A Mem is the word at address Addr.
+	Mem struct {
+		Addr Expr
+	}
+
+	Call struct {
+		Target Expr
+		Args   []Expr
+	}
+
+	// A Name is the address of a function, or of a label when used as a
+	// jump target.
+	Name struct {
+		Name string
+	}
+
+	// An ESeq executes Stmt and then evaluates to Expr.
+	ESeq struct {
+		Stmt Stmt
+		Expr Expr
+	}
+
+	BinOp struct {
+		Op   Op
+		X, Y Expr
+	}
+)
+
+func (*Const) node() {}
+func (*Temp) node()  {}
+func (*Mem) node()   {}
+func (*Call) node()  {}
+func (*Name) node()  {}
+func (*ESeq) node()  {}
+func (*BinOp) node() {}
+
+func (*Const) exprNode() {}
+func (*Temp) exprNode()  {}
+func (*Mem) exprNode()   {}
+func (*Call) exprNode()  {}
+func (*Name) exprNode()  {}
+func (*ESeq) exprNode()  {}
+func (*BinOp) exprNode() {}
+
+type (
+	// A Move stores Src into Dst, which is a Temp or a Mem. The address
+	// of a Mem destination is evaluated before Src.
+	Move struct {
+		Dst Expr
+		Src Expr
+	}
+
+	// An Exp evaluates X for its effects and discards the value.
+	Exp struct {
+		X Expr
+	}
+
+	Seq struct {
+		List []Stmt
+	}
+
+	Jump struct {
+		Target Expr
+	}
+
+	// A CJump jumps to True if Cond is nonzero and to False otherwise.
+	// An empty False label falls through to the next statement.
+	CJump struct {
+		Cond  Expr
+		True  string
+		False string
+	}
+
+	Label struct {
+		Name string
+	}
+
+	Return struct {
+		Results []Expr
+	}
+)
+
+func (*Move) node()   {}
+func (*Exp) node()    {}
+func (*Seq) node()    {}
+func (*Jump) node()   {}
+func (*CJump) node()  {}
+func (*Label) node()  {}
+func (*Return) node() {}
+
+func (*Move) stmtNode()   {}
+func (*Exp) stmtNode()    {}
+func (*Seq) stmtNode()    {}
+func (*Jump) stmtNode()   {}
+func (*CJump) stmtNode()  {}
+func (*Label) stmtNode()  {}
+func (*Return) stmtNode() {}
+
+// A FuncDecl is a function of a compilation unit. Name is the function's
+// mangled name.
+type FuncDecl struct {
+	Name    string
+	NumArgs int
+	NumRets int
+	Body    Stmt
+}
+
+func (*FuncDecl) node() {}
+
+// A CompUnit is a translated program: the functions of one Xi file.
+type CompUnit struct {
+	Name  string
+	Funcs []*FuncDecl
+}
+
+func (*CompUnit) node() {}
+
+// Func returns the function with the given mangled name, or nil if there
+// is none.
+func (cu *CompUnit) Func(name string) *FuncDecl {
+	for _, fn := range cu.Funcs {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
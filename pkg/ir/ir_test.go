@@ -0,0 +1,282 @@
+package ir
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+func translate(t *testing.T, src string) *CompUnit {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := types.Check(fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Translate("test", f, info)
+}
+
+func TestMangle(t *testing.T) {
+	cu := translate(t, `
+main(args: int[][]) {}
+parseInt(str: int[]): int, bool { return 0, false }
+unparseInt(n: int): int[] { return {} }
+under_score'(b: bool, c: bool[][][]): bool { return b }
+`)
+
+	var got []string
+	for _, fn := range cu.Funcs {
+		got = append(got, fn.Name)
+	}
+	want := []string{"_Imain_paai", "_IparseInt_t2ibai", "_IunparseInt_aii", "_Iunder__score'_bbaaab"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
+
+func TestDemangle(t *testing.T) {
+	for _, test := range []struct {
+		mangled, name, params, results string
+	}{
+		{"_Imain_paai", "main", "aai", ""},
+		{"_IparseInt_t2ibai", "parseInt", "ai", "i b"},
+		{"_Iunder__score_bbaaab", "under_score", "b aaab", "b"},
+		{"_Ix_t0", "x", "", ""},
+	} {
+		name, params, results, ok := Demangle(test.mangled)
+		if !ok || name != test.name || strings.Join(params, " ") != test.params || strings.Join(results, " ") != test.results {
+			t.Errorf("Demangle(%s) = %s %v %v %v", test.mangled, name, params, results, ok)
+		}
+	}
+
+	for _, bad := range []string{"main", "_Imain", "_Imain_q", "_Imain_aa", "_If_t2i"} {
+		if _, _, _, ok := Demangle(bad); ok {
+			t.Errorf("Demangle(%s) succeeded", bad)
+		}
+	}
+}
+
+var translateTests = []struct {
+	name string
+	src  string
+	body string // the function's body, without the moves from _ARGi
+}{
+	{"arithmetic", `f(a: int, b: int): int { return -a * (b % 3) }`,
+		"(RETURN (MUL (SUB (CONST 0) (TEMP a)) (MOD (TEMP b) (CONST 3))))"},
+	{"procedure", `f() { x:int x = -9223372036854775808 }`,
+		"(MOVE (TEMP x) (CONST 0)) (MOVE (TEMP x) (SUB (CONST 0) (CONST -9223372036854775808))) (RETURN)"},
+	{"if", `f(a: bool) { if (a) f(!a) }`,
+		"(SEQ (CJUMP (TEMP a) _l1 _l2) (LABEL _l1) (EXP (CALL (NAME _If_pb) (XOR (CONST 1) (TEMP a)))) (LABEL _l2)) (RETURN)"},
+	{"while", `f(n: int) { while (n > 0) n = n - 1 }`,
+		"(SEQ (LABEL _l1) (CJUMP (GT (TEMP n) (CONST 0)) _l2 _l3) (LABEL _l2) (MOVE (TEMP n) (SUB (TEMP n) (CONST 1))) (JUMP (NAME _l1)) (LABEL _l3)) (RETURN)"},
+	{"short circuit", `f(a: bool, b: bool, c: bool) { if (a & !b | c) {} }`,
+		"(SEQ (SEQ (SEQ (CJUMP (TEMP a) _l4 _l3) (LABEL _l4) (CJUMP (TEMP b) _l3 _l1)) (LABEL _l3) (CJUMP (TEMP c) _l1 _l2)) (LABEL _l1) (SEQ) (LABEL _l2)) (RETURN)"},
+	{"boolean value", `f(a: bool, b: bool): bool { return a & b }`,
+		"(RETURN (ESEQ (SEQ (MOVE (TEMP _t1) (CONST 0)) (SEQ (CJUMP (TEMP a) _l3 _l2) (LABEL _l3) (CJUMP (TEMP b) _l1 _l2)) (LABEL _l1) (MOVE (TEMP _t1) (CONST 1)) (LABEL _l2)) (TEMP _t1)))"},
+	{"multiple results", `
+f(): int, bool { return 1, true }
+g() { _, b:bool = f() }`, ""},
+	{"subscript", `f(a: int[], i: int): int { return a[i] + length(a) }`,
+		"(RETURN (ADD (ESEQ (SEQ (MOVE (TEMP _t1) (TEMP a)) (MOVE (TEMP _t2) (TEMP i)) (SEQ (CJUMP (ULT (TEMP _t2) (MEM (SUB (TEMP _t1) (CONST 8)))) _l1 _l2) (LABEL _l2) (EXP (CALL (NAME _xi_out_of_bounds))) (LABEL _l1))) (MEM (ADD (TEMP _t1) (MUL (TEMP _t2) (CONST 8))))) (MEM (SUB (TEMP a) (CONST 8)))))"},
+	{"store", `f(a: int[]) { a[0] = 1 }`,
+		"(SEQ (MOVE (TEMP _t1) (TEMP a)) (MOVE (TEMP _t2) (CONST 0)) (MOVE (TEMP _t3) (CONST 1)) (SEQ (CJUMP (ULT (TEMP _t2) (MEM (SUB (TEMP _t1) (CONST 8)))) _l1 _l2) (LABEL _l2) (EXP (CALL (NAME _xi_out_of_bounds))) (LABEL _l1)) (MOVE (MEM (ADD (TEMP _t1) (MUL (TEMP _t2) (CONST 8)))) (TEMP _t3))) (RETURN)"},
+	{"string", `f(): int[] { return "a\n" }`,
+		"(RETURN (ESEQ (SEQ (SEQ (MOVE (TEMP _t1) (CONST 2)) (MOVE (TEMP _t2) (CALL (NAME _xi_alloc) (MUL (ADD (TEMP _t1) (CONST 1)) (CONST 8)))) (MOVE (MEM (TEMP _t2)) (TEMP _t1)) (MOVE (TEMP _t3) (ADD (TEMP _t2) (CONST 8)))) (MOVE (MEM (ADD (TEMP _t3) (MUL (CONST 0) (CONST 8)))) (CONST 97)) (MOVE (MEM (ADD (TEMP _t3) (MUL (CONST 1) (CONST 8)))) (CONST 10))) (TEMP _t3)))"},
+}
+
+func TestTranslate(t *testing.T) {
+	for _, test := range translateTests {
+		t.Run(test.name, func(t *testing.T) {
+			cu := translate(t, test.src)
+			fn := cu.Funcs[0]
+			if test.body == "" {
+				fn = cu.Funcs[len(cu.Funcs)-1]
+			}
+
+			list := fn.Body.(*Seq).List[fn.NumArgs:]
+			var parts []string
+			for _, stmt := range list {
+				parts = append(parts, Sprint(stmt))
+			}
+			got := strings.Join(parts, " ")
+
+			if test.body == "" {
+				test.body = "(SEQ (EXP (CALL (NAME _If_t2ib))) (MOVE (TEMP b) (TEMP _RET1))) (RETURN)"
+			}
+			if got != test.body {
+				t.Errorf("got\n\t%s\nexpected\n\t%s", got, test.body)
+			}
+		})
+	}
+}
+
+func TestTranslateArgs(t *testing.T) {
+	cu := translate(t, `f(a: int, b: int[]): int { return a }`)
+	fn := cu.Func("_If_iiai")
+	if fn == nil {
+		t.Fatal("function not found")
+	}
+	if fn.NumArgs != 2 || fn.NumRets != 1 {
+		t.Errorf("got %d arguments and %d results, expected 2 and 1", fn.NumArgs, fn.NumRets)
+	}
+	got := Sprint(fn.Body)
+	want := "(SEQ (MOVE (TEMP a) (TEMP _ARG0)) (MOVE (TEMP b) (TEMP _ARG1)) (RETURN (TEMP a)))"
+	if got != want {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+}
+
+func TestTranslateSizedArray(t *testing.T) {
+	cu := translate(t, `f(n: int) { a:int[n][2][] }`)
+
+	var b strings.Builder
+	if err := Fprint(&b, cu.Funcs[0]); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+
+	// Both sizes are evaluated and checked before anything is allocated,
+	// and the innermost, unsized dimension is filled with empty arrays.
+	for _, want := range []string{
+		"(MOVE (TEMP _t1) (TEMP n))\n",
+		"(MOVE (TEMP _t2) (CONST 2))\n",
+		"(CJUMP (GEQ (TEMP _t1) (CONST 0)) _l1 _l2)\n",
+		"(CJUMP (GEQ (TEMP _t2) (CONST 0)) _l3 _l4)\n",
+		"(CALL (NAME _xi_alloc) (MUL (ADD (TEMP _t3) (CONST 1)) (CONST 8)))",
+		"(MOVE (TEMP _t13) (CONST 0))\n",
+		"(MOVE (TEMP a) (TEMP _t5))",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "(CALL (NAME _xi_alloc)"); n != 3 {
+		t.Errorf("got %d allocations, expected 3", n)
+	}
+}
+
+// checkLowered reports the statements of fn that are not in lowered form.
+func checkLowered(t *testing.T, fn *FuncDecl) {
+	t.Helper()
+
+	pure := func(e Expr) bool {
+		ok := true
+		walk(e, func(x Expr) {
+			switch x.(type) {
+			case *Call, *ESeq:
+				ok = false
+			}
+		})
+		return ok
+	}
+	pureCall := func(e Expr) bool {
+		call, ok := e.(*Call)
+		if !ok || !pure(call.Target) {
+			return false
+		}
+		for _, arg := range call.Args {
+			if !pure(arg) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, stmt := range fn.Body.(*Seq).List {
+		ok := false
+		switch s := stmt.(type) {
+		case *Move:
+			switch dst := s.Dst.(type) {
+			case *Temp:
+				ok = pure(s.Src) || pureCall(s.Src)
+			case *Mem:
+				ok = pure(dst.Addr) && pure(s.Src)
+			}
+		case *Exp:
+			ok = pureCall(s.X)
+		case *Jump:
+			ok = pure(s.Target)
+		case *CJump:
+			ok = pure(s.Cond) && s.False == ""
+		case *Label:
+			ok = true
+		case *Return:
+			ok = true
+			for _, x := range s.Results {
+				ok = ok && pure(x)
+			}
+		}
+		if !ok {
+			t.Errorf("%s: not lowered: %s", fn.Name, Sprint(stmt))
+		}
+	}
+}
+
+func TestLower(t *testing.T) {
+	cu := Lower(translate(t, `
+f(a: int[], i: int): int, bool {
+	x:int[i][]
+	a[g(i)] = a[g(i + 1)] + g(a[0])
+	if (i > 0 & a[i-1] == 2 | !(length(x) < g(2))) return length(a + "hé"), true
+	while (g(i) > 0) i = i - 1
+	return g(g(g(1))), a[0] == 1
+}
+g(n: int): int {
+	_, b:bool = f({n}, n)
+	return n
+}
+`))
+
+	for _, fn := range cu.Funcs {
+		checkLowered(t, fn)
+	}
+
+	// The second argument of the addition is a call, which may change a,
+	// so the first is saved before the call.
+	var b strings.Builder
+	Fprint(&b, cu.Func("_If_t2ibaii"))
+	for _, want := range []string{
+		"(MOVE (TEMP _c2) (MEM (ADD (TEMP _t13) (MUL (TEMP _t14) (CONST 8)))))\n",
+		"(MOVE (TEMP _t12) (ADD (TEMP _c2) (TEMP _c1)))\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing\n%s\nin\n%s", want, b.String())
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	for _, test := range []struct {
+		op   Op
+		x, y int64
+		want int64
+		ok   bool
+	}{
+		{Add, math.MaxInt64, 1, math.MinInt64, true},
+		{Div, math.MinInt64, -1, math.MinInt64, true},
+		{HMul, math.MinInt64, 4, -2, true},
+		{Mod, -7, 2, -1, true},
+		{Div, 1, 0, 0, false},
+		{Mod, 1, 0, 0, false},
+		{LShift, 1, 65, 2, true},
+		{RShift, -1, 60, 15, true},
+		{ARShift, -16, 2, -4, true},
+		{ULt, 1, -1, 1, true},
+		{Geq, 2, 3, 0, true},
+	} {
+		got, ok := Fold(test.op, test.x, test.y)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Fold(%s, %d, %d) = %d, %t, expected %d, %t", test.op, test.x, test.y, got, ok, test.want, test.ok)
+		}
+	}
+}
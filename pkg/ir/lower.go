@@ -0,0 +1,276 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lower returns the lowered form of cu, which is what the simulator and
+// the code generators consume. The body of every lowered function is a
+// single Seq of statements of the following forms, where e stands for an
+// expression without Call and ESeq nodes:
+//
+//	(MOVE (TEMP t) e)
+//	(MOVE (TEMP t) (CALL e e...))
+//	(MOVE (MEM e) e)
+//	(EXP (CALL e e...))
+//	(JUMP e)
+//	(CJUMP e l)
+//	(LABEL l)
+//	(RETURN e...)
+//
+// Conditional jumps fall through when the condition is false. Evaluation
+// order is preserved: when a later operand has side effects, the earlier
+// ones are saved in fresh temporaries first.
+func Lower(cu *CompUnit) *CompUnit {
+	lowered := &CompUnit{Name: cu.Name}
+	for _, fn := range cu.Funcs {
+		l := &lowerer{}
+		lowered.Funcs = append(lowered.Funcs, &FuncDecl{
+			Name:    fn.Name,
+			NumArgs: fn.NumArgs,
+			NumRets: fn.NumRets,
+			Body:    &Seq{List: fallThrough(l.stmt(fn.Body))},
+		})
+	}
+	return lowered
+}
+
+type lowerer struct {
+	temps int
+}
+
+// newTemp returns a fresh temporary, distinct from those of the
+// translator.
+func (l *lowerer) newTemp() *Temp {
+	l.temps++
+	return &Temp{Name: fmt.Sprintf("_c%d", l.temps)}
+}
+
+func (l *lowerer) stmt(stmt Stmt) []Stmt {
+	switch s := stmt.(type) {
+	case *Seq:
+		var list []Stmt
+		for _, s := range s.List {
+			list = append(list, l.stmt(s)...)
+		}
+		return list
+
+	case *Move:
+		switch dst := s.Dst.(type) {
+		case *Temp:
+			if call, ok := s.Src.(*Call); ok {
+				list, call := l.call(call)
+				return append(list, &Move{Dst: dst, Src: call})
+			}
+			list, src := l.expr(s.Src)
+			return append(list, &Move{Dst: dst, Src: src})
+		case *Mem:
+			list, exprs := l.exprs(dst.Addr, s.Src)
+			return append(list, &Move{Dst: &Mem{Addr: exprs[0]}, Src: exprs[1]})
+		}
+
+	case *Exp:
+		if call, ok := s.X.(*Call); ok {
+			list, call := l.call(call)
+			return append(list, &Exp{X: call})
+		}
+		list, _ := l.expr(s.X)
+		return list
+
+	case *Jump:
+		list, target := l.expr(s.Target)
+		return append(list, &Jump{Target: target})
+
+	case *CJump:
+		list, cond := l.expr(s.Cond)
+		return append(list, &CJump{Cond: cond, True: s.True, False: s.False})
+
+	case *Label:
+		return []Stmt{s}
+
+	case *Return:
+		list, results := l.exprs(s.Results...)
+		return append(list, &Return{Results: results})
+	}
+
+	panic(fmt.Sprintf("ir: cannot lower %s", Sprint(stmt)))
+}
+
+// expr returns the statements computing the side effects of expr and an
+// expression without side effects for its value afterwards.
+func (l *lowerer) expr(expr Expr) ([]Stmt, Expr) {
+	switch x := expr.(type) {
+	case *Const, *Temp, *Name:
+		return nil, x
+
+	case *Mem:
+		list, addr := l.expr(x.Addr)
+		return list, &Mem{Addr: addr}
+
+	case *BinOp:
+		list, exprs := l.exprs(x.X, x.Y)
+		return list, &BinOp{Op: x.Op, X: exprs[0], Y: exprs[1]}
+
+	case *Call:
+		list, call := l.call(x)
+		t := l.newTemp()
+		return append(list, &Move{Dst: t, Src: call}), t
+
+	case *ESeq:
+		list := l.stmt(x.Stmt)
+		more, e := l.expr(x.Expr)
+		return append(list, more...), e
+	}
+
+	panic(fmt.Sprintf("ir: cannot lower %s", Sprint(expr)))
+}
+
+// exprs lowers a list of expressions that are evaluated from left to
+// right.
+func (l *lowerer) exprs(exprs ...Expr) ([]Stmt, []Expr) {
+	var list []Stmt
+	pure := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		stmts, e := l.expr(expr)
+		if len(stmts) > 0 {
+			// The effects of this expression come after the earlier
+			// ones were evaluated. Save those that the effects could
+			// change.
+			for j := range pure[:i] {
+				if !commutes(stmts, pure[j]) {
+					t := l.newTemp()
+					list = append(list, &Move{Dst: t, Src: pure[j]})
+					pure[j] = t
+				}
+			}
+			list = append(list, stmts...)
+		}
+		pure[i] = e
+	}
+	return list, pure
+}
+
+func (l *lowerer) call(call *Call) ([]Stmt, *Call) {
+	list, exprs := l.exprs(append([]Expr{call.Target}, call.Args...)...)
+	return list, &Call{Target: exprs[0], Args: exprs[1:]}
+}
+
+// commutes reports whether executing list cannot change the value of the
+// side-effect free expression e.
+func commutes(list []Stmt, e Expr) bool {
+	switch e.(type) {
+	case *Const, *Name:
+		return true
+	}
+
+	written := make(map[string]bool)
+	memory := false
+	for _, s := range list {
+		switch s := s.(type) {
+		case *Move:
+			switch dst := s.Dst.(type) {
+			case *Temp:
+				written[dst.Name] = true
+			case *Mem:
+				memory = true
+			}
+			if _, ok := s.Src.(*Call); ok {
+				memory = true
+			}
+		case *Exp:
+			memory = true
+		}
+	}
+
+	ok := true
+	walk(e, func(x Expr) {
+		switch x := x.(type) {
+		case *Temp:
+			// Calls overwrite the result temporaries.
+			if written[x.Name] || memory && isRet(x) {
+				ok = false
+			}
+		case *Mem:
+			if memory {
+				ok = false
+			}
+		}
+	})
+	return ok
+}
+
+func isRet(t *Temp) bool { return strings.HasPrefix(t.Name, "_RET") }
+
+// walk calls f for e and each of its subexpressions.
+func walk(e Expr, f func(Expr)) {
+	f(e)
+	switch x := e.(type) {
+	case *Mem:
+		walk(x.Addr, f)
+	case *BinOp:
+		walk(x.X, f)
+		walk(x.Y, f)
+	case *Call:
+		walk(x.Target, f)
+		for _, arg := range x.Args {
+			walk(arg, f)
+		}
+	}
+}
+
+// fallThrough rewrites the conditional jumps in list so that they fall
+// through when the condition is false, and drops jumps to the immediately
+// following label.
+func fallThrough(list []Stmt) []Stmt {
+	var out []Stmt
+	for i, s := range list {
+		next := ""
+		if i+1 < len(list) {
+			if label, ok := list[i+1].(*Label); ok {
+				next = label.Name
+			}
+		}
+
+		switch s := s.(type) {
+		case *Jump:
+			if name, ok := s.Target.(*Name); ok && name.Name == next {
+				continue
+			}
+		case *CJump:
+			switch {
+			case s.False == "":
+			case s.False == next:
+				s = &CJump{Cond: s.Cond, True: s.True}
+			case s.True == next:
+				s = &CJump{Cond: negate(s.Cond), True: s.False}
+			default:
+				out = append(out, &CJump{Cond: s.Cond, True: s.True}, &Jump{Target: &Name{Name: s.False}})
+				continue
+			}
+			out = append(out, s)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+var negations = map[Op]Op{
+	Eq:  Neq,
+	Neq: Eq,
+	Lt:  Geq,
+	Geq: Lt,
+	Gt:  Leq,
+	Leq: Gt,
+}
+
+// negate returns the logical negation of the boolean expression cond.
+func negate(cond Expr) Expr {
+	if x, ok := cond.(*BinOp); ok {
+		if op, ok := negations[x.Op]; ok {
+			return &BinOp{Op: op, X: x.X, Y: x.Y}
+		}
+	}
+	return &BinOp{Op: Eq, X: cond, Y: &Const{Value: 0}}
+}
@@ -0,0 +1,148 @@
+package ir
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// Mangle returns the assembly-level name of the Xi function name with
+// signature sig. The scheme is _I<name>_<results><parameters>, where
+// underscores in the name are doubled and types are encoded as
+//
+//	i        int
+//	b        bool
+//	a<t>     array of t
+//	p        no results (in the result position only)
+//	t<n><ts> n results (n > 1)
+//
+// For example, main(args: int[][]) is _Imain_paai and
+// parseInt(str: int[]): int, bool is _IparseInt_t2ibai.
+func Mangle(name string, sig *types.Signature) string {
+	var b strings.Builder
+	b.WriteString("_I")
+	b.WriteString(strings.ReplaceAll(name, "_", "__"))
+	b.WriteByte('_')
+
+	switch results := sig.Returns(); results.Len() {
+	case 0:
+		b.WriteByte('p')
+	case 1:
+		mangleType(&b, results.At(0))
+	default:
+		b.WriteByte('t')
+		b.WriteString(strconv.Itoa(results.Len()))
+		for _, typ := range results.Types() {
+			mangleType(&b, typ)
+		}
+	}
+
+	for _, typ := range sig.Parameters().Types() {
+		mangleType(&b, typ)
+	}
+	return b.String()
+}
+
+func mangleType(b *strings.Builder, typ types.Type) {
+	switch t := typ.(type) {
+	case *types.Basic:
+		if t.Kind() == types.Bool {
+			b.WriteByte('b')
+		} else {
+			b.WriteByte('i')
+		}
+	case *types.Array:
+		b.WriteByte('a')
+		mangleType(b, t.Elem())
+	}
+}
+
+// Demangle decodes a name produced by Mangle into the function's Xi name
+// and the encodings of its parameter and result types, such as "ai" for
+// int[]. It reports whether mangled is well formed.
+func Demangle(mangled string) (name string, params, results []string, ok bool) {
+	if len(mangled) < 2 || mangled[:2] != "_I" {
+		return "", nil, nil, false
+	}
+
+	rest := mangled[2:]
+	var b []byte
+	for {
+		if len(rest) == 0 {
+			return "", nil, nil, false
+		}
+		if rest[0] == '_' {
+			if len(rest) > 1 && rest[1] == '_' {
+				b = append(b, '_')
+				rest = rest[2:]
+				continue
+			}
+			rest = rest[1:]
+			break
+		}
+		b = append(b, rest[0])
+		rest = rest[1:]
+	}
+
+	typ := func() (string, bool) {
+		i := 0
+		for i < len(rest) && rest[i] == 'a' {
+			i++
+		}
+		if i == len(rest) || (rest[i] != 'i' && rest[i] != 'b') {
+			return "", false
+		}
+		t := rest[:i+1]
+		rest = rest[i+1:]
+		return t, true
+	}
+
+	switch {
+	case len(rest) > 0 && rest[0] == 'p':
+		rest = rest[1:]
+	case len(rest) > 0 && rest[0] == 't':
+		rest = rest[1:]
+		n := 0
+		for len(rest) > 0 && '0' <= rest[0] && rest[0] <= '9' {
+			n = n*10 + int(rest[0]-'0')
+			rest = rest[1:]
+		}
+		for i := 0; i < n; i++ {
+			t, ok := typ()
+			if !ok {
+				return "", nil, nil, false
+			}
+			results = append(results, t)
+		}
+	default:
+		t, ok := typ()
+		if !ok {
+			return "", nil, nil, false
+		}
+		results = append(results, t)
+	}
+
+	for len(rest) > 0 {
+		t, ok := typ()
+		if !ok {
+			return "", nil, nil, false
+		}
+		params = append(params, t)
+	}
+	return string(b), params, results, true
+}
+
+// NumResults returns the number of results of the function called name,
+// which is a mangled name or one of the run-time functions AllocFunc and
+// OutOfBoundsFunc, and reports whether name is either.
+func NumResults(name string) (int, bool) {
+	switch name {
+	case AllocFunc:
+		return 1, true
+	case OutOfBoundsFunc:
+		return 0, true
+	}
+	_, _, results, ok := Demangle(name)
+	return len(results), ok
+}
@@ -0,0 +1,175 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes node to w as an S-expression, one statement per line.
+//
+//	(MOVE (TEMP x) (ADD (TEMP x) (CONST 1)))
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.node(node)
+	if p.col > 0 {
+		p.newline()
+	}
+	return p.err
+}
+
+// Sprint returns node as a single-line S-expression.
+func Sprint(node Node) string {
+	var buf bytes.Buffer
+	p := &printer{w: &buf, flat: true}
+	p.node(node)
+	return buf.String()
+}
+
+type printer struct {
+	w      io.Writer
+	flat   bool // print everything on one line
+	indent int
+	col    int
+	err    error
+}
+
+func (p *printer) print(s string) {
+	if p.err != nil {
+		return
+	}
+	if p.col == 0 && p.indent > 0 {
+		_, p.err = io.WriteString(p.w, strings.Repeat("  ", p.indent))
+	}
+	_, p.err = io.WriteString(p.w, s)
+	p.col += len(s)
+}
+
+func (p *printer) newline() {
+	if p.flat {
+		p.print(" ")
+		return
+	}
+	p.print("\n")
+	p.col = 0
+}
+
+// open starts a list whose elements go on lines of their own.
+func (p *printer) open(head string) {
+	p.print("(" + head)
+	p.indent++
+}
+
+func (p *printer) close() {
+	p.indent--
+	p.print(")")
+}
+
+func (p *printer) node(node Node) {
+	switch n := node.(type) {
+	case *CompUnit:
+		p.open("COMPUNIT " + n.Name)
+		for _, fn := range n.Funcs {
+			p.newline()
+			p.node(fn)
+		}
+		p.close()
+	case *FuncDecl:
+		p.open(fmt.Sprintf("FUNC %s %d %d", n.Name, n.NumArgs, n.NumRets))
+		p.newline()
+		p.node(n.Body)
+		p.close()
+	case Stmt:
+		p.stmt(n)
+	case Expr:
+		p.expr(n)
+	default:
+		p.print(fmt.Sprintf("(? %T)", node))
+	}
+}
+
+func (p *printer) stmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *Seq:
+		p.open("SEQ")
+		for _, s := range s.List {
+			p.newline()
+			p.stmt(s)
+		}
+		p.close()
+	case *Move:
+		p.print("(MOVE ")
+		p.expr(s.Dst)
+		p.print(" ")
+		p.expr(s.Src)
+		p.print(")")
+	case *Exp:
+		p.print("(EXP ")
+		p.expr(s.X)
+		p.print(")")
+	case *Jump:
+		p.print("(JUMP ")
+		p.expr(s.Target)
+		p.print(")")
+	case *CJump:
+		p.print("(CJUMP ")
+		p.expr(s.Cond)
+		p.print(" " + s.True)
+		if s.False != "" {
+			p.print(" " + s.False)
+		}
+		p.print(")")
+	case *Label:
+		p.print("(LABEL " + s.Name + ")")
+	case *Return:
+		p.print("(RETURN")
+		for _, x := range s.Results {
+			p.print(" ")
+			p.expr(x)
+		}
+		p.print(")")
+	default:
+		p.print(fmt.Sprintf("(? %T)", stmt))
+	}
+}
+
+func (p *printer) expr(expr Expr) {
+	switch x := expr.(type) {
+	case *Const:
+		p.print(fmt.Sprintf("(CONST %d)", x.Value))
+	case *Temp:
+		p.print("(TEMP " + x.Name + ")")
+	case *Name:
+		p.print("(NAME " + x.Name + ")")
+	case *Mem:
+		p.print("(MEM ")
+		p.expr(x.Addr)
+		p.print(")")
+	case *BinOp:
+		p.print("(" + x.Op.String() + " ")
+		p.expr(x.X)
+		p.print(" ")
+		p.expr(x.Y)
+		p.print(")")
+	case *Call:
+		p.print("(CALL ")
+		p.expr(x.Target)
+		for _, arg := range x.Args {
+			p.print(" ")
+			p.expr(arg)
+		}
+		p.print(")")
+	case *ESeq:
+		// The statement may span several lines; the expression
+		// follows it on a line of its own.
+		p.open("ESEQ")
+		p.newline()
+		p.stmt(x.Stmt)
+		p.newline()
+		p.expr(x.Expr)
+		p.close()
+	default:
+		p.print(fmt.Sprintf("(? %T)", expr))
+	}
+}
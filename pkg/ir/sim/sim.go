@@ -0,0 +1,329 @@
+// Package sim executes lowered IR. It simulates a heap and a call stack,
+// so that translated programs can be run and compared with the
+// interpreter before there is a native backend, and before and after each
+// transformation of the IR.
+package sim
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/interp"
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// DefaultMaxDepth is the default limit on the depth of the call stack.
+const DefaultMaxDepth = 10000
+
+// An Error is a run-time error of the simulated program, such as an array
+// index out of bounds.
+type Error struct {
+	Func string // mangled name of the function executing
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s: runtime error: %s", err.Func, err.Msg)
+}
+
+// A Simulator executes the functions of a lowered compilation unit.
+type Simulator struct {
+	// MaxDepth limits the depth of the call stack. Deeper calls are
+	// errors.
+	MaxDepth int
+
+	funcs   map[string]*function
+	externs map[string]interp.Extern
+
+	heap  []int64 // word i is at address i*ir.WordSize
+	depth int
+}
+
+type function struct {
+	body   []ir.Stmt
+	labels map[string]int // statement index of each label
+}
+
+// New returns a simulator for cu, which must be lowered.
+func New(cu *ir.CompUnit) *Simulator {
+	s := &Simulator{
+		MaxDepth: DefaultMaxDepth,
+		funcs:    make(map[string]*function),
+		externs:  make(map[string]interp.Extern),
+		// Word 0 is never allocated, so that address 0 is invalid.
+		heap: make([]int64, 1),
+	}
+	for _, decl := range cu.Funcs {
+		fn := &function{body: decl.Body.(*ir.Seq).List, labels: make(map[string]int)}
+		for i, stmt := range fn.body {
+			if label, ok := stmt.(*ir.Label); ok {
+				fn.labels[label.Name] = i
+			}
+		}
+		s.funcs[decl.Name] = fn
+	}
+	return s
+}
+
+// Bind provides the implementation of a function declared in an interface
+// file, such as one of the standard library's, by its Xi name. Arguments
+// and results are converted according to the function's mangled name.
+func (s *Simulator) Bind(name string, fn interp.Extern) {
+	s.externs[name] = fn
+}
+
+// Call calls the function with the given mangled name and returns its
+// results. Run-time errors are returned as an *Error.
+func (s *Simulator) Call(name string, args ...int64) (results []int64, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*Error)
+			if !ok {
+				panic(e)
+			}
+			err = serr
+		}
+	}()
+
+	return s.call(name, name, args), nil
+}
+
+// MainFunc is the mangled name of main(args: int[][]).
+const MainFunc = "_Imain_paai"
+
+// Main calls main with the given command-line arguments.
+func (s *Simulator) Main(args []string) error {
+	argv := &interp.Array{Elems: make([]interp.Value, len(args))}
+	for i, arg := range args {
+		argv.Elems[i] = interp.NewString(arg)
+	}
+
+	_, err := s.Call(MainFunc, s.fromValue(argv))
+	return err
+}
+
+func errorf(fn string, format string, args ...interface{}) {
+	panic(&Error{Func: fn, Msg: fmt.Sprintf(format, args...)})
+}
+
+// A frame holds the temporaries of one function activation.
+type frame struct {
+	fn    string
+	temps map[string]int64
+}
+
+// call calls the function name on behalf of the function caller.
+func (s *Simulator) call(caller, name string, args []int64) []int64 {
+	fn, ok := s.funcs[name]
+	if !ok {
+		return s.builtin(caller, name, args)
+	}
+
+	if s.depth >= s.MaxDepth {
+		errorf(caller, "stack overflow calling %s", name)
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+
+	f := &frame{fn: name, temps: make(map[string]int64)}
+	for i, arg := range args {
+		f.temps[ir.Arg(i).Name] = arg
+	}
+
+	for pc := 0; pc < len(fn.body); pc++ {
+		switch stmt := fn.body[pc].(type) {
+		case *ir.Move:
+			switch dst := stmt.Dst.(type) {
+			case *ir.Temp:
+				f.temps[dst.Name] = s.eval(f, stmt.Src)
+			case *ir.Mem:
+				addr := s.eval(f, dst.Addr)
+				*s.word(f, addr) = s.eval(f, stmt.Src)
+			default:
+				errorf(name, "cannot move to %s", ir.Sprint(dst))
+			}
+		case *ir.Exp:
+			s.eval(f, stmt.X)
+		case *ir.Jump:
+			pc = s.label(f, fn, s.target(f, stmt.Target))
+		case *ir.CJump:
+			if s.eval(f, stmt.Cond) != 0 {
+				pc = s.label(f, fn, stmt.True)
+			} else if stmt.False != "" {
+				pc = s.label(f, fn, stmt.False)
+			}
+		case *ir.Label:
+		case *ir.Return:
+			results := make([]int64, len(stmt.Results))
+			for i, x := range stmt.Results {
+				results[i] = s.eval(f, x)
+			}
+			return results
+		default:
+			errorf(name, "cannot execute %s; is the IR lowered?", ir.Sprint(stmt))
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) label(f *frame, fn *function, name string) int {
+	pc, ok := fn.labels[name]
+	if !ok {
+		errorf(f.fn, "undefined label %s", name)
+	}
+	return pc
+}
+
+func (s *Simulator) target(f *frame, x ir.Expr) string {
+	name, ok := x.(*ir.Name)
+	if !ok {
+		errorf(f.fn, "cannot jump to %s", ir.Sprint(x))
+	}
+	return name.Name
+}
+
+// word returns the heap word at address addr.
+func (s *Simulator) word(f *frame, addr int64) *int64 {
+	i := addr / ir.WordSize
+	if addr%ir.WordSize != 0 || i <= 0 || i >= int64(len(s.heap)) {
+		errorf(f.fn, "invalid memory address %#x", addr)
+	}
+	return &s.heap[i]
+}
+
+// alloc allocates a zeroed block of size bytes and returns its address.
+func (s *Simulator) alloc(fn string, size int64) int64 {
+	if size < 0 || size%ir.WordSize != 0 {
+		errorf(fn, "invalid allocation size %d", size)
+	}
+	addr := int64(len(s.heap)) * ir.WordSize
+	s.heap = append(s.heap, make([]int64, size/ir.WordSize)...)
+	return addr
+}
+
+func (s *Simulator) eval(f *frame, expr ir.Expr) int64 {
+	switch x := expr.(type) {
+	case *ir.Const:
+		return x.Value
+	case *ir.Temp:
+		return f.temps[x.Name]
+	case *ir.Mem:
+		return *s.word(f, s.eval(f, x.Addr))
+	case *ir.BinOp:
+		return s.binOp(f, x.Op, s.eval(f, x.X), s.eval(f, x.Y))
+	case *ir.Call:
+		args := make([]int64, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = s.eval(f, arg)
+		}
+		results := s.call(f.fn, s.target(f, x.Target), args)
+		for i, result := range results {
+			f.temps[ir.Ret(i).Name] = result
+		}
+		if len(results) == 0 {
+			return 0
+		}
+		return results[0]
+	}
+
+	errorf(f.fn, "cannot evaluate %s; is the IR lowered?", ir.Sprint(expr))
+	return 0
+}
+
+func b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *Simulator) binOp(f *frame, op ir.Op, x, y int64) int64 {
+	v, ok := ir.Fold(op, x, y)
+	if !ok {
+		if op == ir.Div || op == ir.Mod {
+			errorf(f.fn, "integer division by zero")
+		}
+		errorf(f.fn, "unknown operator %s", op)
+	}
+	return v
+}
+
+func (s *Simulator) builtin(caller, name string, args []int64) []int64 {
+	switch name {
+	case ir.AllocFunc:
+		if len(args) != 1 {
+			errorf(caller, "%s takes 1 argument, got %d", name, len(args))
+		}
+		return []int64{s.alloc(caller, args[0])}
+	case ir.OutOfBoundsFunc:
+		errorf(caller, "array index out of bounds")
+	}
+
+	xiName, params, results, ok := ir.Demangle(name)
+	if !ok {
+		errorf(caller, "undefined function %s", name)
+	}
+	fn, ok := s.externs[xiName]
+	if !ok {
+		errorf(caller, "function %s has no implementation", name)
+	}
+	if len(args) != len(params) {
+		errorf(caller, "%s takes %d arguments, got %d", name, len(params), len(args))
+	}
+
+	values := make([]interp.Value, len(args))
+	for i, arg := range args {
+		values[i] = s.toValue(caller, arg, params[i])
+	}
+	out, err := fn(values)
+	if err != nil {
+		errorf(caller, "%s: %v", xiName, err)
+	}
+
+	words := make([]int64, len(out))
+	for i, v := range out {
+		words[i] = s.fromValue(v)
+	}
+	if len(words) != len(results) {
+		errorf(caller, "%s returned %d results, expected %d", xiName, len(words), len(results))
+	}
+	return words
+}
+
+// toValue converts the word w of type typ, in the encoding of mangled
+// names, to an interpreter value.
+func (s *Simulator) toValue(fn string, w int64, typ string) interp.Value {
+	switch typ[0] {
+	case 'i':
+		return w
+	case 'b':
+		return w != 0
+	}
+
+	f := &frame{fn: fn}
+	n := *s.word(f, w-ir.WordSize)
+	arr := &interp.Array{Elems: make([]interp.Value, n)}
+	for i := range arr.Elems {
+		arr.Elems[i] = s.toValue(fn, *s.word(f, w+int64(i)*ir.WordSize), typ[1:])
+	}
+	return arr
+}
+
+// fromValue converts an interpreter value to a word, allocating arrays on
+// the heap.
+func (s *Simulator) fromValue(v interp.Value) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case bool:
+		return b2i(v)
+	case *interp.Array:
+		block := s.alloc("", int64(len(v.Elems)+1)*ir.WordSize)
+		s.heap[block/ir.WordSize] = int64(len(v.Elems))
+		for i, elem := range v.Elems {
+			w := s.fromValue(elem)
+			s.heap[block/ir.WordSize+1+int64(i)] = w
+		}
+		return block + ir.WordSize
+	}
+	panic(fmt.Sprintf("sim: cannot convert %T", v))
+}
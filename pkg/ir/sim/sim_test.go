@@ -0,0 +1,252 @@
+package sim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/interp"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+type program struct {
+	fset *token.FileSet
+	file *ast.File
+	info *types.Info
+}
+
+func load(t *testing.T, src string) *program {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &types.Config{Importer: importer.New(fset, nil)}
+	info, err := types.Check(fset, []*ast.File{f}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &program{fset, f, info}
+}
+
+// interpret runs the program in the interpreter and returns its output
+// and whether it failed.
+func (p *program) interpret(stdin string, args []string) (string, bool) {
+	var stdout bytes.Buffer
+	in := interp.New(p.fset, p.file)
+	rt := stdlib.NewRuntime(strings.NewReader(stdin), &stdout)
+	rt.Bind(in)
+	err := in.Main(args)
+	rt.Flush()
+	return stdout.String(), err != nil
+}
+
+// simulate translates and lowers the program, runs it in the simulator
+// and returns its output and run-time error.
+func (p *program) simulate(stdin string, args []string) (string, error) {
+	var stdout bytes.Buffer
+	s := New(ir.Lower(ir.Translate("test", p.file, p.info)))
+	rt := stdlib.NewRuntime(strings.NewReader(stdin), &stdout)
+	for name, fn := range rt.Funcs() {
+		s.Bind(name, fn)
+	}
+	err := s.Main(args)
+	rt.Flush()
+	return stdout.String(), err
+}
+
+var differentialTests = []struct {
+	name  string
+	src   string
+	stdin string
+	args  []string
+}{
+	{"hello", `use io
+main(args: int[][]) {
+	println("Hello, wörld\x{21}")
+}
+`, "", nil},
+	{"recursion", `use io
+use conv
+fib(n: int): int {
+	if (n < 2) return n
+	return fib(n - 1) + fib(n - 2)
+}
+ack(m: int, n: int): int {
+	if (m == 0) return n + 1
+	if (n == 0) return ack(m - 1, 1)
+	return ack(m - 1, ack(m, n - 1))
+}
+main(args: int[][]) {
+	println(unparseInt(fib(20)) + " " + unparseInt(ack(2, 3)))
+}
+`, "", nil},
+	{"arrays", `use io
+use conv
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+main(args: int[][]) {
+	a:int[] = {5, -3, 8, 0, 2} + {7} + {}
+	sort(a)
+	i:int = 0
+	while (i < length(a)) {
+		print(unparseInt(a[i]) + " ")
+		i = i + 1
+	}
+	m:int[3][4]
+	m[2][3] = 9
+	b:bool[2][]
+	e:int[] = {}
+	println(unparseInt(length(m) * 10 + length(m[1]) + m[2][3] + length(b[1]) + length(e)))
+	println(unparseInt(length(args[1])) + args[0])
+}
+`, "", []string{"one", "three"}},
+	{"multiple results", `use io
+use conv
+divmod(a: int, b: int): int, int {
+	return a / b, a % b
+}
+main(args: int[][]) {
+	q:int, r:int = divmod(-17, 5)
+	_, r2:int = divmod(q * r, 4)
+	n:int, ok:bool = parseInt("-42")
+	_, bad:bool = parseInt("4x2")
+	if (ok & !bad) println(unparseInt(q) + unparseInt(r) + unparseInt(r2) + unparseInt(n))
+}
+`, "", nil},
+	{"short circuit", `use io
+t(s: int[]): bool { print(s) return true }
+f(s: int[]): bool { print(s) return false }
+main(args: int[][]) {
+	b:bool = f("a") & t("b") | t("c") & !f("d")
+	if (b) println("!")
+	while (f("e") | f("f")) {}
+	x:bool = t("g") | f("h")
+	a:int[] = {}
+	if (length(a) > 0 & a[0] == 1) println("unreachable")
+	println("")
+}
+`, "", nil},
+	{"evaluation order", `use io
+use conv
+inc(a: int[]): int {
+	a[2] = a[2] + 1
+	print(unparseInt(a[2]))
+	return a[2]
+}
+main(args: int[][]) {
+	a:int[] = {0, 0, 0}
+	a[inc(a) - 1] = inc(a) * 10 + inc(a) + a[2]
+	println(" " + unparseInt(a[0]) + " " + unparseInt(a[1]) + " " + unparseInt(a[2]))
+}
+`, "", nil},
+	{"input", `use io
+use conv
+main(args: int[][]) {
+	sum:int = 0
+	while (!eof()) {
+		n:int, ok:bool = parseInt(readln())
+		if (ok) sum = sum + n
+	}
+	println(unparseInt(sum))
+	println(unparseInt(getchar()))
+}
+`, "1\n2\nx\n39\n", nil},
+	{"out of bounds", `use io
+main(args: int[][]) {
+	a:int[] = "ab"
+	println(a)
+	a[-1] = 0
+	println("unreachable")
+}
+`, "", nil},
+	{"division by zero", `use io
+use conv
+main(args: int[][]) {
+	zero:int = length({})
+	println("before")
+	println(unparseInt(1 % zero))
+}
+`, "", nil},
+	{"negative size", `use io
+main(args: int[][]) {
+	n:int = -1
+	println("before")
+	a:int[1][n]
+}
+`, "", nil},
+	{"wrapping", `use io
+use conv
+main(args: int[][]) {
+	min:int = -9223372036854775808
+	println(unparseInt(min - 1) + " " + unparseInt(min / -1) + " " + unparseInt(min % -1) + " " + unparseInt(-min))
+	println(unparseInt(9223372036854775807 * 3))
+}
+`, "", nil},
+}
+
+func TestDifferential(t *testing.T) {
+	for _, test := range differentialTests {
+		t.Run(test.name, func(t *testing.T) {
+			p := load(t, test.src)
+			want, failed := p.interpret(test.stdin, test.args)
+			got, err := p.simulate(test.stdin, test.args)
+			if got != want {
+				t.Errorf("got output %q, expected %q", got, want)
+			}
+			if failed != (err != nil) {
+				t.Errorf("got error %v, expected failure: %v", err, failed)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		src, err string
+	}{
+		{`main(args: int[][]) { a:int[] = {} a[0] = 1 }`, "_Imain_paai: runtime error: array index out of bounds"},
+		{`main(args: int[][]) { x:int = 1 / length(args) }`, "_Imain_paai: runtime error: integer division by zero"},
+		{`main(args: int[][]) { f() } f() { f() }`, "_If_p: runtime error: stack overflow calling _If_p"},
+		{`use io
+main(args: int[][]) { x:int = getchar() }`, "_Imain_paai: runtime error: function _Igetchar_i has no implementation"},
+	} {
+		p := load(t, test.src)
+		s := New(ir.Lower(ir.Translate("test", p.file, p.info)))
+		s.MaxDepth = 100
+		if err := s.Main(nil); err == nil || err.Error() != test.err {
+			t.Errorf("got error %v, expected %s", err, test.err)
+		}
+	}
+}
+
+func TestNotLowered(t *testing.T) {
+	p := load(t, `main(args: int[][]) { a:int[] = {1} }`)
+	cu := ir.Translate("test", p.file, p.info)
+	_, err := New(cu).Call(MainFunc, 0)
+	if err == nil || !strings.Contains(err.Error(), "is the IR lowered?") {
+		t.Errorf("got error %v, expected complaint about unlowered IR", err)
+	}
+}
@@ -0,0 +1,481 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/lit"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// Translate translates file into a compilation unit called name. The file
+// must have been type-checked without errors, yielding info.
+//
+// Arrays are heap blocks holding the length followed by the elements; an
+// array value points at its first element, so the length is the word
+// before it. Every subscript is checked against the length. The boolean
+// operators & and | short-circuit, so they become control flow.
+func Translate(name string, file *ast.File, info *types.Info) *CompUnit {
+	cu := &CompUnit{Name: name}
+	for _, decl := range file.FuncDecls {
+		t := &translator{info: info}
+		cu.Funcs = append(cu.Funcs, t.funcDecl(decl))
+	}
+	return cu
+}
+
+type translator struct {
+	info   *types.Info
+	temps  int
+	labels int
+}
+
+// newTemp returns a fresh temporary. Xi identifiers cannot start with an
+// underscore, so its name cannot clash with a variable's.
+func (t *translator) newTemp() *Temp {
+	t.temps++
+	return &Temp{Name: fmt.Sprintf("_t%d", t.temps)}
+}
+
+func (t *translator) newLabel() string {
+	t.labels++
+	return fmt.Sprintf("_l%d", t.labels)
+}
+
+// Variables are translated to temporaries of the same name. Xi forbids
+// shadowing, so a name denotes a single variable at any point in a
+// function.
+func variable(ident *ast.Ident) *Temp { return &Temp{Name: ident.Name} }
+
+func word(x Expr) Expr { return &BinOp{Op: Mul, X: x, Y: &Const{Value: WordSize}} }
+
+// elem returns the address of element i of array a.
+func elem(a, i Expr) Expr { return &BinOp{Op: Add, X: a, Y: word(i)} }
+
+// length returns the length of array a.
+func length(a Expr) Expr { return &Mem{Addr: &BinOp{Op: Sub, X: a, Y: &Const{Value: WordSize}}} }
+
+func seq(list ...Stmt) *Seq { return &Seq{List: list} }
+
+func (t *translator) funcDecl(decl *ast.FuncDecl) *FuncDecl {
+	sig := t.info.Defs[decl.Name].(*types.Func).Signature()
+
+	var list []Stmt
+	for i, arg := range decl.Args {
+		list = append(list, &Move{Dst: variable(arg.Name), Src: Arg(i)})
+	}
+	list = append(list, t.stmts(decl.Body.List)...)
+
+	// A function with results always ends in a return statement, which
+	// the checker ensures.
+	if sig.Returns().Len() == 0 {
+		list = append(list, &Return{})
+	}
+
+	return &FuncDecl{
+		Name:    Mangle(decl.Name.Name, sig),
+		NumArgs: len(decl.Args),
+		NumRets: sig.Returns().Len(),
+		Body:    seq(list...),
+	}
+}
+
+func (t *translator) stmts(list []ast.Stmt) []Stmt {
+	var stmts []Stmt
+	for _, stmt := range list {
+		stmts = append(stmts, t.stmt(stmt))
+	}
+	return stmts
+}
+
+func (t *translator) stmt(stmt ast.Stmt) Stmt {
+	switch s := stmt.(type) {
+	case *ast.SingleDeclStmt:
+		if s.Init != nil {
+			return &Move{Dst: variable(s.Spec.Name), Src: t.expr(s.Init)}
+		}
+		return t.zero(s.Spec)
+
+	case *ast.MultiDeclStmt:
+		list := []Stmt{&Exp{X: t.call(s.Init)}}
+		for i, assignable := range s.Assignables {
+			if spec, ok := assignable.(*ast.Spec); ok {
+				list = append(list, &Move{Dst: variable(spec.Name), Src: Ret(i)})
+			}
+		}
+		return seq(list...)
+
+	case *ast.AssignStmt:
+		return t.assign(s)
+
+	case *ast.CallExpr:
+		return &Exp{X: t.call(s)}
+
+	case *ast.IfStmt:
+		then, end := t.newLabel(), t.newLabel()
+		if s.Else == nil {
+			return seq(
+				t.cond(s.Cond, then, end),
+				&Label{Name: then},
+				t.stmt(s.Then),
+				&Label{Name: end},
+			)
+		}
+		els := t.newLabel()
+		return seq(
+			t.cond(s.Cond, then, els),
+			&Label{Name: then},
+			t.stmt(s.Then),
+			&Jump{Target: &Name{Name: end}},
+			&Label{Name: els},
+			t.stmt(s.Else),
+			&Label{Name: end},
+		)
+
+	case *ast.WhileStmt:
+		head, body, end := t.newLabel(), t.newLabel(), t.newLabel()
+		return seq(
+			&Label{Name: head},
+			t.cond(s.Cond, body, end),
+			&Label{Name: body},
+			t.stmt(s.Body),
+			&Jump{Target: &Name{Name: head}},
+			&Label{Name: end},
+		)
+
+	case *ast.ReturnStmt:
+		results := make([]Expr, len(s.Values))
+		for i, value := range s.Values {
+			results[i] = t.expr(value)
+		}
+		return &Return{Results: results}
+
+	case *ast.BlockStmt:
+		return seq(t.stmts(s.List)...)
+	}
+
+	panic(fmt.Sprintf("ir: cannot translate %T", stmt))
+}
+
+func (t *translator) assign(s *ast.AssignStmt) Stmt {
+	switch lhs := s.Lhs.(type) {
+	case *ast.Ident:
+		return &Move{Dst: variable(lhs), Src: t.expr(s.Rhs)}
+	case *ast.SubscriptExpr:
+		// As in the interpreter, the array, the index and the value are
+		// evaluated in that order before the index is checked.
+		arr, index, value := t.newTemp(), t.newTemp(), t.newTemp()
+		return seq(
+			&Move{Dst: arr, Src: t.expr(lhs.Lhs)},
+			&Move{Dst: index, Src: t.expr(lhs.Subscript)},
+			&Move{Dst: value, Src: t.expr(s.Rhs)},
+			t.checkIndex(arr, index),
+			&Move{Dst: &Mem{Addr: elem(arr, index)}, Src: value},
+		)
+	}
+
+	panic(fmt.Sprintf("ir: cannot assign to %T", s.Lhs))
+}
+
+// zero initializes a variable declared without an initializer, following
+// the interpreter's zero in pkg/interp, which is the reference for the
+// order of the dimensions and the evaluation of their sizes.
+func (t *translator) zero(spec *ast.Spec) Stmt {
+	var dims []*ast.ArrayType
+	for typ := spec.Type; ; {
+		arr, ok := typ.(*ast.ArrayType)
+		if !ok {
+			break
+		}
+		dims = append([]*ast.ArrayType{arr}, dims...)
+		typ = arr.Elt
+	}
+
+	if len(dims) == 0 {
+		return &Move{Dst: variable(spec.Name), Src: &Const{Value: 0}}
+	}
+
+	var list []Stmt
+	var sizes []Expr
+	for _, dim := range dims {
+		if dim.Size == nil {
+			break
+		}
+		size := t.newTemp()
+		list = append(list, &Move{Dst: size, Src: t.expr(dim.Size)})
+		sizes = append(sizes, size)
+	}
+
+	for _, size := range sizes {
+		list = append(list, t.checkSize(size))
+	}
+
+	alloc, arr := t.allocDims(sizes, len(dims))
+	list = append(list, alloc, &Move{Dst: variable(spec.Name), Src: arr})
+	return seq(list...)
+}
+
+// allocDims allocates an array with n dimensions, the first len(sizes) of
+// which are sized. It returns the statement doing so and the temporary
+// holding the array.
+func (t *translator) allocDims(sizes []Expr, n int) (Stmt, *Temp) {
+	if len(sizes) == 0 {
+		return t.alloc(&Const{Value: 0})
+	}
+
+	alloc, arr := t.alloc(sizes[0])
+	if n == 1 {
+		return alloc, arr
+	}
+
+	fill := t.loop(length(arr), func(i *Temp) Stmt {
+		inner, sub := t.allocDims(sizes[1:], n-1)
+		return seq(inner, &Move{Dst: &Mem{Addr: elem(arr, i)}, Src: sub})
+	})
+	return seq(alloc, fill), arr
+}
+
+// alloc allocates a zeroed array of n elements.
+func (t *translator) alloc(n Expr) (Stmt, *Temp) {
+	size, block, arr := t.newTemp(), t.newTemp(), t.newTemp()
+	return seq(
+		&Move{Dst: size, Src: n},
+		&Move{Dst: block, Src: &Call{
+			Target: &Name{Name: AllocFunc},
+			Args:   []Expr{word(&BinOp{Op: Add, X: size, Y: &Const{Value: 1}})},
+		}},
+		&Move{Dst: &Mem{Addr: block}, Src: size},
+		&Move{Dst: arr, Src: &BinOp{Op: Add, X: block, Y: &Const{Value: WordSize}}},
+	), arr
+}
+
+// loop returns a loop executing body for i from 0 up to n, exclusive. n is
+// evaluated once.
+func (t *translator) loop(n Expr, body func(i *Temp) Stmt) Stmt {
+	i, limit := t.newTemp(), t.newTemp()
+	head, next, end := t.newLabel(), t.newLabel(), t.newLabel()
+	return seq(
+		&Move{Dst: i, Src: &Const{Value: 0}},
+		&Move{Dst: limit, Src: n},
+		&Label{Name: head},
+		&CJump{Cond: &BinOp{Op: Lt, X: i, Y: limit}, True: next, False: end},
+		&Label{Name: next},
+		body(i),
+		&Move{Dst: i, Src: &BinOp{Op: Add, X: i, Y: &Const{Value: 1}}},
+		&Jump{Target: &Name{Name: head}},
+		&Label{Name: end},
+	)
+}
+
+// checkIndex aborts the program unless 0 <= index < length(arr). Comparing
+// without sign catches negative indices as well.
+func (t *translator) checkIndex(arr, index Expr) Stmt {
+	return t.check(&BinOp{Op: ULt, X: index, Y: length(arr)})
+}
+
+// checkSize aborts the program if an array size is negative.
+func (t *translator) checkSize(size Expr) Stmt {
+	return t.check(&BinOp{Op: Geq, X: size, Y: &Const{Value: 0}})
+}
+
+func (t *translator) check(cond Expr) Stmt {
+	ok, fail := t.newLabel(), t.newLabel()
+	return seq(
+		&CJump{Cond: cond, True: ok, False: fail},
+		&Label{Name: fail},
+		&Exp{X: &Call{Target: &Name{Name: OutOfBoundsFunc}}},
+		&Label{Name: ok},
+	)
+}
+
+func (t *translator) call(call *ast.CallExpr) *Call {
+	fn := t.info.Uses[call.Func].(*types.Func)
+	args := make([]Expr, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = t.expr(arg)
+	}
+	return &Call{Target: &Name{Name: Mangle(fn.Name(), fn.Signature())}, Args: args}
+}
+
+func (t *translator) expr(expr ast.Expr) Expr {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		return t.basicLit(x)
+
+	case *ast.Ident:
+		return variable(x)
+
+	case *ast.ArrayLit:
+		elts := make([]Expr, len(x.Elts))
+		for i, elt := range x.Elts {
+			elts[i] = t.expr(elt)
+		}
+		return t.array(elts)
+
+	case *ast.CallExpr:
+		return t.call(x)
+
+	case *ast.LengthExpr:
+		return length(t.expr(x.Arg))
+
+	case *ast.SubscriptExpr:
+		arr, index := t.newTemp(), t.newTemp()
+		return &ESeq{
+			Stmt: seq(
+				&Move{Dst: arr, Src: t.expr(x.Lhs)},
+				&Move{Dst: index, Src: t.expr(x.Subscript)},
+				t.checkIndex(arr, index),
+			),
+			Expr: &Mem{Addr: elem(arr, index)},
+		}
+
+	case *ast.UnaryExpr:
+		switch x.Op {
+		case token.Sub:
+			return &BinOp{Op: Sub, X: &Const{Value: 0}, Y: t.expr(x.Rhs)}
+		case token.Not:
+			return &BinOp{Op: Xor, X: &Const{Value: 1}, Y: t.expr(x.Rhs)}
+		}
+
+	case *ast.BinaryExpr:
+		return t.binaryExpr(x)
+	}
+
+	panic(fmt.Sprintf("ir: cannot translate %T", expr))
+}
+
+var binops = map[token.TokenType]Op{
+	token.Add:  Add,
+	token.Sub:  Sub,
+	token.Mul:  Mul,
+	token.HMul: HMul,
+	token.Div:  Div,
+	token.Rem:  Mod,
+	token.Eq:   Eq,
+	token.Neq:  Neq,
+	token.Lt:   Lt,
+	token.Le:   Leq,
+	token.Gt:   Gt,
+	token.Ge:   Geq,
+}
+
+func (t *translator) binaryExpr(x *ast.BinaryExpr) Expr {
+	switch x.Op {
+	case token.And, token.Or:
+		// Materialize the outcome of the short-circuiting jumps.
+		value := t.newTemp()
+		yes, no := t.newLabel(), t.newLabel()
+		return &ESeq{
+			Stmt: seq(
+				&Move{Dst: value, Src: &Const{Value: 0}},
+				t.cond(x, yes, no),
+				&Label{Name: yes},
+				&Move{Dst: value, Src: &Const{Value: 1}},
+				&Label{Name: no},
+			),
+			Expr: value,
+		}
+	case token.Add:
+		if _, ok := t.info.TypeOf(x).(*types.Array); ok {
+			return t.concat(t.expr(x.Lhs), t.expr(x.Rhs))
+		}
+	}
+
+	return &BinOp{Op: binops[x.Op], X: t.expr(x.Lhs), Y: t.expr(x.Rhs)}
+}
+
+// cond translates a boolean expression into jumps to yes if it is true and
+// to no otherwise.
+func (t *translator) cond(expr ast.Expr, yes, no string) Stmt {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.True:
+			return &Jump{Target: &Name{Name: yes}}
+		case token.False:
+			return &Jump{Target: &Name{Name: no}}
+		}
+	case *ast.UnaryExpr:
+		if x.Op == token.Not {
+			return t.cond(x.Rhs, no, yes)
+		}
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.And:
+			mid := t.newLabel()
+			return seq(t.cond(x.Lhs, mid, no), &Label{Name: mid}, t.cond(x.Rhs, yes, no))
+		case token.Or:
+			mid := t.newLabel()
+			return seq(t.cond(x.Lhs, yes, mid), &Label{Name: mid}, t.cond(x.Rhs, yes, no))
+		}
+	}
+
+	return &CJump{Cond: t.expr(expr), True: yes, False: no}
+}
+
+// array allocates an array holding the values of elts, which are evaluated
+// in order.
+func (t *translator) array(elts []Expr) Expr {
+	alloc, arr := t.alloc(&Const{Value: int64(len(elts))})
+	list := []Stmt{alloc}
+	for i, elt := range elts {
+		list = append(list, &Move{Dst: &Mem{Addr: elem(arr, &Const{Value: int64(i)})}, Src: elt})
+	}
+	return &ESeq{Stmt: seq(list...), Expr: arr}
+}
+
+// concat returns a new array holding the elements of a followed by those of
+// b.
+func (t *translator) concat(a, b Expr) Expr {
+	x, y := t.newTemp(), t.newTemp()
+	alloc, arr := t.alloc(&BinOp{Op: Add, X: length(x), Y: length(y)})
+	return &ESeq{
+		Stmt: seq(
+			&Move{Dst: x, Src: a},
+			&Move{Dst: y, Src: b},
+			alloc,
+			t.loop(length(x), func(i *Temp) Stmt {
+				return &Move{Dst: &Mem{Addr: elem(arr, i)}, Src: &Mem{Addr: elem(x, i)}}
+			}),
+			t.loop(length(y), func(i *Temp) Stmt {
+				dst := elem(arr, &BinOp{Op: Add, X: length(x), Y: i})
+				return &Move{Dst: &Mem{Addr: dst}, Src: &Mem{Addr: elem(y, i)}}
+			}),
+		),
+		Expr: arr,
+	}
+}
+
+func (t *translator) basicLit(x *ast.BasicLit) Expr {
+	switch x.Kind {
+	case token.Integer:
+		n, err := lit.ParseInt(x.Value)
+		if err != nil {
+			panic(fmt.Sprintf("ir: invalid integer literal %s: %v", x.Value, err))
+		}
+		return &Const{Value: n}
+	case token.Char:
+		c, err := lit.UnquoteChar(x.Value)
+		if err != nil {
+			panic(fmt.Sprintf("ir: invalid character literal %s: %v", x.Value, err))
+		}
+		return &Const{Value: c}
+	case token.String:
+		s, err := lit.Unquote(x.Value)
+		if err != nil {
+			panic(fmt.Sprintf("ir: invalid string literal %s: %v", x.Value, err))
+		}
+		elts := make([]Expr, len(s))
+		for i, r := range s {
+			elts[i] = &Const{Value: int64(r)}
+		}
+		return t.array(elts)
+	case token.True:
+		return &Const{Value: 1}
+	case token.False:
+		return &Const{Value: 0}
+	}
+
+	panic(fmt.Sprintf("ir: invalid literal %s", x.Value))
+}
@@ -0,0 +1,255 @@
+// Package jsonrpc2 implements the JSON-RPC 2.0 protocol over a framed
+// stream, as used by the Language Server Protocol.
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by calls that are pending when the connection ends.
+var ErrClosed = errors.New("jsonrpc2: connection closed")
+
+// A Handler handles an incoming request or notification. The result of a
+// call is marshaled as the response; the result of a notification is
+// discarded.
+type Handler func(ctx context.Context, conn *Conn, req *Request) (result interface{}, err error)
+
+// A Conn is a bidirectional JSON-RPC connection. Either side may issue
+// calls and notifications.
+type Conn struct {
+	stream Stream
+	seq    int64 // last call ID; accessed atomically
+
+	mu      sync.Mutex
+	pending map[ID]chan *Response
+	closed  bool
+
+	// incoming requests, handled one at a time in arrival order
+	queueMu sync.Mutex
+	queue   []*Request
+	ready   chan struct{}
+}
+
+// NewConn returns a connection speaking JSON-RPC over stream. Nothing is
+// read from the stream until Run is called.
+func NewConn(stream Stream) *Conn {
+	return &Conn{
+		stream:  stream,
+		pending: make(map[ID]chan *Response),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Run reads and dispatches messages until the stream ends or ctx is done.
+// Requests and notifications are passed to h one at a time, in the order
+// they arrived, so a handler observes the effects of all earlier messages.
+// Responses to calls made with Call are delivered as soon as they arrive,
+// so handlers may themselves call back into the peer.
+//
+// Run returns nil if the peer closed the stream.
+func (c *Conn) Run(ctx context.Context, h Handler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.read() }()
+
+	for {
+		if req := c.dequeue(); req != nil {
+			c.handle(ctx, h, req)
+			continue
+		}
+
+		select {
+		case <-c.ready:
+		case err := <-readErr:
+			// Drain whatever arrived before the stream ended.
+			for req := c.dequeue(); req != nil; req = c.dequeue() {
+				c.handle(ctx, h, req)
+			}
+			c.close()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			c.close()
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Conn) read() error {
+	for {
+		data, err := c.stream.Read()
+		if err != nil {
+			return err
+		}
+
+		req, resp, err := decode(data)
+		switch {
+		case err != nil:
+			var rpcErr *Error
+			errors.As(err, &rpcErr)
+			c.reply(nil, nil, rpcErr)
+		case req != nil:
+			c.enqueue(req)
+		default:
+			c.deliver(resp)
+		}
+	}
+}
+
+func (c *Conn) enqueue(req *Request) {
+	c.queueMu.Lock()
+	c.queue = append(c.queue, req)
+	c.queueMu.Unlock()
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) dequeue() *Request {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if len(c.queue) == 0 {
+		return nil
+	}
+	req := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	return req
+}
+
+func (c *Conn) handle(ctx context.Context, h Handler, req *Request) {
+	result, err := h(ctx, c, req)
+	if req.IsNotify() {
+		return
+	}
+
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = Errorf(InternalError, "%v", err)
+		}
+		c.reply(req.ID, nil, rpcErr)
+		return
+	}
+
+	c.reply(req.ID, result, nil)
+}
+
+func (c *Conn) reply(id *ID, result interface{}, rpcErr *Error) {
+	resp := &Response{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = Errorf(InternalError, "marshaling result: %v", err)
+		} else {
+			resp.Result = data
+		}
+	}
+
+	if data, err := encodeResponse(resp); err == nil {
+		c.stream.Write(data)
+	}
+}
+
+func (c *Conn) deliver(resp *Response) {
+	if resp.ID == nil {
+		return
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[*resp.ID]
+	delete(c.pending, *resp.ID)
+	c.mu.Unlock()
+
+	if ok {
+		ch <- resp
+	}
+}
+
+func (c *Conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Call sends a request and waits for the response, which is unmarshaled
+// into result unless result is nil. If the peer answers with an error, it
+// is returned as an *Error.
+func (c *Conn) Call(ctx context.Context, method string, params, result interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	id := ID{Num: atomic.AddInt64(&c.seq, 1)}
+	ch := make(chan *Response, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	data, err := encodeRequest(&Request{ID: &id, Method: method, Params: raw})
+	if err == nil {
+		err = c.stream.Write(data)
+	}
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification, which the peer does not answer.
+func (c *Conn) Notify(ctx context.Context, method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeRequest(&Request{Method: method, Params: raw})
+	if err != nil {
+		return err
+	}
+	return c.stream.Write(data)
+}
@@ -0,0 +1,158 @@
+package jsonrpc2
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const version = "2.0"
+
+// Standard JSON-RPC 2.0 error codes, and the ones reserved by the Language
+// Server Protocol.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+
+	ServerNotInitialized = -32002
+	RequestCancelled     = -32800
+)
+
+// An Error is a JSON-RPC error object. Handlers may return an *Error to
+// control the code sent to the client; any other error is reported as an
+// InternalError.
+type Error struct {
+	Code    int64            `json:"code"`
+	Message string           `json:"message"`
+	Data    *json.RawMessage `json:"data,omitempty"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("jsonrpc2: code %d: %s", err.Code, err.Message)
+}
+
+// Errorf returns an *Error with the given code and formatted message.
+func Errorf(code int64, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// An ID identifies a request. It is either a number or a string; the
+// zero ID is not a valid request ID.
+type ID struct {
+	Num  int64
+	Name string
+}
+
+func (id ID) String() string {
+	if id.Name != "" {
+		return fmt.Sprintf("%q", id.Name)
+	}
+	return fmt.Sprintf("#%d", id.Num)
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.Name != "" {
+		return json.Marshal(id.Name)
+	}
+	return json.Marshal(id.Num)
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	*id = ID{}
+	if err := json.Unmarshal(data, &id.Num); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &id.Name)
+}
+
+// A Request is a call or, if ID is nil, a notification.
+type Request struct {
+	ID     *ID
+	Method string
+	Params json.RawMessage
+}
+
+// IsNotify reports whether the request is a notification, which must not
+// be answered.
+func (r *Request) IsNotify() bool { return r.ID == nil }
+
+// A Response is the answer to a call. ID is nil only for errors about
+// messages whose ID could not be determined.
+type Response struct {
+	ID     *ID
+	Result json.RawMessage
+	Error  *Error
+}
+
+// wireMessage is the union of all JSON-RPC message shapes as they appear on
+// the wire.
+type wireMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *ID              `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  *json.RawMessage `json:"params,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// decode parses a single message, returning either a request or a response.
+func decode(data []byte) (*Request, *Response, error) {
+	var msg wireMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, nil, Errorf(ParseError, "%v", err)
+	}
+
+	if msg.Method != "" {
+		req := &Request{ID: msg.ID, Method: msg.Method}
+		if msg.Params != nil {
+			req.Params = *msg.Params
+		}
+		return req, nil, nil
+	}
+
+	// Error responses about unparseable messages carry a null id.
+	if msg.ID == nil && msg.Error == nil {
+		return nil, nil, Errorf(InvalidRequest, "message has neither a method nor an id")
+	}
+
+	resp := &Response{ID: msg.ID, Error: msg.Error}
+	if msg.Result != nil {
+		resp.Result = *msg.Result
+	} else if msg.Error == nil {
+		// A null result decodes as a nil pointer, just like a missing one.
+		resp.Result = json.RawMessage("null")
+	}
+	return nil, resp, nil
+}
+
+func encodeRequest(req *Request) ([]byte, error) {
+	msg := wireMessage{JSONRPC: version, ID: req.ID, Method: req.Method}
+	if req.Params != nil {
+		msg.Params = &req.Params
+	}
+	return json.Marshal(msg)
+}
+
+// wireResponse differs from wireMessage in that the id is always present,
+// possibly as null.
+type wireResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *ID              `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+func encodeResponse(resp *Response) ([]byte, error) {
+	msg := wireResponse{JSONRPC: version, ID: resp.ID, Error: resp.Error}
+	if resp.Error == nil {
+		// A successful response must carry a result, even if it is null.
+		result := resp.Result
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		msg.Result = &result
+	}
+	return json.Marshal(msg)
+}
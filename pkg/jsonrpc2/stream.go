@@ -0,0 +1,85 @@
+package jsonrpc2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Stream reads and writes framed JSON-RPC messages.
+type Stream interface {
+	// Read returns the next message. It returns io.EOF once the peer has
+	// closed the stream.
+	Read() ([]byte, error)
+
+	// Write sends a single message. It is safe for concurrent use.
+	Write(data []byte) error
+}
+
+type headerStream struct {
+	in *bufio.Reader
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewHeaderStream returns a Stream that frames each message with a
+// Content-Length header, as the Language Server Protocol requires.
+func NewHeaderStream(r io.Reader, w io.Writer) Stream {
+	return &headerStream{in: bufio.NewReader(r), out: w}
+}
+
+func (s *headerStream) Read() ([]byte, error) {
+	length := -1
+
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+
+		name, value := strings.TrimSpace(line[:colon]), strings.TrimSpace(line[colon+1:])
+		if strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(value); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return nil, fmt.Errorf("reading message body: %w", err)
+	}
+	return data, nil
+}
+
+func (s *headerStream) Write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err := s.out.Write(data)
+	return err
+}
@@ -0,0 +1,95 @@
+package jsonrpc2
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHeaderStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewHeaderStream(nil, &buf)
+	for _, msg := range []string{`{"a":1}`, `{}`, `"héllo"`} {
+		if err := w.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "Content-Length: 7\r\n\r\n{\"a\":1}Content-Length: 2\r\n\r\n{}Content-Length: 8\r\n\r\n\"héllo\""
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	// Extra headers are ignored and the header name is case-insensitive.
+	input := buf.String() + "content-length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n[]"
+	r := NewHeaderStream(strings.NewReader(input), nil)
+	for _, want := range []string{`{"a":1}`, `{}`, `"héllo"`, `[]`} {
+		data, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("got %q, expected %q", data, want)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("got %v at end of stream, expected io.EOF", err)
+	}
+}
+
+func TestHeaderStreamErrors(t *testing.T) {
+	for _, input := range []string{
+		"\r\n{}",
+		"Content-Length: x\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+		"Content-Length: 2\r\n",
+		"garbage\r\n\r\n",
+	} {
+		if _, err := NewHeaderStream(strings.NewReader(input), nil).Read(); err == nil || err == io.EOF {
+			t.Errorf("%q: got %v, expected an error", input, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	req, _, err := decode([]byte(`{"jsonrpc":"2.0","id":"abc","method":"m","params":[1]}`))
+	if err != nil || req == nil || req.ID == nil || req.ID.Name != "abc" || string(req.Params) != "[1]" {
+		t.Errorf("call: got %+v, %v", req, err)
+	}
+
+	req, _, err = decode([]byte(`{"jsonrpc":"2.0","method":"n"}`))
+	if err != nil || req == nil || !req.IsNotify() {
+		t.Errorf("notification: got %+v, %v", req, err)
+	}
+
+	_, resp, err := decode([]byte(`{"jsonrpc":"2.0","id":0,"result":null}`))
+	if err != nil || resp == nil || resp.ID == nil || resp.ID.Num != 0 || string(resp.Result) != "null" {
+		t.Errorf("response: got %+v, %v", resp, err)
+	}
+
+	if _, _, err := decode([]byte(`{`)); err == nil || err.(*Error).Code != ParseError {
+		t.Errorf("got %v, expected a parse error", err)
+	}
+	if _, _, err := decode([]byte(`{"jsonrpc":"2.0"}`)); err == nil || err.(*Error).Code != InvalidRequest {
+		t.Errorf("got %v, expected an invalid request error", err)
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	data, err := encodeResponse(&Response{ID: &ID{Num: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"jsonrpc":"2.0","id":0,"result":null}`; string(data) != want {
+		t.Errorf("got %s, expected %s", data, want)
+	}
+
+	data, err = encodeResponse(&Response{Error: Errorf(ParseError, "bad")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"bad"}}`; string(data) != want {
+		t.Errorf("got %s, expected %s", data, want)
+	}
+}
@@ -0,0 +1,191 @@
+// Package lit decodes and encodes Xi literals.
+//
+// An integer literal is a sequence of decimal digits. A string or
+// character literal is enclosed in double quotes (strings) or single quotes
+// (characters) and may contain any Unicode character but a line break,
+// a backslash or its own quote, which must be escaped. The escapes are
+//
+//	\n  \t  \\  \'  \"
+//	\x{h...}  the code point with 1 to 6 hexadecimal digits h
+//
+// where the code point must be at most 0x10FFFF and not a surrogate half.
+// A character literal holds exactly one code point.
+package lit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxHexDigits is the most hexadecimal digits a \x{...} escape may have.
+const MaxHexDigits = 6
+
+// An Error describes a malformed literal.
+type Error struct {
+	Offset int // byte offset in the literal of the offending character
+	Msg    string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset) }
+
+func errorf(offset int, format string, args ...interface{}) *Error {
+	return &Error{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseInt returns the value of lit, a decimal integer literal. The
+// literal 9223372036854775808 is only valid as the operand of unary minus;
+// ParseInt returns it as the minimum int64, whose negation is itself, and
+// leaves it to the caller to reject it elsewhere.
+func ParseInt(lit string) (int64, error) {
+	n, err := strconv.ParseUint(lit, 10, 64)
+	switch {
+	case err != nil && err.(*strconv.NumError).Err == strconv.ErrSyntax:
+		return 0, errorf(0, "malformed integer literal %s", lit)
+	case err != nil || n > 1<<63:
+		return 0, errorf(0, "integer literal %s out of range", lit)
+	}
+	return int64(n), nil
+}
+
+// Unquote returns the code points of lit, a string or character literal.
+func Unquote(lit string) ([]rune, error) {
+	if len(lit) < 2 || lit[0] != lit[len(lit)-1] || (lit[0] != '"' && lit[0] != '\'') {
+		return nil, errorf(0, "literal is not quoted")
+	}
+
+	quote := lit[0]
+	var s []rune
+	for i := 1; i < len(lit)-1; {
+		r, size := utf8.DecodeRuneInString(lit[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			return nil, errorf(i, "invalid UTF-8 encoding")
+		case r == '\n':
+			return nil, errorf(i, "line break in literal")
+		case r == rune(quote):
+			return nil, errorf(i, "unescaped %c in literal", quote)
+		case r == '\\':
+			r, n, err := unescape(lit, i)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, r)
+			i += n
+		default:
+			s = append(s, r)
+			i += size
+		}
+	}
+	return s, nil
+}
+
+// unescape decodes the escape sequence at lit[i], which is a backslash,
+// and returns its code point and length. The closing quote of lit is
+// never part of an escape.
+func unescape(lit string, i int) (rune, int, error) {
+	body := lit[:len(lit)-1]
+	if i+1 == len(body) {
+		return 0, 0, errorf(i, "incomplete escape sequence")
+	}
+
+	switch c := body[i+1]; c {
+	case 'n':
+		return '\n', 2, nil
+	case 't':
+		return '\t', 2, nil
+	case '\\', '\'', '"':
+		return rune(c), 2, nil
+	case 'x':
+	default:
+		r, _ := utf8.DecodeRuneInString(body[i+1:])
+		return 0, 0, errorf(i, "unknown escape sequence \\%c", r)
+	}
+
+	j := i + 2
+	if j == len(body) || body[j] != '{' {
+		return 0, 0, errorf(j, "expected { in \\x escape")
+	}
+	j++
+	start := j
+	for j < len(body) && isHexDigit(body[j]) {
+		j++
+	}
+	switch {
+	case j == start:
+		return 0, 0, errorf(j, "expected hexadecimal digit in \\x escape")
+	case j-start > MaxHexDigits:
+		return 0, 0, errorf(start+MaxHexDigits, "more than %d hexadecimal digits in \\x escape", MaxHexDigits)
+	case j == len(body) || body[j] != '}':
+		return 0, 0, errorf(j, "expected } in \\x escape")
+	}
+
+	n, _ := strconv.ParseUint(body[start:j], 16, 32)
+	switch {
+	case n > unicode.MaxRune:
+		return 0, 0, errorf(start, "code point %#x out of range", n)
+	case 0xD800 <= n && n <= 0xDFFF:
+		return 0, 0, errorf(start, "surrogate half %#x is not a code point", n)
+	}
+	return rune(n), j + 1 - i, nil
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// UnquoteChar returns the code point of lit, a character literal.
+func UnquoteChar(lit string) (int64, error) {
+	if len(lit) < 2 || lit[0] != '\'' {
+		return 0, errorf(0, "character literal is not quoted")
+	}
+	s, err := Unquote(lit)
+	switch {
+	case err != nil:
+		return 0, err
+	case len(s) == 0:
+		return 0, errorf(1, "empty character literal")
+	case len(s) > 1:
+		return 0, errorf(1, "more than one character in character literal")
+	}
+	return int64(s[0]), nil
+}
+
+// Quote returns a string literal for s. Printable characters stand for
+// themselves; the others are escaped, as \n, \t or \x{...}.
+func Quote(s []rune) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		quoteRune(&b, r, '"')
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// QuoteChar returns a character literal for r.
+func QuoteChar(r rune) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	quoteRune(&b, r, '\'')
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func quoteRune(b *strings.Builder, r rune, quote rune) {
+	switch {
+	case r == quote || r == '\\':
+		b.WriteByte('\\')
+		b.WriteRune(r)
+	case r == '\n':
+		b.WriteString(`\n`)
+	case r == '\t':
+		b.WriteString(`\t`)
+	case unicode.IsPrint(r) && utf8.ValidRune(r):
+		b.WriteRune(r)
+	default:
+		fmt.Fprintf(b, `\x{%X}`, r)
+	}
+}
@@ -0,0 +1,152 @@
+package lit
+
+import (
+	"testing"
+)
+
+func TestUnquote(t *testing.T) {
+	for _, test := range []struct {
+		lit, want string
+	}{
+		{`"abc"`, "abc"},
+		{`"a\nb\t\\\""`, "a\nb\t\\\""},
+		{`'\''`, "'"},
+		{`"'"`, "'"},
+		{`'"'`, `"`},
+		{`"\x{48}\x{1F600}!"`, "H😀!"},
+		{`"\x{0}\x{10FFFF}"`, "\x00\U0010FFFF"},
+		{`"\x{00e9}\x{00E9}"`, "éé"},
+		{`"β"`, "β"},
+		{`""`, ""},
+	} {
+		got, err := Unquote(test.lit)
+		if err != nil || string(got) != test.want {
+			t.Errorf("Unquote(%s) = %q, %v; expected %q", test.lit, string(got), err, test.want)
+		}
+	}
+}
+
+func TestUnquoteErrors(t *testing.T) {
+	for _, test := range []struct {
+		lit    string
+		offset int
+	}{
+		{`"\q"`, 1},
+		{`"ab\`, 0},
+		{`"\`, 0},
+		{`abc`, 0},
+		{`"abc'`, 0},
+		{`"a"b"`, 2},
+		{"\"a\nb\"", 2},
+		{"\"a\xffb\"", 2},
+		{`"ab\"`, 3},
+		{`"\x41"`, 3},
+		{`"\x{}"`, 4},
+		{`"\x{g}"`, 4},
+		{`"\x{41"`, 6},
+		{`"\x{1234567}"`, 10},
+		{`"a\x{110000}"`, 5},
+		{`"\x{D800}"`, 4},
+		{`"\x{dfff}"`, 4},
+	} {
+		_, err := Unquote(test.lit)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Unquote(%q) = %v; expected an *Error", test.lit, err)
+			continue
+		}
+		if e.Offset != test.offset {
+			t.Errorf("Unquote(%q): error %q at offset %d; expected offset %d", test.lit, e.Msg, e.Offset, test.offset)
+		}
+	}
+}
+
+func TestUnquoteChar(t *testing.T) {
+	for _, test := range []struct {
+		lit  string
+		want int64
+	}{
+		{`'a'`, 'a'},
+		{`'\n'`, '\n'},
+		{`'\''`, '\''},
+		{`'\\'`, '\\'},
+		{`'"'`, '"'},
+		{`'β'`, 'β'},
+		{`'\x{1F600}'`, 0x1F600},
+	} {
+		got, err := UnquoteChar(test.lit)
+		if err != nil || got != test.want {
+			t.Errorf("UnquoteChar(%s) = %d, %v; expected %d", test.lit, got, err, test.want)
+		}
+	}
+
+	for _, lit := range []string{`''`, `'ab'`, `'''`, `"a"`, `'\x{D800}'`, `'`} {
+		if _, err := UnquoteChar(lit); err == nil {
+			t.Errorf("UnquoteChar(%s) succeeded", lit)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, test := range []struct {
+		s, want string
+	}{
+		{"abc", `"abc"`},
+		{"a\nb\tc", `"a\nb\tc"`},
+		{`"\'`, `"\"\\'"`},
+		{"é😀", `"é😀"`},
+		{"\x00\x7f​", `"\x{0}\x{7F}\x{200B}"`},
+		{"", `""`},
+	} {
+		got := Quote([]rune(test.s))
+		if got != test.want {
+			t.Errorf("Quote(%q) = %s; expected %s", test.s, got, test.want)
+		}
+		if s, err := Unquote(got); err != nil || string(s) != test.s {
+			t.Errorf("Unquote(Quote(%q)) = %q, %v", test.s, string(s), err)
+		}
+	}
+
+	for _, test := range []struct {
+		r    rune
+		want string
+	}{
+		{'a', `'a'`},
+		{'\'', `'\''`},
+		{'"', `'"'`},
+		{'\\', `'\\'`},
+		{'\n', `'\n'`},
+		{0x1b, `'\x{1B}'`},
+	} {
+		got := QuoteChar(test.r)
+		if got != test.want {
+			t.Errorf("QuoteChar(%q) = %s; expected %s", test.r, got, test.want)
+		}
+		if r, err := UnquoteChar(got); err != nil || r != int64(test.r) {
+			t.Errorf("UnquoteChar(QuoteChar(%q)) = %d, %v", test.r, r, err)
+		}
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	for _, test := range []struct {
+		lit  string
+		want int64
+	}{
+		{"0", 0},
+		{"007", 7},
+		{"9223372036854775807", 1<<63 - 1},
+		{"9223372036854775808", -1 << 63},
+	} {
+		got, err := ParseInt(test.lit)
+		if err != nil || got != test.want {
+			t.Errorf("ParseInt(%s) = %d, %v; expected %d", test.lit, got, err, test.want)
+		}
+	}
+
+	for _, lit := range []string{"", "9223372036854775809", "99999999999999999999", "-1", "+1", "1_000", "12a"} {
+		if _, err := ParseInt(lit); err == nil {
+			t.Errorf("ParseInt(%q) succeeded", lit)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package lsp
+
+import (
+	"path/filepath"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// An analysis holds the syntax tree, type information and diagnostics for
+// one version of a document. It is immutable once built.
+type analysis struct {
+	text []byte
+	fset *token.FileSet
+	file *token.File
+	ast  *ast.File
+	info *types.Info
+
+	diagnostics []protocol.Diagnostic
+}
+
+// analyze parses and type-checks the content of the document uri. Use
+// declarations are resolved in the document's directory first, then in
+// libPath.
+func analyze(uri protocol.DocumentURI, text []byte, libPath []string) *analysis {
+	a := &analysis{text: text, fset: token.NewFileSet()}
+
+	base := a.fset.Base()
+	f, err := parser.ParseFile(a.fset, string(uri), text, parser.AllErrors)
+	a.file = a.fset.File(token.Pos(base))
+	a.ast = f
+
+	var path []string
+	if filename := uri.Path(); filename != "" {
+		path = append(path, filepath.Dir(filename))
+	}
+	path = append(path, libPath...)
+
+	conf := &types.Config{
+		Error:    a.diagnose(err),
+		Importer: importer.New(a.fset, path),
+	}
+	a.info, _ = types.Check(a.fset, []*ast.File{f}, conf)
+
+	return a
+}
+
+// protocolPosition converts p into a zero-based line and UTF-16 character.
+func (a *analysis) protocolPosition(p token.Pos) protocol.Position {
+	return positionIn(a.file, a.text, p)
+}
+
+// positionIn converts p, a position in file with content src, into a
+// zero-based line and UTF-16 character.
+func positionIn(file *token.File, src []byte, p token.Pos) protocol.Position {
+	return protocol.Position{
+		Line:      uint32(file.Line(p) - 1),
+		Character: uint32(file.UTF16Column(src, p) - 1),
+	}
+}
+
+func (a *analysis) protocolRange(pos, end token.Pos) protocol.Range {
+	return protocol.Range{Start: a.protocolPosition(pos), End: a.protocolPosition(end)}
+}
+
+// pos converts an LSP position into a Pos in the analyzed file.
+func (a *analysis) pos(p protocol.Position) token.Pos {
+	return a.file.PosUTF16(a.text, int(p.Line)+1, int(p.Character)+1)
+}
+
+// identAt returns the resolved identifier under the cursor at p, or nil.
+// A cursor just past the end of an identifier still counts as being on it.
+func (a *analysis) identAt(p token.Pos) *ast.Ident {
+	var found *ast.Ident
+	visit := func(id *ast.Ident) {
+		if id.Pos() <= p && p <= id.End() && (found == nil || found.End() == p) {
+			found = id
+		}
+	}
+	for id := range a.info.Defs {
+		visit(id)
+	}
+	for id := range a.info.Uses {
+		visit(id)
+	}
+	return found
+}
@@ -0,0 +1,89 @@
+package lsp
+
+import (
+	"errors"
+
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+	"github.com/manapointer/xi/pkg/scanner"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+const diagnosticSource = "xi"
+
+// diagnose reports the syntax errors in a.text and records the type errors
+// found by check, which must then be passed as the checker's error handler.
+// Only the first error at each position is kept.
+func (a *analysis) diagnose(syntaxErr error) (check func(types.Error)) {
+	a.diagnostics = []protocol.Diagnostic{}
+
+	// A lexical error is usually followed by parse errors at the same
+	// position complaining about the token the scanner produced instead.
+	// Lexical errors win because they name the actual problem.
+	reported := make(map[int]bool)
+	report := func(list scanner.ErrorList) {
+		for _, e := range list {
+			if !reported[e.Pos.Offset] {
+				reported[e.Pos.Offset] = true
+				pos := a.file.Pos(e.Pos.Offset)
+				a.diagnostics = append(a.diagnostics, a.newDiagnostic(pos, a.tokenEnd(pos), e.Msg))
+			}
+		}
+	}
+
+	report(scanErrors(a.text))
+
+	var syntaxErrors scanner.ErrorList
+	if errors.As(syntaxErr, &syntaxErrors) {
+		report(syntaxErrors)
+	}
+
+	// Type checking runs even if there are syntax errors; the checker is
+	// silent about the bad nodes the parser leaves behind.
+	return func(e types.Error) {
+		end := e.End
+		if !end.IsValid() {
+			end = a.tokenEnd(e.Pos)
+		}
+		a.diagnostics = append(a.diagnostics, a.newDiagnostic(e.Pos, end, e.Msg))
+	}
+}
+
+// scanErrors returns the lexical errors in text.
+func scanErrors(text []byte) scanner.ErrorList {
+	var list scanner.ErrorList
+	file := token.NewFileSet().AddFile("", -1, len(text))
+	s := scanner.NewScanner(file, text, list.Add, 0)
+	for s.Scan().Typ != token.Eof {
+	}
+	return list
+}
+
+func (a *analysis) newDiagnostic(pos, end token.Pos, msg string) protocol.Diagnostic {
+	return protocol.Diagnostic{
+		Range:    a.protocolRange(pos, end),
+		Severity: protocol.SeverityError,
+		Source:   diagnosticSource,
+		Message:  msg,
+	}
+}
+
+// tokenEnd returns the end of the token that starts at pos. Syntax errors
+// only carry a start position, so this is how the squiggle gets its width.
+// If no token starts exactly at pos, as for errors reported at the end of
+// a line, the range is empty.
+func (a *analysis) tokenEnd(pos token.Pos) token.Pos {
+	rest := a.text[a.file.Offset(pos):]
+	restFile := token.NewFileSet().AddFile("", -1, len(rest))
+	s := scanner.NewScanner(restFile, rest, nil, 0)
+	for {
+		tok := s.Scan()
+		switch {
+		case tok.Typ == token.Error:
+			continue
+		case tok.Typ == token.Eof || tok.Pos != restFile.Pos(0):
+			return pos
+		}
+		return pos + token.Pos(len(tok.Lit))
+	}
+}
@@ -0,0 +1,58 @@
+package lsp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+)
+
+func TestDiagnose(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		want []string // "line:char-line:char: message"
+	}{
+		{
+			"f() {\n\tx:int = 0\n}\n",
+			nil,
+		},
+		{
+			"f() {\n\tx:int = true\n\ty = 1\n}\n",
+			[]string{
+				"1:9-1:13: cannot use value of type bool as int",
+				"2:1-2:2: undefined: y",
+			},
+		},
+		{
+			// Only the scanner's error is reported at the illegal character.
+			"f() { x:int = @ }\n",
+			[]string{"0:14-0:15: unexpected token: U+0040 '@'"},
+		},
+		{
+			// Columns are in UTF-16 code units; the emoji takes two.
+			"g(): int {\n\ts:int[] = \"😀\\q\" + 1\n}\n",
+			[]string{
+				`1:14-1:15: unknown escape sequence \q`,
+				"1:11-1:21: mismatched types int[] and int for operator +",
+				"2:0-2:1: missing return in g",
+			},
+		},
+		{
+			"f() {\n\tx:int =\n}\n",
+			[]string{"2:0-2:1: expected expression, found }"},
+		},
+	} {
+		var got []string
+		for _, d := range analyze("file:///test/test.xi", []byte(test.src), nil).diagnostics {
+			if d.Severity != protocol.SeverityError {
+				t.Errorf("%q: got severity %d for %q", test.src, d.Severity, d.Message)
+			}
+			r := d.Range
+			got = append(got, fmt.Sprintf("%d:%d-%d:%d: %s", r.Start.Line, r.Start.Character, r.End.Line, r.End.Character, d.Message))
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q:\ngot      %q\nexpected %q", test.src, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package lsp
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// A Document is the in-memory content of a file open in the editor. While a
+// document is open, it takes precedence over the file on disk.
+type Document struct {
+	URI        protocol.DocumentURI
+	LanguageID string
+	Version    int32
+	Text       []byte
+
+	file     *token.File // line table for Text
+	analysis *analysis   // analysis of Text, computed on demand
+}
+
+func newDocument(item protocol.TextDocumentItem) *Document {
+	d := &Document{
+		URI:        item.URI,
+		LanguageID: item.LanguageID,
+		Version:    item.Version,
+	}
+	d.setText([]byte(item.Text))
+	return d
+}
+
+func (d *Document) setText(text []byte) {
+	d.Text = text
+	d.analysis = nil
+	d.file = token.NewFileSet().AddFile(string(d.URI), -1, len(text))
+	d.file.SetLinesForContent(text)
+}
+
+// offset converts an LSP position into a byte offset in the document.
+// Positions past the end of a line or of the document are clamped.
+func (d *Document) offset(pos protocol.Position) int {
+	return d.file.Offset(d.file.PosUTF16(d.Text, int(pos.Line)+1, int(pos.Character)+1))
+}
+
+// applyChanges applies the content changes of a didChange notification in
+// order. Changes without a range replace the whole document.
+func (d *Document) applyChanges(version int32, changes []protocol.TextDocumentContentChangeEvent) error {
+	for _, change := range changes {
+		if change.Range == nil {
+			d.setText([]byte(change.Text))
+			continue
+		}
+
+		start, end := d.offset(change.Range.Start), d.offset(change.Range.End)
+		if start > end {
+			return fmt.Errorf("invalid range %v: start is after end", *change.Range)
+		}
+
+		text := make([]byte, 0, len(d.Text)-(end-start)+len(change.Text))
+		text = append(text, d.Text[:start]...)
+		text = append(text, change.Text...)
+		text = append(text, d.Text[end:]...)
+		d.setText(text)
+	}
+
+	d.Version = version
+	return nil
+}
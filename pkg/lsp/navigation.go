@@ -0,0 +1,169 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// analysis returns the analysis of the current content of uri, computing
+// it if the diagnostics run has not done so yet. It returns nil if the
+// document is not open. The analysis runs without s.mu held, so that a
+// large document does not hold up edits and diagnostics of the others.
+func (s *Server) analysis(uri protocol.DocumentURI) *analysis {
+	s.mu.Lock()
+	doc, ok := s.documents[uri]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	if a := doc.analysis; a != nil {
+		s.mu.Unlock()
+		return a
+	}
+	// Edits replace Text and file rather than modifying them, so the
+	// snapshot stays valid after the lock is released.
+	text, file := doc.Text, doc.file
+	s.mu.Unlock()
+
+	a := analyze(uri, text, s.LibPath)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only cache the result if the document was not edited, or closed
+	// and reopened, in the meantime.
+	if doc, ok := s.documents[uri]; ok && doc.file == file && doc.analysis == nil {
+		doc.analysis = a
+	}
+	return a
+}
+
+// objectAt returns the identifier at the given position and the object it
+// denotes.
+func (s *Server) objectAt(params *protocol.TextDocumentPositionParams) (*analysis, *ast.Ident, types.Object) {
+	a := s.analysis(params.TextDocument.URI)
+	if a == nil {
+		s.log.Printf("request for unopened document %s", params.TextDocument.URI)
+		return nil, nil, nil
+	}
+
+	id := a.identAt(a.pos(params.Position))
+	if id == nil {
+		return a, nil, nil
+	}
+	return a, id, a.info.ObjectOf(id)
+}
+
+// declIdent returns the identifier that declares obj.
+func (a *analysis) declIdent(obj types.Object) *ast.Ident {
+	for id, def := range a.info.Defs {
+		if def == obj && id.Pos() == obj.Position() {
+			return id
+		}
+	}
+	return nil
+}
+
+func (a *analysis) location(uri protocol.DocumentURI, id *ast.Ident) protocol.Location {
+	return protocol.Location{URI: uri, Range: a.protocolRange(id.Pos(), id.End())}
+}
+
+// importedLocation returns the location of the declaration of obj, which
+// was imported from an interface file on disk.
+func (a *analysis) importedLocation(obj types.Object) (protocol.Location, bool) {
+	file := a.fset.File(obj.Position())
+	if file == nil || file == a.file {
+		return protocol.Location{}, false
+	}
+
+	// The columns are in UTF-16, so the content is needed as well.
+	src, err := ioutil.ReadFile(file.Name())
+	if err != nil || len(src) != file.Size() {
+		return protocol.Location{}, false
+	}
+	filename, err := filepath.Abs(file.Name())
+	if err != nil {
+		return protocol.Location{}, false
+	}
+
+	pos := obj.Position()
+	return protocol.Location{
+		URI: protocol.URIFromPath(filename),
+		Range: protocol.Range{
+			Start: positionIn(file, src, pos),
+			End:   positionIn(file, src, pos+token.Pos(len(obj.Name()))),
+		},
+	}, true
+}
+
+func (s *Server) definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
+	a, _, obj := s.objectAt(&params.TextDocumentPositionParams)
+	if obj == nil {
+		return nil, nil
+	}
+
+	if loc, ok := a.importedLocation(obj); ok {
+		return []protocol.Location{loc}, nil
+	}
+
+	decl := a.declIdent(obj)
+	if decl == nil {
+		return nil, nil
+	}
+	return []protocol.Location{a.location(params.TextDocument.URI, decl)}, nil
+}
+
+func (s *Server) references(ctx context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
+	a, _, obj := s.objectAt(&params.TextDocumentPositionParams)
+	if obj == nil {
+		return nil, nil
+	}
+
+	var ids []*ast.Ident
+	for id, use := range a.info.Uses {
+		if use == obj {
+			ids = append(ids, id)
+		}
+	}
+	if params.Context.IncludeDeclaration {
+		if decl := a.declIdent(obj); decl != nil {
+			ids = append(ids, decl)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos() < ids[j].Pos() })
+
+	locations := []protocol.Location{}
+	for _, id := range ids {
+		locations = append(locations, a.location(params.TextDocument.URI, id))
+	}
+	return locations, nil
+}
+
+func (s *Server) hover(ctx context.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
+	a, id, obj := s.objectAt(&params.TextDocumentPositionParams)
+	if obj == nil {
+		return nil, nil
+	}
+
+	rng := a.protocolRange(id.Pos(), id.End())
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  protocol.Markdown,
+			Value: fmt.Sprintf("```xi\n%s\n```", objectString(obj)),
+		},
+		Range: &rng,
+	}, nil
+}
+
+// objectString describes obj for hover text, e.g. "x: int[]" or
+// "f: function (int) (bool)".
+func objectString(obj types.Object) string {
+	return fmt.Sprintf("%s: %s", obj.Name(), types.TypeString(obj.Type()))
+}
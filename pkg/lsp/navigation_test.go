@@ -0,0 +1,253 @@
+package lsp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+)
+
+const navigationSrc = `even(n: int): bool {
+	return n % 2 == 0
+}
+
+main(args: int[][]) {
+	count:int = length(args)
+	if (even(count)) {
+		count = count + 1
+	}
+}
+`
+
+func position(line, char uint32) protocol.TextDocumentPositionParams {
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "file:///test/nav.xi"},
+		Position:     protocol.Position{Line: line, Character: char},
+	}
+}
+
+func formatLocations(locations []protocol.Location) []string {
+	var s []string
+	for _, l := range locations {
+		s = append(s, fmt.Sprintf("%d:%d-%d:%d", l.Range.Start.Line, l.Range.Start.Character, l.Range.End.Line, l.Range.End.Character))
+	}
+	return s
+}
+
+func newNavigationClient(t *testing.T) *testClient {
+	c := newTestClient(t)
+	result := c.initialize()
+	caps := result.Capabilities
+	if !caps.DefinitionProvider || !caps.ReferencesProvider || !caps.HoverProvider {
+		t.Errorf("missing capabilities: %+v", caps)
+	}
+	c.open("file:///test/nav.xi", 1, navigationSrc)
+	return c
+}
+
+func TestDefinition(t *testing.T) {
+	c := newNavigationClient(t)
+
+	for _, test := range []struct {
+		line, char uint32
+		want       string
+	}{
+		{1, 8, "0:5-0:6"},  // parameter n
+		{6, 6, "0:0-0:4"},  // function even
+		{6, 13, "5:1-5:6"}, // local count, cursor at the end of the name
+		{7, 2, "5:1-5:6"},  // assigned local
+		{5, 21, "4:5-4:9"}, // parameter args
+		{0, 1, "0:0-0:4"},  // a declaration is its own definition
+		{5, 14, ""},        // length is not an identifier
+		{3, 0, ""},         // blank line
+	} {
+		var locations []protocol.Location
+		err := c.call(protocol.MethodTextDocumentDefinition, &protocol.DefinitionParams{
+			TextDocumentPositionParams: position(test.line, test.char),
+		}, &locations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want []string
+		if test.want != "" {
+			want = []string{test.want}
+		}
+		if got := formatLocations(locations); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%d:%d: got %v, expected %v", test.line, test.char, got, want)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	c := newNavigationClient(t)
+
+	for _, test := range []struct {
+		line, char  uint32
+		includeDecl bool
+		want        []string
+	}{
+		{7, 2, true, []string{"5:1-5:6", "6:10-6:15", "7:2-7:7", "7:10-7:15"}},
+		{7, 2, false, []string{"6:10-6:15", "7:2-7:7", "7:10-7:15"}},
+		{0, 2, true, []string{"0:0-0:4", "6:5-6:9"}},
+		{4, 6, false, []string{"5:20-5:24"}},
+	} {
+		var locations []protocol.Location
+		err := c.call(protocol.MethodTextDocumentReferences, &protocol.ReferenceParams{
+			TextDocumentPositionParams: position(test.line, test.char),
+			Context:                    protocol.ReferenceContext{IncludeDeclaration: test.includeDecl},
+		}, &locations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, l := range locations {
+			if l.URI != "file:///test/nav.xi" {
+				t.Errorf("reference in unexpected document %s", l.URI)
+			}
+		}
+		if got := formatLocations(locations); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%d:%d: got %v, expected %v", test.line, test.char, got, test.want)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newNavigationClient(t)
+
+	for _, test := range []struct {
+		line, char uint32
+		want       string
+	}{
+		{6, 7, "even: function (int) (bool)"},
+		{1, 9, "n: int"},
+		{5, 22, "args: int[][]"},
+		{7, 12, "count: int"},
+		{1, 2, ""},
+	} {
+		var hover *protocol.Hover
+		err := c.call(protocol.MethodTextDocumentHover, &protocol.HoverParams{
+			TextDocumentPositionParams: position(test.line, test.char),
+		}, &hover)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.want == "" {
+			if hover != nil {
+				t.Errorf("%d:%d: got hover %q, expected none", test.line, test.char, hover.Contents.Value)
+			}
+			continue
+		}
+
+		want := "```xi\n" + test.want + "\n```"
+		if hover == nil || hover.Contents.Value != want || hover.Contents.Kind != protocol.Markdown {
+			t.Errorf("%d:%d: got %+v, expected %q", test.line, test.char, hover, want)
+		}
+	}
+}
+
+func TestImportedDefinition(t *testing.T) {
+	dir := t.TempDir()
+	libDir := filepath.Join(dir, "lib")
+	if err := os.Mkdir(libDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The document's own directory is searched before the library path.
+	if err := ioutil.WriteFile(filepath.Join(dir, "util.ixi"), []byte("twice(n: int): int\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(libDir, "io.ixi"), []byte("// I/O\nprint(s: int[])\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uri := protocol.URIFromPath(filepath.Join(dir, "main.xi"))
+	src := "use io\nuse util\nmain(args: int[][]) {\n\tprint(args[twice(0)])\n}\n"
+
+	c := newTestClient(t)
+	c.server.LibPath = []string{libDir}
+	c.initialize()
+	c.open(uri, 1, src)
+
+	if params := c.waitDiagnostics(); len(params.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", params.Diagnostics)
+	}
+
+	for _, test := range []struct {
+		char uint32
+		uri  protocol.DocumentURI
+		want string
+	}{
+		{2, protocol.URIFromPath(filepath.Join(libDir, "io.ixi")), "1:0-1:5"},
+		{13, protocol.URIFromPath(filepath.Join(dir, "util.ixi")), "0:0-0:5"},
+	} {
+		params := protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 3, Character: test.char},
+		}
+
+		var locations []protocol.Location
+		if err := c.call(protocol.MethodTextDocumentDefinition, &protocol.DefinitionParams{TextDocumentPositionParams: params}, &locations); err != nil {
+			t.Fatal(err)
+		}
+		if len(locations) != 1 || locations[0].URI != test.uri || formatLocations(locations)[0] != test.want {
+			t.Errorf("3:%d: got %+v, expected %s %s", test.char, locations, test.uri, test.want)
+		}
+	}
+
+	var hover *protocol.Hover
+	if err := c.call(protocol.MethodTextDocumentHover, &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 3, Character: 3},
+		},
+	}, &hover); err != nil {
+		t.Fatal(err)
+	}
+	if want := "```xi\nprint: function (int[]) ()\n```"; hover == nil || hover.Contents.Value != want {
+		t.Errorf("got hover %+v, expected %q", hover, want)
+	}
+}
+
+func TestAnalysisCache(t *testing.T) {
+	s := NewServer(nil, nil)
+	uri := protocol.DocumentURI("file:///test/nav.xi")
+	s.documents[uri] = newDocument(protocol.TextDocumentItem{URI: uri, Version: 1, Text: navigationSrc})
+
+	a := s.analysis(uri)
+	if a == nil || string(a.text) != navigationSrc {
+		t.Fatalf("analysis of the open document: got %v", a)
+	}
+	if s.analysis(uri) != a {
+		t.Error("analysis was not cached")
+	}
+
+	// Requests analyze concurrently with edits; each analysis is of some
+	// version of the text, and the cached one is of the current text.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			s.analysis(uri)
+		}
+		done <- true
+	}()
+	for i := 0; i < 20; i++ {
+		s.mu.Lock()
+		err := s.documents[uri].applyChanges(int32(i+2), []protocol.TextDocumentContentChangeEvent{{Text: fmt.Sprintf("f() { x:int = %d }", i)}})
+		s.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if got, want := string(s.analysis(uri).text), "f() { x:int = 19 }"; got != want {
+		t.Errorf("analysis after the edits: got text %q, expected %q", got, want)
+	}
+	if s.analysis("file:///test/closed.xi") != nil {
+		t.Error("analysis of a document that is not open")
+	}
+}
@@ -0,0 +1,207 @@
+// Package protocol contains the subset of the Language Server Protocol
+// types that xls uses. Field names and JSON tags follow the specification.
+package protocol
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+)
+
+// Method names.
+const (
+	MethodInitialize  = "initialize"
+	MethodInitialized = "initialized"
+	MethodShutdown    = "shutdown"
+	MethodExit        = "exit"
+
+	MethodTextDocumentDidOpen   = "textDocument/didOpen"
+	MethodTextDocumentDidChange = "textDocument/didChange"
+	MethodTextDocumentDidClose  = "textDocument/didClose"
+
+	MethodTextDocumentPublishDiagnostics = "textDocument/publishDiagnostics"
+
+	MethodTextDocumentDefinition = "textDocument/definition"
+	MethodTextDocumentReferences = "textDocument/references"
+	MethodTextDocumentHover      = "textDocument/hover"
+)
+
+// A DocumentURI identifies a text document, e.g. file:///home/me/main.xi.
+type DocumentURI string
+
+// URIFromPath returns the file URI for an absolute path.
+func URIFromPath(path string) DocumentURI {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return DocumentURI(u.String())
+}
+
+// Path returns the file system path of a file URI, or "" if uri does not
+// use the file scheme.
+func (uri DocumentURI) Path() string {
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// Position is a zero-based line and character offset in a document.
+// Characters are counted in UTF-16 code units.
+type Position struct {
+	Line      uint32 `json:"line"`
+	Character uint32 `json:"character"`
+}
+
+// A Range is a half-open range of positions in a document.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// A Location is a range inside a particular document.
+type Location struct {
+	URI   DocumentURI `json:"uri"`
+	Range Range       `json:"range"`
+}
+
+type TextDocumentIdentifier struct {
+	URI DocumentURI `json:"uri"`
+}
+
+type VersionedTextDocumentIdentifier struct {
+	URI     DocumentURI `json:"uri"`
+	Version int32       `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        DocumentURI `json:"uri"`
+	LanguageID string      `json:"languageId"`
+	Version    int32       `json:"version"`
+	Text       string      `json:"text"`
+}
+
+type InitializeParams struct {
+	ProcessID    *int32          `json:"processId"`
+	RootURI      *DocumentURI    `json:"rootUri"`
+	Capabilities json.RawMessage `json:"capabilities,omitempty"`
+}
+
+// TextDocumentSyncKind defines how the client syncs document changes.
+type TextDocumentSyncKind int
+
+const (
+	SyncNone        TextDocumentSyncKind = 0
+	SyncFull        TextDocumentSyncKind = 1
+	SyncIncremental TextDocumentSyncKind = 2
+)
+
+type TextDocumentSyncOptions struct {
+	OpenClose bool                 `json:"openClose"`
+	Change    TextDocumentSyncKind `json:"change"`
+}
+
+type ServerCapabilities struct {
+	TextDocumentSync   TextDocumentSyncOptions `json:"textDocumentSync"`
+	HoverProvider      bool                    `json:"hoverProvider,omitempty"`
+	DefinitionProvider bool                    `json:"definitionProvider,omitempty"`
+	ReferencesProvider bool                    `json:"referencesProvider,omitempty"`
+}
+
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   *ServerInfo        `json:"serverInfo,omitempty"`
+}
+
+type InitializedParams struct{}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// A TextDocumentContentChangeEvent describes a change to a document. If
+// Range is nil, Text is the new content of the whole document.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DiagnosticSeverity is the severity of a diagnostic.
+type DiagnosticSeverity int
+
+const (
+	SeverityError       DiagnosticSeverity = 1
+	SeverityWarning     DiagnosticSeverity = 2
+	SeverityInformation DiagnosticSeverity = 3
+	SeverityHint        DiagnosticSeverity = 4
+)
+
+type Diagnostic struct {
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	Message  string             `json:"message"`
+}
+
+// PublishDiagnosticsParams replaces all diagnostics previously published
+// for URI. An empty Diagnostics list clears them.
+type PublishDiagnosticsParams struct {
+	URI         DocumentURI  `json:"uri"`
+	Version     *int32       `json:"version,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// TextDocumentPositionParams identifies a position in a document. It is
+// embedded in the parameters of most position-based requests.
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type DefinitionParams struct {
+	TextDocumentPositionParams
+}
+
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	Context ReferenceContext `json:"context"`
+}
+
+type HoverParams struct {
+	TextDocumentPositionParams
+}
+
+// MarkupKind is the format of a MarkupContent value.
+type MarkupKind string
+
+const (
+	PlainText MarkupKind = "plaintext"
+	Markdown  MarkupKind = "markdown"
+)
+
+type MarkupContent struct {
+	Kind  MarkupKind `json:"kind"`
+	Value string     `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
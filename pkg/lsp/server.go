@@ -0,0 +1,359 @@
+// Package lsp implements xls, the Xi language server.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/manapointer/xi/pkg/jsonrpc2"
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+)
+
+// ErrExitWithoutShutdown is returned by Serve if the client sent exit
+// without asking the server to shut down first.
+var ErrExitWithoutShutdown = errors.New("lsp: exit notification received before shutdown")
+
+// DefaultDiagnosticsDelay is how long the server waits after the last edit
+// to a document before recomputing its diagnostics.
+const DefaultDiagnosticsDelay = 200 * time.Millisecond
+
+type serverState int
+
+const (
+	serverCreated serverState = iota
+	serverInitializing
+	serverInitialized
+	serverShutDown
+)
+
+// A Server answers Language Server Protocol requests for Xi files.
+type Server struct {
+	conn *jsonrpc2.Conn
+	log  *log.Logger
+
+	// DiagnosticsDelay debounces diagnostics: they are published once a
+	// document has been left unchanged for this long. It must not be
+	// modified once Serve has been called.
+	DiagnosticsDelay time.Duration
+
+	// LibPath lists the directories searched for the interface files of
+	// use declarations, after the directory of the document itself. It
+	// must not be modified once Serve has been called.
+	LibPath []string
+
+	mu        sync.Mutex
+	state     serverState
+	documents map[protocol.DocumentURI]*Document
+	timers    map[protocol.DocumentURI]*pendingDiagnostics
+	exit      context.CancelFunc
+	exited    bool
+
+	// publishMu serializes publishing, so that the diagnostics for a
+	// document always arrive in the order its versions were checked.
+	publishMu sync.Mutex
+}
+
+// NewServer returns a server that talks to its client over stream. If
+// logger is nil, log output is discarded.
+func NewServer(stream jsonrpc2.Stream, logger *log.Logger) *Server {
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	return &Server{
+		conn:             jsonrpc2.NewConn(stream),
+		log:              logger,
+		DiagnosticsDelay: DefaultDiagnosticsDelay,
+		documents:        make(map[protocol.DocumentURI]*Document),
+		timers:           make(map[protocol.DocumentURI]*pendingDiagnostics),
+	}
+}
+
+// Serve handles requests until the client sends exit or closes the stream.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	s.exit = cancel
+	s.mu.Unlock()
+
+	err := s.conn.Run(ctx, s.handle)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exited {
+		if s.state != serverShutDown {
+			return ErrExitWithoutShutdown
+		}
+		return nil
+	}
+	return err
+}
+
+// Document returns the open document with the given URI, or nil.
+func (s *Server) Document(uri protocol.DocumentURI) *Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.documents[uri]
+}
+
+func (s *Server) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	s.mu.Lock()
+	state := s.state
+	s.mu.Unlock()
+
+	switch {
+	case req.Method == protocol.MethodExit:
+		return nil, s.exitNotification()
+	case state == serverCreated && req.Method != protocol.MethodInitialize:
+		if req.IsNotify() {
+			return nil, nil // dropped, as the specification requires
+		}
+		return nil, jsonrpc2.Errorf(jsonrpc2.ServerNotInitialized, "server not initialized")
+	case state == serverShutDown:
+		return nil, jsonrpc2.Errorf(jsonrpc2.InvalidRequest, "server is shutting down")
+	}
+
+	switch req.Method {
+	case protocol.MethodInitialize:
+		var params protocol.InitializeParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.initialize(ctx, &params)
+	case protocol.MethodInitialized:
+		return nil, s.initialized(ctx)
+	case protocol.MethodShutdown:
+		return nil, s.shutdown(ctx)
+	case protocol.MethodTextDocumentDidOpen:
+		var params protocol.DidOpenTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didOpen(ctx, &params)
+	case protocol.MethodTextDocumentDidChange:
+		var params protocol.DidChangeTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didChange(ctx, &params)
+	case protocol.MethodTextDocumentDidClose:
+		var params protocol.DidCloseTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didClose(ctx, &params)
+	case protocol.MethodTextDocumentDefinition:
+		var params protocol.DefinitionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(ctx, &params)
+	case protocol.MethodTextDocumentReferences:
+		var params protocol.ReferenceParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.references(ctx, &params)
+	case protocol.MethodTextDocumentHover:
+		var params protocol.HoverParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(ctx, &params)
+	}
+
+	if !req.IsNotify() {
+		return nil, jsonrpc2.Errorf(jsonrpc2.MethodNotFound, "method %q not found", req.Method)
+	}
+	// Unknown notifications, such as $/cancelRequest, may be ignored.
+	return nil, nil
+}
+
+func unmarshalParams(req *jsonrpc2.Request, v interface{}) error {
+	if len(req.Params) == 0 {
+		return jsonrpc2.Errorf(jsonrpc2.InvalidParams, "missing params for %s", req.Method)
+	}
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return jsonrpc2.Errorf(jsonrpc2.InvalidParams, "invalid params for %s: %v", req.Method, err)
+	}
+	return nil
+}
+
+func (s *Server) initialize(ctx context.Context, params *protocol.InitializeParams) (*protocol.InitializeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != serverCreated {
+		return nil, jsonrpc2.Errorf(jsonrpc2.InvalidRequest, "server already initialized")
+	}
+	s.state = serverInitializing
+
+	return &protocol.InitializeResult{
+		Capabilities: protocol.ServerCapabilities{
+			TextDocumentSync: protocol.TextDocumentSyncOptions{
+				OpenClose: true,
+				Change:    protocol.SyncIncremental,
+			},
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			ReferencesProvider: true,
+		},
+		ServerInfo: &protocol.ServerInfo{Name: "xls"},
+	}, nil
+}
+
+func (s *Server) initialized(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = serverInitialized
+	return nil
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = serverShutDown
+	s.documents = make(map[protocol.DocumentURI]*Document)
+	for uri, p := range s.timers {
+		p.timer.Stop()
+		delete(s.timers, uri)
+	}
+	return nil
+}
+
+func (s *Server) exitNotification() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exited = true
+	if s.exit != nil {
+		s.exit()
+	}
+	return nil
+}
+
+func (s *Server) didOpen(ctx context.Context, params *protocol.DidOpenTextDocumentParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc := newDocument(params.TextDocument)
+	s.documents[doc.URI] = doc
+	s.log.Printf("opened %s (version %d)", doc.URI, doc.Version)
+	s.scheduleDiagnostics(doc.URI)
+	return nil
+}
+
+func (s *Server) didChange(ctx context.Context, params *protocol.DidChangeTextDocumentParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		s.log.Printf("change to unopened document %s", params.TextDocument.URI)
+		return nil
+	}
+
+	if err := doc.applyChanges(params.TextDocument.Version, params.ContentChanges); err != nil {
+		s.log.Printf("applying changes to %s: %v", doc.URI, err)
+		return err
+	}
+	s.scheduleDiagnostics(doc.URI)
+	return nil
+}
+
+func (s *Server) didClose(ctx context.Context, params *protocol.DidCloseTextDocumentParams) error {
+	uri := params.TextDocument.URI
+
+	s.mu.Lock()
+	delete(s.documents, uri)
+	if p, ok := s.timers[uri]; ok {
+		p.timer.Stop()
+		delete(s.timers, uri)
+	}
+	s.mu.Unlock()
+
+	s.log.Printf("closed %s", uri)
+
+	// The file on disk is not checked, so its diagnostics would go stale.
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+	return s.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, &protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: []protocol.Diagnostic{},
+	})
+}
+
+// pendingDiagnostics is a scheduled diagnostics run. Its identity tells a
+// run whether it has been superseded by a later edit.
+type pendingDiagnostics struct {
+	timer *time.Timer
+}
+
+// scheduleDiagnostics (re)starts the debounce timer for uri. s.mu must be
+// held.
+func (s *Server) scheduleDiagnostics(uri protocol.DocumentURI) {
+	if p, ok := s.timers[uri]; ok {
+		p.timer.Stop()
+	}
+
+	p := &pendingDiagnostics{}
+	p.timer = time.AfterFunc(s.DiagnosticsDelay, func() {
+		s.publishDiagnostics(uri, p)
+	})
+	s.timers[uri] = p
+}
+
+// publishDiagnostics checks the current content of uri and sends the
+// result to the client, unless the document changed in the meantime, in
+// which case a newer timer is already on its way.
+func (s *Server) publishDiagnostics(uri protocol.DocumentURI, p *pendingDiagnostics) {
+	s.mu.Lock()
+	doc, ok := s.documents[uri]
+	if !ok || s.timers[uri] != p {
+		s.mu.Unlock()
+		return
+	}
+	// Edits replace Text rather than modifying it, so the snapshot stays
+	// valid after the lock is released.
+	text, version := doc.Text, doc.Version
+	s.mu.Unlock()
+
+	a := analyze(uri, text, s.LibPath)
+
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.mu.Lock()
+	current := s.timers[uri] == p
+	if current {
+		delete(s.timers, uri)
+		// Any edit would have replaced the timer, so doc.Text is still
+		// the text that was analyzed.
+		if doc, ok := s.documents[uri]; ok {
+			doc.analysis = a
+		}
+	}
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	err := s.conn.Notify(context.Background(), protocol.MethodTextDocumentPublishDiagnostics, &protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     &version,
+		Diagnostics: a.diagnostics,
+	})
+	if err != nil {
+		s.log.Printf("publishing diagnostics for %s: %v", uri, err)
+	}
+}
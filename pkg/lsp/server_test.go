@@ -0,0 +1,320 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/manapointer/xi/pkg/jsonrpc2"
+	"github.com/manapointer/xi/pkg/lsp/protocol"
+)
+
+// A testClient drives a Server over an in-memory pipe, speaking the same
+// Content-Length framed JSON-RPC an editor would.
+type testClient struct {
+	t      *testing.T
+	conn   *jsonrpc2.Conn
+	server *Server
+	served chan error // result of Server.Serve
+
+	diagnostics chan *protocol.PublishDiagnosticsParams
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientEnd, serverEnd := net.Pipe()
+
+	c := &testClient{
+		t:      t,
+		conn:   jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd)),
+		server: NewServer(jsonrpc2.NewHeaderStream(serverEnd, serverEnd), nil),
+		served: make(chan error, 1),
+
+		diagnostics: make(chan *protocol.PublishDiagnosticsParams, 100),
+	}
+	c.server.DiagnosticsDelay = time.Millisecond
+
+	go func() {
+		c.served <- c.server.Serve(context.Background())
+		serverEnd.Close()
+	}()
+	go c.conn.Run(context.Background(), c.handle)
+
+	t.Cleanup(func() { clientEnd.Close() })
+	return c
+}
+
+func (c *testClient) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Method == protocol.MethodTextDocumentPublishDiagnostics {
+		var params protocol.PublishDiagnosticsParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			c.t.Errorf("invalid publishDiagnostics params: %v", err)
+			return nil, nil
+		}
+		c.diagnostics <- &params
+	}
+	return nil, nil
+}
+
+// waitDiagnostics returns the next diagnostics published by the server.
+func (c *testClient) waitDiagnostics() *protocol.PublishDiagnosticsParams {
+	select {
+	case params := <-c.diagnostics:
+		return params
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for diagnostics")
+		return nil
+	}
+}
+
+func (c *testClient) call(method string, params, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.conn.Call(ctx, method, params, result)
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	if err := c.conn.Notify(context.Background(), method, params); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+}
+
+// sync waits until the server has handled every message sent so far. The
+// server handles messages in order, so a round trip suffices.
+func (c *testClient) sync() {
+	err := c.call("xls/ping", struct{}{}, nil)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.MethodNotFound {
+		c.t.Fatalf("sync: unexpected result %v", err)
+	}
+}
+
+func (c *testClient) initialize() *protocol.InitializeResult {
+	var result protocol.InitializeResult
+	if err := c.call(protocol.MethodInitialize, &protocol.InitializeParams{}, &result); err != nil {
+		c.t.Fatalf("initialize: %v", err)
+	}
+	c.notify(protocol.MethodInitialized, &protocol.InitializedParams{})
+	return &result
+}
+
+func (c *testClient) exit() error {
+	c.notify(protocol.MethodExit, nil)
+	select {
+	case err := <-c.served:
+		return err
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("server did not exit")
+		return nil
+	}
+}
+
+func (c *testClient) text(uri protocol.DocumentURI) string {
+	doc := c.server.Document(uri)
+	if doc == nil {
+		c.t.Fatalf("document %s is not open", uri)
+	}
+	return string(doc.Text)
+}
+
+func rng(startLine, startChar, endLine, endChar uint32) *protocol.Range {
+	return &protocol.Range{
+		Start: protocol.Position{Line: startLine, Character: startChar},
+		End:   protocol.Position{Line: endLine, Character: endChar},
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	c := newTestClient(t)
+
+	err := c.call(protocol.MethodShutdown, nil, nil)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.ServerNotInitialized {
+		t.Errorf("shutdown before initialize: got %v, expected ServerNotInitialized", err)
+	}
+
+	result := c.initialize()
+	if got := result.Capabilities.TextDocumentSync.Change; got != protocol.SyncIncremental {
+		t.Errorf("got sync kind %d, expected incremental", got)
+	}
+	if !result.Capabilities.TextDocumentSync.OpenClose {
+		t.Error("server does not ask for open/close notifications")
+	}
+
+	err = c.call(protocol.MethodInitialize, &protocol.InitializeParams{}, nil)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.InvalidRequest {
+		t.Errorf("second initialize: got %v, expected InvalidRequest", err)
+	}
+
+	if err := c.call(protocol.MethodShutdown, nil, nil); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := c.exit(); err != nil {
+		t.Errorf("exit after shutdown: got %v, expected nil", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize()
+
+	if err := c.exit(); !errors.Is(err, ErrExitWithoutShutdown) {
+		t.Errorf("got %v, expected ErrExitWithoutShutdown", err)
+	}
+}
+
+func TestDocumentSync(t *testing.T) {
+	const uri = "file:///test/main.xi"
+
+	c := newTestClient(t)
+	c.initialize()
+
+	c.notify(protocol.MethodTextDocumentDidOpen, &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			URI:        uri,
+			LanguageID: "xi",
+			Version:    1,
+			Text:       "main(args: int[][]) {\n\ts:int[] = \"😀\"\n}\n",
+		},
+	})
+	c.sync()
+
+	for _, test := range []struct {
+		name    string
+		changes []protocol.TextDocumentContentChangeEvent
+		want    string
+	}{
+		{
+			"insert",
+			[]protocol.TextDocumentContentChangeEvent{{Range: rng(1, 1, 1, 1), Text: "x:int = 0\n\t"}},
+			"main(args: int[][]) {\n\tx:int = 0\n\ts:int[] = \"😀\"\n}\n",
+		},
+		{
+			// The emoji is two UTF-16 code units wide.
+			"replace after surrogate pair",
+			[]protocol.TextDocumentContentChangeEvent{{Range: rng(2, 14, 2, 15), Text: "é\""}},
+			"main(args: int[][]) {\n\tx:int = 0\n\ts:int[] = \"😀é\"\n}\n",
+		},
+		{
+			"delete across lines, then insert",
+			[]protocol.TextDocumentContentChangeEvent{
+				{Range: rng(1, 10, 2, 17), Text: ""},
+				{Range: rng(0, 0, 0, 4), Text: "start"},
+			},
+			"start(args: int[][]) {\n\tx:int = 0\n}\n",
+		},
+		{
+			"full",
+			[]protocol.TextDocumentContentChangeEvent{{Text: "f() {}\n"}},
+			"f() {}\n",
+		},
+		{
+			"past end of document",
+			[]protocol.TextDocumentContentChangeEvent{{Range: rng(7, 3, 9, 0), Text: "g() {}\n"}},
+			"f() {}\ng() {}\n",
+		},
+	} {
+		c.notify(protocol.MethodTextDocumentDidChange, &protocol.DidChangeTextDocumentParams{
+			TextDocument:   protocol.VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+			ContentChanges: test.changes,
+		})
+		c.sync()
+
+		if got := c.text(uri); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+
+	if v := c.server.Document(uri).Version; v != 2 {
+		t.Errorf("got version %d, expected 2", v)
+	}
+
+	c.notify(protocol.MethodTextDocumentDidClose, &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	c.sync()
+
+	if doc := c.server.Document(uri); doc != nil {
+		t.Error("document still open after didClose")
+	}
+}
+
+func (c *testClient) open(uri protocol.DocumentURI, version int32, text string) {
+	c.notify(protocol.MethodTextDocumentDidOpen, &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, LanguageID: "xi", Version: version, Text: text},
+	})
+}
+
+func (c *testClient) change(uri protocol.DocumentURI, version int32, text string) {
+	c.notify(protocol.MethodTextDocumentDidChange, &protocol.DidChangeTextDocumentParams{
+		TextDocument:   protocol.VersionedTextDocumentIdentifier{URI: uri, Version: version},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: text}},
+	})
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	const uri = "file:///test/main.xi"
+
+	c := newTestClient(t)
+	c.initialize()
+
+	c.open(uri, 1, "f() {\n\tx:int = true\n}\n")
+	params := c.waitDiagnostics()
+	if params.URI != uri || params.Version == nil || *params.Version != 1 {
+		t.Errorf("got diagnostics for %s version %v, expected %s version 1", params.URI, params.Version, uri)
+	}
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Message != "cannot use value of type bool as int" {
+		t.Errorf("got %+v", params.Diagnostics)
+	}
+
+	c.change(uri, 2, "f() {\n\tx:int = 1\n}\n")
+	if params := c.waitDiagnostics(); *params.Version != 2 || len(params.Diagnostics) != 0 {
+		t.Errorf("got %+v for version %d, expected no diagnostics", params.Diagnostics, *params.Version)
+	}
+
+	c.notify(protocol.MethodTextDocumentDidClose, &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	if params := c.waitDiagnostics(); params.Version != nil || len(params.Diagnostics) != 0 {
+		t.Errorf("got %+v after close, expected diagnostics to be cleared", params)
+	}
+}
+
+func TestDiagnosticsDebounced(t *testing.T) {
+	const uri = "file:///test/main.xi"
+
+	c := newTestClient(t)
+	c.server.DiagnosticsDelay = time.Hour
+	c.initialize()
+
+	c.open(uri, 1, "f() {}\n")
+	for v := int32(2); v <= 10; v++ {
+		c.change(uri, v, fmt.Sprintf("f() { x:int = %d }\n", v))
+	}
+	c.change(uri, 11, "f() { x = 1 }\n")
+	c.sync()
+
+	select {
+	case params := <-c.diagnostics:
+		t.Fatalf("diagnostics for version %d published before the delay", *params.Version)
+	default:
+	}
+
+	// Fire the pending run now instead of waiting an hour.
+	c.server.mu.Lock()
+	c.server.timers[uri].timer.Reset(0)
+	c.server.mu.Unlock()
+
+	params := c.waitDiagnostics()
+	if *params.Version != 11 || len(params.Diagnostics) != 1 || params.Diagnostics[0].Message != "undefined: x" {
+		t.Errorf("got %+v for version %d", params.Diagnostics, *params.Version)
+	}
+
+	c.sync()
+	select {
+	case params := <-c.diagnostics:
+		t.Errorf("unexpected second publish for version %d", *params.Version)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
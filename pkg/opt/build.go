@@ -0,0 +1,227 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// Build returns the three-address form of fn, which must be lowered.
+// Blocks keep the labels of the IR; blocks without one are given fresh
+// labels.
+func Build(fn *ir.FuncDecl) *Func {
+	b := &builder{
+		f:      &Func{Name: fn.Name, NumArgs: fn.NumArgs, NumRets: fn.NumRets},
+		labels: make(map[string]*Block),
+	}
+
+	var list []ir.Stmt
+	if seq, ok := fn.Body.(*ir.Seq); ok {
+		list = seq.List
+	} else {
+		list = []ir.Stmt{fn.Body}
+	}
+
+	// Split the statements into blocks, and record where each block jumps
+	// before all labels are known.
+	b.block = b.newBlock("")
+	for _, stmt := range list {
+		if label, ok := stmt.(*ir.Label); ok {
+			if b.block.Label != "" || len(b.block.Instrs) > 0 {
+				b.startBlock()
+			}
+			b.block.Label = label.Name
+			b.labels[label.Name] = b.block
+			continue
+		}
+		b.stmt(stmt)
+		if b.block.Instrs[len(b.block.Instrs)-1].IsTerminator() {
+			b.startBlock()
+		}
+	}
+	b.end()
+
+	f := b.f
+	for i, block := range f.Blocks {
+		if block.Label == "" {
+			block.Label = fmt.Sprintf("_ob%d", i)
+		}
+	}
+	f.Renumber()
+	return f
+}
+
+type builder struct {
+	f      *Func
+	block  *Block
+	labels map[string]*Block
+
+	// targets holds the labels jumped to by each block other than falling
+	// through to the next.
+	targets [][]string
+}
+
+func (b *builder) newBlock(label string) *Block {
+	block := &Block{Index: len(b.f.Blocks), Label: label}
+	b.f.Blocks = append(b.f.Blocks, block)
+	b.targets = append(b.targets, nil)
+	return block
+}
+
+// startBlock ends the current block, falling through to a new one.
+func (b *builder) startBlock() {
+	b.block = b.newBlock("")
+}
+
+// end terminates every block, now that all labels are known.
+func (b *builder) end() {
+	f := b.f
+	// An empty last block is only there because of a terminator or a
+	// label at the end of the body.
+	if last := f.Blocks[len(f.Blocks)-1]; len(last.Instrs) == 0 && last.Label == "" && len(f.Blocks) > 1 {
+		f.Blocks = f.Blocks[:len(f.Blocks)-1]
+	}
+
+	for i, block := range f.Blocks {
+		var next *Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+		for _, label := range b.targets[i] {
+			target, ok := b.labels[label]
+			if !ok {
+				panic(fmt.Sprintf("opt: jump to unknown label %s in %s", label, f.Name))
+			}
+			block.Succs = append(block.Succs, target)
+		}
+
+		var last *Instr
+		if n := len(block.Instrs); n > 0 {
+			last = block.Instrs[n-1]
+		}
+		switch {
+		case last != nil && last.Kind == Branch:
+			// The false branch falls through.
+			block.Succs = append(block.Succs, next)
+		case last != nil && last.IsTerminator():
+		case next != nil:
+			block.Instrs = append(block.Instrs, &Instr{Kind: Jump})
+			block.Succs = append(block.Succs, next)
+		default:
+			// Control falls off the end of the body, which it only does
+			// in dead code.
+			ret := &Instr{Kind: Return}
+			for j := 0; j < f.NumRets; j++ {
+				ret.Args = append(ret.Args, Const(0))
+			}
+			block.Instrs = append(block.Instrs, ret)
+		}
+	}
+}
+
+func (b *builder) emit(in *Instr) { b.block.Instrs = append(b.block.Instrs, in) }
+
+func (b *builder) stmt(stmt ir.Stmt) {
+	switch s := stmt.(type) {
+	case *ir.Move:
+		switch dst := s.Dst.(type) {
+		case *ir.Temp:
+			b.assign(Temp(dst.Name), s.Src)
+		case *ir.Mem:
+			addr := b.expr(dst.Addr)
+			b.emit(&Instr{Kind: Store, Args: []Value{addr, b.expr(s.Src)}})
+		default:
+			panic(fmt.Sprintf("opt: cannot move to %s", ir.Sprint(dst)))
+		}
+
+	case *ir.Exp:
+		call, ok := s.X.(*ir.Call)
+		if !ok {
+			panic(fmt.Sprintf("opt: %s is not lowered", ir.Sprint(s)))
+		}
+		b.call("", call)
+
+	case *ir.Jump:
+		name, ok := s.Target.(*ir.Name)
+		if !ok {
+			panic(fmt.Sprintf("opt: cannot jump to %s", ir.Sprint(s.Target)))
+		}
+		b.targets[b.block.Index] = append(b.targets[b.block.Index], name.Name)
+		b.emit(&Instr{Kind: Jump})
+
+	case *ir.CJump:
+		if s.False != "" {
+			panic(fmt.Sprintf("opt: %s is not lowered", ir.Sprint(s)))
+		}
+		in := &Instr{Kind: Branch}
+		if x, ok := s.Cond.(*ir.BinOp); ok && x.Op.IsComparison() {
+			in.Op = x.Op
+			in.Args = []Value{b.expr(x.X), b.expr(x.Y)}
+		} else {
+			in.Op = ir.Neq
+			in.Args = []Value{b.expr(s.Cond), Const(0)}
+		}
+		b.targets[b.block.Index] = append(b.targets[b.block.Index], s.True)
+		b.emit(in)
+
+	case *ir.Return:
+		in := &Instr{Kind: Return}
+		for _, result := range s.Results {
+			in.Args = append(in.Args, b.expr(result))
+		}
+		b.emit(in)
+
+	default:
+		panic(fmt.Sprintf("opt: %s is not lowered", ir.Sprint(stmt)))
+	}
+}
+
+// assign emits the instructions assigning src to dst.
+func (b *builder) assign(dst Temp, src ir.Expr) {
+	switch x := src.(type) {
+	case *ir.BinOp:
+		l, r := b.expr(x.X), b.expr(x.Y)
+		b.emit(&Instr{Kind: BinOp, Op: x.Op, Dst: dst, Args: []Value{l, r}})
+	case *ir.Mem:
+		b.emit(&Instr{Kind: Load, Dst: dst, Args: []Value{b.expr(x.Addr)}})
+	case *ir.Call:
+		b.call(dst, x)
+	default:
+		b.emit(&Instr{Kind: Copy, Dst: dst, Args: []Value{b.expr(src)}})
+	}
+}
+
+func (b *builder) call(dst Temp, call *ir.Call) {
+	name, ok := call.Target.(*ir.Name)
+	if !ok {
+		panic(fmt.Sprintf("opt: cannot call %s", ir.Sprint(call.Target)))
+	}
+	n, ok := ir.NumResults(name.Name)
+	if !ok {
+		panic(fmt.Sprintf("opt: cannot call %s, which is not a mangled name", name.Name))
+	}
+
+	in := &Instr{Kind: Call, Dst: dst, Args: []Value{Name(name.Name)}, NumRets: n}
+	for _, arg := range call.Args {
+		in.Args = append(in.Args, b.expr(arg))
+	}
+	b.emit(in)
+}
+
+// expr emits the instructions computing x, which has no side effects, and
+// returns its value.
+func (b *builder) expr(x ir.Expr) Value {
+	switch x := x.(type) {
+	case *ir.Const:
+		return Const(x.Value)
+	case *ir.Temp:
+		return Temp(x.Name)
+	case *ir.Name:
+		return Name(x.Name)
+	case *ir.BinOp, *ir.Mem:
+		t := b.f.NewTemp()
+		b.assign(t, x)
+		return t
+	}
+	panic(fmt.Sprintf("opt: %s is not lowered", ir.Sprint(x)))
+}
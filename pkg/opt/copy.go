@@ -0,0 +1,90 @@
+package opt
+
+import "github.com/manapointer/xi/pkg/cfg"
+
+// A copyFact records that dst holds the same value as src.
+type copyFact struct {
+	dst Temp
+	src Value
+}
+
+// CopyProp replaces uses of temporaries by the temporaries or constants
+// they were copied from, where the copy reaches the use along every path
+// and neither has been assigned since.
+func CopyProp(f *Func) bool {
+	universe := make(cfg.Set)
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			if c, ok := copyOf(in); ok {
+				universe[c] = true
+			}
+		}
+	}
+	if len(universe) == 0 {
+		return false
+	}
+
+	res := cfg.Solve(f, &cfg.Problem{
+		Lattice:   &cfg.SetLattice{Must: true, Universe: universe},
+		Direction: cfg.Forward,
+		Boundary:  cfg.Set{},
+		Transfer: func(i int, in cfg.Fact) cfg.Fact {
+			avail := in.(cfg.Set).Copy()
+			for _, instr := range f.Blocks[i].Instrs {
+				copyTransfer(avail, instr)
+			}
+			return avail
+		},
+	})
+
+	changed := false
+	for _, b := range f.Blocks {
+		avail := res.In[b.Index].(cfg.Set).Copy()
+		for _, in := range b.Instrs {
+			for i, arg := range in.Args {
+				t, ok := arg.(Temp)
+				if !ok {
+					continue
+				}
+				for x := range avail {
+					if c := x.(copyFact); c.dst == t {
+						in.Args[i] = c.src
+						changed = true
+						break
+					}
+				}
+			}
+			copyTransfer(avail, in)
+		}
+	}
+	return changed
+}
+
+// copyOf returns the copy made by in, if any.
+func copyOf(in *Instr) (copyFact, bool) {
+	if in.Kind != Copy {
+		return copyFact{}, false
+	}
+	if in.Args[0] == Value(in.Dst) {
+		return copyFact{}, false
+	}
+	switch in.Args[0].(type) {
+	case Temp, Const:
+		return copyFact{in.Dst, in.Args[0]}, true
+	}
+	return copyFact{}, false
+}
+
+// copyTransfer updates the set of available copies after in.
+func copyTransfer(avail cfg.Set, in *Instr) {
+	for _, d := range in.Defs() {
+		for x := range avail {
+			if c := x.(copyFact); c.dst == d || c.src == Value(d) {
+				delete(avail, x)
+			}
+		}
+	}
+	if c, ok := copyOf(in); ok {
+		avail[c] = true
+	}
+}
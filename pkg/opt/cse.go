@@ -0,0 +1,129 @@
+package opt
+
+import (
+	"github.com/manapointer/xi/pkg/cfg"
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// An expr is an operation whose value CSE can reuse: a BinOp or a Load.
+type expr struct {
+	kind Kind
+	op   ir.Op
+	x, y Value // y is nil for loads
+}
+
+func exprOf(in *Instr) (expr, bool) {
+	switch in.Kind {
+	case BinOp:
+		return expr{BinOp, in.Op, in.Args[0], in.Args[1]}, true
+	case Load:
+		return expr{kind: Load, x: in.Args[0]}, true
+	}
+	return expr{}, false
+}
+
+func (e expr) reads(t Temp) bool { return e.x == Value(t) || e.y == Value(t) }
+
+// LocalCSE replaces operations computed earlier in the same block by the
+// earlier results.
+func LocalCSE(f *Func) bool { return cse(f, false) }
+
+// GlobalCSE replaces operations computed earlier on every path to them by
+// the earlier results.
+func GlobalCSE(f *Func) bool { return cse(f, true) }
+
+// cse saves the value of each operation that is computed again while
+// available in a fresh temporary, and replaces the repeated computations
+// by copies from it. Copy propagation and dead code elimination clean up.
+func cse(f *Func, global bool) bool {
+	in := make([]cfg.Set, len(f.Blocks))
+	if global {
+		universe := make(cfg.Set)
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if e, ok := exprOf(instr); ok {
+					universe[e] = true
+				}
+			}
+		}
+		res := cfg.Solve(f, &cfg.Problem{
+			Lattice:   &cfg.SetLattice{Must: true, Universe: universe},
+			Direction: cfg.Forward,
+			Boundary:  cfg.Set{},
+			Transfer: func(i int, in cfg.Fact) cfg.Fact {
+				avail := in.(cfg.Set).Copy()
+				for _, instr := range f.Blocks[i].Instrs {
+					exprTransfer(avail, instr)
+				}
+				return avail
+			},
+		})
+		for i := range in {
+			in[i] = res.In[i].(cfg.Set)
+		}
+	} else {
+		for i := range in {
+			in[i] = cfg.Set{}
+		}
+	}
+
+	// Find the redundant computations.
+	redundant := make(map[*Instr]expr)
+	saved := make(map[expr]Temp)
+	for _, b := range f.Blocks {
+		avail := in[b.Index].Copy()
+		for _, instr := range b.Instrs {
+			if e, ok := exprOf(instr); ok && avail[e] {
+				redundant[instr] = e
+				if _, ok := saved[e]; !ok {
+					saved[e] = f.NewTemp()
+				}
+			}
+			exprTransfer(avail, instr)
+		}
+	}
+	if len(redundant) == 0 {
+		return false
+	}
+
+	// Save the value of the other computations and reuse it in the
+	// redundant ones.
+	for _, b := range f.Blocks {
+		var list []*Instr
+		for _, instr := range b.Instrs {
+			if e, ok := redundant[instr]; ok {
+				list = append(list, &Instr{Kind: Copy, Dst: instr.Dst, Args: []Value{saved[e]}})
+				continue
+			}
+			list = append(list, instr)
+			if e, ok := exprOf(instr); ok {
+				if t, ok := saved[e]; ok {
+					list = append(list, &Instr{Kind: Copy, Dst: t, Args: []Value{instr.Dst}})
+				}
+			}
+		}
+		b.Instrs = list
+	}
+	return true
+}
+
+// exprTransfer updates the set of available operations after in.
+func exprTransfer(avail cfg.Set, in *Instr) {
+	if e, ok := exprOf(in); ok {
+		avail[e] = true
+	}
+	for _, d := range in.Defs() {
+		for x := range avail {
+			if x.(expr).reads(d) {
+				delete(avail, x)
+			}
+		}
+	}
+	if in.Kind == Store || in.Kind == Call {
+		for x := range avail {
+			if x.(expr).kind == Load {
+				delete(avail, x)
+			}
+		}
+	}
+}
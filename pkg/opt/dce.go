@@ -0,0 +1,94 @@
+package opt
+
+import "github.com/manapointer/xi/pkg/cfg"
+
+// Liveness returns the temporaries live on entry to and exit from each
+// block of f. Facts are cfg.Sets of Temps.
+func Liveness(f *Func) *cfg.Result {
+	return cfg.Solve(f, &cfg.Problem{
+		Lattice:   &cfg.SetLattice{},
+		Direction: cfg.Backward,
+		Boundary:  cfg.Set{},
+		Transfer: func(i int, out cfg.Fact) cfg.Fact {
+			live := out.(cfg.Set).Copy()
+			instrs := f.Blocks[i].Instrs
+			for j := len(instrs) - 1; j >= 0; j-- {
+				liveTransfer(live, instrs[j])
+			}
+			return live
+		},
+	})
+}
+
+// liveTransfer updates the set of live temporaries after in to the set
+// before it.
+func liveTransfer(live cfg.Set, in *Instr) {
+	for _, d := range in.Defs() {
+		delete(live, d)
+	}
+	for _, u := range in.Uses() {
+		live[u] = true
+	}
+}
+
+// DeadCode removes the instructions without side effects whose results
+// are never used, and copies of temporaries to themselves.
+func DeadCode(f *Func) bool {
+	res := Liveness(f)
+	changed := false
+	for _, b := range f.Blocks {
+		live := res.Out[b.Index].(cfg.Set).Copy()
+		keep := make([]bool, len(b.Instrs))
+		n := 0
+		for j := len(b.Instrs) - 1; j >= 0; j-- {
+			in := b.Instrs[j]
+			self := in.Kind == Copy && in.Args[0] == Value(in.Dst)
+			if self || !in.HasSideEffects() && !live[in.Dst] {
+				changed = true
+				continue
+			}
+			keep[j] = true
+			n++
+			liveTransfer(live, in)
+		}
+
+		list := make([]*Instr, 0, n)
+		for j, in := range b.Instrs {
+			if keep[j] {
+				list = append(list, in)
+			}
+		}
+		b.Instrs = list
+	}
+	return changed
+}
+
+// Unreachable removes the blocks that control cannot reach from the
+// entry.
+func Unreachable(f *Func) bool {
+	seen := make([]bool, len(f.Blocks))
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if seen[b.Index] {
+			return
+		}
+		seen[b.Index] = true
+		for _, s := range b.Succs {
+			visit(s)
+		}
+	}
+	visit(f.Blocks[0])
+
+	var list []*Block
+	for _, b := range f.Blocks {
+		if seen[b.Index] {
+			list = append(list, b)
+		}
+	}
+	if len(list) == len(f.Blocks) {
+		return false
+	}
+	f.Blocks = list
+	f.Renumber()
+	return true
+}
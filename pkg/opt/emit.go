@@ -0,0 +1,158 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// Emit returns f as an IR function. It is lowered but for conditional
+// jumps, which have both targets; ir.Lower makes them fall through.
+//
+// Emit rebuilds expression trees, which the code generators tile better
+// than single operations: a temporary assigned and used once in the same
+// block is replaced by its definition, unless something in between
+// changes an operand or, for loads and divisions, stores or calls.
+func Emit(f *Func) *ir.FuncDecl {
+	uses := make(map[Temp]int)
+	defs := make(map[Temp]int)
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			for _, t := range in.Uses() {
+				uses[t]++
+			}
+			for _, t := range in.Defs() {
+				defs[t]++
+			}
+		}
+	}
+
+	e := &emitter{}
+	for _, b := range f.Blocks {
+		e.list = append(e.list, &ir.Label{Name: b.Label})
+		e.pending = nil
+		for _, in := range b.Instrs {
+			e.instr(b, in, uses[in.Dst] == 1 && defs[in.Dst] == 1)
+		}
+	}
+
+	return &ir.FuncDecl{
+		Name:    f.Name,
+		NumArgs: f.NumArgs,
+		NumRets: f.NumRets,
+		Body:    &ir.Seq{List: e.list},
+	}
+}
+
+type emitter struct {
+	list    []ir.Stmt
+	pending []*tree
+}
+
+// A tree is the definition of a temporary waiting to be substituted for
+// its only use.
+type tree struct {
+	dst    Temp
+	expr   ir.Expr
+	reads  map[Temp]bool
+	memory bool // reads memory or may trap
+}
+
+func (e *emitter) emit(s ir.Stmt) { e.list = append(e.list, s) }
+
+func (e *emitter) instr(b *Block, in *Instr, foldable bool) {
+	t := &tree{reads: make(map[Temp]bool)}
+	args := make([]ir.Expr, len(in.Args))
+	for i, arg := range in.Args {
+		args[i] = e.value(t, arg)
+	}
+
+	// Emit the definitions that in would invalidate.
+	var keep []*tree
+	for _, p := range e.pending {
+		invalid := in.IsTerminator() || p.memory && (in.Kind == Store || in.Kind == Call)
+		for _, d := range in.Defs() {
+			invalid = invalid || p.reads[d]
+		}
+		if invalid {
+			e.emit(&ir.Move{Dst: &ir.Temp{Name: string(p.dst)}, Src: p.expr})
+		} else {
+			keep = append(keep, p)
+		}
+	}
+	e.pending = keep
+
+	dst := &ir.Temp{Name: string(in.Dst)}
+	switch in.Kind {
+	case Copy, BinOp, Load:
+		var src ir.Expr
+		switch in.Kind {
+		case Copy:
+			src = args[0]
+		case BinOp:
+			src = &ir.BinOp{Op: in.Op, X: args[0], Y: args[1]}
+			t.memory = t.memory || in.HasSideEffects()
+		case Load:
+			src = &ir.Mem{Addr: args[0]}
+			t.memory = true
+		}
+		if foldable {
+			t.dst, t.expr = in.Dst, src
+			e.pending = append(e.pending, t)
+			return
+		}
+		e.emit(&ir.Move{Dst: dst, Src: src})
+
+	case Store:
+		e.emit(&ir.Move{Dst: &ir.Mem{Addr: args[0]}, Src: args[1]})
+
+	case Call:
+		call := &ir.Call{Target: args[0], Args: args[1:]}
+		if in.Dst != "" {
+			e.emit(&ir.Move{Dst: dst, Src: call})
+		} else {
+			e.emit(&ir.Exp{X: call})
+		}
+
+	case Jump:
+		e.emit(&ir.Jump{Target: &ir.Name{Name: b.Succs[0].Label}})
+
+	case Branch:
+		e.emit(&ir.CJump{
+			Cond:  &ir.BinOp{Op: in.Op, X: args[0], Y: args[1]},
+			True:  b.Succs[0].Label,
+			False: b.Succs[1].Label,
+		})
+
+	case Return:
+		e.emit(&ir.Return{Results: args})
+
+	default:
+		panic(fmt.Sprintf("opt: cannot emit %s", in))
+	}
+}
+
+// value returns the IR for v, substituting a pending definition, and
+// records what it reads in t.
+func (e *emitter) value(t *tree, v Value) ir.Expr {
+	switch v := v.(type) {
+	case Const:
+		return &ir.Const{Value: int64(v)}
+	case Name:
+		return &ir.Name{Name: string(v)}
+	case Temp:
+		for i, p := range e.pending {
+			if p.dst == v {
+				e.pending = append(e.pending[:i], e.pending[i+1:]...)
+				for r := range p.reads {
+					t.reads[r] = true
+				}
+				t.memory = t.memory || p.memory
+				return p.expr
+			}
+		}
+		t.reads[v] = true
+		return &ir.Temp{Name: string(v)}
+	}
+	panic(fmt.Sprintf("opt: unexpected value %v", v))
+}
@@ -0,0 +1,125 @@
+package opt
+
+import "github.com/manapointer/xi/pkg/ir"
+
+// ConstFold replaces operations on constants by their results, simplifies
+// operations with an identity or absorbing operand, and turns branches
+// whose outcome is known into jumps. Within a block, it substitutes the
+// constants assigned to temporaries for their uses, so that the flattened
+// expression trees of the IR fold completely.
+func ConstFold(f *Func) bool {
+	changed := false
+	for _, b := range f.Blocks {
+		consts := make(map[Temp]Const)
+		for _, in := range b.Instrs {
+			for i, arg := range in.Args {
+				if t, ok := arg.(Temp); ok {
+					if c, ok := consts[t]; ok {
+						in.Args[i] = c
+						changed = true
+					}
+				}
+			}
+
+			switch in.Kind {
+			case BinOp:
+				if v, ok := fold(in.Op, in.Args[0], in.Args[1]); ok {
+					in.Kind, in.Op, in.Args = Copy, 0, []Value{v}
+					changed = true
+				}
+			case Branch:
+				taken := -1
+				if v, ok := fold(in.Op, in.Args[0], in.Args[1]); ok {
+					if v != Const(0) {
+						taken = 0
+					} else {
+						taken = 1
+					}
+				} else if b.Succs[0] == b.Succs[1] {
+					taken = 0
+				}
+				if taken >= 0 {
+					in.Kind, in.Op, in.Args = Jump, 0, nil
+					b.Succs = []*Block{b.Succs[taken]}
+					changed = true
+				}
+			}
+
+			for _, d := range in.Defs() {
+				delete(consts, d)
+			}
+			if in.Kind == Copy {
+				if c, ok := in.Args[0].(Const); ok {
+					consts[in.Dst] = c
+				}
+			}
+		}
+	}
+	if changed {
+		f.Renumber()
+	}
+	return changed
+}
+
+func bothConst(x, y Value) (int64, int64, bool) {
+	cx, ok1 := x.(Const)
+	cy, ok2 := y.(Const)
+	return int64(cx), int64(cy), ok1 && ok2
+}
+
+// fold returns the value of x op y if it is a constant or one of the
+// operands.
+func fold(op ir.Op, x, y Value) (Value, bool) {
+	if cx, cy, ok := bothConst(x, y); ok {
+		v, ok := ir.Fold(op, cx, cy)
+		return Const(v), ok
+	}
+
+	is := func(v Value, c int64) bool { return v == Const(c) }
+	switch op {
+	case ir.Add, ir.Or, ir.Xor:
+		switch {
+		case is(x, 0):
+			return y, true
+		case is(y, 0):
+			return x, true
+		}
+	case ir.Sub, ir.LShift, ir.RShift, ir.ARShift:
+		if is(y, 0) {
+			return x, true
+		}
+	case ir.Mul:
+		switch {
+		case is(x, 1):
+			return y, true
+		case is(y, 1):
+			return x, true
+		case is(x, 0) || is(y, 0):
+			return Const(0), true
+		}
+	case ir.Div:
+		if is(y, 1) {
+			return x, true
+		}
+	case ir.And:
+		switch {
+		case is(x, 0) || is(y, 0):
+			return Const(0), true
+		}
+	}
+
+	// x - x, x ^ x and x == x do not depend on x.
+	if x == y {
+		if _, ok := x.(Temp); ok {
+			switch op {
+			case ir.Sub, ir.Xor:
+				return Const(0), true
+			case ir.Eq, ir.Leq, ir.Geq:
+				return Const(1), true
+			case ir.Neq, ir.Lt, ir.Gt, ir.ULt:
+				return Const(0), true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,252 @@
+// Package opt optimizes functions in a three-address form.
+//
+// Build flattens the expression trees of a lowered IR function into
+// instructions with at most one operator each, grouped into basic blocks;
+// Emit turns the result back into lowered IR for the code generators.
+// In between, passes rewrite the function. Each pass can be enabled on
+// its own:
+//
+//	cf           constant folding and branches on constants
+//	cp           copy and constant propagation
+//	lcse         common subexpression elimination within blocks
+//	cse          common subexpression elimination across blocks
+//	dce          removal of instructions whose results are never used
+//	unreachable  removal of blocks control never reaches
+//
+// Optimize runs the enabled passes over a compilation unit until none of
+// them changes anything.
+package opt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// A Value is an operand of an instruction: a Temp, a Const or a Name.
+type Value interface {
+	String() string
+	value()
+}
+
+type (
+	// A Temp is a variable of the function.
+	Temp string
+
+	Const int64
+
+	// A Name is the address of a function, which is only used as the
+	// target of a call.
+	Name string
+)
+
+func (t Temp) String() string  { return string(t) }
+func (c Const) String() string { return strconv.FormatInt(int64(c), 10) }
+func (n Name) String() string  { return string(n) }
+
+func (Temp) value()  {}
+func (Const) value() {}
+func (Name) value()  {}
+
+// A Kind is the kind of an instruction.
+type Kind int
+
+const (
+	Copy   Kind = iota // Dst = Args[0]
+	BinOp              // Dst = Args[0] Op Args[1]
+	Load               // Dst = [Args[0]]
+	Store              // [Args[0]] = Args[1]
+	Call               // Dst = Args[0](Args[1:]...); Dst may be empty
+	Jump               // goto Succs[0]
+	Branch             // if Args[0] Op Args[1] goto Succs[0] else Succs[1]
+	Return             // return Args...
+	Phi                // Dst = Args[i] on entry from Block.Preds[i]; only in SSA form
+)
+
+// An Instr is an instruction. Every block ends with a Jump, Branch or
+// Return, which appear nowhere else.
+type Instr struct {
+	Kind Kind
+	Op   ir.Op // for BinOp and Branch, which compares
+	Dst  Temp
+	Args []Value
+
+	// NumRets is the number of results of a call, which are left in the
+	// temporaries ir.Ret(i) whether or not the first is also assigned to
+	// Dst.
+	NumRets int
+}
+
+// IsTerminator reports whether in ends a block.
+func (in *Instr) IsTerminator() bool {
+	return in.Kind == Jump || in.Kind == Branch || in.Kind == Return
+}
+
+// Defs returns the temporaries in assigns.
+func (in *Instr) Defs() []Temp {
+	var defs []Temp
+	if in.Dst != "" {
+		defs = append(defs, in.Dst)
+	}
+	if in.Kind == Call {
+		for i := 0; i < in.NumRets; i++ {
+			defs = append(defs, Temp(ir.Ret(i).Name))
+		}
+	}
+	return defs
+}
+
+// Uses returns the temporaries in reads, in order, with repetitions.
+func (in *Instr) Uses() []Temp {
+	var uses []Temp
+	for _, arg := range in.Args {
+		if t, ok := arg.(Temp); ok {
+			uses = append(uses, t)
+		}
+	}
+	return uses
+}
+
+// HasSideEffects reports whether in does more than assign Dst, so that it
+// must be kept even if Dst is never used. Division traps when dividing by
+// zero, and loads are kept because the optimizer does not know which
+// addresses are valid.
+func (in *Instr) HasSideEffects() bool {
+	switch in.Kind {
+	case Copy, Phi:
+		return false
+	case BinOp:
+		if in.Op == ir.Div || in.Op == ir.Mod {
+			c, ok := in.Args[1].(Const)
+			return !ok || c == 0
+		}
+		return false
+	}
+	return true
+}
+
+func (in *Instr) String() string {
+	switch in.Kind {
+	case Copy:
+		return fmt.Sprintf("%s = %s", in.Dst, in.Args[0])
+	case BinOp:
+		return fmt.Sprintf("%s = %s %s %s", in.Dst, in.Op, in.Args[0], in.Args[1])
+	case Load:
+		return fmt.Sprintf("%s = [%s]", in.Dst, in.Args[0])
+	case Store:
+		return fmt.Sprintf("[%s] = %s", in.Args[0], in.Args[1])
+	case Call:
+		s := fmt.Sprintf("call %s(%s) # %d", in.Args[0], values(in.Args[1:]), in.NumRets)
+		if in.Dst != "" {
+			s = fmt.Sprintf("%s = %s", in.Dst, s)
+		}
+		return s
+	case Jump:
+		return "jump"
+	case Branch:
+		return fmt.Sprintf("branch %s %s %s", in.Op, in.Args[0], in.Args[1])
+	case Return:
+		if len(in.Args) == 0 {
+			return "return"
+		}
+		return "return " + values(in.Args)
+	case Phi:
+		return fmt.Sprintf("%s = phi(%s)", in.Dst, values(in.Args))
+	}
+	return fmt.Sprintf("Kind(%d)", int(in.Kind))
+}
+
+func values(list []Value) string {
+	s := make([]string, len(list))
+	for i, v := range list {
+		s[i] = v.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// A Block is a basic block.
+type Block struct {
+	Index  int // position in Func.Blocks
+	Label  string
+	Instrs []*Instr
+
+	// A block ending in a Branch has two successors, taken when the
+	// comparison holds and when it does not; they may be the same block.
+	Succs []*Block
+	Preds []*Block
+}
+
+// Terminator returns the last instruction of b.
+func (b *Block) Terminator() *Instr { return b.Instrs[len(b.Instrs)-1] }
+
+// A Func is a function in three-address form. Blocks[0] is the entry; on
+// entry, the arguments are in the temporaries ir.Arg(i).
+type Func struct {
+	Name    string
+	NumArgs int
+	NumRets int
+	Blocks  []*Block
+
+	temps int
+}
+
+// NewTemp returns a fresh temporary, distinct from those of the IR.
+func (f *Func) NewTemp() Temp {
+	f.temps++
+	return Temp(fmt.Sprintf("_o%d", f.temps))
+}
+
+// NumNodes, Succs and Preds make f a cfg.Flow over its blocks.
+func (f *Func) NumNodes() int { return len(f.Blocks) }
+
+func (f *Func) Succs(i int) []int { return indices(f.Blocks[i].Succs) }
+func (f *Func) Preds(i int) []int { return indices(f.Blocks[i].Preds) }
+
+func indices(blocks []*Block) []int {
+	list := make([]int, len(blocks))
+	for i, b := range blocks {
+		list[i] = b.Index
+	}
+	return list
+}
+
+// Renumber sets the indices and predecessors of the blocks after blocks
+// have been removed or their successors changed.
+func (f *Func) Renumber() {
+	for i, b := range f.Blocks {
+		b.Index = i
+		b.Preds = nil
+	}
+	for _, b := range f.Blocks {
+		for _, s := range b.Succs {
+			s.Preds = append(s.Preds, b)
+		}
+	}
+}
+
+func (f *Func) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d %d:\n", f.Name, f.NumArgs, f.NumRets)
+	for _, block := range f.Blocks {
+		fmt.Fprintf(&b, "%s:", block.Label)
+		if len(block.Preds) > 0 {
+			b.WriteString(" # from")
+			for _, p := range block.Preds {
+				fmt.Fprintf(&b, " %s", p.Label)
+			}
+		}
+		b.WriteByte('\n')
+		for _, in := range block.Instrs {
+			fmt.Fprintf(&b, "\t%s", in)
+			if in.IsTerminator() && len(block.Succs) > 0 {
+				for _, s := range block.Succs {
+					fmt.Fprintf(&b, " %s", s.Label)
+				}
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,239 @@
+package opt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/ir/sim"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// lower translates and lowers a program.
+func lower(t *testing.T, src string) *ir.CompUnit {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &types.Config{Importer: importer.New(fset, nil)}
+	info, err := types.Check(fset, []*ast.File{f}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ir.Lower(ir.Translate("test", f, info))
+}
+
+// simulate runs a lowered program and returns its output and run-time
+// error.
+func simulate(cu *ir.CompUnit, stdin string) (string, error) {
+	var stdout bytes.Buffer
+	s := sim.New(cu)
+	rt := stdlib.NewRuntime(strings.NewReader(stdin), &stdout)
+	for name, fn := range rt.Funcs() {
+		s.Bind(name, fn)
+	}
+	err := s.Main(nil)
+	rt.Flush()
+	return stdout.String(), err
+}
+
+var programs = []struct {
+	name  string
+	src   string
+	stdin string
+}{
+	{"loops", `use io
+use conv
+sum(a: int[], k: int): int {
+	s:int = 0
+	i:int = 0
+	while (i < length(a)) {
+		x:int = 2 * 3 + k
+		s = s + a[i] * x + a[i] * x
+		i = i + 1
+	}
+	if (false) { s = 0 }
+	return s
+}
+main(args: int[][]) {
+	println(unparseInt(sum({1, 2, 3}, 1)))
+}
+`, ""},
+	{"sort", `use io
+use conv
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+main(args: int[][]) {
+	a:int[] = {5, 3, -8, 13, 0, 3}
+	sort(a)
+	i:int = 0
+	while (i < length(a)) {
+		print(unparseInt(a[i]) + " ")
+		i = i + 1
+	}
+	println("")
+}
+`, ""},
+	{"results", `use io
+use conv
+divmod(a: int, b: int): int, int, int {
+	return a / b, a % b, a
+}
+main(args: int[][]) {
+	q:int, r:int, a:int = divmod(-7, 2)
+	x:int, _, _ = divmod(a * 10, q)
+	b:bool = q < r & !(x == 0) | false
+	if (b) println(unparseInt(q) + unparseInt(r) + unparseInt(x))
+}
+`, ""},
+	{"input", `use io
+use conv
+main(args: int[][]) {
+	n:int, ok:bool = parseInt(readln())
+	x:int = n * n
+	y:int = n * n
+	n = n + 1
+	z:int = n * n
+	if (ok) println(unparseInt(x + y + z))
+}
+`, "12\n"},
+	{"division by zero", `use io
+use conv
+main(args: int[][]) {
+	zero:int = 0
+	print("before ")
+	x:int = 1 / zero
+	println(unparseInt(x))
+}
+`, ""},
+}
+
+func TestPrograms(t *testing.T) {
+	configs := map[string][]*Pass{"all": Passes}
+	for _, p := range Passes {
+		configs[p.Name] = []*Pass{p}
+	}
+
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			cu := lower(t, test.src)
+			want, wantErr := simulate(cu, test.stdin)
+			for name, passes := range configs {
+				got, err := simulate(Optimize(cu, &Config{Passes: passes}), test.stdin)
+				if got != want {
+					t.Errorf("%s: got output %q, expected %q", name, got, want)
+				}
+				if (err == nil) != (wantErr == nil) {
+					t.Errorf("%s: got error %v, expected %v", name, err, wantErr)
+				}
+			}
+		})
+	}
+}
+
+// optimize returns the three-address form of the function in src after
+// the given passes.
+func optimize(t *testing.T, src, passes string) string {
+	t.Helper()
+
+	list, err := Lookup(passes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump strings.Builder
+	Optimize(lower(t, src), &Config{Passes: list, Dump: &dump})
+
+	// The last dump is the final form.
+	s := dump.String()
+	s = s[strings.LastIndex(s, "\n# ")+1:]
+	return s[strings.Index(s, "\n")+1:]
+}
+
+func TestPasses(t *testing.T) {
+	for _, test := range []struct {
+		passes string
+		src    string
+		want   []string // lines of the result
+		absent []string
+	}{
+		{"cf", `f(): int { return 2 * 3 + 4 }`, []string{"return 10"}, nil},
+		{"cf", `f(x: int): int { return x * 1 + 0 - x }`, nil, []string{"MUL", "ADD"}},
+		{"cf,unreachable", `f(x: int): int {
+	if (1 > 2) { x = 3 }
+	return x
+}`, nil, []string{"x = 3"}},
+		{"cp,dce", `f(x: int): int {
+	y:int = x
+	z:int = y
+	return z + 1
+}`, []string{"_o1 = ADD _ARG0 1", "return _o1"}, []string{"y ="}},
+		{"lcse,cp,dce", `f(a: int, b: int): int { return a * b + a * b }`, []string{"_o2 = MUL _ARG0 _ARG1", "_o1 = ADD _o2 _o2"}, nil},
+		{"cse,cp,dce", `f(a: int, b: int, c: bool): int {
+	x:int = a * b
+	if (c) { x = x + 1 }
+	return a * b + x
+}`, []string{"x = MUL _ARG0 _ARG1", "_o3 = x", "_o1 = ADD _o3 x"}, nil},
+		// b is assigned on one path, so a * b is not available.
+		{"cse", `f(a: int, b: int, c: bool): int {
+	x:int = a * b
+	if (c) { b = 2 }
+	return a * b + x
+}`, []string{"_o2 = MUL a b"}, nil},
+		// Stores kill loads.
+		{"cse,cp,dce", `f(a: int[]): int {
+	x:int = a[0]
+	a[0] = 2
+	return x + a[0]
+}`, []string{"_o12 = [_o3]", "_o11 = ADD x _o12"}, nil},
+		{"dce", `f(x: int): int {
+	y:int = x * 2
+	z:int = x / 0
+	return x
+}`, []string{"z = DIV x 0"}, []string{"y ="}},
+	} {
+		got := optimize(t, test.src, test.passes)
+		for _, want := range test.want {
+			if !strings.Contains(got, "\t"+want+"\n") {
+				t.Errorf("%s: missing %q in\n%s", test.passes, want, got)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(got, absent) {
+				t.Errorf("%s: unexpected %q in\n%s", test.passes, absent, got)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	passes, err := Lookup("dce, cf")
+	if err != nil || len(passes) != 2 || passes[0].Name != "cf" || passes[1].Name != "dce" {
+		t.Errorf("Lookup(\"dce, cf\") = %v, %v", passes, err)
+	}
+	if _, err := Lookup("cf,licm"); err == nil {
+		t.Error("Lookup accepted an unknown pass")
+	}
+}
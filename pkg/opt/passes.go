@@ -0,0 +1,97 @@
+package opt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/ir"
+)
+
+// A Pass rewrites a function and reports whether it changed anything.
+type Pass struct {
+	Name string
+	Run  func(f *Func) bool
+}
+
+// Passes lists the passes in the order Optimize runs them.
+var Passes = []*Pass{
+	{"cf", ConstFold},
+	{"cp", CopyProp},
+	{"lcse", LocalCSE},
+	{"cse", GlobalCSE},
+	{"dce", DeadCode},
+	{"unreachable", Unreachable},
+}
+
+// Lookup returns the passes named in the comma-separated list names, in
+// the order Optimize runs them. The name "all" stands for every pass.
+func Lookup(names string) ([]*Pass, error) {
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			return Passes, nil
+		}
+		known := false
+		for _, p := range Passes {
+			known = known || p.Name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("opt: unknown pass %q", name)
+		}
+		enabled[name] = true
+	}
+
+	var list []*Pass
+	for _, p := range Passes {
+		if enabled[p.Name] {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+// A Config configures Optimize.
+type Config struct {
+	Passes []*Pass
+
+	// If Dump is not nil, each function is written to it before the first
+	// pass and after every pass that changes it.
+	Dump io.Writer
+}
+
+// maxRounds bounds the number of times Optimize runs the passes over a
+// function.
+const maxRounds = 10
+
+// Optimize returns cu, which must be lowered, optimized by the passes of
+// config. The result is lowered.
+func Optimize(cu *ir.CompUnit, config *Config) *ir.CompUnit {
+	out := &ir.CompUnit{Name: cu.Name}
+	for _, fn := range cu.Funcs {
+		f := Build(fn)
+		if config.Dump != nil {
+			fmt.Fprintf(config.Dump, "# %s\n%s\n", "build", f)
+		}
+		for round := 0; round < maxRounds; round++ {
+			changed := false
+			for _, p := range config.Passes {
+				if p.Run(f) {
+					changed = true
+					if config.Dump != nil {
+						fmt.Fprintf(config.Dump, "# %s\n%s\n", p.Name, f)
+					}
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+		out.Funcs = append(out.Funcs, Emit(f))
+	}
+	return ir.Lower(out)
+}
@@ -5,12 +5,15 @@ import (
 	"io/ioutil"
 
 	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
 )
 
 type Mode int
 
 const (
-	Trace Mode = (1 << iota)
+	Trace         Mode = (1 << iota) // print a trace of parsed productions
+	AllErrors                        // report all errors (not just the first 10 on different lines)
+	ParseComments                    // keep comments and add them to the AST
 )
 
 func readSource(filename string, src interface{}) ([]byte, error) {
@@ -26,32 +29,47 @@ func readSource(filename string, src interface{}) ([]byte, error) {
 	return ioutil.ReadFile(filename)
 }
 
-func ParseFile(filename string, src interface{}, mode Mode) (file *ast.File, err error) {
-	_, err = readSource(filename, src)
+// ParseFile parses the source code of a single Xi file and returns the
+// corresponding ast.File. Position information is recorded in fset, which
+// must not be nil.
+//
+// If src != nil, ParseFile parses the source from src and the filename is
+// only used when recording position information. The type of the argument
+// for the src parameter must be string, []byte, or io.Reader. If src == nil,
+// ParseFile parses the file specified by filename.
+//
+// If the source couldn't be read, the returned AST is nil and the error
+// indicates the specific failure. If the source was read but syntax errors
+// were found, the result is a partial AST (with ast.Bad* nodes representing
+// the fragments of erroneous source code). Multiple errors are returned via
+// a scanner.ErrorList which is sorted by source position.
+func ParseFile(fset *token.FileSet, filename string, src interface{}, mode Mode) (file *ast.File, err error) {
+	content, err := readSource(filename, src)
 	if err != nil {
 		return nil, err
 	}
 
+	var p parser
+	p.init(fset, filename, content, mode)
+	file = p.parseFile()
+
+	p.errors.Sort()
+	return file, p.errors.Err()
+}
+
+// ParseInterface parses the source code of a single Xi interface (.ixi)
+// file, which holds function signatures without bodies. The arguments and
+// results are as for ParseFile.
+func ParseInterface(fset *token.FileSet, filename string, src interface{}, mode Mode) (iface *ast.Interface, err error) {
 	content, err := readSource(filename, src)
 	if err != nil {
 		return nil, err
 	}
 
 	var p parser
-	defer func() {
-		if e := recover(); e != nil {
-			switch t := e.(type) {
-			case error:
-				file = nil
-				err = t
-			default:
-				panic(e)
-			}
-		}
-	}()
-
-	p.init(filename, content, mode)
-
-	f := p.parseFile()
-	return f, nil
+	p.init(fset, filename, content, mode)
+	iface = p.parseInterface()
+
+	p.errors.Sort()
+	return iface, p.errors.Err()
 }
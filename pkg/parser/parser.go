@@ -9,20 +9,28 @@ import (
 )
 
 type parser struct {
+	file    *token.File
+	errors  scanner.ErrorList
 	scanner *scanner.Scanner
+	mode    Mode
 	indent  int
 	trace   bool
 
-	pos token.Position
-	tok token.TokenType
-	lit string
+	pos  token.Pos
+	tok  token.TokenType
+	lit  string
+	prev token.Pos // position of the previous token
+
+	comments []*ast.CommentGroup
+	lead     *ast.CommentGroup // comment on the lines right before the current token
 }
 
 // Copied from go/parser. Credit to the Go team!
 func (p *parser) printTrace(a ...interface{}) {
 	const dots = ". . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . "
 	const n = len(dots)
-	fmt.Printf("%5d:%3d: ", p.pos.Line, p.pos.Column)
+	pos := p.file.Position(p.pos)
+	fmt.Printf("%5d:%3d: ", pos.Line, pos.Column)
 	i := 2 * p.indent
 	for i > n {
 		fmt.Print(dots)
@@ -45,37 +53,185 @@ func un(p *parser) {
 	p.printTrace(")")
 }
 
-func (p *parser) init(filename string, src []byte, mode Mode) {
-	p.scanner = scanner.NewScanner(src, nil)
+func (p *parser) init(fset *token.FileSet, filename string, src []byte, mode Mode) {
+	p.file = fset.AddFile(filename, -1, len(src))
+	eh := func(pos token.Position, msg string) { p.errorAt(pos, msg) }
+	var smode scanner.Mode
+	if mode&ParseComments != 0 {
+		smode = scanner.ScanComments
+	}
+	p.scanner = scanner.NewScanner(p.file, src, eh, smode)
+	p.mode = mode
 	p.trace = mode&Trace != 0
 	p.next()
 }
 
-func (p *parser) next() {
+// scan returns the next token from the scanner. Scanner errors have
+// already been reported through the error handler, so error tokens are
+// skipped.
+func (p *parser) scan() token.Token {
 	tok := p.scanner.Scan()
-	if tok.Typ == token.Error {
-		panic(fmt.Errorf(tok.Lit))
+	for tok.Typ == token.Error {
+		tok = p.scanner.Scan()
+	}
+	return tok
+}
+
+// next advances to the next token, collecting the comments before it. A
+// comment on the line of the previous token is a group of its own; the
+// group that ends on the line before the new token is its lead comment.
+func (p *parser) next() {
+	p.prev = p.pos
+	p.lead = nil
+
+	tok := p.scan()
+	if tok.Typ == token.Comment {
+		if p.prev.IsValid() && p.file.Line(tok.Pos) == p.file.Line(p.prev) {
+			_, _, tok = p.commentGroup(tok, 0)
+		}
+
+		var group *ast.CommentGroup
+		end := -1
+		for tok.Typ == token.Comment {
+			group, end, tok = p.commentGroup(tok, 1)
+		}
+		if end+1 == p.file.Line(tok.Pos) {
+			p.lead = group
+		}
 	}
 
 	p.pos, p.tok, p.lit = tok.Pos, tok.Typ, tok.Lit
 }
 
-func (p *parser) expect(tok token.TokenType) token.Position {
+// commentGroup collects the comments starting with tok that are at most n
+// lines after the previous one. It returns the group, its last line and
+// the token after it.
+func (p *parser) commentGroup(tok token.Token, n int) (*ast.CommentGroup, int, token.Token) {
+	var list []*ast.Comment
+	end := p.file.Line(tok.Pos)
+	for tok.Typ == token.Comment && p.file.Line(tok.Pos) <= end+n {
+		list = append(list, &ast.Comment{Slash: tok.Pos, Text: tok.Lit})
+		end = p.file.Line(tok.Pos)
+		tok = p.scan()
+	}
+
+	group := &ast.CommentGroup{List: list}
+	p.comments = append(p.comments, group)
+	return group, end, tok
+}
+
+// A bailout panic is raised to indicate early termination.
+type bailout struct{}
+
+// bailedOut reports whether e, a recovered panic value, is a bailout, and
+// resumes any other panic. Declaration lists recover from a bailout so that
+// the declarations parsed before it survive.
+func bailedOut(e interface{}) bool {
+	if e == nil {
+		return false
+	}
+	if _, ok := e.(bailout); !ok {
+		panic(e)
+	}
+	return true
+}
+
+func (p *parser) error(pos token.Pos, msg string) {
+	p.errorAt(p.file.Position(pos), msg)
+}
+
+func (p *parser) errorAt(epos token.Position, msg string) {
+	// If AllErrors is not set, discard errors reported on the same line
+	// as the last recorded error and stop parsing if there are more than
+	// 10 errors.
+	if p.mode&AllErrors == 0 {
+		n := len(p.errors)
+		if n > 0 && p.errors[n-1].Pos.Line == epos.Line {
+			return // discard - likely a spurious error
+		}
+		if n > 10 {
+			panic(bailout{})
+		}
+	}
+
+	p.errors.Add(epos, msg)
+}
+
+func (p *parser) errorExpected(pos token.Pos, what string) {
+	msg := "expected " + what
+	if pos == p.pos {
+		// the error happened at the current position;
+		// make the error message more specific
+		switch {
+		case p.tok == token.Eof:
+			msg += ", found EOF"
+		case p.lit != "" && p.tok != token.Illegal:
+			msg += ", found " + p.lit
+		default:
+			msg += ", found " + p.tok.String()
+		}
+	}
+	p.error(pos, msg)
+}
+
+// expect consumes the current token if it is tok, and reports an error
+// otherwise. The offending token is left in place so that the caller can
+// carry on as if the expected token had been there.
+func (p *parser) expect(tok token.TokenType) token.Pos {
 	pos := p.pos
 
 	if p.tok != tok {
-		panic(fmt.Errorf("unexpected token: %s, wanted: %s", p.lit, tok))
+		p.errorExpected(pos, tok.String())
+		return pos
 	}
 
 	p.next()
 	return pos
 }
 
+// atLineStart reports whether the current token is the first on its line.
+func (p *parser) atLineStart() bool {
+	return !p.prev.IsValid() || p.file.Line(p.prev) < p.file.Line(p.pos)
+}
+
+// syncStmt advances to the next token that plausibly starts a statement,
+// or closes the enclosing block. Identifiers, discards and braces only
+// count when they are the first token on their line, since otherwise they
+// are more likely to be part of the broken statement.
+func (p *parser) syncStmt() {
+	for ; p.tok != token.Eof; p.next() {
+		switch p.tok {
+		case token.If, token.While, token.Return, token.Rbrace:
+			return
+		case token.Ident, token.Underscore, token.Lbrace:
+			if p.atLineStart() {
+				return
+			}
+		}
+	}
+}
+
+// syncDecl advances to the next token that plausibly starts a top-level
+// declaration: a use, or an identifier in the first column.
+func (p *parser) syncDecl() {
+	for ; p.tok != token.Eof; p.next() {
+		switch p.tok {
+		case token.Use:
+			return
+		case token.Ident:
+			if p.atLineStart() && p.file.Position(p.pos).Column == 1 {
+				return
+			}
+		}
+	}
+}
+
 func (p *parser) parseIdent() *ast.Ident {
 	if p.trace {
 		defer un(trace(p, "Ident"))
 	}
 
+	pos := p.pos
 	name := "_"
 	if p.tok == token.Ident {
 		name = p.lit
@@ -83,7 +239,7 @@ func (p *parser) parseIdent() *ast.Ident {
 	} else {
 		p.expect(token.Ident)
 	}
-	return &ast.Ident{Name: name}
+	return &ast.Ident{NamePos: pos, Name: name}
 }
 
 func (p *parser) parseArrayLit() *ast.ArrayLit {
@@ -91,7 +247,7 @@ func (p *parser) parseArrayLit() *ast.ArrayLit {
 		defer un(trace(p, "ArrayLit"))
 	}
 
-	p.expect(token.Lbrace)
+	lbrace := p.expect(token.Lbrace)
 
 	elts := []ast.Expr{}
 
@@ -103,8 +259,8 @@ func (p *parser) parseArrayLit() *ast.ArrayLit {
 		p.next()
 	}
 
-	p.expect(token.Rbrace)
-	return &ast.ArrayLit{Elts: elts}
+	rbrace := p.expect(token.Rbrace)
+	return &ast.ArrayLit{Lbrace: lbrace, Elts: elts, Rbrace: rbrace}
 }
 
 func (p *parser) parseType() ast.Type {
@@ -113,14 +269,16 @@ func (p *parser) parseType() ast.Type {
 	}
 
 	if p.tok != token.Int && p.tok != token.Bool {
-		panic(fmt.Errorf("unexpected token: %s", p.lit))
+		p.errorExpected(p.pos, "type")
+		return &ast.BadExpr{From: p.pos, To: p.pos}
 	}
 
-	var typ ast.Type = &ast.PrimitiveType{Kind: p.tok}
+	var typ ast.Type = &ast.PrimitiveType{KindPos: p.pos, Kind: p.tok}
 
 	p.next()
 
 	for p.tok == token.Lbrack {
+		lbrack := p.pos
 		p.next()
 
 		var size ast.Expr
@@ -128,10 +286,12 @@ func (p *parser) parseType() ast.Type {
 			size = p.parseExpr()
 		}
 
-		p.expect(token.Rbrack)
+		rbrack := p.expect(token.Rbrack)
 		typ = &ast.ArrayType{
-			Elt:  typ,
-			Size: size,
+			Elt:    typ,
+			Lbrack: lbrack,
+			Size:   size,
+			Rbrack: rbrack,
 		}
 	}
 
@@ -143,7 +303,7 @@ func (p *parser) parseCallExpr(ident0 *ast.Ident) *ast.CallExpr {
 		defer un(trace(p, "CallExpr"))
 	}
 
-	p.expect(token.Lparen)
+	lparen := p.expect(token.Lparen)
 
 	var args []ast.Expr
 
@@ -157,11 +317,13 @@ func (p *parser) parseCallExpr(ident0 *ast.Ident) *ast.CallExpr {
 		}
 	}
 
-	p.expect(token.Rparen)
+	rparen := p.expect(token.Rparen)
 
 	return &ast.CallExpr{
-		Func: ident0,
-		Args: args,
+		Func:   ident0,
+		Lparen: lparen,
+		Args:   args,
+		Rparen: rparen,
 	}
 }
 
@@ -170,73 +332,68 @@ func (p *parser) parseSubscriptExpr(lhs ast.Expr) *ast.SubscriptExpr {
 		defer un(trace(p, "SubscriptExpr"))
 	}
 
-	p.expect(token.Lbrack)
-	subscript := p.parseExpr()
-	p.expect(token.Rbrack)
-
-	expr := &ast.SubscriptExpr{Lhs: lhs, Subscript: subscript}
+	var expr *ast.SubscriptExpr
 
 	for p.tok == token.Lbrack {
+		lbrack := p.pos
 		p.next()
 		subscript := p.parseExpr()
-		p.expect(token.Rbrack)
-		expr = &ast.SubscriptExpr{Lhs: lhs, Subscript: subscript}
+		rbrack := p.expect(token.Rbrack)
+		expr = &ast.SubscriptExpr{Lhs: lhs, Lbrack: lbrack, Subscript: subscript, Rbrack: rbrack}
+		lhs = expr
 	}
 
 	return expr
 }
 
-func (p *parser) parseLengthExpr(tok token.TokenType) ast.Expr {
+func (p *parser) parseLengthExpr(pos token.Pos, tok token.TokenType) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "LengthExpr"))
 	}
 
-	p.expect(token.Lparen)
+	lparen := p.expect(token.Lparen)
 
 	arg := p.parseExpr()
 
-	p.expect(token.Rparen)
+	rparen := p.expect(token.Rparen)
 
 	return &ast.LengthExpr{
-		Tok: tok,
-		Arg: arg,
+		TokPos: pos,
+		Tok:    tok,
+		Lparen: lparen,
+		Arg:    arg,
+		Rparen: rparen,
 	}
 }
 
 func (p *parser) parseExpr() ast.Expr {
-	return p.parseExpr0(true)
-}
-
-func (p *parser) parseExpr0(strict bool) (expr ast.Expr) {
 	if p.trace {
 		defer un(trace(p, "Expr"))
 	}
 
-	if !strict {
-		defer func() {
-			if e := recover(); e != nil {
-				switch e.(type) {
-				case error:
-					expr = nil
-				default:
-					panic(e)
-				}
-			}
-		}()
-	}
-
 	return p.parseOrExpr()
 }
 
+// isExprStart reports whether tok may begin an expression.
+func isExprStart(tok token.TokenType) bool {
+	switch tok {
+	case token.Ident, token.Integer, token.Char, token.String, token.True, token.False,
+		token.Lbrace, token.Lparen, token.Sub, token.Not, token.Length:
+		return true
+	}
+	return false
+}
+
 func (p *parser) parseOrExpr() ast.Expr {
 	var lhs ast.Expr = p.parseAndExpr()
 	for p.tok == token.Or {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseAndExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseAndExpr(),
 		}
 	}
 	return lhs
@@ -245,12 +402,13 @@ func (p *parser) parseOrExpr() ast.Expr {
 func (p *parser) parseAndExpr() ast.Expr {
 	var lhs ast.Expr = p.parseEqualityExpr()
 	for p.tok == token.And {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseEqualityExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseEqualityExpr(),
 		}
 	}
 	return lhs
@@ -259,12 +417,13 @@ func (p *parser) parseAndExpr() ast.Expr {
 func (p *parser) parseEqualityExpr() ast.Expr {
 	var lhs ast.Expr = p.parseComparisonExpr()
 	for p.tok == token.Eq || p.tok == token.Neq {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseComparisonExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseComparisonExpr(),
 		}
 	}
 	return lhs
@@ -273,12 +432,13 @@ func (p *parser) parseEqualityExpr() ast.Expr {
 func (p *parser) parseComparisonExpr() ast.Expr {
 	var lhs ast.Expr = p.parseTermExpr()
 	for p.tok == token.Lt || p.tok == token.Le || p.tok == token.Gt || p.tok == token.Ge {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseTermExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseTermExpr(),
 		}
 	}
 	return lhs
@@ -287,12 +447,13 @@ func (p *parser) parseComparisonExpr() ast.Expr {
 func (p *parser) parseTermExpr() ast.Expr {
 	var lhs ast.Expr = p.parseFactorExpr()
 	for p.tok == token.Add || p.tok == token.Sub {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseFactorExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseFactorExpr(),
 		}
 	}
 	return lhs
@@ -300,13 +461,14 @@ func (p *parser) parseTermExpr() ast.Expr {
 
 func (p *parser) parseFactorExpr() ast.Expr {
 	var lhs ast.Expr = p.parseUnaryExpr()
-	for p.tok == token.Mul || p.tok == token.Div || p.tok == token.Rem {
-		tok := p.tok
+	for p.tok == token.Mul || p.tok == token.HMul || p.tok == token.Div || p.tok == token.Rem {
+		pos, tok := p.pos, p.tok
 		p.next()
 		lhs = &ast.BinaryExpr{
-			Lhs: lhs,
-			Op:  tok,
-			Rhs: p.parseUnaryExpr(),
+			Lhs:   lhs,
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseUnaryExpr(),
 		}
 	}
 	return lhs
@@ -314,11 +476,12 @@ func (p *parser) parseFactorExpr() ast.Expr {
 
 func (p *parser) parseUnaryExpr() ast.Expr {
 	if p.tok == token.Sub || p.tok == token.Not {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
 		return &ast.UnaryExpr{
-			Op:  tok,
-			Rhs: p.parseCallOrSubscriptExpr(),
+			OpPos: pos,
+			Op:    tok,
+			Rhs:   p.parseUnaryExpr(),
 		}
 	}
 	return p.parseCallOrSubscriptExpr()
@@ -326,9 +489,9 @@ func (p *parser) parseUnaryExpr() ast.Expr {
 
 func (p *parser) parseCallOrSubscriptExpr() ast.Expr {
 	if p.tok == token.Length {
-		tok := p.tok
+		pos, tok := p.pos, p.tok
 		p.next()
-		return p.parseLengthExpr(tok)
+		return p.parseLengthExpr(pos, tok)
 	}
 
 	lhs := p.parseBaseExpr()
@@ -337,18 +500,25 @@ loop:
 	for {
 		switch p.tok {
 		case token.Lbrack:
+			lbrack := p.pos
 			p.next()
 			expr := p.parseOrExpr()
-			p.expect(token.Rbrack)
+			rbrack := p.expect(token.Rbrack)
 			lhs = &ast.SubscriptExpr{
 				Lhs:       lhs,
+				Lbrack:    lbrack,
 				Subscript: expr,
+				Rbrack:    rbrack,
 			}
 		case token.Lparen:
-			if _, ok := lhs.(*ast.Ident); !ok {
-				panic(fmt.Errorf("can't call a non-identifier expression"))
+			ident, ok := lhs.(*ast.Ident)
+			if !ok {
+				p.error(p.pos, "can't call a non-identifier expression")
+				call := p.parseCallExpr(&ast.Ident{NamePos: lhs.Pos(), Name: "_"})
+				lhs = &ast.BadExpr{From: lhs.Pos(), To: call.End()}
+				continue
 			}
-			lhs = p.parseCallExpr(lhs.(*ast.Ident))
+			lhs = p.parseCallExpr(ident)
 		default:
 			break loop
 		}
@@ -366,7 +536,7 @@ func (p *parser) parseBaseExpr() ast.Expr {
 	case token.Ident:
 		return p.parseIdent()
 	case token.Integer, token.String, token.True, token.False, token.Char:
-		lit := ast.BasicLit{Kind: p.tok, Value: p.lit}
+		lit := ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		p.next()
 		return &lit
 	case token.Lbrace:
@@ -377,7 +547,10 @@ func (p *parser) parseBaseExpr() ast.Expr {
 		p.expect(token.Rparen)
 		return expr
 	default:
-		panic(fmt.Errorf("unexpected token: %s", p.tok))
+		// Leave the offending token for the enclosing statement to
+		// resynchronize on.
+		p.errorExpected(p.pos, "expression")
+		return &ast.BadExpr{From: p.pos, To: p.pos}
 	}
 }
 
@@ -407,7 +580,8 @@ func (p *parser) parseAssignable() ast.Assignable {
 		type_ := p.parseType()
 		return &ast.Spec{Name: ident, Type: type_}
 	default:
-		panic("unreachable")
+		p.errorExpected(p.pos, "declaration or _")
+		return &ast.BadExpr{From: p.pos, To: p.pos}
 	}
 }
 
@@ -458,10 +632,10 @@ func (p *parser) parseMultiDeclStmt(assigns []ast.Assignable) *ast.MultiDeclStmt
 		defer un(trace(p, "MultiDeclStmt"))
 	}
 
-	p.expect(token.Assign)
+	assign := p.expect(token.Assign)
 	ident := p.parseIdent()
 	expr := p.parseCallExpr(ident)
-	return &ast.MultiDeclStmt{Assignables: assigns, Init: expr}
+	return &ast.MultiDeclStmt{Assignables: assigns, Assign: assign, Init: expr}
 }
 
 // either single or multi
@@ -489,9 +663,9 @@ func (p *parser) parseAssignStmt(ident0 *ast.Ident) *ast.AssignStmt {
 	}
 
 	lvalue := p.parseLvalue(ident0)
-	p.expect(token.Assign)
+	assign := p.expect(token.Assign)
 	expr := p.parseExpr()
-	return &ast.AssignStmt{Lhs: lvalue, Rhs: expr}
+	return &ast.AssignStmt{Lhs: lvalue, Assign: assign, Rhs: expr}
 }
 
 func (p *parser) parseIfStmt() *ast.IfStmt {
@@ -499,18 +673,20 @@ func (p *parser) parseIfStmt() *ast.IfStmt {
 		defer un(trace(p, "If"))
 	}
 
-	p.expect(token.If)
+	pos := p.expect(token.If)
 
 	cond := p.parseExpr()
 	then := p.parseStmt()
 
+	var elsePos token.Pos
 	var else_ ast.Stmt
 	if p.tok == token.Else {
+		elsePos = p.pos
 		p.next()
 		else_ = p.parseStmt()
 	}
 
-	return &ast.IfStmt{Cond: cond, Then: then, Else: else_}
+	return &ast.IfStmt{If: pos, Cond: cond, Then: then, ElsePos: elsePos, Else: else_}
 }
 
 func (p *parser) parseWhileStmt() *ast.WhileStmt {
@@ -518,12 +694,12 @@ func (p *parser) parseWhileStmt() *ast.WhileStmt {
 		defer un(trace(p, "While"))
 	}
 
-	p.expect(token.While)
+	pos := p.expect(token.While)
 
 	cond := p.parseExpr()
 	body := p.parseStmt()
 
-	return &ast.WhileStmt{Cond: cond, Body: body}
+	return &ast.WhileStmt{While: pos, Cond: cond, Body: body}
 }
 
 func (p *parser) parseReturn() *ast.ReturnStmt {
@@ -531,13 +707,12 @@ func (p *parser) parseReturn() *ast.ReturnStmt {
 		defer un(trace(p, "Return"))
 	}
 
-	p.expect(token.Return)
+	pos := p.expect(token.Return)
 
 	vals := make([]ast.Expr, 0)
-	val := p.parseExpr0(false)
 
-	if val != nil {
-		vals = append(vals, val)
+	if isExprStart(p.tok) {
+		vals = append(vals, p.parseExpr())
 		for {
 			if p.tok != token.Comma {
 				break
@@ -549,7 +724,7 @@ func (p *parser) parseReturn() *ast.ReturnStmt {
 		}
 	}
 
-	return &ast.ReturnStmt{Values: vals}
+	return &ast.ReturnStmt{Return: pos, Values: vals}
 }
 
 func (p *parser) parseBlock() *ast.BlockStmt {
@@ -557,7 +732,7 @@ func (p *parser) parseBlock() *ast.BlockStmt {
 		defer un(trace(p, "Block"))
 	}
 
-	p.expect(token.Lbrace)
+	lbrace := p.expect(token.Lbrace)
 
 	list := make([]ast.Stmt, 0)
 
@@ -569,8 +744,8 @@ func (p *parser) parseBlock() *ast.BlockStmt {
 		}
 	}
 
-	p.expect(token.Rbrace)
-	return &ast.BlockStmt{List: list}
+	rbrace := p.expect(token.Rbrace)
+	return &ast.BlockStmt{Lbrace: lbrace, List: list, Rbrace: rbrace}
 }
 
 func (p *parser) parseDiscard() *ast.Discard {
@@ -578,8 +753,8 @@ func (p *parser) parseDiscard() *ast.Discard {
 		defer un(trace(p, "Discard"))
 	}
 
-	p.expect(token.Underscore)
-	return &ast.Discard{}
+	pos := p.expect(token.Underscore)
+	return &ast.Discard{Underscore: pos}
 }
 
 func (p *parser) parseStmt() ast.Stmt {
@@ -598,7 +773,15 @@ func (p *parser) parseStmt() ast.Stmt {
 		case token.Lparen:
 			return p.parseCallExpr(ident0)
 		default:
-			panic(fmt.Errorf("unexpected token in stmt: %s", p.tok))
+			if p.atLineStart() {
+				// The statement ended with the identifier; blaming the
+				// next line would be confusing.
+				p.error(ident0.End(), "expected :, =, [ or ( after "+ident0.Name)
+			} else {
+				p.errorExpected(p.pos, ":, =, [ or (")
+			}
+			p.syncStmt()
+			return &ast.BadStmt{From: ident0.Pos(), To: p.pos}
 		}
 	case token.Underscore:
 		return p.parseDeclStmt(p.parseDiscard())
@@ -611,7 +794,11 @@ func (p *parser) parseStmt() ast.Stmt {
 	case token.Lbrace:
 		return p.parseBlock()
 	default:
-		panic(fmt.Errorf("unknown token: %+v", p.tok))
+		pos := p.pos
+		p.errorExpected(pos, "statement")
+		p.next() // make progress
+		p.syncStmt()
+		return &ast.BadStmt{From: pos, To: p.pos}
 	}
 }
 
@@ -635,13 +822,12 @@ func (p *parser) parseParameters() []*ast.Spec {
 		p.next()
 	}
 
-	p.expect(token.Rparen)
 	return l
 }
 
 func (p *parser) parseResults() []ast.Type {
 	if p.trace {
-		un(trace(p, "Results"))
+		defer un(trace(p, "Results"))
 	}
 
 	p.expect(token.Colon)
@@ -656,27 +842,56 @@ func (p *parser) parseResults() []ast.Type {
 	return results
 }
 
-func (p *parser) parseFuncDecl() *ast.FuncDecl {
+// parseFuncDecl parses a function declaration, which has no body in
+// interface files. If the declaration is too broken to make sense of, it
+// returns a *ast.BadDecl instead.
+func (p *parser) parseFuncDecl(interfaceFile bool) ast.Decl {
 	if p.trace {
 		defer un(trace(p, "FuncDecl"))
 	}
 
+	doc := p.lead
 	ident := p.parseIdent()
 
-	p.expect(token.Lparen)
+	if p.tok != token.Lparen {
+		p.errorExpected(p.pos, "(")
+		p.syncDecl()
+		return &ast.BadDecl{From: ident.Pos(), To: p.pos}
+	}
+
+	lparen := p.expect(token.Lparen)
 
 	args := p.parseParameters()
 
+	rparen := p.expect(token.Rparen)
+
+	if !interfaceFile && p.tok != token.Colon && p.tok != token.Lbrace {
+		p.errorExpected(p.pos, "{")
+		p.syncDecl()
+		return &ast.BadDecl{From: ident.Pos(), To: p.pos}
+	}
+
 	var results []ast.Type
 	if p.tok == token.Colon {
 		results = p.parseResults()
 	}
 
-	body := p.parseBlock()
+	var body *ast.BlockStmt
+	switch {
+	case !interfaceFile:
+		body = p.parseBlock()
+	case p.tok == token.Lbrace:
+		p.error(p.pos, "function body not permitted in interface file")
+		p.parseBlock()
+	}
+
 	decl := &ast.FuncDecl{
+		Doc:     doc,
 		Name:    ident,
-		Body:    body,
+		Lparen:  lparen,
 		Args:    args,
+		Rparen:  rparen,
+		Body:    body,
 		Results: results,
 	}
 	return decl
@@ -687,32 +902,93 @@ func (p *parser) parseUseDecl() *ast.UseDecl {
 		defer un(trace(p, "UseDecl"))
 	}
 
-	p.expect(token.Use)
+	doc := p.lead
+	pos := p.expect(token.Use)
 	return &ast.UseDecl{
+		Doc: doc,
+		Use: pos,
 		Lib: p.parseIdent(),
 	}
 }
 
-func (p *parser) parseFile() *ast.File {
+func (p *parser) parseFile() (file *ast.File) {
 	if p.trace {
 		defer un(trace(p, "File"))
 	}
 
-	var (
-		useDecls  []*ast.UseDecl
-		funcDecls []*ast.FuncDecl
-	)
+	file = &ast.File{}
+	var from token.Pos // start of the declaration being parsed
+	defer func() {
+		if bailedOut(recover()) {
+			file.BadDecls = append(file.BadDecls, &ast.BadDecl{From: from, To: p.file.Pos(p.file.Size())})
+		}
+		file.Comments = p.comments
+	}()
 
 	for p.tok == token.Use {
-		useDecls = append(useDecls, p.parseUseDecl())
+		from = p.pos
+		file.UseDecls = append(file.UseDecls, p.parseUseDecl())
 	}
 
 	for p.tok != token.Eof {
-		funcDecls = append(funcDecls, p.parseFuncDecl())
+		from = p.pos
+		switch p.tok {
+		case token.Ident:
+			switch decl := p.parseFuncDecl(false).(type) {
+			case *ast.FuncDecl:
+				file.FuncDecls = append(file.FuncDecls, decl)
+			case *ast.BadDecl:
+				file.BadDecls = append(file.BadDecls, decl)
+			}
+		case token.Use:
+			p.error(p.pos, "use declarations must precede function declarations")
+			file.UseDecls = append(file.UseDecls, p.parseUseDecl())
+		default:
+			p.errorExpected(from, "function declaration")
+			p.next() // make progress
+			p.syncDecl()
+			file.BadDecls = append(file.BadDecls, &ast.BadDecl{From: from, To: p.pos})
+		}
 	}
 
-	return &ast.File{
-		FuncDecls: funcDecls,
-		UseDecls:  useDecls,
+	return file
+}
+
+func (p *parser) parseInterface() (iface *ast.Interface) {
+	if p.trace {
+		defer un(trace(p, "Interface"))
 	}
+
+	iface = &ast.Interface{}
+	var from token.Pos // start of the declaration being parsed
+	defer func() {
+		if bailedOut(recover()) {
+			iface.BadDecls = append(iface.BadDecls, &ast.BadDecl{From: from, To: p.file.Pos(p.file.Size())})
+		}
+		iface.Comments = p.comments
+	}()
+
+	for p.tok != token.Eof {
+		from = p.pos
+		switch p.tok {
+		case token.Ident:
+			switch decl := p.parseFuncDecl(true).(type) {
+			case *ast.FuncDecl:
+				iface.FuncDecls = append(iface.FuncDecls, decl)
+			case *ast.BadDecl:
+				iface.BadDecls = append(iface.BadDecls, decl)
+			}
+		case token.Use:
+			decl := p.parseUseDecl()
+			p.error(decl.Pos(), "use declarations not permitted in interface file")
+			iface.BadDecls = append(iface.BadDecls, &ast.BadDecl{From: decl.Pos(), To: decl.End()})
+		default:
+			p.errorExpected(from, "function declaration")
+			p.next() // make progress
+			p.syncDecl()
+			iface.BadDecls = append(iface.BadDecls, &ast.BadDecl{From: from, To: p.pos})
+		}
+	}
+
+	return iface
 }
@@ -0,0 +1,332 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/scanner"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+const positionsSrc = `use io
+
+max(a: int[], n: int): int, bool {
+	m:int = a[0]
+	i:int = 1
+	while (i < n) {
+		if (a[i] > m) m = a[i]
+		i = i + 1
+	}
+	return m, length(a) > 0
+}
+`
+
+func pos(line, column int) token.Position {
+	return token.Position{Filename: "positions.xi", Line: line, Column: column}
+}
+
+func TestPositions(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := ParseFile(fset, "positions.xi", positionsSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decl := f.FuncDecls[0]
+	body := decl.Body.List
+	while := body[2].(*ast.WhileStmt)
+	if_ := while.Body.(*ast.BlockStmt).List[0].(*ast.IfStmt)
+	ret := body[3].(*ast.ReturnStmt)
+
+	for _, test := range []struct {
+		name     string
+		node     ast.Node
+		pos, end token.Position
+	}{
+		{"UseDecl", f.UseDecls[0], pos(1, 1), pos(1, 7)},
+		{"FuncDecl", decl, pos(3, 1), pos(11, 2)},
+		{"Spec", decl.Args[0], pos(3, 5), pos(3, 13)},
+		{"ArrayType", decl.Args[0].Type, pos(3, 8), pos(3, 13)},
+		{"PrimitiveType", decl.Results[1], pos(3, 29), pos(3, 33)},
+		{"SingleDeclStmt", body[0], pos(4, 2), pos(4, 14)},
+		{"SubscriptExpr", body[0].(*ast.SingleDeclStmt).Init, pos(4, 10), pos(4, 14)},
+		{"WhileStmt", while, pos(6, 2), pos(9, 3)},
+		{"BinaryExpr", while.Cond, pos(6, 9), pos(6, 14)},
+		{"IfStmt", if_, pos(7, 3), pos(7, 25)},
+		{"AssignStmt", if_.Then, pos(7, 17), pos(7, 25)},
+		{"ReturnStmt", ret, pos(10, 2), pos(10, 25)},
+		{"LengthExpr", ret.Values[1].(*ast.BinaryExpr).Lhs, pos(10, 12), pos(10, 21)},
+	} {
+		if got := fset.Position(test.node.Pos()); got.String() != test.pos.String() {
+			t.Errorf("%s.Pos(): got %s, expected %s", test.name, got, test.pos)
+		}
+		if got := fset.Position(test.node.End()); got.String() != test.end.String() {
+			t.Errorf("%s.End(): got %s, expected %s", test.name, got, test.end)
+		}
+	}
+}
+
+func TestNestedSubscriptAssign(t *testing.T) {
+	f, err := ParseFile(token.NewFileSet(), "subscript.xi", "f(a: int[][]) { a[1][2] = 3 }", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assign := f.FuncDecls[0].Body.List[0].(*ast.AssignStmt)
+	outer := assign.Lhs.(*ast.SubscriptExpr)
+	inner, ok := outer.Lhs.(*ast.SubscriptExpr)
+	if !ok {
+		t.Fatalf("expected a[1][2] to nest subscripts, got %T", outer.Lhs)
+	}
+
+	if lit := outer.Subscript.(*ast.BasicLit); lit.Value != "2" {
+		t.Errorf("outer subscript: got %s, expected 2", lit.Value)
+	}
+	if lit := inner.Subscript.(*ast.BasicLit); lit.Value != "1" {
+		t.Errorf("inner subscript: got %s, expected 1", lit.Value)
+	}
+}
+
+const typosSrc = `use io
+
+f(x: int): int {
+	y:int = x + * 2
+	z:int = )
+	w = x y
+	if (x > 0 { return 1 }
+	return x
+}
+
+g(a int) {
+	@
+	_ = f(1)
+}
+
+h((
+i() {}
+`
+
+func TestErrorRecovery(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := ParseFile(fset, "typos.xi", typosSrc, 0)
+
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		t.Fatalf("expected a scanner.ErrorList, got %T: %v", err, err)
+	}
+
+	want := []string{
+		"typos.xi:4:14: expected expression, found *",
+		"typos.xi:5:10: expected expression, found )",
+		"typos.xi:6:9: expected :, =, [ or ( after y",
+		"typos.xi:7:12: expected ), found {",
+		"typos.xi:11:5: expected :, found int",
+		"typos.xi:12:2: unexpected token: U+0040 '@'",
+		"typos.xi:16:3: expected IDENT, found (",
+	}
+
+	for i := 0; i < len(list) || i < len(want); i++ {
+		switch {
+		case i >= len(list):
+			t.Errorf("missing error %q", want[i])
+		case i >= len(want):
+			t.Errorf("unexpected error %q", list[i])
+		case list[i].Error() != want[i]:
+			t.Errorf("error %d: got %q, expected %q", i, list[i], want[i])
+		}
+	}
+
+	// The well-formed parts of the file survive.
+	var names []string
+	for _, decl := range f.FuncDecls {
+		names = append(names, decl.Name.Name)
+	}
+	if got := strings.Join(names, " "); got != "f g i" {
+		t.Errorf("got functions %q, expected %q", got, "f g i")
+	}
+	if len(f.BadDecls) != 1 {
+		t.Errorf("got %d bad declarations, expected 1", len(f.BadDecls))
+	}
+	if len(f.UseDecls) != 1 {
+		t.Errorf("got %d use declarations, expected 1", len(f.UseDecls))
+	}
+
+	body := f.FuncDecls[0].Body.List
+	if len(body) != 7 {
+		t.Fatalf("got %d statements in f, expected 7", len(body))
+	}
+	for i, want := range []string{
+		"*ast.SingleDeclStmt",
+		"*ast.SingleDeclStmt",
+		"*ast.BadStmt",
+		"*ast.AssignStmt",
+		"*ast.BadStmt",
+		"*ast.IfStmt",
+		"*ast.ReturnStmt",
+	} {
+		if got := fmt.Sprintf("%T", body[i]); got != want {
+			t.Errorf("statement %d: got %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestErrorLimit(t *testing.T) {
+	src := strings.Repeat("f() { x = }\n", 20)
+
+	fset := token.NewFileSet()
+	f, err := ParseFile(fset, "limit.xi", src, 0)
+	if n := len(err.(scanner.ErrorList)); n != 11 {
+		t.Errorf("got %d errors, expected parsing to stop after 11", n)
+	}
+
+	// The declarations parsed before stopping survive, and the rest of the
+	// file becomes a bad declaration.
+	if len(f.FuncDecls) != 11 {
+		t.Errorf("got %d functions after stopping, expected 11", len(f.FuncDecls))
+	}
+	if len(f.BadDecls) != 1 {
+		t.Fatalf("got %d bad declarations after stopping, expected 1", len(f.BadDecls))
+	}
+	if from, to := fset.Position(f.BadDecls[0].From), fset.Position(f.BadDecls[0].To); from.Line != 12 || to.Offset != len(src) {
+		t.Errorf("bad declaration spans %s to %s, expected line 12 to the end of the file", from, to)
+	}
+
+	_, err = ParseFile(token.NewFileSet(), "limit.xi", src, AllErrors)
+	if n := len(err.(scanner.ErrorList)); n != 20 {
+		t.Errorf("got %d errors with AllErrors, expected 20", n)
+	}
+}
+
+func TestParseInterface(t *testing.T) {
+	src := `print(str: int[])
+parseInt(str: int[]): int, bool
+eof(): bool
+unparseInt(n: int): int[]
+`
+	fset := token.NewFileSet()
+	iface, err := ParseInterface(fset, "io.ixi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, decl := range iface.FuncDecls {
+		if decl.Body != nil {
+			t.Errorf("%s has a body", decl.Name.Name)
+		}
+		got = append(got, fmt.Sprintf("%s/%d/%d@%s", decl.Name.Name, len(decl.Args), len(decl.Results), fset.Position(decl.End())))
+	}
+
+	want := []string{
+		"print/1/0@io.ixi:1:18",
+		"parseInt/1/2@io.ixi:2:32",
+		"eof/0/1@io.ixi:3:12",
+		"unparseInt/1/1@io.ixi:4:26",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
+
+func TestParseInterfaceErrors(t *testing.T) {
+	src := `use io
+f(x: int): int { return x }
+g: int
+h(): int
+`
+	iface, err := ParseInterface(token.NewFileSet(), "bad.ixi", src, AllErrors)
+
+	var msgs []string
+	for _, e := range err.(scanner.ErrorList) {
+		msgs = append(msgs, fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Msg))
+	}
+	want := []string{
+		"1:1: use declarations not permitted in interface file",
+		"2:16: function body not permitted in interface file",
+		"3:2: expected (, found :",
+	}
+	if fmt.Sprint(msgs) != fmt.Sprint(want) {
+		t.Errorf("got errors\n%s\nexpected\n%s", strings.Join(msgs, "\n"), strings.Join(want, "\n"))
+	}
+
+	var names []string
+	for _, decl := range iface.FuncDecls {
+		names = append(names, decl.Name.Name)
+	}
+	if got := strings.Join(names, " "); got != "f h" {
+		t.Errorf("got declarations %q, expected %q", got, "f h")
+	}
+}
+
+const commentsSrc = `// Package comment, not a doc comment.
+
+// io is for printing.
+use io // trailing
+use conv
+
+// max returns the larger
+// of a and b.
+max(a: int, b: int): int {
+	// inside
+	if (a > b) return a
+	return b // b
+}
+// min is undocumented: a blank line follows.
+
+min(a: int, b: int): int { return a }
+`
+
+func TestComments(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := ParseFile(fset, "comments.xi", commentsSrc, ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []string
+	for _, g := range f.Comments {
+		var list []string
+		for _, c := range g.List {
+			list = append(list, c.Text)
+		}
+		groups = append(groups, strings.Join(list, "|"))
+	}
+	want := []string{
+		"// Package comment, not a doc comment.",
+		"// io is for printing.",
+		"// trailing",
+		"// max returns the larger|// of a and b.",
+		"// inside",
+		"// b",
+		"// min is undocumented: a blank line follows.",
+	}
+	if strings.Join(groups, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got comment groups\n\t%s\nexpected\n\t%s", strings.Join(groups, "\n\t"), strings.Join(want, "\n\t"))
+	}
+
+	for _, test := range []struct {
+		name string
+		doc  *ast.CommentGroup
+		want string
+	}{
+		{"use io", f.UseDecls[0].Doc, "io is for printing.\n"},
+		{"use conv", f.UseDecls[1].Doc, ""},
+		{"max", f.FuncDecls[0].Doc, "max returns the larger\nof a and b.\n"},
+		{"min", f.FuncDecls[1].Doc, ""},
+	} {
+		if got := test.doc.Text(); got != test.want {
+			t.Errorf("%s: got doc %q, expected %q", test.name, got, test.want)
+		}
+	}
+
+	// Without ParseComments, there are none.
+	f, err = ParseFile(token.NewFileSet(), "comments.xi", commentsSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Comments != nil || f.FuncDecls[0].Doc != nil {
+		t.Errorf("got comments %v and doc %v without ParseComments", f.Comments, f.FuncDecls[0].Doc)
+	}
+}
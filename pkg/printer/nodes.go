@@ -0,0 +1,443 @@
+package printer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// line returns the source line of pos.
+func (p *printer) line(pos token.Pos) int { return p.fset.Position(pos).Line }
+
+// write appends s to the output, indenting it if it starts a line. Lines
+// that continue a statement or declaration broken by a comment are indented
+// one level deeper.
+func (p *printer) write(s string) {
+	if p.newlines > 0 {
+		n := p.indent
+		if p.cont {
+			n++
+		}
+		for i := 0; i < n; i++ {
+			p.out.WriteByte('\t')
+		}
+	}
+	p.out.WriteString(s)
+	p.newlines = 0
+}
+
+// linebreak ends the current line, and adds blank lines until n line
+// breaks end the output, unless the output is empty.
+func (p *printer) linebreak(n int) {
+	if p.out.Len() == 0 {
+		return
+	}
+	p.trimSpace()
+	for p.newlines < n {
+		p.out.WriteByte('\n')
+		p.newlines++
+	}
+}
+
+// trimSpace removes the spaces at the end of the output.
+func (p *printer) trimSpace() {
+	b := p.out.Bytes()
+	n := len(b)
+	for n > 0 && (b[n-1] == ' ' || b[n-1] == '\t') {
+		n--
+	}
+	p.out.Truncate(n)
+}
+
+// token prints s, a token at pos in the source, after the comments that
+// precede it. pos may be token.NoPos for tokens the syntax tree does not
+// record.
+func (p *printer) token(pos token.Pos, s string) {
+	if pos.IsValid() {
+		if p.newlines == 0 && p.hasComments(pos) {
+			p.cont = true
+		}
+		p.flush(pos)
+	}
+	p.write(s)
+	if pos.IsValid() {
+		p.lastLine = p.line(pos)
+	}
+}
+
+// flush prints the comments before pos. A comment on the line of the last
+// token stays at the end of that line; the others get lines of their own.
+func (p *printer) flush(pos token.Pos) {
+	for len(p.comments) > 0 && p.comments[0].Slash < pos {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		line := p.line(c.Slash)
+		text := strings.TrimRight(c.Text, " \t\r")
+		if line == p.lastLine && p.newlines == 0 && p.out.Len() > 0 {
+			p.trimSpace()
+			p.out.WriteString(" " + text)
+		} else {
+			p.startLine(line)
+			p.write(text)
+		}
+		p.newlines = 0
+		p.linebreak(1)
+		p.lastLine = line
+	}
+}
+
+// flushTrailing prints the comments on the line of the last token.
+func (p *printer) flushTrailing() {
+	for len(p.comments) > 0 && p.line(p.comments[0].Slash) == p.lastLine {
+		p.flush(p.comments[0].Slash + 1)
+	}
+}
+
+// startLine starts a line for something at the given source line, keeping
+// one blank line if the source has any before it.
+func (p *printer) startLine(line int) {
+	p.linebreak(1)
+	if p.lastLine > 0 && line > p.lastLine+1 {
+		p.linebreak(2)
+	}
+}
+
+// hasComments reports whether there are comments before pos.
+func (p *printer) hasComments(pos token.Pos) bool {
+	return len(p.comments) > 0 && p.comments[0].Slash < pos
+}
+
+func (p *printer) bad(node ast.Node) {
+	if p.err == nil {
+		p.err = fmt.Errorf("printer: cannot print syntax errors at %s", p.fset.Position(node.Pos()))
+	}
+}
+
+func (p *printer) file(f *ast.File) {
+	for _, d := range f.BadDecls {
+		p.bad(d)
+	}
+	for _, d := range f.UseDecls {
+		p.decl(d.Pos(), 1)
+		p.token(d.Use, "use ")
+		p.token(d.Lib.Pos(), d.Lib.Name)
+	}
+	for _, d := range f.FuncDecls {
+		p.decl(d.Pos(), 2)
+		p.funcDecl(d)
+	}
+	p.eof()
+}
+
+func (p *printer) iface(i *ast.Interface) {
+	for _, d := range i.BadDecls {
+		p.bad(d)
+	}
+	for _, d := range i.FuncDecls {
+		p.decl(d.Pos(), 1)
+		p.funcDecl(d)
+	}
+	p.eof()
+}
+
+// decl starts a declaration at pos, with at least n line breaks before it.
+func (p *printer) decl(pos token.Pos, n int) {
+	p.flushTrailing()
+	p.cont = false
+	p.linebreak(n)
+	p.flush(pos)
+	p.startLine(p.line(pos))
+}
+
+// eof prints the remaining comments and ends the last line.
+func (p *printer) eof() {
+	p.flushTrailing()
+	p.cont = false
+	if len(p.comments) > 0 {
+		p.flush(p.comments[len(p.comments)-1].Slash + 1)
+	}
+	p.linebreak(1)
+}
+
+func (p *printer) funcDecl(d *ast.FuncDecl) {
+	p.token(d.Name.Pos(), d.Name.Name)
+	p.token(d.Lparen, "(")
+	for i, arg := range d.Args {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.spec(arg, true)
+	}
+	p.token(d.Rparen, ")")
+	for i, typ := range d.Results {
+		if i == 0 {
+			p.write(": ")
+		} else {
+			p.write(", ")
+		}
+		p.typ(typ)
+	}
+	if d.Body != nil {
+		p.write(" ")
+		p.block(d.Body)
+	}
+}
+
+// spec prints a parameter or declared variable. Parameters have a space
+// after the colon, as in f(a: int), but variables do not, as in x:int = 0.
+func (p *printer) spec(s *ast.Spec, param bool) {
+	p.token(s.Name.Pos(), s.Name.Name)
+	if param {
+		p.write(": ")
+	} else {
+		p.write(":")
+	}
+	p.typ(s.Type)
+}
+
+func (p *printer) typ(t ast.Type) {
+	switch t := t.(type) {
+	case *ast.PrimitiveType:
+		p.token(t.KindPos, t.Kind.String())
+	case *ast.ArrayType:
+		p.typ(t.Elt)
+		p.token(t.Lbrack, "[")
+		if t.Size != nil {
+			p.expr(t.Size)
+		}
+		p.token(t.Rbrack, "]")
+	default:
+		p.bad(t)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStmt) {
+	p.token(b.Lbrace, "{")
+	if len(b.List) == 0 && !p.hasComments(b.Rbrace) {
+		p.token(b.Rbrace, "}")
+		return
+	}
+	p.indent++
+	for _, s := range b.List {
+		p.cont = false
+		p.flush(s.Pos())
+		p.startLine(p.line(s.Pos()))
+		p.stmt(s)
+	}
+	p.cont = false
+	p.flush(b.Rbrace)
+	p.indent--
+	p.linebreak(1)
+	p.token(b.Rbrace, "}")
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.SingleDeclStmt:
+		p.spec(s.Spec, false)
+		if s.Init != nil {
+			p.write(" = ")
+			p.expr(s.Init)
+		}
+
+	case *ast.MultiDeclStmt:
+		for i, a := range s.Assignables {
+			if i > 0 {
+				p.write(", ")
+			}
+			switch a := a.(type) {
+			case *ast.Spec:
+				p.spec(a, false)
+			case *ast.Discard:
+				p.token(a.Underscore, "_")
+			default:
+				p.bad(a)
+			}
+		}
+		p.write(" ")
+		p.token(s.Assign, "= ")
+		p.expr(s.Init)
+
+	case *ast.AssignStmt:
+		p.expr(s.Lhs.(ast.Expr))
+		p.write(" ")
+		p.token(s.Assign, "= ")
+		p.expr(s.Rhs)
+
+	case *ast.CallExpr:
+		p.expr(s)
+
+	case *ast.IfStmt:
+		p.token(s.If, "if (")
+		p.expr(s.Cond)
+		p.write(")")
+		broken := p.branch(s.Then)
+		if s.Else != nil {
+			// Comments before the else stay with the then branch, and
+			// an else that no longer follows it on one line starts a
+			// line of its own.
+			p.flush(s.ElsePos)
+			if broken || p.newlines > 0 {
+				p.linebreak(1)
+				p.cont = false
+			} else {
+				p.write(" ")
+			}
+			p.token(s.ElsePos, "else")
+			p.branch(s.Else)
+		}
+
+	case *ast.WhileStmt:
+		p.token(s.While, "while (")
+		p.expr(s.Cond)
+		p.write(")")
+		p.branch(s.Body)
+
+	case *ast.ReturnStmt:
+		p.token(s.Return, "return")
+		for i, v := range s.Values {
+			if i == 0 {
+				p.write(" ")
+			} else {
+				p.write(", ")
+			}
+			p.expr(v)
+		}
+
+	case *ast.BlockStmt:
+		p.block(s)
+
+	default:
+		p.bad(s)
+	}
+}
+
+// branch prints s, the branch of an if, else or while. If comments end the
+// line before s, a block starts the next line and any other statement goes
+// on a line of its own, one level deeper. branch reports whether s was
+// moved to a line of its own.
+func (p *printer) branch(s ast.Stmt) bool {
+	if !p.hasComments(s.Pos()) {
+		p.write(" ")
+		p.stmt(s)
+		return false
+	}
+
+	p.flush(s.Pos())
+	p.cont = false
+	if _, ok := s.(*ast.BlockStmt); ok {
+		p.stmt(s)
+		return false
+	}
+	p.indent++
+	p.startLine(p.line(s.Pos()))
+	p.stmt(s)
+	p.indent--
+	p.cont = false
+	return true
+}
+
+// Precedences of operators; operands bind tighter than any operator.
+const (
+	lowestPrec  = 0
+	unaryPrec   = 7
+	operandPrec = 8
+)
+
+func binaryPrec(op token.TokenType) int {
+	switch op {
+	case token.Or:
+		return 1
+	case token.And:
+		return 2
+	case token.Eq, token.Neq:
+		return 3
+	case token.Lt, token.Le, token.Gt, token.Ge:
+		return 4
+	case token.Add, token.Sub:
+		return 5
+	case token.Mul, token.HMul, token.Div, token.Rem:
+		return 6
+	}
+	return lowestPrec
+}
+
+// prec returns the precedence of the operator at the root of x.
+func prec(x ast.Expr) int {
+	switch x := x.(type) {
+	case *ast.BinaryExpr:
+		return binaryPrec(x.Op)
+	case *ast.UnaryExpr:
+		return unaryPrec
+	case *ast.LengthExpr:
+		// length(a)[i] does not parse as a subscript of length(a).
+		return unaryPrec
+	}
+	return operandPrec
+}
+
+// operand prints x, parenthesized if its operator binds less tightly than
+// min.
+func (p *printer) operand(x ast.Expr, min int) {
+	if prec(x) < min {
+		p.write("(")
+		p.expr(x)
+		p.write(")")
+		return
+	}
+	p.expr(x)
+}
+
+func (p *printer) exprList(list []ast.Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		p.token(x.NamePos, x.Name)
+	case *ast.BasicLit:
+		p.token(x.ValuePos, x.Value)
+	case *ast.ArrayLit:
+		p.token(x.Lbrace, "{")
+		p.exprList(x.Elts)
+		p.token(x.Rbrace, "}")
+	case *ast.CallExpr:
+		p.token(x.Func.Pos(), x.Func.Name)
+		p.token(x.Lparen, "(")
+		p.exprList(x.Args)
+		p.token(x.Rparen, ")")
+	case *ast.LengthExpr:
+		p.token(x.TokPos, x.Tok.String())
+		p.token(x.Lparen, "(")
+		p.expr(x.Arg)
+		p.token(x.Rparen, ")")
+	case *ast.SubscriptExpr:
+		p.operand(x.Lhs, operandPrec)
+		p.token(x.Lbrack, "[")
+		p.expr(x.Subscript)
+		p.token(x.Rbrack, "]")
+	case *ast.UnaryExpr:
+		p.token(x.OpPos, x.Op.String())
+		if y, ok := x.Rhs.(*ast.UnaryExpr); ok && x.Op == token.Sub && y.Op == token.Sub {
+			p.write(" ") // - -x rather than --x
+		}
+		p.operand(x.Rhs, unaryPrec)
+	case *ast.BinaryExpr:
+		// Binary operators associate to the left.
+		prec := binaryPrec(x.Op)
+		p.operand(x.Lhs, prec)
+		p.write(" ")
+		p.token(x.OpPos, x.Op.String()+" ")
+		p.operand(x.Rhs, prec+1)
+	default:
+		p.bad(x)
+	}
+}
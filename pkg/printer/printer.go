@@ -0,0 +1,87 @@
+// Package printer formats Xi syntax trees as source code.
+//
+// The layout is canonical: declarations and statements go on lines of
+// their own, indented by tabs. Binary operators have a space on each side,
+// and so do the colons of parameters but not those of local variables, as
+// in f(a: int) { x:int = a }. If and while conditions are parenthesized,
+// parentheses appear in expressions only where precedence requires them,
+// and semicolons are dropped.
+//
+// Comments are placed where they were relative to the tokens around them,
+// and a blank line between two statements or declarations is kept.
+// Formatting formatted source leaves it unchanged, and parsing it yields
+// the same syntax tree but for positions.
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+type printer struct {
+	fset     *token.FileSet
+	out      bytes.Buffer
+	indent   int
+	newlines int            // line breaks at the end of out
+	cont     bool           // a comment broke the current statement or declaration
+	comments []*ast.Comment // not yet printed
+	lastLine int            // source line of the last token or comment printed
+	err      error
+}
+
+// Fprint writes node, an *ast.File or an *ast.Interface, to w in the
+// canonical layout, with the comments in its Comments. Positions in node
+// are resolved in fset.
+func Fprint(w io.Writer, fset *token.FileSet, node ast.Node) error {
+	p := &printer{fset: fset}
+	switch n := node.(type) {
+	case *ast.File:
+		p.addComments(n.Comments)
+		p.file(n)
+	case *ast.Interface:
+		p.addComments(n.Comments)
+		p.iface(n)
+	default:
+		return fmt.Errorf("printer: cannot print %T", node)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+func (p *printer) addComments(groups []*ast.CommentGroup) {
+	for _, g := range groups {
+		p.comments = append(p.comments, g.List...)
+	}
+}
+
+// Source formats src, the content of the Xi source or interface file
+// filename, keeping its comments. It fails if src has syntax errors.
+func Source(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	var node ast.Node
+	var err error
+	if filepath.Ext(filename) == importer.Ext {
+		node, err = parser.ParseInterface(fset, filename, src, parser.ParseComments)
+	} else {
+		node, err = parser.ParseFile(fset, filename, src, parser.ParseComments)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := Fprint(&b, fset, node); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
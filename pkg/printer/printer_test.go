@@ -0,0 +1,176 @@
+package printer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/ast/sexp"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+func TestSource(t *testing.T) {
+	for _, test := range []struct {
+		src, want string
+	}{
+		{"use io use conv", "use io\nuse conv\n"},
+		{"f(a:int,b:int[][]):int,bool{return a,true}", "f(a: int, b: int[][]): int, bool {\n\treturn a, true\n}\n"},
+		{"f(){x:int=1;y:int[2][]}", "f() {\n\tx:int = 1\n\ty:int[2][]\n}\n"},
+		{"f(){a:int,_,b:bool=g()}", "f() {\n\ta:int, _, b:bool = g()\n}\n"},
+		{"f(){if x<1 return else{x=2}}", "f() {\n\tif (x < 1) return else {\n\t\tx = 2\n\t}\n}\n"},
+		{"f(){while(x){}}", "f() {\n\twhile (x) {}\n}\n"},
+
+		// Parentheses are only kept where they are needed.
+		{"f(){x=((a+b))*(c-d)}", "f() {\n\tx = (a + b) * (c - d)\n}\n"},
+		{"f(){x=a-(b-c)-d}", "f() {\n\tx = a - (b - c) - d\n}\n"},
+		{"f(){x=(a*b)+c%d}", "f() {\n\tx = a * b + c % d\n}\n"},
+		{"f(){x=(a*>>b)*c*>>(d*e)+f}", "f() {\n\tx = a *>> b * c *>> (d * e) + f\n}\n"},
+		{"f(){x=!(a&b)|-(-c)<d}", "f() {\n\tx = !(a & b) | - -c < d\n}\n"},
+		{"f(){x=(length(a))[0]+(-b)[1]+{1,2}[0]}", "f() {\n\tx = (length(a))[0] + (-b)[1] + {1, 2}[0]\n}\n"},
+		{`f(){x="a//b"+'/'}`, "f() {\n\tx = \"a//b\" + '/'\n}\n"},
+
+		// Comments.
+		{"// c\nuse io // d\n\n\n// e\nf() { // g\n\tx = 1 // h\n\t// i\n\n\n\ty = 2 // j\n\t// k\n} // l\n// m",
+			"// c\nuse io // d\n\n// e\nf() { // g\n\tx = 1 // h\n\t// i\n\n\ty = 2 // j\n\t// k\n} // l\n// m\n"},
+		{"f() {\n\tx = a + // a\n\tb\n}\n", "f() {\n\tx = a + // a\n\t\tb\n}\n"},
+		{"f() {\n\tx:int[] = {1, // one\n2}\n}\n", "f() {\n\tx:int[] = {1, // one\n\t\t2}\n}\n"},
+		{"f() {\n\tg(1, // one\n// two\n2)\n}\n", "f() {\n\tg(1, // one\n\t\t// two\n\t\t2)\n}\n"},
+		{"f(a: int, // a\nb: int) {\nx = 1\n}\n", "f(a: int, // a\n\tb: int) {\n\tx = 1\n}\n"},
+		{"f() {\n\tif (a < 2) { return a } // trailing\n\tx = 1\n}\n", "f() {\n\tif (a < 2) {\n\t\treturn a\n\t} // trailing\n\tx = 1\n}\n"},
+
+		// Unbraced branches after comments get lines of their own.
+		{"f() {\nif (a > b) // cond\nreturn a // ra\nelse // el\nreturn b\n}\n",
+			"f() {\n\tif (a > b) // cond\n\t\treturn a // ra\n\telse // el\n\t\treturn b\n}\n"},
+		{"f() {\nwhile (x > 0) // loop\nx = x - 1 // dec\ny = 1\n}\n",
+			"f() {\n\twhile (x > 0) // loop\n\t\tx = x - 1 // dec\n\ty = 1\n}\n"},
+		{"f() {\nif (c) return a // ra\n// about else\nelse return b\n}\n",
+			"f() {\n\tif (c) return a // ra\n\t// about else\n\telse return b\n}\n"},
+		{"f() {\nif (a) // a\nif (b) return // b\nelse return // c\nelse return // d\n}\n",
+			"f() {\n\tif (a) // a\n\t\tif (b) return // b\n\t\telse return // c\n\telse return // d\n}\n"},
+		{"f() {\nif (x) // c\n{ y = 1 } else { y = 2 }\n}\n",
+			"f() {\n\tif (x) // c\n\t{\n\t\ty = 1\n\t} else {\n\t\ty = 2\n\t}\n}\n"},
+		{"f() {\n\t// only\n}\n", "f() {\n\t// only\n}\n"},
+		{"use io\nf() {}\n// g\ng() {}", "use io\n\nf() {}\n\n// g\ng() {}\n"},
+	} {
+		got, err := Source("test.xi", []byte(test.src))
+		if err != nil {
+			t.Errorf("Source(%q): %v", test.src, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("Source(%q) =\n%s\nexpected\n%s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestSourceInterface(t *testing.T) {
+	src := "// Numbers.\nparseInt(s:int[]):int,bool\n\nunparseInt(n:int):int[]"
+	want := "// Numbers.\nparseInt(s: int[]): int, bool\n\nunparseInt(n: int): int[]\n"
+	got, err := Source("conv.ixi", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	if _, err := Source("test.xi", []byte("f() { x = }")); err == nil {
+		t.Error("Source accepted a syntax error")
+	}
+}
+
+var corpus = []string{
+	`use io
+use conv
+
+// gcd returns the greatest common divisor of a and b.
+gcd(a: int, b: int): int {
+	while(b!=0){ t:int=b; b=a%b
+	a=t // swap
+	}
+
+
+	// done
+	return a
+}
+
+main(args:int[][]){
+	x:int,_=f((1+2)*3, -(-4), !(true&false)|true)
+	if (x<2) println("small")   else if (x < 10) { println("medium") } else println("big")
+	a:int[3][] = {{1},{2},{3},}; a[0][0] = length(a) - 1 - (2 - 3) * -a[1][0]
+	if x == 1 { }
+	while (x > 0) x = x - 1
+	c:int = '\n' // char
+	s:int[] = "\x{41}" + {c}
+	// trailing block comment
+}
+f(a:int,b:int,c:bool):int,bool{return a+b,c|a<=b&b>=a|a!=b==c}
+// the end`,
+	"f() { x = 1 // one\n\n\n}\n",
+	"f(a: int, // a\nb: int): int {\nif (a > b) // cond\nreturn a // ra\nelse // el\nreturn {a, // x\nb}[0] // rb\n}\n",
+	"// only a comment",
+	"",
+}
+
+func TestIdempotent(t *testing.T) {
+	for _, src := range corpus {
+		once, err := Source("test.xi", []byte(src))
+		if err != nil {
+			t.Errorf("Source(%q): %v", src, err)
+			continue
+		}
+		twice, err := Source("test.xi", once)
+		if err != nil {
+			t.Errorf("Source(%q): %v", once, err)
+			continue
+		}
+		if !bytes.Equal(once, twice) {
+			t.Errorf("formatting is not idempotent:\n%s\nbecomes\n%s", once, twice)
+		}
+
+		if want, got := tree(t, src), tree(t, string(once)); got != want {
+			t.Errorf("formatting changed the syntax tree of\n%s\nfrom %s\nto   %s", src, want, got)
+		}
+		if strings.Count(src, "//")-strings.Count(src, `"a//b"`) != strings.Count(string(once), "//") {
+			t.Errorf("formatting lost comments of\n%s\ngot\n%s", src, once)
+		}
+	}
+}
+
+func tree(t *testing.T, src string) string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sexp.Sprint(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFprint(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", "use io // gone\nmain(args:int[][]){println(\"hi\")}", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := Fprint(&b, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	want := "use io\n\nmain(args: int[][]) {\n\tprintln(\"hi\")\n}\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nexpected\n%s", b.String(), want)
+	}
+
+	f.FuncDecls[0].Body.List = append(f.FuncDecls[0].Body.List, &ast.BadStmt{})
+	if err := Fprint(&b, fset, f); err == nil {
+		t.Error("Fprint printed a bad statement")
+	}
+}
@@ -4,25 +4,34 @@ import (
 	"fmt"
 	"unicode/utf8"
 
+	"github.com/manapointer/xi/pkg/lit"
 	"github.com/manapointer/xi/pkg/token"
 )
 
+// A Mode controls what the scanner returns.
+type Mode uint
+
+const (
+	ScanComments Mode = 1 << iota // return comments as token.Comment tokens
+)
+
 type (
 	state func(*Scanner) state
 
 	ErrorHandler func(pos token.Position, message string)
 
 	Scanner struct {
-		src    []byte
-		tokens chan token.Token
-		err    ErrorHandler
-
-		ch      rune // current character
-		pos     int  // character position
-		rpos    int  // next read position
-		start   int  // start of next token
-		line    int  // scanner's line number
-		linepos int  // start of the current line; used to calculate pos column
+		file    *token.File // source file handle
+		src     []byte
+		state   state         // next state function to run; nil once Eof was emitted
+		pending []token.Token // tokens emitted by the state machine but not yet returned
+		err     ErrorHandler
+		mode    Mode
+
+		ch    rune // current character
+		pos   int  // character position
+		rpos  int  // next read position
+		start int  // start of next token
 
 		ErrorCount int
 	}
@@ -63,10 +72,6 @@ func isAlpha(r rune) bool {
 	return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')
 }
 
-func isHexDigit(r rune) bool {
-	return ('A' <= r && r <= 'F') || ('a' <= r && r < 'f') || isDigit(r)
-}
-
 func (s *Scanner) bump() {
 	s.start++
 }
@@ -80,87 +85,63 @@ func (s *Scanner) switch2(tok0, tok1 token.TokenType) token.TokenType {
 	return tok0
 }
 
-func (s *Scanner) scanEscape(quote rune) bool {
+// scanQuoted advances past a string or character literal, whose escape
+// sequences are only skipped here. It reports whether the closing quote was
+// found on the same line.
+func (s *Scanner) scanQuoted(quote rune) bool {
 	s.next()
-	switch s.ch {
-	case '\\', quote, 'n', 't':
-		s.next()
-	case 'x':
-		s.next()
-		if s.ch != '{' {
-			s.errorf("expected { in escape sequence")
-			return false
-		}
-
-		s.next()
-		if !isHexDigit(s.ch) {
-			s.errorf("illegal character %#U in escape sequence", s.ch)
+	for s.ch != quote {
+		switch s.ch {
+		case '\n', eof:
 			return false
-		}
-
-		s.next()
-		for i := 0; i < 3; i++ {
-			if !isHexDigit(s.ch) {
+		case '\\':
+			s.next()
+			if s.ch == '\n' || s.ch == eof {
 				return false
 			}
-			s.next()
 		}
-
-		if s.ch != '}' {
-			s.errorf("expected } in escape sequence")
-			return false
-		}
-	default:
-		s.errorf("unknown escape sequence")
-		return false
+		s.next()
 	}
-
+	s.next()
 	return true
 }
 
-func scanCharacter(s *Scanner) state {
-	s.next()
-	switch s.ch {
-	case '\\':
-		s.scanEscape('\'')
-	case '\'':
-		s.errorf("illegal character literal")
+// literalError reports err, an error decoding the current literal, at the
+// offending character.
+func (s *Scanner) literalError(err error) {
+	if e, ok := err.(*lit.Error); ok {
+		s.errorAt(s.start+e.Offset, "%s", e.Msg)
+		return
 	}
+	s.errorf("%v", err)
+}
 
-	s.next()
-	if s.ch == '\'' {
-		s.next()
+func scanCharacter(s *Scanner) state {
+	if !s.scanQuoted('\'') {
+		s.errorf("character literal not terminated")
 		s.emit(token.Char)
 		return scanDefault
 	}
 
-	s.errorf("illegal character literal")
-	for s.ch != '\'' && s.ch != eof {
-		s.next()
+	if _, err := lit.UnquoteChar(s.lexeme()); err != nil {
+		s.literalError(err)
 	}
-
 	s.emit(token.Char)
 	return scanDefault
 }
 
 func scanString(s *Scanner) state {
-	s.next()
+	if !s.scanQuoted('"') {
+		s.errorf("string literal not terminated")
+		s.emit(token.String)
+		return scanDefault
+	}
 
-	for {
-		switch s.ch {
-		case '\n', eof:
-			s.errorf("string literal not terminated")
-			return scanDefault
-		case '\\':
-			s.scanEscape('"')
-		case '"':
-			s.next()
-			s.emit(token.String)
-			return scanDefault
-		default:
-			s.next()
-		}
+	if _, err := lit.Unquote(s.lexeme()); err != nil {
+		s.literalError(err)
 	}
+	s.emit(token.String)
+	return scanDefault
 }
 
 func scanIdent(s *Scanner) state {
@@ -208,7 +189,7 @@ func scanInt(s *Scanner) state {
 		s.next()
 
 		if isAlpha(s.ch) {
-			s.errorf("unexpected token: %#U", s.ch)
+			s.errorAt(s.pos, "unexpected token: %#U", s.ch)
 		}
 
 		if !isDigit(s.ch) {
@@ -216,12 +197,21 @@ func scanInt(s *Scanner) state {
 		}
 	}
 
+	if _, err := lit.ParseInt(s.lexeme()); err != nil {
+		s.literalError(err)
+	}
 	s.emit(token.Integer)
 	return scanDefault
 }
 
+// errorf reports an error at the start of the current token.
 func (s *Scanner) errorf(format string, args ...interface{}) {
-	s.tokens <- token.Token{Typ: token.Error, Lit: fmt.Sprintf(format, args...)}
+	s.errorAt(s.start, format, args...)
+}
+
+// errorAt reports an error at offs, an offset in the source.
+func (s *Scanner) errorAt(offs int, format string, args ...interface{}) {
+	s.pending = append(s.pending, token.Token{Typ: token.Error, Pos: s.file.Pos(offs), Lit: fmt.Sprintf(format, args...)})
 }
 
 func scanDefault(s *Scanner) state {
@@ -242,7 +232,7 @@ func scanDefault(s *Scanner) state {
 		s.next()
 		switch ch {
 		case eof:
-			s.tokens <- token.Token{Typ: token.Eof, Lit: ""}
+			s.pending = append(s.pending, token.Token{Typ: token.Eof, Pos: s.position(), Lit: ""})
 			return nil
 		case '+':
 			typ = token.Add
@@ -250,18 +240,28 @@ func scanDefault(s *Scanner) state {
 			typ = token.Sub
 		case '*':
 			typ = token.Mul
+			if s.ch == '>' && s.rpos < len(s.src) && s.src[s.rpos] == '>' {
+				s.next()
+				s.next()
+				typ = token.HMul
+			}
 		case '/':
 			if s.ch == '/' {
-				s.bump() // bump one to ignore 'ch'
 				for s.ch != '\n' && s.ch != eof {
-					s.bump() // bump
 					s.next()
 				}
-				return scanDefault
+				if s.mode&ScanComments == 0 {
+					s.start = s.pos
+					return scanDefault
+				}
+				typ = token.Comment
+				break
 			}
 			typ = token.Div
 		case '%':
 			typ = token.Rem
+		case '_':
+			typ = token.Underscore
 		case '&':
 			typ = token.And
 		case '|':
@@ -293,14 +293,14 @@ func scanDefault(s *Scanner) state {
 		case '>':
 			typ = s.switch2(token.Gt, token.Ge)
 		case '\n':
-			s.line += 1
-			s.linepos = s.pos
+			s.file.AddLine(s.pos)
 			fallthrough
 		case ' ', '\t':
 			s.bump()
 			return scanDefault
 		default:
 			s.errorf("unexpected token: %#U", ch)
+			typ = token.Illegal
 		}
 	}
 
@@ -308,45 +308,52 @@ func scanDefault(s *Scanner) state {
 	return scanDefault
 }
 
-func (s *Scanner) run() {
-	for state := scanDefault; state != nil; {
-		state = state(s)
+// NewScanner returns a scanner for src, the content of file. The scanner
+// records line information in file as it goes. It panics if the file size
+// does not match len(src). Comments are skipped unless mode has
+// ScanComments; the literal of a comment token runs from the // to the end
+// of the line, excluding the line break.
+func NewScanner(file *token.File, src []byte, err ErrorHandler, mode Mode) *Scanner {
+	if file.Size() != len(src) {
+		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
 	}
 
-	close(s.tokens)
-}
-
-func NewScanner(src []byte, err ErrorHandler) *Scanner {
 	s := &Scanner{
-		src:    src,
-		err:    err,
-		tokens: make(chan token.Token),
-		ch:     ' ',
-		line:   1,
+		file:  file,
+		src:   src,
+		err:   err,
+		mode:  mode,
+		state: scanDefault,
+		ch:    ' ',
 	}
 
 	s.next()
 
-	go s.run()
 	return s
 }
 
+// Scan returns the next token. The state machine is run inline until it
+// emits at least one token; once Eof has been returned, every further call
+// returns Eof again.
 func (s *Scanner) Scan() token.Token {
-	var tok token.Token
-
-	for {
-		tok = <-s.tokens
+	for len(s.pending) == 0 {
+		if s.state == nil {
+			return token.Token{Typ: token.Eof, Pos: s.position()}
+		}
+		s.state = s.state(s)
+	}
 
-		if tok.Typ == token.Error {
-			if s.err != nil {
-				s.err(tok.Pos, tok.Lit)
-			}
-			s.ErrorCount += 1
+	// The queue never holds more than a couple of tokens, so shifting it down
+	// is cheaper than letting the slice creep forward and reallocate.
+	tok := s.pending[0]
+	n := copy(s.pending, s.pending[1:])
+	s.pending = s.pending[:n]
 
-			return tok
-		} else {
-			break
+	if tok.Typ == token.Error {
+		if s.err != nil {
+			s.err(s.file.Position(tok.Pos), tok.Lit)
 		}
+		s.ErrorCount += 1
 	}
 
 	return tok
@@ -356,7 +363,11 @@ func (s *Scanner) lexeme() string {
 	return string(s.src[s.start:s.pos])
 }
 
+func (s *Scanner) position() token.Pos {
+	return s.file.Pos(s.start)
+}
+
 func (s *Scanner) emit(typ token.TokenType) {
-	s.tokens <- token.Token{Typ: typ, Lit: s.lexeme(), Pos: token.Position{Filename: "", Line: s.line, Column: s.start - s.linepos + 1}}
+	s.pending = append(s.pending, token.Token{Typ: typ, Lit: s.lexeme(), Pos: s.position()})
 	s.start = s.pos
 }
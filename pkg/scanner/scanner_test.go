@@ -1,8 +1,11 @@
 package scanner
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
+	"github.com/manapointer/xi/pkg/lit"
 	"github.com/manapointer/xi/pkg/token"
 )
 
@@ -24,6 +27,8 @@ var (
 	tokRparen = makeToken(token.Rparen, ")")
 	tokSub    = makeToken(token.Sub, "-")
 	tokAdd    = makeToken(token.Add, "+")
+	tokMul    = makeToken(token.Mul, "*")
+	tokHMul   = makeToken(token.HMul, "*>>")
 	tokDiv    = makeToken(token.Div, "/")
 	tokRem    = makeToken(token.Rem, "%")
 	tokEq     = makeToken(token.Eq, "==")
@@ -60,6 +65,17 @@ var lexTests = []scannerTest{
 		tokGe,
 		tokEof,
 	}},
+	{"high multiply", "a*>>b *>c*>", []token.Token{
+		makeToken(token.Ident, "a"),
+		tokHMul,
+		makeToken(token.Ident, "b"),
+		tokMul,
+		tokGt,
+		makeToken(token.Ident, "c"),
+		tokMul,
+		tokGt,
+		tokEof,
+	}},
 	{"number", "1337", []token.Token{
 		makeToken(token.Integer, "1337"),
 		tokEof,
@@ -93,7 +109,7 @@ var lexTests = []scannerTest{
 		tokEof,
 	}},
 	{"unknown escape sequence", `"Hello, world\d"`, []token.Token{
-		makeToken(token.Error, "unknown escape sequence"),
+		makeToken(token.Error, `unknown escape sequence \d`),
 	}},
 	{"unterminated string", "\"Hello, world\n", []token.Token{
 		makeToken(token.Error, "string literal not terminated"),
@@ -226,8 +242,13 @@ func TestScan(t *testing.T) {
 	}
 }
 
+func newScanner(src []byte) *Scanner {
+	fset := token.NewFileSet()
+	return NewScanner(fset.AddFile("", -1, len(src)), src, nil, 0)
+}
+
 func runTest(test scannerTest) (tokens []token.Token) {
-	s := NewScanner([]byte(test.input), nil)
+	s := newScanner([]byte(test.input))
 
 	for {
 		tok := s.Scan()
@@ -255,3 +276,227 @@ func tokensEqual(t1, t2 []token.Token) bool {
 
 	return true
 }
+
+func TestScanAfterEof(t *testing.T) {
+	s := newScanner([]byte("a"))
+
+	if tok := s.Scan(); tok.Typ != token.Ident {
+		t.Fatalf("got %v, expected IDENT", tok.Typ)
+	}
+
+	for i := 0; i < 3; i++ {
+		if tok := s.Scan(); tok.Typ != token.Eof {
+			t.Fatalf("scan %d after end of input: got %v, expected EOF", i, tok.Typ)
+		}
+	}
+}
+
+// benchSource generates a syntactically plausible Xi file of roughly n
+// functions, exercising identifiers, keywords, literals, operators and
+// comments.
+func benchSource(n int) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("use io\nuse conv\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `// sort%d sorts a in place.
+sort%d(a: int[], n: int): int[], bool {
+	i:int = 0
+	while (i < n) {
+		j:int = i
+		while (j > 0 & a[j-1] > a[j]) {
+			swap:int = a[j]
+			a[j] = a[j-1]
+			a[j-1] = swap
+			j = j-1
+		}
+		i = i+1
+	}
+	s: int[] = "sorted\n\x{263A}"
+	c: int = 'c' * 3 / 2 %% 7
+	b: bool = !(length(a) >= 1337) | true != false
+	return a, b
+}
+
+`, i, i)
+	}
+
+	return buf.Bytes()
+}
+
+func benchmarkScan(b *testing.B, n int) {
+	src := benchSource(n)
+
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s := newScanner(src)
+		for tok := s.Scan(); tok.Typ != token.Eof; tok = s.Scan() {
+			if tok.Typ == token.Error {
+				b.Fatalf("unexpected error: %s", tok.Lit)
+			}
+		}
+	}
+}
+
+func BenchmarkScanSmall(b *testing.B)  { benchmarkScan(b, 1) }
+func BenchmarkScanMedium(b *testing.B) { benchmarkScan(b, 100) }
+func BenchmarkScanLarge(b *testing.B)  { benchmarkScan(b, 10000) }
+
+// BenchmarkScanEarlyExit measures the cost of scanners that are abandoned
+// after the first token, as happens when the parser gives up on a file.
+func BenchmarkScanEarlyExit(b *testing.B) {
+	src := benchSource(10)
+
+	for i := 0; i < b.N; i++ {
+		s := newScanner(src)
+		s.Scan()
+	}
+}
+
+func TestPositions(t *testing.T) {
+	src := []byte("a\n\tbc  1\n\n\"s\"")
+
+	fset := token.NewFileSet()
+	s := NewScanner(fset.AddFile("pos.xi", -1, len(src)), src, nil, 0)
+
+	for _, want := range []string{"pos.xi:1:1", "pos.xi:2:2", "pos.xi:2:6", "pos.xi:4:1", "pos.xi:4:4"} {
+		tok := s.Scan()
+		if got := fset.Position(tok.Pos).String(); got != want {
+			t.Errorf("%v %q: got %s, expected %s", tok.Typ, tok.Lit, got, want)
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	src := []byte("a // one\n//two\nb/c")
+
+	for _, test := range []struct {
+		mode Mode
+		want []token.Token
+	}{
+		{0, []token.Token{makeToken(token.Ident, "a"), makeToken(token.Ident, "b"), tokDiv, makeToken(token.Ident, "c"), tokEof}},
+		{ScanComments, []token.Token{
+			makeToken(token.Ident, "a"),
+			makeToken(token.Comment, "// one"),
+			makeToken(token.Comment, "//two"),
+			makeToken(token.Ident, "b"),
+			tokDiv,
+			makeToken(token.Ident, "c"),
+			tokEof,
+		}},
+	} {
+		fset := token.NewFileSet()
+		s := NewScanner(fset.AddFile("", -1, len(src)), src, nil, test.mode)
+		var tokens []token.Token
+		for {
+			tok := s.Scan()
+			tokens = append(tokens, tok)
+			if tok.Typ == token.Eof {
+				break
+			}
+		}
+		if !tokensEqual(tokens, test.want) {
+			t.Errorf("mode %d: got\n\t%v\nexpected\n\t%v", test.mode, tokens, test.want)
+		}
+	}
+}
+
+// literalTests lists literals with their decoded values, or the errors
+// scanning them reports, each as line:column followed by the message.
+var literalTests = []struct {
+	src  string
+	typ  token.TokenType
+	n    int64
+	str  string
+	errs []string
+}{
+	{src: "0", typ: token.Integer, n: 0},
+	{src: "007", typ: token.Integer, n: 7},
+	{src: "9223372036854775807", typ: token.Integer, n: 1<<63 - 1},
+	{src: "9223372036854775808", typ: token.Integer, n: -1 << 63},
+	{src: "9223372036854775809", typ: token.Integer, errs: []string{"1:1: integer literal 9223372036854775809 out of range"}},
+	{src: "99999999999999999999", typ: token.Integer, errs: []string{"1:1: integer literal 99999999999999999999 out of range"}},
+	{src: "12a", typ: token.Integer, errs: []string{"1:3: unexpected token: U+0061 'a'"}},
+
+	{src: `'a'`, typ: token.Char, n: 'a'},
+	{src: `'\n'`, typ: token.Char, n: '\n'},
+	{src: `'\t'`, typ: token.Char, n: '\t'},
+	{src: `'\''`, typ: token.Char, n: '\''},
+	{src: `'\\'`, typ: token.Char, n: '\\'},
+	{src: `'"'`, typ: token.Char, n: '"'},
+	{src: `'\"'`, typ: token.Char, n: '"'},
+	{src: `'β'`, typ: token.Char, n: 'β'},
+	{src: `'\x{f}'`, typ: token.Char, n: 0xf},
+	{src: `'\x{Ff}'`, typ: token.Char, n: 0xff},
+	{src: `'\x{10FFFF}'`, typ: token.Char, n: 0x10ffff},
+	{src: `'\x{1F600}'`, typ: token.Char, n: 0x1f600},
+	{src: `''`, typ: token.Char, errs: []string{"1:2: empty character literal"}},
+	{src: `'ab'`, typ: token.Char, errs: []string{"1:2: more than one character in character literal"}},
+	{src: `'a`, typ: token.Char, errs: []string{"1:1: character literal not terminated"}},
+	{src: "'\n'", typ: token.Char, errs: []string{"1:1: character literal not terminated"}},
+	{src: `'\q'`, typ: token.Char, errs: []string{`1:2: unknown escape sequence \q`}},
+	{src: `'\x{D800}'`, typ: token.Char, errs: []string{"1:5: surrogate half 0xd800 is not a code point"}},
+	{src: `'\x{110000}'`, typ: token.Char, errs: []string{"1:5: code point 0x110000 out of range"}},
+
+	{src: `""`, typ: token.String},
+	{src: `"Hello, world\n"`, typ: token.String, str: "Hello, world\n"},
+	{src: `"\"quoted\" \\ 'x'"`, typ: token.String, str: `"quoted" \ 'x'`},
+	{src: `"\x{48}\x{69}\x{1F600}"`, typ: token.String, str: "Hi😀"},
+	{src: `"é\tß"`, typ: token.String, str: "é\tß"},
+	{src: `"ab\x41"`, typ: token.String, errs: []string{"1:6: expected { in \\x escape"}},
+	{src: `"ab\x{}"`, typ: token.String, errs: []string{"1:7: expected hexadecimal digit in \\x escape"}},
+	{src: `"ab\x{4g}"`, typ: token.String, errs: []string{"1:8: expected } in \\x escape"}},
+	{src: `"ab\x{0000041}"`, typ: token.String, errs: []string{"1:13: more than 6 hexadecimal digits in \\x escape"}},
+	{src: `"ab\x{DFFF}"`, typ: token.String, errs: []string{"1:7: surrogate half 0xdfff is not a code point"}},
+	{src: `"ab\x{FFFFFF}"`, typ: token.String, errs: []string{"1:7: code point 0xffffff out of range"}},
+	{src: "\"a\xffb\"", typ: token.String, errs: []string{"1:3: invalid UTF-8 encoding"}},
+	{src: `"ab\"`, typ: token.String, errs: []string{"1:1: string literal not terminated"}},
+	{src: "\"ab\\\n\"", typ: token.String, errs: []string{"1:1: string literal not terminated"}},
+}
+
+func TestLiterals(t *testing.T) {
+	for _, test := range literalTests {
+		src := []byte(test.src)
+		fset := token.NewFileSet()
+		s := NewScanner(fset.AddFile("", -1, len(src)), src, nil, 0)
+
+		var errs []string
+		tok := s.Scan()
+		for ; tok.Typ == token.Error; tok = s.Scan() {
+			errs = append(errs, fmt.Sprintf("%s: %s", fset.Position(tok.Pos), tok.Lit))
+		}
+
+		if tok.Typ != test.typ {
+			t.Errorf("%q: got %v, expected %v", test.src, tok.Typ, test.typ)
+		}
+		if len(test.errs) == 0 {
+			n, str := decodeLiteral(tok)
+			if n != test.n || str != test.str {
+				t.Errorf("%q: got value %d %q, expected %d %q", test.src, n, str, test.n, test.str)
+			}
+		}
+		if fmt.Sprint(errs) != fmt.Sprint(test.errs) {
+			t.Errorf("%q: got errors %q, expected %q", test.src, errs, test.errs)
+		}
+		if s.ErrorCount != len(test.errs) {
+			t.Errorf("%q: ErrorCount = %d, expected %d", test.src, s.ErrorCount, len(test.errs))
+		}
+	}
+}
+
+// decodeLiteral returns the value of a well-formed literal token: the
+// value of an Integer or Char token, or the code points of a String token.
+func decodeLiteral(tok token.Token) (n int64, str string) {
+	switch tok.Typ {
+	case token.Integer:
+		n, _ = lit.ParseInt(tok.Lit)
+	case token.Char:
+		n, _ = lit.UnquoteChar(tok.Lit)
+	case token.String:
+		s, _ := lit.Unquote(tok.Lit)
+		str = string(s)
+	}
+	return n, str
+}
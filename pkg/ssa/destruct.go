@@ -0,0 +1,128 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/manapointer/xi/pkg/opt"
+)
+
+// Destruct takes f out of SSA form. The phi instructions of a block
+// become a parallel copy at the end of each predecessor, which is then
+// sequentialized, saving a temporary in a fresh one where the copies form
+// a cycle. Edges from blocks with several successors to blocks with phi
+// instructions are first split by new blocks, so that the copies only run
+// on the way to the block.
+func Destruct(f *opt.Func) {
+	labels := make(map[string]bool)
+	for _, b := range f.Blocks {
+		labels[b.Label] = true
+	}
+	n := 0
+	newLabel := func() string {
+		for {
+			n++
+			l := fmt.Sprintf("_se%d", n)
+			if !labels[l] {
+				labels[l] = true
+				return l
+			}
+		}
+	}
+
+	for _, s := range append([]*opt.Block(nil), f.Blocks...) {
+		if !hasPhis(s) {
+			continue
+		}
+		for j, p := range s.Preds {
+			if len(p.Succs) < 2 {
+				continue
+			}
+			e := &opt.Block{
+				Index:  len(f.Blocks),
+				Label:  newLabel(),
+				Instrs: []*opt.Instr{{Kind: opt.Jump}},
+				Succs:  []*opt.Block{s},
+				Preds:  []*opt.Block{p},
+			}
+			f.Blocks = append(f.Blocks, e)
+			for k, x := range p.Succs {
+				if x == s {
+					p.Succs[k] = e
+				}
+			}
+			s.Preds[j] = e
+		}
+	}
+
+	for _, s := range f.Blocks {
+		if !hasPhis(s) {
+			continue
+		}
+		for j, p := range s.Preds {
+			var copies []*opt.Instr
+			for _, in := range s.Instrs {
+				if in.Kind == opt.Phi {
+					copies = append(copies, &opt.Instr{Kind: opt.Copy, Dst: in.Dst, Args: []opt.Value{in.Args[j]}})
+				}
+			}
+			copies = sequentialize(f, copies)
+			last := len(p.Instrs) - 1
+			p.Instrs = append(append(p.Instrs[:last:last], copies...), p.Instrs[last])
+		}
+		var list []*opt.Instr
+		for _, in := range s.Instrs {
+			if in.Kind != opt.Phi {
+				list = append(list, in)
+			}
+		}
+		s.Instrs = list
+	}
+	f.Renumber()
+}
+
+func hasPhis(b *opt.Block) bool {
+	return len(b.Instrs) > 0 && b.Instrs[0].Kind == opt.Phi
+}
+
+// sequentialize orders copies that happen all at once, whose destinations
+// are distinct, so that none overwrites a temporary a later one reads.
+func sequentialize(f *opt.Func, copies []*opt.Instr) []*opt.Instr {
+	var pending []*opt.Instr
+	for _, c := range copies {
+		if c.Args[0] != opt.Value(c.Dst) {
+			pending = append(pending, c)
+		}
+	}
+
+	var list []*opt.Instr
+	for len(pending) > 0 {
+		ready := -1
+		for i, c := range pending {
+			read := false
+			for k, d := range pending {
+				read = read || k != i && d.Args[0] == opt.Value(c.Dst)
+			}
+			if !read {
+				ready = i
+				break
+			}
+		}
+		if ready >= 0 {
+			list = append(list, pending[ready])
+			pending = append(pending[:ready:ready], pending[ready+1:]...)
+			continue
+		}
+
+		// Every destination is read by another copy, so the copies form
+		// cycles. Break one by saving a destination.
+		dst := pending[0].Dst
+		t := f.NewTemp()
+		list = append(list, &opt.Instr{Kind: opt.Copy, Dst: t, Args: []opt.Value{dst}})
+		for _, c := range pending {
+			if c.Args[0] == opt.Value(dst) {
+				c.Args[0] = t
+			}
+		}
+	}
+	return list
+}
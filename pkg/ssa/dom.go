@@ -0,0 +1,154 @@
+package ssa
+
+import "github.com/manapointer/xi/pkg/opt"
+
+// A DomTree is the dominator tree of a function: block a dominates block
+// b if every path from the entry to b passes through a. Blocks that the
+// entry does not reach are not in the tree.
+type DomTree struct {
+	idom     []*opt.Block
+	children [][]*opt.Block
+	frontier [][]*opt.Block
+
+	// pre and post number the tree in preorder and postorder, so that a
+	// dominates b if b's interval lies within a's.
+	pre, post []int
+}
+
+// Dominators computes the dominator tree of f, using the algorithm of
+// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm".
+func Dominators(f *opt.Func) *DomTree {
+	n := len(f.Blocks)
+	t := &DomTree{
+		idom:     make([]*opt.Block, n),
+		children: make([][]*opt.Block, n),
+		frontier: make([][]*opt.Block, n),
+		pre:      make([]int, n),
+		post:     make([]int, n),
+	}
+	if n == 0 {
+		return t
+	}
+
+	// Number the reachable blocks in postorder.
+	order := make([]int, n) // postorder number plus one; 0 if unreachable
+	var rpo []*opt.Block
+	var visit func(b *opt.Block)
+	visit = func(b *opt.Block) {
+		order[b.Index] = -1
+		for _, s := range b.Succs {
+			if order[s.Index] == 0 {
+				visit(s)
+			}
+		}
+		rpo = append(rpo, b)
+		order[b.Index] = len(rpo)
+	}
+	entry := f.Blocks[0]
+	visit(entry)
+	for i, j := 0, len(rpo)-1; i < j; i, j = i+1, j-1 {
+		rpo[i], rpo[j] = rpo[j], rpo[i]
+	}
+
+	intersect := func(a, b *opt.Block) *opt.Block {
+		for a != b {
+			for order[a.Index] < order[b.Index] {
+				a = t.idom[a.Index]
+			}
+			for order[b.Index] < order[a.Index] {
+				b = t.idom[b.Index]
+			}
+		}
+		return a
+	}
+
+	t.idom[entry.Index] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			var idom *opt.Block
+			for _, p := range b.Preds {
+				if t.idom[p.Index] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if t.idom[b.Index] != idom {
+				t.idom[b.Index] = idom
+				changed = true
+			}
+		}
+	}
+	t.idom[entry.Index] = nil
+
+	for _, b := range rpo[1:] {
+		d := t.idom[b.Index]
+		t.children[d.Index] = append(t.children[d.Index], b)
+	}
+
+	// A join point is in the frontier of each block that dominates one of
+	// its predecessors but not the join point itself.
+	for _, b := range rpo {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			if order[p.Index] == 0 {
+				continue
+			}
+			for r := p; r != t.idom[b.Index]; r = t.idom[r.Index] {
+				if !contains(t.frontier[r.Index], b) {
+					t.frontier[r.Index] = append(t.frontier[r.Index], b)
+				}
+			}
+		}
+	}
+
+	clock := 0
+	var number func(b *opt.Block)
+	number = func(b *opt.Block) {
+		clock++
+		t.pre[b.Index] = clock
+		for _, c := range t.children[b.Index] {
+			number(c)
+		}
+		clock++
+		t.post[b.Index] = clock
+	}
+	number(entry)
+
+	return t
+}
+
+func contains(list []*opt.Block, b *opt.Block) bool {
+	for _, x := range list {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Idom returns the immediate dominator of b, or nil for the entry and
+// unreachable blocks.
+func (t *DomTree) Idom(b *opt.Block) *opt.Block { return t.idom[b.Index] }
+
+// Children returns the blocks whose immediate dominator is b.
+func (t *DomTree) Children(b *opt.Block) []*opt.Block { return t.children[b.Index] }
+
+// Frontier returns the dominance frontier of b: the blocks that b does not
+// strictly dominate but one of whose predecessors it dominates.
+func (t *DomTree) Frontier(b *opt.Block) []*opt.Block { return t.frontier[b.Index] }
+
+// Dominates reports whether a dominates b. Every reachable block
+// dominates itself.
+func (t *DomTree) Dominates(a, b *opt.Block) bool {
+	if t.pre[a.Index] == 0 || t.pre[b.Index] == 0 {
+		return false
+	}
+	return t.pre[a.Index] <= t.pre[b.Index] && t.post[b.Index] <= t.post[a.Index]
+}
@@ -0,0 +1,239 @@
+package ssa
+
+import (
+	"github.com/manapointer/xi/pkg/cfg"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/opt"
+)
+
+type edge struct{ from, to *opt.Block }
+
+// sccp holds the state of sparse conditional constant propagation.
+type sccp struct {
+	block    map[*opt.Instr]*opt.Block
+	uses     map[opt.Temp][]*opt.Instr
+	single   map[opt.Temp]bool // assigned by exactly one instruction
+	values   map[opt.Temp]cfg.Value
+	executed map[*opt.Block]bool
+	edges    map[edge]bool
+
+	flowWork []edge
+	ssaWork  []*opt.Instr
+}
+
+// SCCP performs sparse conditional constant propagation on f, which must
+// be in SSA form, following Wegman and Zadeck, "Constant Propagation with
+// Conditional Branches". It assumes a temporary is constant until an
+// executable assignment shows otherwise, and an edge is not executable
+// until a branch that may take it is, so it finds the constants of loops
+// and of code guarded by constant conditions.
+//
+// It replaces the uses of constant temporaries by the constants and
+// removes their assignments, turns branches whose outcome is known into
+// jumps and removes the blocks that are never executed. It reports
+// whether f changed.
+func SCCP(f *opt.Func) bool {
+	s := &sccp{
+		block:    make(map[*opt.Instr]*opt.Block),
+		uses:     make(map[opt.Temp][]*opt.Instr),
+		single:   make(map[opt.Temp]bool),
+		values:   make(map[opt.Temp]cfg.Value),
+		executed: make(map[*opt.Block]bool),
+		edges:    make(map[edge]bool),
+	}
+	defs := make(map[opt.Temp]int)
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			s.block[in] = b
+			for _, t := range in.Uses() {
+				s.uses[t] = append(s.uses[t], in)
+			}
+			for _, t := range in.Defs() {
+				defs[t]++
+			}
+		}
+	}
+	for t, n := range defs {
+		s.single[t] = n == 1
+	}
+
+	s.flowWork = []edge{{nil, f.Blocks[0]}}
+	for len(s.flowWork) > 0 || len(s.ssaWork) > 0 {
+		if n := len(s.flowWork); n > 0 {
+			e := s.flowWork[n-1]
+			s.flowWork = s.flowWork[:n-1]
+			if s.edges[e] {
+				continue
+			}
+			s.edges[e] = true
+			if !s.executed[e.to] {
+				s.executed[e.to] = true
+				for _, in := range e.to.Instrs {
+					s.visit(in)
+				}
+			} else {
+				for _, in := range e.to.Instrs {
+					if in.Kind == opt.Phi {
+						s.visit(in)
+					}
+				}
+			}
+			continue
+		}
+		n := len(s.ssaWork)
+		in := s.ssaWork[n-1]
+		s.ssaWork = s.ssaWork[:n-1]
+		if s.executed[s.block[in]] {
+			s.visit(in)
+		}
+	}
+
+	return s.rewrite(f)
+}
+
+// value returns what is known about v.
+func (s *sccp) value(v opt.Value) cfg.Value {
+	switch v := v.(type) {
+	case opt.Const:
+		return cfg.Value{Level: cfg.Constant, Const: int64(v)}
+	case opt.Temp:
+		if s.single[v] {
+			return s.values[v]
+		}
+	}
+	return cfg.Value{Level: cfg.Varying}
+}
+
+// binOp returns what is known about x op y.
+func (s *sccp) binOp(op ir.Op, x, y opt.Value) cfg.Value {
+	vx, vy := s.value(x), s.value(y)
+	switch {
+	case vx.Level == cfg.Varying || vy.Level == cfg.Varying:
+		return cfg.Value{Level: cfg.Varying}
+	case vx.Level == cfg.Undefined || vy.Level == cfg.Undefined:
+		return cfg.Value{}
+	}
+	if c, ok := ir.Fold(op, vx.Const, vy.Const); ok {
+		return cfg.Value{Level: cfg.Constant, Const: c}
+	}
+	return cfg.Value{Level: cfg.Varying}
+}
+
+// visit evaluates in in an executable block.
+func (s *sccp) visit(in *opt.Instr) {
+	b := s.block[in]
+	var v cfg.Value
+	switch in.Kind {
+	case opt.Phi:
+		for j, p := range b.Preds {
+			if s.edges[edge{p, b}] {
+				v = v.Meet(s.value(in.Args[j]))
+			}
+		}
+	case opt.Copy:
+		v = s.value(in.Args[0])
+	case opt.BinOp:
+		v = s.binOp(in.Op, in.Args[0], in.Args[1])
+	case opt.Jump:
+		s.flowWork = append(s.flowWork, edge{b, b.Succs[0]})
+		return
+	case opt.Branch:
+		switch c := s.binOp(in.Op, in.Args[0], in.Args[1]); c.Level {
+		case cfg.Constant:
+			taken := b.Succs[1]
+			if c.Const != 0 {
+				taken = b.Succs[0]
+			}
+			s.flowWork = append(s.flowWork, edge{b, taken})
+		case cfg.Varying:
+			s.flowWork = append(s.flowWork, edge{b, b.Succs[0]}, edge{b, b.Succs[1]})
+		}
+		return
+	case opt.Return, opt.Store:
+		return
+	default:
+		v = cfg.Value{Level: cfg.Varying}
+	}
+
+	for _, t := range in.Defs() {
+		if !s.single[t] {
+			continue
+		}
+		old := s.values[t]
+		if nv := old.Meet(v); nv != old {
+			s.values[t] = nv
+			s.ssaWork = append(s.ssaWork, s.uses[t]...)
+		}
+	}
+}
+
+// constant returns the constant value of v, if any.
+func (s *sccp) constant(v opt.Value) (opt.Const, bool) {
+	if t, ok := v.(opt.Temp); ok {
+		if x := s.value(t); x.Level == cfg.Constant {
+			return opt.Const(x.Const), true
+		}
+	}
+	return 0, false
+}
+
+// rewrite applies the results of the propagation to f.
+func (s *sccp) rewrite(f *opt.Func) bool {
+	changed := false
+	var blocks []*opt.Block
+	for _, b := range f.Blocks {
+		if !s.executed[b] {
+			changed = true
+			continue
+		}
+		blocks = append(blocks, b)
+
+		var list []*opt.Instr
+		for _, in := range b.Instrs {
+			if _, ok := s.constant(in.Dst); ok && !in.HasSideEffects() {
+				changed = true
+				continue
+			}
+			for i, arg := range in.Args {
+				if c, ok := s.constant(arg); ok {
+					in.Args[i] = c
+					changed = true
+				}
+			}
+			if in.Kind == opt.Branch {
+				if v := s.binOp(in.Op, in.Args[0], in.Args[1]); v.Level == cfg.Constant {
+					taken, other := b.Succs[0], b.Succs[1]
+					if v.Const == 0 {
+						taken, other = other, taken
+					}
+					removePred(other, predIndex(other, b))
+					in.Kind, in.Op, in.Args = opt.Jump, 0, nil
+					b.Succs = []*opt.Block{taken}
+					changed = true
+				}
+			}
+			list = append(list, in)
+		}
+		b.Instrs = list
+	}
+
+	// Remove the edges from blocks that were never executed.
+	for _, b := range blocks {
+		for j := len(b.Preds) - 1; j >= 0; j-- {
+			if !s.executed[b.Preds[j]] {
+				removePred(b, j)
+			}
+		}
+		if len(b.Preds) == 1 {
+			for _, in := range b.Instrs {
+				if in.Kind == opt.Phi {
+					in.Kind = opt.Copy
+					changed = true
+				}
+			}
+		}
+	}
+	f.Blocks = blocks
+	reindex(f)
+	return changed
+}
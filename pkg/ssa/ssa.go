@@ -0,0 +1,174 @@
+// Package ssa converts functions in the three-address form of package opt
+// to and from static single assignment form, in which each temporary is
+// assigned by exactly one instruction.
+//
+// Construct places phi instructions at the iterated dominance frontiers of
+// the assignments to each temporary and renames the temporaries along the
+// dominator tree, following Cytron et al., "Efficiently Computing Static
+// Single Assignment Form and the Control Dependence Graph". Destruct
+// replaces the phi instructions by copies at the ends of the predecessors.
+// In between, SCCP propagates constants.
+//
+// The arguments in ir.Arg(i), which are never assigned, and the results
+// of calls in ir.Ret(i), which are read right after the calls, keep their
+// names.
+package ssa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/manapointer/xi/pkg/cfg"
+	"github.com/manapointer/xi/pkg/opt"
+)
+
+// fixed reports whether t keeps its name in SSA form.
+func fixed(t opt.Temp) bool {
+	return strings.HasPrefix(string(t), "_ARG") || strings.HasPrefix(string(t), "_RET")
+}
+
+// Construct converts f to SSA form. It first removes the blocks control
+// never reaches and turns branches whose targets are the same into jumps,
+// so that each predecessor of a block corresponds to one phi argument.
+//
+// The i-th version of a temporary t is named t$i. A temporary read on
+// some path before it is assigned keeps its name in the phi argument for
+// that path.
+func Construct(f *opt.Func) {
+	normalize(f)
+	opt.Unreachable(f)
+
+	live := opt.Liveness(f)
+	dom := Dominators(f)
+
+	// Place the phi instructions, only where the temporary is live.
+	defsites := make(map[opt.Temp][]*opt.Block)
+	var temps []opt.Temp
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			t := in.Dst
+			if t == "" || fixed(t) {
+				continue
+			}
+			if _, ok := defsites[t]; !ok {
+				temps = append(temps, t)
+			}
+			if list := defsites[t]; !contains(list, b) {
+				defsites[t] = append(list, b)
+			}
+		}
+	}
+	phis := make(map[*opt.Instr]opt.Temp) // the temporary of each phi
+	for _, t := range temps {
+		placed := make(map[*opt.Block]bool)
+		work := append([]*opt.Block(nil), defsites[t]...)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, d := range dom.Frontier(b) {
+				if placed[d] || !live.In[d.Index].(cfg.Set)[t] {
+					continue
+				}
+				placed[d] = true
+				phi := &opt.Instr{Kind: opt.Phi, Dst: t, Args: make([]opt.Value, len(d.Preds))}
+				for i := range phi.Args {
+					phi.Args[i] = t
+				}
+				d.Instrs = append([]*opt.Instr{phi}, d.Instrs...)
+				phis[phi] = t
+				if !contains(defsites[t], d) {
+					work = append(work, d)
+				}
+			}
+		}
+	}
+
+	// Rename the temporaries in a preorder walk of the dominator tree,
+	// keeping the current version of each on a stack.
+	versions := make(map[opt.Temp]int)
+	stacks := make(map[opt.Temp][]opt.Temp)
+	current := func(t opt.Temp) opt.Temp {
+		if s := stacks[t]; len(s) > 0 {
+			return s[len(s)-1]
+		}
+		return t
+	}
+	var rename func(b *opt.Block)
+	rename = func(b *opt.Block) {
+		var pushed []opt.Temp
+		for _, in := range b.Instrs {
+			if in.Kind != opt.Phi {
+				for i, arg := range in.Args {
+					if t, ok := arg.(opt.Temp); ok && !fixed(t) {
+						in.Args[i] = current(t)
+					}
+				}
+			}
+			if t := in.Dst; t != "" && !fixed(t) {
+				versions[t]++
+				in.Dst = opt.Temp(fmt.Sprintf("%s$%d", t, versions[t]))
+				stacks[t] = append(stacks[t], in.Dst)
+				pushed = append(pushed, t)
+			}
+		}
+		for _, s := range b.Succs {
+			j := predIndex(s, b)
+			for _, in := range s.Instrs {
+				if t, ok := phis[in]; ok {
+					in.Args[j] = current(t)
+				}
+			}
+		}
+		for _, c := range dom.Children(b) {
+			rename(c)
+		}
+		for _, t := range pushed {
+			stacks[t] = stacks[t][:len(stacks[t])-1]
+		}
+	}
+	rename(f.Blocks[0])
+}
+
+// normalize turns branches to the same block on both outcomes into jumps.
+func normalize(f *opt.Func) {
+	changed := false
+	for _, b := range f.Blocks {
+		if in := b.Terminator(); in.Kind == opt.Branch && b.Succs[0] == b.Succs[1] {
+			in.Kind, in.Op, in.Args = opt.Jump, 0, nil
+			b.Succs = b.Succs[:1]
+			changed = true
+		}
+	}
+	if changed {
+		f.Renumber()
+	}
+}
+
+// predIndex returns the index of p among the predecessors of b.
+func predIndex(b, p *opt.Block) int {
+	for j, x := range b.Preds {
+		if x == p {
+			return j
+		}
+	}
+	panic(fmt.Sprintf("ssa: %s is not a predecessor of %s", p.Label, b.Label))
+}
+
+// removePred removes the j-th predecessor of b and the corresponding
+// arguments of its phi instructions.
+func removePred(b *opt.Block, j int) {
+	b.Preds = append(b.Preds[:j:j], b.Preds[j+1:]...)
+	for _, in := range b.Instrs {
+		if in.Kind == opt.Phi {
+			in.Args = append(in.Args[:j:j], in.Args[j+1:]...)
+		}
+	}
+}
+
+// reindex sets the indices of the blocks of f. Unlike f.Renumber, it
+// keeps the order of the predecessors, which the phi arguments follow.
+func reindex(f *opt.Func) {
+	for i, b := range f.Blocks {
+		b.Index = i
+	}
+}
@@ -0,0 +1,328 @@
+package ssa
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/ir"
+	"github.com/manapointer/xi/pkg/ir/sim"
+	"github.com/manapointer/xi/pkg/opt"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// lower translates and lowers a program.
+func lower(t *testing.T, src string) *ir.CompUnit {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &types.Config{Importer: importer.New(fset, nil)}
+	info, err := types.Check(fset, []*ast.File{f}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ir.Lower(ir.Translate("test", f, info))
+}
+
+// simulate runs a lowered program and returns its output and run-time
+// error.
+func simulate(cu *ir.CompUnit) (string, error) {
+	var stdout bytes.Buffer
+	s := sim.New(cu)
+	rt := stdlib.NewRuntime(strings.NewReader(""), &stdout)
+	for name, fn := range rt.Funcs() {
+		s.Bind(name, fn)
+	}
+	err := s.Main(nil)
+	rt.Flush()
+	return stdout.String(), err
+}
+
+// build returns the three-address form of the function in src.
+func build(t *testing.T, src string) *opt.Func {
+	t.Helper()
+	return opt.Build(lower(t, src).Funcs[0])
+}
+
+// checkSSA checks that each temporary of f other than the fixed ones is
+// assigned once.
+func checkSSA(t *testing.T, f *opt.Func) {
+	t.Helper()
+	defs := make(map[opt.Temp]int)
+	for _, b := range f.Blocks {
+		for i, in := range b.Instrs {
+			if in.Kind == opt.Phi && (i > 0 && b.Instrs[i-1].Kind != opt.Phi || len(in.Args) != len(b.Preds)) {
+				t.Errorf("misplaced %s in %s", in, b.Label)
+			}
+			if in.Dst != "" && !fixed(in.Dst) {
+				defs[in.Dst]++
+			}
+		}
+	}
+	for d, n := range defs {
+		if n > 1 {
+			t.Errorf("%s assigned %d times in\n%s", d, n, f)
+		}
+	}
+}
+
+func TestDominators(t *testing.T) {
+	f := build(t, `f(c: bool): int {
+	x:int = 0
+	if (c) { x = 1 } else { x = 2 }
+	while (x < 10) { x = x + 1 }
+	return x
+}`)
+	opt.Unreachable(f)
+	dom := Dominators(f)
+
+	entry := f.Blocks[0]
+	for _, b := range f.Blocks {
+		if !dom.Dominates(entry, b) {
+			t.Errorf("entry does not dominate %s", b.Label)
+		}
+		if !dom.Dominates(b, b) {
+			t.Errorf("%s does not dominate itself", b.Label)
+		}
+		if b != entry && !dom.Dominates(dom.Idom(b), b) {
+			t.Errorf("idom of %s does not dominate it", b.Label)
+		}
+		for _, c := range dom.Children(b) {
+			if dom.Idom(c) != b {
+				t.Errorf("%s is a child of %s but its idom is %s", c.Label, b.Label, dom.Idom(c).Label)
+			}
+		}
+	}
+	if dom.Idom(entry) != nil {
+		t.Errorf("entry has idom %s", dom.Idom(entry).Label)
+	}
+
+	// The successors of the branch on c do not dominate each other, and
+	// their join point is in both frontiers.
+	branch := entry
+	for len(branch.Succs) != 2 {
+		branch = branch.Succs[0]
+	}
+	then, els := branch.Succs[0], branch.Succs[1]
+	if dom.Dominates(then, els) || dom.Dominates(els, then) {
+		t.Errorf("%s and %s dominate each other", then.Label, els.Label)
+	}
+	for _, join := range dom.Frontier(then) {
+		if !contains(dom.Frontier(els), join) {
+			t.Errorf("%s is in the frontier of %s but not %s", join.Label, then.Label, els.Label)
+		}
+		if dom.Idom(join) != branch {
+			t.Errorf("idom of %s is %s, expected %s", join.Label, dom.Idom(join).Label, branch.Label)
+		}
+	}
+	if len(dom.Frontier(then)) != 1 {
+		t.Errorf("frontier of %s is %d blocks, expected 1", then.Label, len(dom.Frontier(then)))
+	}
+}
+
+func TestConstruct(t *testing.T) {
+	f := build(t, `f(c: bool): int {
+	x:int = 0
+	y:int = 5
+	if (c) { x = 1 }
+	i:int = 0
+	while (i < 10) { i = i + 1 }
+	return x + y + i
+}`)
+	Construct(f)
+	checkSSA(t, f)
+
+	s := f.String()
+	for _, want := range []string{"x$3 = phi(", "i$2 = phi(i$1, i$3)", "i$3 = ADD i$2 1"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("missing %q in\n%s", want, s)
+		}
+	}
+	// y has a single assignment, and neither version of x is live in the
+	// loop, so neither needs a phi there.
+	if strings.Contains(s, "y$2") || strings.Count(s, "phi(") != 2 {
+		t.Errorf("unexpected phis in\n%s", s)
+	}
+}
+
+var programs = []struct {
+	name string
+	src  string
+}{
+	{"loops", `use io
+use conv
+sum(a: int[], k: int): int {
+	s:int = 0
+	i:int = 0
+	while (i < length(a)) {
+		x:int = 2 * 3 + k
+		s = s + a[i] * x
+		i = i + 1
+	}
+	if (false) { s = 0 }
+	return s
+}
+main(args: int[][]) {
+	println(unparseInt(sum({1, 2, 3}, 1)))
+}
+`},
+	{"sort", `use io
+use conv
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+main(args: int[][]) {
+	a:int[] = {5, 3, -8, 13, 0, 3}
+	sort(a)
+	i:int = 0
+	while (i < length(a)) {
+		print(unparseInt(a[i]) + " ")
+		i = i + 1
+	}
+	println("")
+}
+`},
+	{"fib", `use io
+use conv
+fib(n: int): int, int {
+	a:int = 0
+	b:int = 1
+	k:int = 1
+	while (n > 0) {
+		t:int = a
+		a = b
+		b = t + b
+		n = n - 1
+		if (k == 1) { k = 1 } else { k = 2 }
+	}
+	return a, k
+}
+main(args: int[][]) {
+	x:int, k:int = fib(10)
+	println(unparseInt(x) + " " + unparseInt(k))
+}
+`},
+}
+
+func TestPrograms(t *testing.T) {
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			cu := lower(t, test.src)
+			want, wantErr := simulate(cu)
+			for _, sccp := range []bool{false, true} {
+				out := &ir.CompUnit{Name: cu.Name}
+				for _, fn := range cu.Funcs {
+					f := opt.Build(fn)
+					Construct(f)
+					checkSSA(t, f)
+					if sccp {
+						SCCP(f)
+						checkSSA(t, f)
+					}
+					Destruct(f)
+					out.Funcs = append(out.Funcs, opt.Emit(f))
+				}
+				got, err := simulate(ir.Lower(out))
+				if got != want || (err == nil) != (wantErr == nil) {
+					t.Errorf("sccp=%v: got %q, %v, expected %q, %v", sccp, got, err, want, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestSCCP(t *testing.T) {
+	for _, test := range []struct {
+		src    string
+		want   []string
+		absent []string
+	}{
+		// k stays 1 around the loop, which only SCCP's optimism finds.
+		{`f(n: int): int {
+	k:int = 1
+	while (n > 0) {
+		if (k != 1) { k = 2 }
+		n = n - 1
+	}
+	return k
+}`, []string{"return 1"}, []string{"k$", "phi(k"}},
+		{`f(x: int): int {
+	a:int = 2 * 3
+	if (a > 5) { x = a + 1 } else { x = 0 }
+	return x
+}`, []string{"return 7"}, []string{"branch", "x$2", "x$3"}},
+		{`f(x: int): int {
+	y:int = x * 2
+	z:int = 10 / 0
+	return y
+}`, []string{"DIV 10 0", "return y$1"}, nil},
+	} {
+		f := build(t, test.src)
+		Construct(f)
+		SCCP(f)
+		checkSSA(t, f)
+		got := f.String()
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("missing %q in\n%s", want, got)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(got, absent) {
+				t.Errorf("unexpected %q in\n%s", absent, got)
+			}
+		}
+	}
+}
+
+func TestSequentialize(t *testing.T) {
+	f := &opt.Func{}
+	cp := func(dst string, src opt.Value) *opt.Instr {
+		return &opt.Instr{Kind: opt.Copy, Dst: opt.Temp(dst), Args: []opt.Value{src}}
+	}
+	// a and b swap, c takes the old a, d is unchanged.
+	list := sequentialize(f, []*opt.Instr{
+		cp("a", opt.Temp("b")),
+		cp("b", opt.Temp("a")),
+		cp("c", opt.Temp("a")),
+		cp("d", opt.Temp("d")),
+	})
+
+	env := map[opt.Temp]int64{"a": 1, "b": 2, "c": 3, "d": 4}
+	for _, in := range list {
+		switch v := in.Args[0].(type) {
+		case opt.Temp:
+			env[in.Dst] = env[v]
+		case opt.Const:
+			env[in.Dst] = int64(v)
+		}
+	}
+	if env["a"] != 2 || env["b"] != 1 || env["c"] != 1 || env["d"] != 4 {
+		t.Errorf("got a=%d b=%d c=%d d=%d from %v", env["a"], env["b"], env["c"], env["d"], list)
+	}
+	if len(list) != 4 {
+		t.Errorf("got %d copies, expected 4: %v", len(list), list)
+	}
+}
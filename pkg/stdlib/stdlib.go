@@ -0,0 +1,133 @@
+// Package stdlib is the Xi standard library. It bundles the interface
+// files of the io and conv modules and implements them for the
+// interpreter.
+package stdlib
+
+import (
+	"bufio"
+	"embed"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/manapointer/xi/pkg/interp"
+)
+
+// Interfaces holds io.ixi and conv.ixi.
+//
+//go:embed *.ixi
+var Interfaces embed.FS
+
+// A Runtime implements the standard library on top of a reader and a
+// writer, normally the process's standard input and output. Output is
+// buffered; it is flushed before every read and by Flush.
+type Runtime struct {
+	stdin  *bufio.Reader
+	stdout *bufio.Writer
+}
+
+// NewRuntime returns a runtime that reads from stdin and writes to stdout.
+func NewRuntime(stdin io.Reader, stdout io.Writer) *Runtime {
+	return &Runtime{stdin: bufio.NewReader(stdin), stdout: bufio.NewWriter(stdout)}
+}
+
+// Funcs returns the implementations of all standard library functions,
+// keyed by name.
+func (rt *Runtime) Funcs() map[string]interp.Extern {
+	return map[string]interp.Extern{
+		"print":      rt.print,
+		"println":    rt.println,
+		"readln":     rt.readln,
+		"getchar":    rt.getchar,
+		"eof":        rt.eof,
+		"parseInt":   parseInt,
+		"unparseInt": unparseInt,
+	}
+}
+
+// Bind provides the implementations of all standard library functions to
+// in.
+func (rt *Runtime) Bind(in *interp.Interpreter) {
+	for name, fn := range rt.Funcs() {
+		in.Bind(name, fn)
+	}
+}
+
+// Flush writes any buffered output.
+func (rt *Runtime) Flush() error {
+	return rt.stdout.Flush()
+}
+
+func (rt *Runtime) print(args []interp.Value) ([]interp.Value, error) {
+	for _, c := range args[0].(*interp.Array).Elems {
+		r := rune(c.(int64))
+		if int64(r) != c.(int64) || !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		rt.stdout.WriteRune(r)
+	}
+	return nil, nil
+}
+
+func (rt *Runtime) println(args []interp.Value) ([]interp.Value, error) {
+	rt.print(args)
+	return nil, rt.stdout.WriteByte('\n')
+}
+
+func (rt *Runtime) readln(args []interp.Value) ([]interp.Value, error) {
+	if err := rt.Flush(); err != nil {
+		return nil, err
+	}
+
+	line, err := rt.stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return []interp.Value{interp.NewString(line)}, nil
+}
+
+func (rt *Runtime) getchar(args []interp.Value) ([]interp.Value, error) {
+	if err := rt.Flush(); err != nil {
+		return nil, err
+	}
+
+	r, _, err := rt.stdin.ReadRune()
+	if err == io.EOF {
+		return []interp.Value{int64(-1)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []interp.Value{int64(r)}, nil
+}
+
+func (rt *Runtime) eof(args []interp.Value) ([]interp.Value, error) {
+	if err := rt.Flush(); err != nil {
+		return nil, err
+	}
+
+	_, err := rt.stdin.Peek(1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []interp.Value{err == io.EOF}, nil
+}
+
+func parseInt(args []interp.Value) ([]interp.Value, error) {
+	s := args[0].(*interp.Array).String()
+
+	// strconv also accepts a leading plus sign, which Xi does not.
+	if strings.HasPrefix(s, "+") {
+		return []interp.Value{int64(0), false}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return []interp.Value{int64(0), false}, nil
+	}
+	return []interp.Value{n, true}, nil
+}
+
+func unparseInt(args []interp.Value) ([]interp.Value, error) {
+	return []interp.Value{interp.NewString(strconv.FormatInt(args[0].(int64), 10))}, nil
+}
@@ -0,0 +1,87 @@
+package stdlib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/importer"
+	"github.com/manapointer/xi/pkg/interp"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/stdlib"
+	"github.com/manapointer/xi/pkg/token"
+	"github.com/manapointer/xi/pkg/types"
+)
+
+// run checks src against the bundled interfaces and runs its main function
+// with the given standard input, returning what it wrote.
+func run(t *testing.T, src, stdin string, args ...string) string {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "test.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &types.Config{Importer: importer.New(fset, nil)}
+	if _, err := types.Check(fset, []*ast.File{f}, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	in := interp.New(fset, f)
+	rt := stdlib.NewRuntime(strings.NewReader(stdin), &stdout)
+	rt.Bind(in)
+	if err := in.Main(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String()
+}
+
+func TestIO(t *testing.T) {
+	const src = `use io
+
+main(args: int[][]) {
+	print("name? ")
+	name:int[] = readln()
+	println("hello, " + name)
+	n:int = 0
+	while (!eof()) {
+		c:int = getchar()
+		if (c == 'x') n = n + 1
+	}
+	println({'0' + n, 'é'})
+	_ = getchar()
+}
+`
+	got := run(t, src, "wörld\r\nxaxx\nx")
+	want := "name? hello, wörld\n4é\n"
+	if got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestConv(t *testing.T) {
+	const src = `use io
+use conv
+
+main(args: int[][]) {
+	i:int = 0
+	while (i < length(args)) {
+		n:int, ok:bool = parseInt(args[i])
+		if (ok) println(unparseInt(n * 2))
+		else println("bad: " + args[i])
+		i = i + 1
+	}
+}
+`
+	got := run(t, src, "", "21", "-5", "+1", "12a", "", "9223372036854775807", "9223372036854775808")
+	want := "42\n-10\nbad: +1\nbad: 12a\nbad: \n-2\nbad: 9223372036854775808\n"
+	if got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
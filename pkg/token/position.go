@@ -1,11 +1,44 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Position describes a source position including the file, line and
+// column. Line and Column are 1-based; Column counts bytes.
 type Position struct {
 	Filename string
+	Offset   int // byte offset, starting at 0
 	Line     int
 	Column   int
 }
 
+// IsValid reports whether the position is valid.
+func (pos *Position) IsValid() bool { return pos.Line > 0 }
+
+// String returns a string in one of several forms:
+//
+//	file:line:column    valid position with file name
+//	line:column         valid position without file name
+//	file                invalid position with file name
+//	-                   invalid position without file name
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 func compareInt(lhs, rhs int) int {
 	switch {
 	case lhs < rhs:
@@ -24,3 +57,281 @@ func (pos Position) Compare(other Position) int {
 	}
 	return cmp
 }
+
+// Pos is a compact encoding of a source position within a file set. It can
+// be converted into a Position for a more convenient, but much larger,
+// representation.
+//
+// The Pos value for a given file is a number in the range [base, base+size],
+// where base and size are specified when the file is added to the file set.
+// The difference between a Pos value and the corresponding file base is the
+// byte offset of that position within the file.
+type Pos int
+
+// NoPos is the zero value for Pos; there is no file and line information
+// associated with it.
+const NoPos Pos = 0
+
+// IsValid reports whether the position is valid.
+func (p Pos) IsValid() bool { return p != NoPos }
+
+// A File is a handle for a file belonging to a FileSet.
+type File struct {
+	name string
+	base int
+	size int
+
+	mutex sync.Mutex
+	lines []int // offsets of the first character of each line; lines[0] == 0
+}
+
+func (f *File) Name() string { return f.name }
+func (f *File) Base() int    { return f.base }
+func (f *File) Size() int    { return f.size }
+
+// LineCount returns the number of lines in the file.
+func (f *File) LineCount() int {
+	f.mutex.Lock()
+	n := len(f.lines)
+	f.mutex.Unlock()
+	return n
+}
+
+// AddLine adds the line offset for a new line. The line offset must be
+// larger than the offset of the previous line and smaller than the file
+// size; otherwise it is ignored.
+func (f *File) AddLine(offset int) {
+	f.mutex.Lock()
+	if i := len(f.lines); (i == 0 || f.lines[i-1] < offset) && offset < f.size {
+		f.lines = append(f.lines, offset)
+	}
+	f.mutex.Unlock()
+}
+
+// SetLinesForContent sets the line offsets for the given file content,
+// for files that were not produced by the scanner.
+func (f *File) SetLinesForContent(content []byte) {
+	lines := []int{0}
+	for offset, b := range content {
+		if b == '\n' && offset+1 < len(content) {
+			lines = append(lines, offset+1)
+		}
+	}
+
+	f.mutex.Lock()
+	f.lines = lines
+	f.mutex.Unlock()
+}
+
+// Pos returns the Pos value for the given file offset, which must be in
+// the range [0, f.Size()].
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > f.size {
+		panic(fmt.Sprintf("invalid file offset %d (should be <= %d)", offset, f.size))
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the offset for the given file position p, which must be a
+// Pos value in that file.
+func (f *File) Offset(p Pos) int {
+	if int(p) < f.base || int(p) > f.base+f.size {
+		panic(fmt.Sprintf("invalid Pos value %d (should be in [%d, %d])", p, f.base, f.base+f.size))
+	}
+	return int(p) - f.base
+}
+
+// Line returns the line number for the given file position p.
+func (f *File) Line(p Pos) int {
+	return f.Position(p).Line
+}
+
+// LineStart returns the Pos value of the start of the given 1-based line.
+func (f *File) LineStart(line int) Pos {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if line < 1 || line > len(f.lines) {
+		panic(fmt.Sprintf("invalid line number %d (should be in [1, %d])", line, len(f.lines)))
+	}
+	return Pos(f.base + f.lines[line-1])
+}
+
+func (f *File) position(offset int) Position {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	i := sort.SearchInts(f.lines, offset+1) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return Position{
+		Filename: f.name,
+		Offset:   offset,
+		Line:     i + 1,
+		Column:   offset - f.lines[i] + 1,
+	}
+}
+
+// Position returns the Position value for the given file position p.
+// Calling f.Position(p) is equivalent to calling fset.Position(p).
+func (f *File) Position(p Pos) Position {
+	if p == NoPos {
+		return Position{}
+	}
+	return f.position(f.Offset(p))
+}
+
+// UTF16Column returns the 1-based column of p counted in UTF-16 code
+// units, as used by the Language Server Protocol. src must be the content
+// of the file.
+func (f *File) UTF16Column(src []byte, p Pos) int {
+	pos := f.Position(p)
+	line := src[pos.Offset-pos.Column+1 : pos.Offset]
+	return utf16Len(line) + 1
+}
+
+// PosUTF16 returns the Pos for the given 1-based line and 1-based column
+// counted in UTF-16 code units. Columns past the end of the line are
+// clamped to the end of the line, as LSP requires, and lines past the end of
+// the file to the end of the file. src must be the content of the file.
+func (f *File) PosUTF16(src []byte, line, col int) Pos {
+	if line < 1 {
+		line = 1
+	}
+	if line > f.LineCount() {
+		return f.Pos(f.size)
+	}
+
+	start := f.Offset(f.LineStart(line))
+
+	end := len(src)
+	if line < f.LineCount() {
+		end = f.Offset(f.LineStart(line+1)) - 1
+	}
+
+	offset := start
+	for units := 1; offset < end; {
+		r, w := utf8.DecodeRune(src[offset:end])
+		if r == '\n' {
+			break
+		}
+
+		n := utf16RuneLen(r)
+		if units+n > col {
+			break
+		}
+		units += n
+		offset += w
+	}
+
+	return f.Pos(offset)
+}
+
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, w := utf8.DecodeRune(b)
+		b = b[w:]
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+// utf16RuneLen returns the number of UTF-16 code units needed to encode r.
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// A FileSet represents a set of source files. Methods of file sets are
+// synchronized; multiple goroutines may invoke them concurrently.
+type FileSet struct {
+	mutex sync.RWMutex
+	base  int     // base offset for the next file
+	files []*File // list of files in the order added to the set
+	last  *File   // cache of last file looked up
+}
+
+// NewFileSet creates a new file set.
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1} // 0 == NoPos
+}
+
+// Base returns the minimum base offset that must be provided to AddFile
+// when adding the next file.
+func (s *FileSet) Base() int {
+	s.mutex.RLock()
+	b := s.base
+	s.mutex.RUnlock()
+	return b
+}
+
+// AddFile adds a new file with a given filename, base offset, and file size
+// to the file set s and returns the file. If base is negative, the current
+// value of s.Base() is used instead.
+func (s *FileSet) AddFile(filename string, base, size int) *File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if base < 0 {
+		base = s.base
+	}
+	if base < s.base {
+		panic(fmt.Sprintf("invalid base %d (should be >= %d)", base, s.base))
+	}
+	if size < 0 {
+		panic(fmt.Sprintf("invalid size %d (should be >= 0)", size))
+	}
+
+	f := &File{name: filename, base: base, size: size, lines: []int{0}}
+
+	// +1 because EOF also has a position
+	s.base = base + size + 1
+	s.files = append(s.files, f)
+	s.last = f
+	return f
+}
+
+// File returns the file that contains the position p. If no such file is
+// found (for instance for p == NoPos), the result is nil.
+func (s *FileSet) File(p Pos) *File {
+	if p == NoPos {
+		return nil
+	}
+
+	s.mutex.RLock()
+	f := s.last
+	s.mutex.RUnlock()
+
+	if f != nil && f.base <= int(p) && int(p) <= f.base+f.size {
+		return f
+	}
+
+	s.mutex.RLock()
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i >= 0 && int(p) <= s.files[i].base+s.files[i].size {
+		f = s.files[i]
+	} else {
+		f = nil
+	}
+	s.mutex.RUnlock()
+
+	if f != nil {
+		s.mutex.Lock()
+		s.last = f
+		s.mutex.Unlock()
+	}
+	return f
+}
+
+// Position converts a Pos p in the file set into a Position value.
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return Position{}
+}
@@ -0,0 +1,91 @@
+package token
+
+import "testing"
+
+func TestFileSetPosition(t *testing.T) {
+	fset := NewFileSet()
+
+	src1 := []byte("a\nbc\n\ndef")
+	f1 := fset.AddFile("one.xi", -1, len(src1))
+	f1.SetLinesForContent(src1)
+
+	src2 := []byte("x\ny")
+	f2 := fset.AddFile("two.xi", -1, len(src2))
+	f2.SetLinesForContent(src2)
+
+	for _, test := range []struct {
+		file   *File
+		offset int
+		want   string
+	}{
+		{f1, 0, "one.xi:1:1"},
+		{f1, 1, "one.xi:1:2"},
+		{f1, 2, "one.xi:2:1"},
+		{f1, 4, "one.xi:2:3"},
+		{f1, 5, "one.xi:3:1"},
+		{f1, 6, "one.xi:4:1"},
+		{f1, 9, "one.xi:4:4"}, // EOF
+		{f2, 0, "two.xi:1:1"},
+		{f2, 2, "two.xi:2:1"},
+	} {
+		p := test.file.Pos(test.offset)
+		if got := fset.Position(p).String(); got != test.want {
+			t.Errorf("%s offset %d: got %s, expected %s", test.file.Name(), test.offset, got, test.want)
+		}
+		if got := fset.File(p); got != test.file {
+			t.Errorf("%s offset %d: position attributed to the wrong file", test.file.Name(), test.offset)
+		}
+		if got := test.file.Offset(p); got != test.offset {
+			t.Errorf("%s offset %d: Offset returned %d", test.file.Name(), test.offset, got)
+		}
+	}
+
+	if got := fset.Position(NoPos).String(); got != "-" {
+		t.Errorf("NoPos: got %s, expected -", got)
+	}
+
+	if got := f1.LineStart(3); got != f1.Pos(5) {
+		t.Errorf("LineStart(3): got offset %d, expected 5", f1.Offset(got))
+	}
+}
+
+func TestUTF16(t *testing.T) {
+	// 'é' is two bytes but one UTF-16 unit; '😀' is four bytes and two units.
+	src := []byte("aé😀b\nc")
+	f := NewFileSet().AddFile("utf16.xi", -1, len(src))
+	f.SetLinesForContent(src)
+
+	for _, test := range []struct {
+		offset int
+		col16  int
+	}{
+		{0, 1},
+		{1, 2},
+		{3, 3},
+		{7, 5},
+		{8, 6}, // newline
+		{9, 1},
+	} {
+		p := f.Pos(test.offset)
+		if got := f.UTF16Column(src, p); got != test.col16 {
+			t.Errorf("UTF16Column(offset %d): got %d, expected %d", test.offset, got, test.col16)
+		}
+
+		line := f.Line(p)
+		if got := f.PosUTF16(src, line, test.col16); got != p {
+			t.Errorf("PosUTF16(%d, %d): got offset %d, expected %d", line, test.col16, f.Offset(got), test.offset)
+		}
+	}
+
+	// Columns in the middle of a surrogate pair and past the end of the
+	// line are clamped.
+	if got := f.Offset(f.PosUTF16(src, 1, 4)); got != 3 {
+		t.Errorf("PosUTF16 inside surrogate pair: got offset %d, expected 3", got)
+	}
+	if got := f.Offset(f.PosUTF16(src, 1, 100)); got != 8 {
+		t.Errorf("PosUTF16 past end of line: got offset %d, expected 8", got)
+	}
+	if got := f.Offset(f.PosUTF16(src, 7, 1)); got != len(src) {
+		t.Errorf("PosUTF16 past end of file: got offset %d, expected %d", got, len(src))
+	}
+}
@@ -32,6 +32,7 @@ const (
 	Add
 	Sub
 	Mul
+	HMul
 	Div
 	Rem
 
@@ -81,11 +82,12 @@ var tokens = [...]string{
 	Char:    "CHAR",
 	String:  "STRING",
 
-	Add: "+",
-	Sub: "-",
-	Mul: "*",
-	Div: "/",
-	Rem: "%",
+	Add:  "+",
+	Sub:  "-",
+	Mul:  "*",
+	HMul: "*>>",
+	Div:  "/",
+	Rem:  "%",
 
 	Assign: "=",
 	Not:    "!",
@@ -120,6 +122,6 @@ func (typ TokenType) String() string {
 
 type Token struct {
 	Typ TokenType
-	Pos Position
+	Pos Pos
 	Lit string
 }
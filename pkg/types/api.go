@@ -1,7 +1,138 @@
 package types
 
-import "go/ast"
+import (
+	"fmt"
+	"sort"
+	"strings"
 
-func Check(*ast.File) error {
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// An Error describes a type-checking error.
+type Error struct {
+	Fset *token.FileSet // file set for interpretation of Pos
+	Pos  token.Pos      // error position
+	End  token.Pos      // end of the offending node, or NoPos
+	Msg  string         // error message
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Fset.Position(err.Pos), err.Msg)
+}
+
+// An ErrorList is a list of type-checking errors, in source order.
+type ErrorList []Error
+
+func (l ErrorList) Len() int           { return len(l) }
+func (l ErrorList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l ErrorList) Less(i, j int) bool { return l[i].Pos < l[j].Pos }
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+
+	var b strings.Builder
+	for i, err := range l {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Err returns an error equivalent to this error list. If the list is empty,
+// Err returns nil.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Config configures the type checker.
+type Config struct {
+	// Error, if set, is called with each error as soon as it is found.
+	// Checking always continues past errors; the complete list is also
+	// returned by Check.
+	Error func(err Error)
+
+	// Importer resolves use declarations. If it is nil, any use
+	// declaration is an error.
+	Importer Importer
+}
+
+// TypeAndValue reports the type of an expression.
+type TypeAndValue struct {
+	Type Type
+}
+
+// Info holds the results of type checking.
+type Info struct {
+	// Types maps every checked expression to its type. Expressions whose
+	// type could not be determined are absent.
+	Types map[ast.Expr]TypeAndValue
+
+	// Defs maps identifiers to the objects they declare: function names
+	// and parameters, and declared local variables.
+	Defs map[*ast.Ident]Object
+
+	// Uses maps identifiers to the objects they refer to: variables in
+	// expressions and function names in calls. Identifiers that do not
+	// resolve are absent.
+	Uses map[*ast.Ident]Object
+
+	// Implicits maps nodes to the objects they declare without naming
+	// them. Each _ in a multiple declaration maps to an unnamed *Var
+	// holding the discarded result.
+	Implicits map[ast.Node]Object
+
+	// Scopes maps nodes to the scopes they open. The following nodes
+	// open scopes:
+	//
+	//     *ast.File
+	//     *ast.FuncDecl (parameters and the top level of the body)
+	//     *ast.BlockStmt, except function bodies
+	//     the branches of if and while statements, even if not blocks
+	//
+	// The file scopes' parent is the package scope, which holds the
+	// functions of all files and whose parent is the Universe.
+	Scopes map[ast.Node]*Scope
+}
+
+// TypeOf returns the type of expression e, or nil if it is unknown.
+func (info *Info) TypeOf(e ast.Expr) Type {
+	if t, ok := info.Types[e]; ok {
+		return t.Type
+	}
 	return nil
 }
+
+// ObjectOf returns the object denoted by id, or nil if it is not known.
+func (info *Info) ObjectOf(id *ast.Ident) Object {
+	if obj := info.Defs[id]; obj != nil {
+		return obj
+	}
+	return info.Uses[id]
+}
+
+// Check type-checks the given files, which together form one program and
+// must have been parsed using fset. It returns the recorded type
+// information and, if any errors were found, an ErrorList holding all of
+// them.
+func Check(fset *token.FileSet, files []*ast.File, conf *Config) (*Info, error) {
+	if conf == nil {
+		conf = &Config{}
+	}
+
+	c := newChecker(fset, conf)
+	c.files(files)
+
+	sort.Stable(c.errors)
+	return c.info, c.errors.Err()
+}
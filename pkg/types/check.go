@@ -1,7 +1,10 @@
 package types
 
 import (
+	"fmt"
+
 	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/lit"
 	"github.com/manapointer/xi/pkg/token"
 )
 
@@ -50,10 +53,12 @@ var unopPredicates = OpPredicates{
 }
 
 var binopPredicates = OpPredicates{
-	token.Add: isIntOrArray,
-	token.Sub: isInt,
-	token.Mul: isInt,
-	token.Div: isInt,
+	token.Add:  isIntOrArray,
+	token.Sub:  isInt,
+	token.Mul:  isInt,
+	token.HMul: isInt,
+	token.Div:  isInt,
+	token.Rem:  isInt,
 
 	token.Le: isInt,
 	token.Lt: isInt,
@@ -66,14 +71,27 @@ var binopPredicates = OpPredicates{
 	token.Or:  isBool,
 }
 
-func (c *Checker) predicate(r *result, predicates OpPredicates, op token.TokenType, typ Type) {
+// comparisons yield bool regardless of their operand types.
+var comparisons = map[token.TokenType]bool{
+	token.Le:  true,
+	token.Lt:  true,
+	token.Ge:  true,
+	token.Gt:  true,
+	token.Eq:  true,
+	token.Neq: true,
+}
+
+func (c *Checker) predicate(r *result, at ast.Node, predicates OpPredicates, op token.TokenType, typ Type) bool {
 	if pred := predicates[op]; pred != nil {
 		if !pred(typ) {
-			panic("cannot apply operation")
+			c.errorf(at, "operator %s not defined on %s", op, typ)
+			return false
 		}
-	} else {
-		panic("unknown op")
+		return true
 	}
+
+	c.errorf(at, "unknown operator %s", op)
+	return false
 }
 
 func isBasic(typ Type, kind BasicKind) bool {
@@ -86,62 +104,136 @@ func isBasic(typ Type, kind BasicKind) bool {
 }
 
 type Checker struct {
-	scope *Scope
+	fset   *token.FileSet
+	conf   *Config
+	info   *Info
+	errors ErrorList
+
+	pkg   *Scope     // scope holding the functions of all checked files
+	scope *Scope     // current scope
+	sig   *Signature // signature of the function being checked
+
+	negated *ast.BasicLit // integer literal that is the operand of a unary minus
 }
 
-func (check *Checker) openScope() {
-	check.scope = NewScope(check.scope)
+func newChecker(fset *token.FileSet, conf *Config) *Checker {
+	return &Checker{
+		fset: fset,
+		conf: conf,
+		info: &Info{
+			Types: make(map[ast.Expr]TypeAndValue),
+			Defs:  make(map[*ast.Ident]Object),
+			Uses:  make(map[*ast.Ident]Object),
+
+			Implicits: make(map[ast.Node]Object),
+			Scopes:    make(map[ast.Node]*Scope),
+		},
+	}
 }
 
-func (check *Checker) closeScope() {
-	check.scope = check.scope.parent
+func (c *Checker) errorf(at ast.Node, format string, args ...interface{}) {
+	c.report(at.Pos(), at.End(), fmt.Sprintf(format, args...))
 }
 
-func (c *Checker) decl() {
+func (c *Checker) errorfAt(pos token.Pos, format string, args ...interface{}) {
+	c.report(pos, token.NoPos, fmt.Sprintf(format, args...))
+}
 
+func (c *Checker) report(pos, end token.Pos, msg string) {
+	err := Error{Fset: c.fset, Pos: pos, End: end, Msg: msg}
+	c.errors = append(c.errors, err)
+	if c.conf.Error != nil {
+		c.conf.Error(err)
+	}
 }
 
-func (c *Checker) stmt() {
+// openScope opens a scope for node and records it in Info.Scopes.
+func (check *Checker) openScope(node ast.Node) {
+	check.scope = NewScope(check.scope, node.Pos(), node.End())
+	check.info.Scopes[node] = check.scope
+}
 
+func (check *Checker) closeScope() {
+	check.scope = check.scope.parent
+}
+
+// lookup returns the object name refers to in the current scope or any of
+// its parents. Checking proceeds in source order, so only objects declared
+// before the current point are visible.
+func (c *Checker) lookup(name string) Object {
+	for s := c.scope; s != nil; s = s.parent {
+		if obj := s.Lookup(name); obj != nil {
+			return obj
+		}
+	}
+	return nil
 }
 
 func (c *Checker) expr(r *result, expr ast.Expr) {
+	r.mode = invalid
+	r.typ = nil
+
 	switch t := expr.(type) {
 	case *ast.Ident:
 		c.ident(r, t)
 	case *ast.BasicLit:
-		c.basicLit(r, t.Kind)
+		c.basicLit(r, t)
 	case *ast.LengthExpr:
-		c.lengthExpr(r, t.Arg)
+		c.lengthExpr(r, t)
 	case *ast.UnaryExpr:
-		c.unaryExpr(r, t.Rhs, t.Op)
+		c.unaryExpr(r, t)
 	case *ast.BinaryExpr:
-		c.binaryExpr(r, t.Lhs, t.Rhs, t.Op)
+		c.binaryExpr(r, t)
 	case *ast.SubscriptExpr:
-		c.subscriptExpr(r, t.Lhs, t.Subscript)
+		c.subscriptExpr(r, t)
 	case *ast.ArrayLit:
-		c.arrayLit(r, t.Elts)
+		c.arrayLit(r, t)
+	case *ast.CallExpr:
+		c.callExpr(r, t)
+	case *ast.BadExpr:
+		// The syntax error was reported by the parser.
+	default:
+		c.errorf(expr, "unexpected expression %T", expr)
+	}
+
+	if r.mode == ok {
+		c.info.Types[expr] = TypeAndValue{Type: r.typ}
 	}
 }
 
-func (c *Checker) basicLit(r *result, kind token.TokenType) {
-	switch kind {
+func (c *Checker) basicLit(r *result, x *ast.BasicLit) {
+	switch x.Kind {
 	case token.String:
-		r.typ = makeArrayType(PredeclaredTyp[Int], 0)
-	case token.Int, token.Char:
+		r.typ = stringType
+	case token.Integer:
+		// The scanner reports literals above 2^63; 2^63 itself is only
+		// valid negated.
+		if n, err := lit.ParseInt(x.Value); err == nil && n < 0 && x != c.negated {
+			c.errorf(x, "integer literal %s out of range", x.Value)
+			return
+		}
+		r.typ = PredeclaredTyp[Int]
+	case token.Char:
 		r.typ = PredeclaredTyp[Int]
 	case token.True, token.False:
 		r.typ = PredeclaredTyp[Bool]
 	default:
-		panic("invalid type for basic literal")
+		c.errorf(x, "invalid literal %s", x.Value)
+		return
 	}
+
+	r.mode = ok
 }
 
-func (c *Checker) lengthExpr(r *result, expr ast.Expr) {
-	c.expr(r, expr)
+func (c *Checker) lengthExpr(r *result, expr *ast.LengthExpr) {
+	if c.expr(r, expr.Arg); r.mode == invalid {
+		return
+	}
 
 	if !isArray(r.typ) {
-		panic("can't take length of non-array value")
+		c.errorf(expr.Arg, "cannot take length of non-array value of type %s", r.typ)
+		r.mode = invalid
+		return
 	}
 
 	r.typ = PredeclaredTyp[Int]
@@ -149,66 +241,192 @@ func (c *Checker) lengthExpr(r *result, expr ast.Expr) {
 }
 
 func (c *Checker) ident(r *result, ident *ast.Ident) {
-	obj := c.scope.Lookup(ident.Name)
-	if obj == nil {
-		panic("not defined")
+	obj := c.lookup(ident.Name)
+	switch obj.(type) {
+	case nil:
+		c.errorf(ident, "undefined: %s", ident.Name)
+		return
+	case *Var:
+		c.info.Uses[ident] = obj
+	default:
+		c.info.Uses[ident] = obj
+		c.errorf(ident, "%s is not a variable", ident.Name)
+		return
+	}
+
+	// Errors in the variable's declared type were reported already.
+	if isBasic(obj.Type(), Invalid) {
+		return
 	}
 
 	r.typ = obj.Type()
 	r.mode = ok
 }
 
-func (c *Checker) unaryExpr(r *result, expr ast.Expr, op token.TokenType) {
-	c.expr(r, expr)
-	c.predicate(r, unopPredicates, op, r.typ)
+func (c *Checker) unaryExpr(r *result, expr *ast.UnaryExpr) {
+	if x, ok := expr.Rhs.(*ast.BasicLit); ok && expr.Op == token.Sub {
+		c.negated = x
+	}
+	if c.expr(r, expr.Rhs); r.mode == invalid {
+		return
+	}
+
+	if !c.predicate(r, expr, unopPredicates, expr.Op, r.typ) {
+		r.mode = invalid
+		return
+	}
+
 	r.mode = ok
 }
 
-func (c *Checker) binaryExpr(r *result, lhs, rhs ast.Expr, op token.TokenType) {
+func (c *Checker) binaryExpr(r *result, expr *ast.BinaryExpr) {
 	var r2 result
 
-	c.expr(r, lhs)
-	c.expr(&r2, rhs)
+	c.expr(r, expr.Lhs)
+	c.expr(&r2, expr.Rhs)
+	if r.mode == invalid || r2.mode == invalid {
+		r.mode = invalid
+		return
+	}
+
+	typ, joined := join(r.typ, r2.typ)
+	if !joined {
+		c.errorf(expr, "mismatched types %s and %s for operator %s", r.typ, r2.typ, expr.Op)
+		r.mode = invalid
+		return
+	}
 
-	if !TypeEqual(r.typ, r2.typ) {
-		panic("types in binary expr not equal")
+	if !c.predicate(r, expr, binopPredicates, expr.Op, typ) {
+		r.mode = invalid
+		return
 	}
 
-	c.predicate(r, binopPredicates, op, r.typ)
+	if comparisons[expr.Op] {
+		typ = PredeclaredTyp[Bool]
+	}
+
+	r.typ = typ
 	r.mode = ok
 }
 
-func (c *Checker) subscriptExpr(r *result, lhs, subscript ast.Expr) {
-	c.expr(r, subscript)
-	if !isInt(r.typ) {
-		panic("cannot subscript an array with a non-integer value")
+func (c *Checker) subscriptExpr(r *result, expr *ast.SubscriptExpr) {
+	var index result
+
+	c.expr(r, expr.Lhs)
+	c.expr(&index, expr.Subscript)
+
+	if index.mode != invalid && !isInt(index.typ) {
+		c.errorf(expr.Subscript, "cannot index an array with a value of type %s", index.typ)
 	}
 
-	c.expr(r, lhs)
-	if !isArray(r.typ) {
-		panic("cannot subscript an non-array")
+	if r.mode == invalid {
+		return
+	}
+
+	if !isArray(r.typ) || underlying(r.typ) == nil {
+		c.errorf(expr.Lhs, "cannot index a value of type %s", r.typ)
+		r.mode = invalid
+		return
 	}
 
 	r.typ = underlying(r.typ)
 	r.mode = ok
 }
 
-func (c *Checker) arrayLit(r *result, elts []ast.Expr) {
-	if len(elts) == 0 {
-		r.typ = nil
-		r.mode = unknown
+func (c *Checker) arrayLit(r *result, lit *ast.ArrayLit) {
+	var (
+		elem  Type
+		valid = true
+		y     result
+	)
+
+	for _, elt := range lit.Elts {
+		if c.expr(&y, elt); y.mode == invalid {
+			valid = false
+			continue
+		}
+
+		if elem == nil {
+			elem = y.typ
+			continue
+		}
+
+		joined, ok := join(elem, y.typ)
+		if !ok {
+			c.errorf(elt, "mismatched array element types %s and %s", elem, y.typ)
+			valid = false
+			continue
+		}
+		elem = joined
+	}
+
+	if !valid {
 		return
 	}
 
-	c.expr(r, elts[0])
+	r.typ = NewArray(elem)
+	r.mode = ok
+}
+
+// call checks a function call and returns the function's results, or nil if
+// the call is invalid.
+func (c *Checker) call(call *ast.CallExpr) *Tuple {
+	obj := c.lookup(call.Func.Name)
+	if obj != nil {
+		c.info.Uses[call.Func] = obj
+	}
+
+	var fn *Func
+	switch t := obj.(type) {
+	case nil:
+		c.errorf(call.Func, "undefined: %s", call.Func.Name)
+	case *Func:
+		fn = t
+	default:
+		c.errorf(call.Func, "cannot call non-function %s", call.Func.Name)
+	}
+
+	var args []result
+	for _, arg := range call.Args {
+		var r result
+		c.expr(&r, arg)
+		args = append(args, r)
+	}
+
+	if fn == nil {
+		return nil
+	}
+
+	sig := fn.Signature()
+	if len(args) != sig.parameters.Len() {
+		c.errorf(call, "wrong number of arguments in call to %s: have %d, want %d",
+			fn.name, len(args), sig.parameters.Len())
+		return sig.returns
+	}
 
-	var y result
-	for _, elt := range elts[1:] {
-		c.expr(&y, elt)
-		if !TypeEqual(r.typ, y.typ) {
-			panic("mismatched array elements")
+	for i, arg := range args {
+		want := sig.parameters.At(i)
+		if arg.mode != invalid && !AssignableTo(arg.typ, want) {
+			c.errorf(call.Args[i], "cannot use value of type %s as %s argument to %s", arg.typ, want, fn.name)
 		}
 	}
 
-	r.mode = ok
+	return sig.returns
+}
+
+func (c *Checker) callExpr(r *result, call *ast.CallExpr) {
+	results := c.call(call)
+	if results == nil {
+		return
+	}
+
+	switch results.Len() {
+	case 0:
+		c.errorf(call, "%s has no results and cannot be used as a value", call.Func.Name)
+	case 1:
+		r.typ = results.At(0)
+		r.mode = ok
+	default:
+		c.errorf(call, "multiple-value %s in single-value context", call.Func.Name)
+	}
 }
@@ -0,0 +1,570 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/parser"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+type checkTest struct {
+	name   string
+	src    string
+	errors []string // expected error message substrings, in order
+}
+
+var checkTests = []checkTest{
+	{"sort", `
+sort(a: int[]) {
+	i:int = 0
+	n:int = length(a)
+	while (i < n) {
+		j:int = i
+		while (j > 0) {
+			if (a[j-1] > a[j]) {
+				swap:int = a[j]
+				a[j] = a[j-1]
+				a[j-1] = swap
+			}
+			j = j-1
+		}
+		i = i+1
+	}
+}
+`, nil},
+	{"multiple results", `
+divmod(a: int, b: int): int, int {
+	return a / b, a % b
+}
+
+main(args: int[][]) {
+	q:int, _ = divmod(7, 2)
+	_, r:int = divmod(7, 2)
+	s:int[] = "str" + {'a', 'b'}
+	m:int[][] = {}
+	m = {{}, {1, 2}}
+	b:bool = s == {} | q != r
+}
+`, nil},
+	{"mutual recursion", `
+even(n: int): bool {
+	if (n == 0) return true else return odd(n - 1)
+}
+
+odd(n: int): bool {
+	if (n == 0) { return false }
+	return even(n - 1)
+}
+`, nil},
+	{"errors", `
+f(x: int): int {
+	y:bool = x
+	z:int = w
+	x = true
+	if (x) { }
+	while (1 + 2) { }
+	return x, x
+}
+
+g() {
+	a:int, b:bool = f(1)
+	f(1)
+	c:int = g()
+	d:int = length(3)
+	e:int = f(1, 2)
+}
+`, []string{
+		"cannot use value of type int as bool",
+		"undefined: w",
+		"cannot use value of type bool as int",
+		"non-bool condition of type int",
+		"non-bool condition of type int",
+		"wrong number of return values: have 2, want 1",
+		"assignment mismatch: 2 variables but f returns 1 values",
+		"result of f is not used",
+		"g has no results and cannot be used as a value",
+		"cannot take length of non-array value of type int",
+		"wrong number of arguments in call to f: have 2, want 1",
+	}},
+	{"scopes", `
+f(x: int) {
+	x:int = 1
+	if (true) y:int = 2
+	y = 3
+	f:int = 0
+}
+
+f() { }
+`, []string{
+		"x redeclared in this block",
+		"undefined: y",
+		"f redeclared in this block",
+		"f redeclared",
+	}},
+	{"operators", `
+f(a: int[], b: bool[]) {
+	x:int = a * 2
+	y:bool = a == b
+	z:int = -true
+	w:bool = !1
+	v:int[] = a + b
+	u:int = a[true]
+	s:int = 3 *>> -2 *>> 1
+	t:bool = true *>> false
+}
+`, []string{
+		"mismatched types int[] and int for operator *",
+		"mismatched types int[] and bool[] for operator ==",
+		"operator - not defined on bool",
+		"operator ! not defined on int",
+		"mismatched types int[] and bool[] for operator +",
+		"cannot index an array with a value of type bool",
+		"operator *>> not defined on bool",
+	}},
+	{"min int", `
+f(): int {
+	x:int = -9223372036854775808
+	y:int = - -9223372036854775808
+	return 9223372036854775807
+}
+`, nil},
+	{"integer out of range", `
+f(a: int) {
+	x:int = 9223372036854775808
+	y:int = a - 9223372036854775808
+	z:int = -(9223372036854775808 + 1)
+}
+`, []string{
+		"integer literal 9223372036854775808 out of range",
+		"integer literal 9223372036854775808 out of range",
+		"integer literal 9223372036854775808 out of range",
+	}},
+	{"missing return", `
+f(x: int): int {
+	if (x > 0) return 1
+}
+
+g(x: int): int {
+	return 1
+	x = 2
+}
+`, []string{
+		"missing return in f",
+		"unreachable statement after return",
+		"missing return in g",
+	}},
+	{"array sizes", `
+f(a: int[3]) {
+	b:int[][3]
+	c:int[3][] = {}
+	d:int[true]
+	e:int[2][3]
+}
+`, []string{
+		"array size not permitted here",
+		"array size not permitted after an unsized dimension",
+		"cannot initialize an array declared with a size",
+		"array size must be an int, not bool",
+	}},
+}
+
+func TestCheck(t *testing.T) {
+	for _, test := range checkTests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, test.name+".xi", test.src, 0)
+		if err != nil {
+			t.Errorf("%s: parse error: %v", test.name, err)
+			continue
+		}
+
+		var reported []Error
+		conf := &Config{Error: func(err Error) { reported = append(reported, err) }}
+
+		_, err = Check(fset, []*ast.File{f}, conf)
+		if len(test.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected errors:\n%v", test.name, err)
+			}
+			continue
+		}
+
+		list, _ := err.(ErrorList)
+		if len(list) != len(reported) {
+			t.Errorf("%s: Check returned %d errors, but %d were reported", test.name, len(list), len(reported))
+		}
+
+		if len(list) != len(test.errors) {
+			t.Errorf("%s: got %d errors, expected %d:\n%v", test.name, len(list), len(test.errors), err)
+			continue
+		}
+
+		for i, want := range test.errors {
+			if !strings.Contains(list[i].Msg, want) {
+				t.Errorf("%s: error %d: got %q, expected %q", test.name, i, list[i].Msg, want)
+			}
+		}
+	}
+}
+
+func TestInfoTypes(t *testing.T) {
+	src := `f(a: int[][]): int { return length(a[0]) + 'c' }`
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "info.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Check(fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := f.FuncDecls[0].Body.List[0].(*ast.ReturnStmt)
+	sum := ret.Values[0].(*ast.BinaryExpr)
+	length := sum.Lhs.(*ast.LengthExpr)
+
+	for _, test := range []struct {
+		expr ast.Expr
+		want string
+	}{
+		{sum, "int"},
+		{length, "int"},
+		{length.Arg, "int[]"},
+		{length.Arg.(*ast.SubscriptExpr).Lhs, "int[][]"},
+		{sum.Rhs, "int"},
+	} {
+		if got := TypeString(info.TypeOf(test.expr)); got != test.want {
+			t.Errorf("type of %T: got %s, expected %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestInfoObjects(t *testing.T) {
+	src := `f(n: int): int {
+	x:int = n
+	x = g(x, n)
+	return x
+}
+g(a: int, b: int): int { return a + b }
+`
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "objects.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Check(fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Render each identifier as name@line:col -> line:col of its object.
+	var defs, uses []string
+	for id, obj := range info.Defs {
+		defs = append(defs, fmt.Sprintf("%s@%s", id.Name, fset.Position(obj.Position())))
+	}
+	for id, obj := range info.Uses {
+		uses = append(uses, fmt.Sprintf("%s@%s->%s", id.Name, fset.Position(id.Pos()), fset.Position(obj.Position())))
+	}
+	sort.Strings(defs)
+	sort.Strings(uses)
+
+	wantDefs := []string{
+		"a@objects.xi:6:3",
+		"b@objects.xi:6:11",
+		"f@objects.xi:1:1",
+		"g@objects.xi:6:1",
+		"n@objects.xi:1:3",
+		"x@objects.xi:2:2",
+	}
+	wantUses := []string{
+		"a@objects.xi:6:33->objects.xi:6:3",
+		"b@objects.xi:6:37->objects.xi:6:11",
+		"g@objects.xi:3:6->objects.xi:6:1",
+		"n@objects.xi:2:10->objects.xi:1:3",
+		"n@objects.xi:3:11->objects.xi:1:3",
+		"x@objects.xi:3:2->objects.xi:2:2",
+		"x@objects.xi:3:8->objects.xi:2:2",
+		"x@objects.xi:4:9->objects.xi:2:2",
+	}
+	if fmt.Sprint(defs) != fmt.Sprint(wantDefs) {
+		t.Errorf("Defs:\ngot      %v\nexpected %v", defs, wantDefs)
+	}
+	if fmt.Sprint(uses) != fmt.Sprint(wantUses) {
+		t.Errorf("Uses:\ngot      %v\nexpected %v", uses, wantUses)
+	}
+}
+
+func TestInfoScopes(t *testing.T) {
+	src := `pair(): int, int { return 1, 2 }
+
+f(a: int) {
+	x:int = a
+	if (a > 0) {
+		y:int = x
+	} else
+		z:int = x
+	while (x > 0) x = x - 1
+	_, b:int = pair()
+}
+`
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "scopes.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Check(fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileScope := info.Scopes[f]
+	if fileScope == nil || fileScope.Parent() == nil || fileScope.Parent().Parent() != Universe {
+		t.Fatal("file scope is not nested in a package scope")
+	}
+	if got := fileScope.Parent().Names(); fmt.Sprint(got) != "[f pair]" {
+		t.Errorf("package scope holds %v", got)
+	}
+
+	decl := f.FuncDecls[1]
+	ifStmt := decl.Body.List[1].(*ast.IfStmt)
+	whileStmt := decl.Body.List[2].(*ast.WhileStmt)
+
+	for _, test := range []struct {
+		node  ast.Node
+		names string
+	}{
+		{decl, "[a b x]"},
+		{ifStmt.Then, "[y]"},
+		{ifStmt.Else, "[z]"},
+		{whileStmt.Body, "[]"},
+	} {
+		scope := info.Scopes[test.node]
+		if scope == nil {
+			t.Errorf("no scope recorded for %T", test.node)
+			continue
+		}
+		if got := fmt.Sprint(scope.Names()); got != test.names {
+			t.Errorf("%T scope: got %s, expected %s", test.node, got, test.names)
+		}
+	}
+
+	if n := len(info.Scopes); n != 6 {
+		t.Errorf("got %d scopes, expected 6", n)
+	}
+
+	for _, test := range []struct {
+		pos  token.Pos
+		want ast.Node
+	}{
+		{ifStmt.Then.(*ast.BlockStmt).List[0].Pos(), ifStmt.Then},
+		{ifStmt.Else.Pos(), ifStmt.Else},
+		{whileStmt.Cond.Pos(), decl},
+		{f.FuncDecls[0].Body.Pos(), f.FuncDecls[0]},
+		{token.Pos(fset.File(f.Pos()).Base() + len(src) - 1), f}, // final newline
+	} {
+		if got := fileScope.Parent().Innermost(test.pos); got != info.Scopes[test.want] {
+			t.Errorf("innermost scope at %s is not the one of %T", fset.Position(test.pos), test.want)
+		}
+	}
+
+	multi := decl.Body.List[3].(*ast.MultiDeclStmt)
+	obj := info.Implicits[multi.Assignables[0]]
+	if obj == nil || obj.Name() != "_" || TypeString(obj.Type()) != "int" {
+		t.Errorf("got implicit object %v for _, expected an int variable", obj)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	src := "f(): int {\n\tx:int = true\n}\n"
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "positions.xi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Check(fset, []*ast.File{f}, nil)
+	list, _ := err.(ErrorList)
+	if len(list) != 2 {
+		t.Fatalf("got %d errors, expected 2:\n%v", len(list), err)
+	}
+
+	for i, want := range []string{"positions.xi:2:10: cannot use value of type bool as int", "positions.xi:3:1: missing return in f"} {
+		if got := list[i].Error(); got != want {
+			t.Errorf("error %d: got %q, expected %q", i, got, want)
+		}
+	}
+}
+
+// mapImporter imports interfaces from source held in memory.
+type mapImporter struct {
+	fset    *token.FileSet
+	sources map[string]string
+}
+
+func (imp *mapImporter) Import(name string) (*Library, error) {
+	src, ok := imp.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("no library %s", name)
+	}
+
+	iface, err := parser.ParseInterface(imp.fset, name+".ixi", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return CheckInterface(imp.fset, name, iface, nil)
+}
+
+func TestUse(t *testing.T) {
+	sources := map[string]string{
+		"io":   "print(s: int[])\nprintln(s: int[])\n",
+		"conv": "parseInt(s: int[]): int, bool\nunparseInt(n: int): int[]\n",
+		"alt":  "print(s: int[]): bool\n",
+	}
+
+	for _, test := range []struct {
+		name   string
+		src    string
+		errors []string
+	}{
+		{
+			"imported functions",
+			"use io\nuse conv\nmain(args: int[][]) {\n\tn:int, ok:bool = parseInt(args[0])\n\tif (ok) println(unparseInt(n))\n}\n",
+			nil,
+		},
+		{
+			"not used",
+			"main(args: int[][]) { print(\"hi\") }\n",
+			[]string{"undefined: print"},
+		},
+		{
+			"missing library",
+			"use nope\nmain(args: int[][]) {}\n",
+			[]string{"could not import nope (no library nope)"},
+		},
+		{
+			"matching redeclaration",
+			"use io\nprint(s: int[]) {}\n",
+			nil,
+		},
+		{
+			"conflicting redeclaration",
+			"use io\nprint(s: int) {}\n",
+			[]string{"print in io has type function (int[]) (), which conflicts with function (int) ()"},
+		},
+		{
+			"conflicting libraries",
+			"use io\nuse alt\nmain(args: int[][]) {}\n",
+			[]string{"print in alt has type function (int[]) (bool), which conflicts with function (int[]) ()"},
+		},
+		{
+			"wrong arguments",
+			"use io\nmain(args: int[][]) { print(1) }\n",
+			[]string{"cannot use value of type int as int[] argument to print"},
+		},
+	} {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "use.xi", test.src, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var got []string
+		conf := &Config{
+			Importer: &mapImporter{fset, sources},
+			Error:    func(err Error) { got = append(got, err.Msg) },
+		}
+		Check(fset, []*ast.File{f}, conf)
+
+		if fmt.Sprint(got) != fmt.Sprint(test.errors) {
+			t.Errorf("%s: got errors %q, expected %q", test.name, got, test.errors)
+		}
+	}
+}
+
+func TestUseWithoutImporter(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "use.xi", "use io\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Check(fset, []*ast.File{f}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot import io: no importer configured") {
+		t.Errorf("got %v, expected an import error", err)
+	}
+}
+
+func TestCheckInterface(t *testing.T) {
+	src := "f(a: int, a: int)\ng(x: int[3])\nf()\nh(s: int[]): int[][], bool\n"
+	fset := token.NewFileSet()
+	iface, err := parser.ParseInterface(fset, "lib.ixi", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := CheckInterface(fset, "lib", iface, nil)
+
+	var got []string
+	for _, e := range err.(ErrorList) {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"lib.ixi:1:11: a redeclared in this block",
+		"lib.ixi:2:10: array size not permitted here",
+		"lib.ixi:3:1: f redeclared",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got errors %q, expected %q", got, want)
+	}
+
+	var funcs []string
+	for _, fn := range lib.Funcs() {
+		funcs = append(funcs, fn.Name()+": "+TypeString(fn.Type()))
+	}
+	wantFuncs := []string{
+		"f: function (int,int) ()",
+		"g: function (int[]) ()",
+		"h: function (int[]) (int[][],bool)",
+	}
+	if fmt.Sprint(funcs) != fmt.Sprint(wantFuncs) {
+		t.Errorf("got %q, expected %q", funcs, wantFuncs)
+	}
+}
+
+// TestConcurrentCheck checks files in parallel, as the language server
+// does for open documents. Run with -race, it catches state shared
+// between checks.
+func TestConcurrentCheck(t *testing.T) {
+	src := `f(): int[], int[][] {
+	s:int[] = "abc" + "d"
+	a:int[][] = {"x", s}
+	return s, a
+}
+`
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, "test.xi", src, 0)
+			if err == nil {
+				_, err = Check(fset, []*ast.File{f}, nil)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -1,14 +1,136 @@
 package types
 
 import (
-	"fmt"
-	"go/ast"
-
+	"github.com/manapointer/xi/pkg/ast"
 	"github.com/manapointer/xi/pkg/token"
 )
 
-func (c *Checker) declare(s *Scope, ident *ast.Ident, obj Object, pos token.Position) {
+func (c *Checker) declare(s *Scope, ident *ast.Ident, obj Object, pos token.Pos) {
+	c.info.Defs[ident] = obj
 	if !s.Insert(obj) {
-		fmt.Println("duplicate declaration")
+		c.errorf(ident, "%s redeclared", ident.Name)
+	}
+}
+
+// declareVar declares a local variable or parameter in the current scope.
+// Xi does not allow shadowing, so the name must not be visible yet.
+func (c *Checker) declareVar(ident *ast.Ident, typ Type) {
+	if alt := c.lookup(ident.Name); alt != nil {
+		if _, ok := alt.(*TypeName); !ok {
+			c.errorf(ident, "%s redeclared in this block", ident.Name)
+			return
+		}
+	}
+
+	c.declare(c.scope, ident, NewVar(ident.Pos(), ident.Name, typ), ident.Pos())
+}
+
+func (c *Checker) files(files []*ast.File) {
+	c.pkg = NewScope(Universe, token.NoPos, token.NoPos)
+
+	// Collect all signatures first so functions may be called before
+	// they are declared.
+	objs := make(map[*ast.FuncDecl]*Func)
+	for _, file := range files {
+		for _, decl := range file.FuncDecls {
+			c.scope = c.pkg
+			obj := NewFunc(decl.Name.Pos(), decl.Name.Name, c.funcType(decl))
+			c.declare(c.pkg, decl.Name, obj, decl.Name.Pos())
+			objs[decl] = obj
+		}
+	}
+
+	for _, file := range files {
+		c.scope = c.pkg
+		c.fileScope(file)
+		c.useDecls(file.UseDecls)
+		for _, decl := range file.FuncDecls {
+			c.funcBody(decl, objs[decl])
+		}
+	}
+}
+
+// fileScope opens the scope of file, which spans the whole source file
+// rather than just its declarations.
+func (c *Checker) fileScope(file *ast.File) {
+	pos, end := file.Pos(), file.End()
+	if f := c.fset.File(pos); f != nil {
+		pos, end = token.Pos(f.Base()), token.Pos(f.Base()+f.Size())
+	}
+	c.scope = NewScope(c.pkg, pos, end)
+	c.info.Scopes[file] = c.scope
+}
+
+func (c *Checker) funcType(decl *ast.FuncDecl) *Signature {
+	var params, results []Type
+
+	for _, arg := range decl.Args {
+		params = append(params, c.typ(arg.Type, false))
+	}
+
+	for _, typ := range decl.Results {
+		results = append(results, c.typ(typ, false))
+	}
+
+	return NewSignature(NewTuple(params...), NewTuple(results...))
+}
+
+// typ converts an ast.Type into a Type. Array sizes are only permitted in
+// variable declarations, and only in the outermost dimensions.
+func (c *Checker) typ(typ ast.Type, sizes bool) Type {
+	switch t := typ.(type) {
+	case *ast.PrimitiveType:
+		switch t.Kind {
+		case token.Int:
+			return PredeclaredTyp[Int]
+		case token.Bool:
+			return PredeclaredTyp[Bool]
+		}
+	case *ast.ArrayType:
+		if t.Size != nil {
+			if !sizes {
+				c.errorf(t.Size, "array size not permitted here")
+			} else {
+				var r result
+				if c.expr(&r, t.Size); r.mode != invalid && !isInt(r.typ) {
+					c.errorf(t.Size, "array size must be an int, not %s", r.typ)
+				}
+			}
+
+			// The brackets are nested innermost-first, so the sized
+			// dimensions must all be inside this one: int[][3] is invalid.
+			if elt, ok := t.Elt.(*ast.ArrayType); ok && elt.Size == nil && sizes {
+				c.errorf(t.Size, "array size not permitted after an unsized dimension")
+			}
+		}
+		return NewArray(c.typ(t.Elt, sizes))
+	case *ast.BadExpr:
+		return PredeclaredTyp[Invalid]
+	}
+
+	c.errorf(typ, "invalid type")
+	return PredeclaredTyp[Invalid]
+}
+
+func (c *Checker) funcBody(decl *ast.FuncDecl, obj *Func) {
+	c.sig = obj.Signature()
+	defer func() { c.sig = nil }()
+
+	// The parameters and the top level of the body share a scope.
+	c.openScope(decl)
+	defer c.closeScope()
+
+	for i, arg := range decl.Args {
+		c.declareVar(arg.Name, c.sig.parameters.At(i))
+	}
+
+	if decl.Body == nil {
+		return
+	}
+
+	c.stmtList(decl.Body.List)
+
+	if c.sig.returns.Len() > 0 && !isTerminating(decl.Body) {
+		c.errorfAt(decl.Body.Rbrace, "missing return in %s", decl.Name.Name)
 	}
 }
@@ -0,0 +1,99 @@
+package types
+
+import (
+	"sort"
+
+	"github.com/manapointer/xi/pkg/ast"
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// A Library is the set of functions declared by an interface (.ixi) file.
+// Programs bring them into scope with a use declaration.
+type Library struct {
+	name  string
+	scope *Scope
+}
+
+// NewLibrary returns a new, empty library with the given name.
+func NewLibrary(name string) *Library {
+	return &Library{name: name, scope: NewScope(nil, token.NoPos, token.NoPos)}
+}
+
+func (lib *Library) Name() string { return lib.name }
+
+// Scope returns the scope holding the library's *Func objects.
+func (lib *Library) Scope() *Scope { return lib.scope }
+
+// Funcs returns the library's functions, sorted by name.
+func (lib *Library) Funcs() []*Func {
+	var funcs []*Func
+	for _, name := range lib.scope.Names() {
+		funcs = append(funcs, lib.scope.Lookup(name).(*Func))
+	}
+	return funcs
+}
+
+// An Importer resolves the library names of use declarations.
+type Importer interface {
+	// Import returns the library with the given name.
+	Import(name string) (*Library, error)
+}
+
+// CheckInterface type-checks the signatures in an interface file, which
+// must have been parsed using fset, and returns the library it declares.
+// Errors are reported as by Check.
+func CheckInterface(fset *token.FileSet, name string, iface *ast.Interface, conf *Config) (*Library, error) {
+	if conf == nil {
+		conf = &Config{}
+	}
+
+	c := newChecker(fset, conf)
+	lib := NewLibrary(name)
+
+	for _, decl := range iface.FuncDecls {
+		c.scope = lib.scope
+		obj := NewFunc(decl.Name.Pos(), decl.Name.Name, c.funcType(decl))
+		c.declare(lib.scope, decl.Name, obj, decl.Name.Pos())
+
+		// Without a body, this only checks the parameter names.
+		c.funcBody(decl, obj)
+	}
+
+	sort.Stable(c.errors)
+	return lib, c.errors.Err()
+}
+
+// useDecls imports the libraries named by the use declarations of a file
+// into the current (file) scope. A function may be both declared in the
+// program and imported, or imported from several libraries, as long as
+// the signatures agree.
+func (c *Checker) useDecls(decls []*ast.UseDecl) {
+	for _, decl := range decls {
+		name := decl.Lib.Name
+		if c.conf.Importer == nil {
+			c.errorf(decl.Lib, "cannot import %s: no importer configured", name)
+			continue
+		}
+
+		lib, err := c.conf.Importer.Import(name)
+		if err != nil {
+			c.errorf(decl.Lib, "could not import %s (%v)", name, err)
+			continue
+		}
+
+		for _, fn := range lib.Funcs() {
+			_, alt := c.scope.LookupParent(fn.Name(), token.NoPos)
+			switch alt := alt.(type) {
+			case nil:
+				c.scope.Insert(fn)
+			case *Func:
+				if !TypeEqual(alt.Type(), fn.Type()) {
+					c.errorf(decl.Lib, "%s in %s has type %s, which conflicts with %s",
+						fn.Name(), name, fn.Type(), alt.Type())
+				}
+			default:
+				c.errorf(decl.Lib, "%s in %s conflicts with %s", fn.Name(), name, alt.Name())
+			}
+		}
+	}
+}
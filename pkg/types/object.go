@@ -5,7 +5,7 @@ import "github.com/manapointer/xi/pkg/token"
 type Object interface {
 	Parent() *Scope
 	Name() string
-	Position() token.Position
+	Position() token.Pos
 	Type() Type
 
 	setParent(*Scope)
@@ -14,39 +14,51 @@ type Object interface {
 type object struct {
 	name   string
 	parent *Scope
-	pos    token.Position
+	pos    token.Pos
 	typ    Type
 }
 
-func (obj *object) Parent() *Scope           { return obj.parent }
-func (obj *object) Name() string             { return obj.name }
-func (obj *object) Position() token.Position { return obj.pos }
-func (obj *object) Type() Type               { return obj.typ }
+func (obj *object) Parent() *Scope      { return obj.parent }
+func (obj *object) Name() string        { return obj.name }
+func (obj *object) Position() token.Pos { return obj.pos }
+func (obj *object) Type() Type          { return obj.typ }
 
 func (obj *object) setParent(parent *Scope) {
 	obj.parent = parent
 }
 
-func (obj *object) setPosition(pos token.Position) {
+func (obj *object) setPosition(pos token.Pos) {
 	obj.pos = pos
 }
 
+// A Func represents a declared function or procedure.
 type Func struct {
 	object
 }
 
+func NewFunc(pos token.Pos, name string, sig *Signature) *Func {
+	return &Func{object{name, nil, pos, sig}}
+}
+
+func (obj *Func) Signature() *Signature { return obj.typ.(*Signature) }
+
 type Builtin struct {
 	object
 }
 
+// A Var represents a local variable or function parameter.
 type Var struct {
 	object
 }
 
+func NewVar(pos token.Pos, name string, typ Type) *Var {
+	return &Var{object{name, nil, pos, typ}}
+}
+
 type TypeName struct {
 	object
 }
 
-func NewTypeName(pos token.Position, name string, typ Type) *TypeName {
+func NewTypeName(pos token.Pos, name string, typ Type) *TypeName {
 	return &TypeName{object{name, nil, pos, typ}}
 }
@@ -1,25 +1,99 @@
 package types
 
-import "github.com/manapointer/xi/pkg/token"
+import (
+	"sort"
 
+	"github.com/manapointer/xi/pkg/token"
+)
+
+// A Scope maintains a set of objects and links to its containing (parent)
+// and contained (children) scopes.
 type Scope struct {
-	parent *Scope
-	elems  map[string]Object
+	parent   *Scope
+	children []*Scope
+	elems    map[string]Object
+	pos, end token.Pos // scope extent; may be invalid
+}
+
+// NewScope returns a new, empty scope contained in the given parent scope,
+// if any, and covering the source range [pos, end].
+func NewScope(parent *Scope, pos, end token.Pos) *Scope {
+	s := &Scope{parent: parent, elems: make(map[string]Object), pos: pos, end: end}
+	// The Universe is shared by all checks, so it does not keep track of
+	// the package scopes below it.
+	if parent != nil && parent != Universe {
+		parent.children = append(parent.children, s)
+	}
+	return s
+}
+
+// Parent returns the scope's containing (parent) scope.
+func (s *Scope) Parent() *Scope { return s.parent }
+
+// Len returns the number of scope elements.
+func (s *Scope) Len() int { return len(s.elems) }
+
+// Names returns the scope's element names in sorted order.
+func (s *Scope) Names() []string {
+	names := make([]string, 0, len(s.elems))
+	for name := range s.elems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func NewScope(parent *Scope) *Scope {
-	return &Scope{parent: parent, elems: make(map[string]Object)}
+// NumChildren returns the number of scopes nested in s.
+func (s *Scope) NumChildren() int { return len(s.children) }
+
+// Child returns the i'th child scope for 0 <= i < NumChildren().
+func (s *Scope) Child(i int) *Scope { return s.children[i] }
+
+// Pos and End describe the scope's source code extent [pos, end]. The
+// results are only valid if the scope has a corresponding syntax node.
+func (s *Scope) Pos() token.Pos { return s.pos }
+func (s *Scope) End() token.Pos { return s.end }
+
+// Contains reports whether pos is within the scope's extent.
+func (s *Scope) Contains(pos token.Pos) bool {
+	return s.pos <= pos && pos < s.end
+}
+
+// Innermost returns the innermost (child) scope containing pos. If pos is
+// not within any scope, the result is nil. The package scope has no extent
+// of its own, so for it only the children are considered.
+func (s *Scope) Innermost(pos token.Pos) *Scope {
+	for _, child := range s.children {
+		if child.Contains(pos) {
+			return child.Innermost(pos)
+		}
+	}
+	if s.Contains(pos) {
+		return s
+	}
+	return nil
 }
 
 func (s *Scope) Lookup(name string) Object {
 	return s.elems[name]
 }
 
-func (s *Scope) LookupParent(name string, pos token.Position) (*Scope, Object) {
+// LookupParent follows the parent chain of scopes starting with s until it
+// finds a scope where Lookup(name) returns a non-nil object, and then
+// returns that scope and object. If a valid position pos is provided, only
+// variables that were declared at or before pos are considered; functions
+// are visible throughout the file. If no such scope and object exists, the
+// result is (nil, nil).
+func (s *Scope) LookupParent(name string, pos token.Pos) (*Scope, Object) {
 	for ; s != nil; s = s.parent {
-		if obj, ok := s.elems[name]; ok && obj.Position().Compare(pos) != 1 {
-			return s, obj
+		obj, ok := s.elems[name]
+		if !ok {
+			continue
+		}
+		if _, isVar := obj.(*Var); isVar && pos.IsValid() && obj.Position() > pos {
+			continue
 		}
+		return s, obj
 	}
 
 	return nil, nil
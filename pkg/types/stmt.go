@@ -0,0 +1,183 @@
+package types
+
+import (
+	"github.com/manapointer/xi/pkg/ast"
+)
+
+func (c *Checker) stmtList(list []ast.Stmt) {
+	for i, stmt := range list {
+		if _, ok := stmt.(*ast.ReturnStmt); ok && i != len(list)-1 {
+			c.errorf(list[i+1], "unreachable statement after return")
+		}
+		c.stmt(stmt)
+	}
+}
+
+func (c *Checker) stmt(stmt ast.Stmt) {
+	switch t := stmt.(type) {
+	case *ast.SingleDeclStmt:
+		c.singleDeclStmt(t)
+	case *ast.MultiDeclStmt:
+		c.multiDeclStmt(t)
+	case *ast.AssignStmt:
+		c.assignStmt(t)
+	case *ast.IfStmt:
+		c.condition(t.Cond)
+		c.scopedStmt(t.Then)
+		if t.Else != nil {
+			c.scopedStmt(t.Else)
+		}
+	case *ast.WhileStmt:
+		c.condition(t.Cond)
+		c.scopedStmt(t.Body)
+	case *ast.ReturnStmt:
+		c.returnStmt(t)
+	case *ast.BlockStmt:
+		c.scopedStmt(t)
+	case *ast.BadStmt:
+		// The syntax error was reported by the parser.
+	case *ast.CallExpr:
+		if results := c.call(t); results != nil && results.Len() > 0 {
+			c.errorf(t, "result of %s is not used", t.Func.Name)
+		}
+	default:
+		c.errorf(stmt, "unexpected statement %T", stmt)
+	}
+}
+
+// scopedStmt checks stmt in a fresh scope, so that declarations in the
+// branches of if and while statements do not leak out of them. The scope
+// is recorded for stmt even if it is not a block.
+func (c *Checker) scopedStmt(stmt ast.Stmt) {
+	c.openScope(stmt)
+	defer c.closeScope()
+
+	if block, ok := stmt.(*ast.BlockStmt); ok {
+		c.stmtList(block.List)
+		return
+	}
+	c.stmt(stmt)
+}
+
+func (c *Checker) condition(cond ast.Expr) {
+	var r result
+	if c.expr(&r, cond); r.mode != invalid && !isBool(r.typ) {
+		c.errorf(cond, "non-bool condition of type %s", r.typ)
+	}
+}
+
+// assign reports an error unless the value r may be stored in a location of
+// type typ.
+func (c *Checker) assign(r *result, at ast.Node, typ Type) {
+	if r.mode == invalid || isBasic(typ, Invalid) {
+		return
+	}
+
+	if !AssignableTo(r.typ, typ) {
+		c.errorf(at, "cannot use value of type %s as %s", r.typ, typ)
+	}
+}
+
+func hasSize(typ ast.Type) bool {
+	t, ok := typ.(*ast.ArrayType)
+	for ok {
+		if t.Size != nil {
+			return true
+		}
+		t, ok = t.Elt.(*ast.ArrayType)
+	}
+	return false
+}
+
+func (c *Checker) singleDeclStmt(stmt *ast.SingleDeclStmt) {
+	typ := c.typ(stmt.Spec.Type, true)
+
+	if stmt.Init != nil {
+		if hasSize(stmt.Spec.Type) {
+			c.errorf(stmt.Init, "cannot initialize an array declared with a size")
+		}
+
+		var r result
+		c.expr(&r, stmt.Init)
+		c.assign(&r, stmt.Init, typ)
+	}
+
+	c.declareVar(stmt.Spec.Name, typ)
+}
+
+func (c *Checker) multiDeclStmt(stmt *ast.MultiDeclStmt) {
+	results := c.call(stmt.Init)
+
+	if results != nil && results.Len() != len(stmt.Assignables) {
+		c.errorf(stmt, "assignment mismatch: %d variables but %s returns %d values",
+			len(stmt.Assignables), stmt.Init.Func.Name, results.Len())
+		results = nil
+	}
+
+	for i, assignable := range stmt.Assignables {
+		spec, isSpec := assignable.(*ast.Spec)
+		if !isSpec {
+			if results != nil {
+				c.info.Implicits[assignable] = NewVar(assignable.Pos(), "_", results.At(i))
+			}
+			continue
+		}
+
+		typ := c.typ(spec.Type, false)
+		if results != nil {
+			r := result{mode: ok, typ: results.At(i)}
+			c.assign(&r, spec, typ)
+		}
+
+		c.declareVar(spec.Name, typ)
+	}
+}
+
+func (c *Checker) assignStmt(stmt *ast.AssignStmt) {
+	var lhs, rhs result
+
+	switch t := stmt.Lhs.(type) {
+	case *ast.Ident:
+		c.expr(&lhs, t)
+	case *ast.SubscriptExpr:
+		c.expr(&lhs, t)
+	default:
+		c.errorf(stmt.Lhs, "cannot assign to %T", stmt.Lhs)
+	}
+
+	c.expr(&rhs, stmt.Rhs)
+
+	if lhs.mode != invalid {
+		c.assign(&rhs, stmt.Rhs, lhs.typ)
+	}
+}
+
+func (c *Checker) returnStmt(stmt *ast.ReturnStmt) {
+	results := make([]result, len(stmt.Values))
+	for i, value := range stmt.Values {
+		c.expr(&results[i], value)
+	}
+
+	want := c.sig.returns
+	if len(results) != want.Len() {
+		c.errorf(stmt, "wrong number of return values: have %d, want %d", len(results), want.Len())
+		return
+	}
+
+	for i := range results {
+		c.assign(&results[i], stmt.Values[i], want.At(i))
+	}
+}
+
+// isTerminating reports whether control can never fall off the end of stmt.
+func isTerminating(stmt ast.Stmt) bool {
+	switch t := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		return len(t.List) > 0 && isTerminating(t.List[len(t.List)-1])
+	case *ast.IfStmt:
+		return t.Else != nil && isTerminating(t.Then) && isTerminating(t.Else)
+	}
+	return false
+}
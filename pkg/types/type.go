@@ -35,22 +35,35 @@ func (t *Basic) Hash() int {
 	}
 }
 
+// An Array is an array type. The element type is nil for the type of the
+// empty array literal {}, which is assignable to every array type.
 type Array struct {
 	elem Type
 }
 
+func NewArray(elem Type) *Array { return &Array{elem: elem} }
+
 func (t *Array) Elem() Type     { return t.elem }
 func (t *Array) String() string { return TypeString(t) }
 
 func (t *Array) Hash() int {
+	if t.elem == nil || t.elem.Hash() < 0 {
+		return -1
+	}
 	return 2 + t.elem.Hash()
 }
 
+// A Tuple is an ordered list of types. Tuples are only used for function
+// parameters and results; they are not first-class Xi types.
 type Tuple struct {
 	types []Type
 }
 
+func NewTuple(types ...Type) *Tuple { return &Tuple{types: types} }
+
 func (t *Tuple) Types() []Type  { return t.types }
+func (t *Tuple) Len() int       { return len(t.types) }
+func (t *Tuple) At(i int) Type  { return t.types[i] }
 func (t *Tuple) String() string { return TypeString(t) }
 
 func (t *Tuple) Hash() int { return -1 }
@@ -60,6 +73,16 @@ type Signature struct {
 	returns    *Tuple
 }
 
+func NewSignature(parameters, returns *Tuple) *Signature {
+	if parameters == nil {
+		parameters = NewTuple()
+	}
+	if returns == nil {
+		returns = NewTuple()
+	}
+	return &Signature{parameters: parameters, returns: returns}
+}
+
 func (s *Signature) Parameters() *Tuple {
 	return s.parameters
 }
@@ -96,6 +119,10 @@ func (w *typeWriter) typ(typ Type) {
 	case *Basic:
 		w.str(t.name)
 	case *Array:
+		if t.elem == nil {
+			w.str("{}")
+			return
+		}
 		w.typ(t.elem)
 		w.byte('[')
 		w.byte(']')
@@ -117,36 +144,85 @@ func (w *typeWriter) typ(typ Type) {
 	}
 }
 
+// TypeEqual reports whether t1 and t2 are identical types.
 func TypeEqual(t1, t2 Type) bool {
-	return t1.Hash() == t2.Hash()
-}
+	if t1 == t2 {
+		return true
+	}
 
-var arrayTypeCache = make(map[int]*Array)
+	switch x := t1.(type) {
+	case *Basic:
+		if y, ok := t2.(*Basic); ok {
+			return x.kind == y.kind
+		}
+	case *Array:
+		if y, ok := t2.(*Array); ok {
+			if x.elem == nil || y.elem == nil {
+				return x.elem == y.elem
+			}
+			return TypeEqual(x.elem, y.elem)
+		}
+	case *Tuple:
+		if y, ok := t2.(*Tuple); ok {
+			return tupleEqual(x, y)
+		}
+	case *Signature:
+		if y, ok := t2.(*Signature); ok {
+			return tupleEqual(x.parameters, y.parameters) && tupleEqual(x.returns, y.returns)
+		}
+	}
 
-func makeArrayType(basic *Basic, extraDimensions int) *Array {
-	var hash int
+	return false
+}
 
-	switch basic.kind {
-	case Bool:
-		hash = 2
-	case Int:
-		hash = 3
-	default:
-		panic("invalid type for array")
+func tupleEqual(x, y *Tuple) bool {
+	if len(x.types) != len(y.types) {
+		return false
 	}
+	for i := range x.types {
+		if !TypeEqual(x.types[i], y.types[i]) {
+			return false
+		}
+	}
+	return true
+}
 
-	hash += extraDimensions * 2
+// AssignableTo reports whether a value of type v may be assigned to a
+// variable of type t. This only differs from TypeEqual for array types
+// containing the type of the empty array literal.
+func AssignableTo(v, t Type) bool {
+	x, ok1 := v.(*Array)
+	y, ok2 := t.(*Array)
+	if !ok1 || !ok2 {
+		return v != nil && t != nil && TypeEqual(v, t)
+	}
 
-	if typ, ok := arrayTypeCache[hash]; ok {
-		return typ
+	if x.elem == nil {
+		return true
 	}
+	if y.elem == nil {
+		return false
+	}
+	return AssignableTo(x.elem, y.elem)
+}
 
-	typ := &Array{elem: basic}
+// join returns the most specific type both t1 and t2 are assignable to, if
+// there is one.
+func join(t1, t2 Type) (Type, bool) {
+	switch {
+	case AssignableTo(t1, t2):
+		return t2, true
+	case AssignableTo(t2, t1):
+		return t1, true
+	}
 
-	for i := 0; i < extraDimensions; i++ {
-		typ = &Array{elem: typ}
+	x, ok1 := t1.(*Array)
+	y, ok2 := t2.(*Array)
+	if ok1 && ok2 {
+		if elem, ok := join(x.elem, y.elem); ok {
+			return NewArray(elem), true
+		}
 	}
 
-	arrayTypeCache[hash] = typ
-	return typ
+	return nil, false
 }
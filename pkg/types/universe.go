@@ -5,31 +5,22 @@ import "github.com/manapointer/xi/pkg/token"
 var Universe *Scope
 
 var PredeclaredTyp = []*Basic{
-	Bool: {kind: Bool, name: "bool"},
-	Int:  {kind: Int, name: "int"},
+	Invalid: {kind: Invalid, name: "invalid type"},
+	Bool:    {kind: Bool, name: "bool"},
+	Int:     {kind: Int, name: "int"},
 }
 
-var PredeclaredFunc = [...]struct {
-	name string
-	sig  *Signature
-}{}
+// stringType is the type of string literals. Types are never modified
+// once built, so concurrent checks can share it.
+var stringType = NewArray(PredeclaredTyp[Int])
 
 func init() {
-	Universe = NewScope(nil)
+	Universe = NewScope(nil, token.NoPos, token.NoPos)
+	defPredeclaredTypes()
 }
 
 func defPredeclaredTypes() {
-	for _, typ := range PredeclaredTyp {
-		Universe.Insert(NewTypeName(token.Position{}, typ.name, typ))
+	for _, typ := range PredeclaredTyp[Bool:] {
+		Universe.Insert(NewTypeName(token.NoPos, typ.name, typ))
 	}
 }
-
-// func defPredeclaredFuncs() {
-// 	for _, f := range PredeclaredFunc {
-// 		Universe.Insert()
-// 	}
-// }
-
-// func newFunc(name string, sig *Signature) *Func {
-// 	return &Func{object{name, nil, token.Position{}, PredeclaredTyp[Bool]}}
-// }
@@ -0,0 +1,267 @@
+// The run-time system of Xi programs compiled to assembly by xi build.
+// It provides the program's entry point, memory allocation, run-time
+// errors and the io and conv modules of the standard library, behaving
+// like their implementations in the interpreter. Link a program with
+//
+//	gcc -o prog prog.s runtime/xi.c
+//
+// Arrays are blocks of 64-bit words holding the length followed by the
+// elements. An array is passed as a pointer to its first element, so that
+// the length is at index -1. Strings are arrays of Unicode code points.
+
+#include <signal.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+typedef int64_t word;
+
+void _Imain_paai(word *args);
+
+static void out_of_memory(void) {
+	fflush(stdout);
+	fputs("runtime error: out of memory\n", stderr);
+	exit(1);
+}
+
+void *_xi_alloc(word size) {
+	// Allocate at least one byte, so that calloc cannot return NULL for
+	// an empty block.
+	void *p = calloc(1, size > 0 ? (size_t)size : 1);
+	if (p == NULL)
+		out_of_memory();
+	return p;
+}
+
+void _xi_out_of_bounds(void) {
+	fflush(stdout);
+	fputs("runtime error: array index out of bounds\n", stderr);
+	exit(1);
+}
+
+// Division by zero traps, so it is reported by a signal handler.
+static void divide_by_zero(int sig) {
+	static const char msg[] = "runtime error: integer division by zero\n";
+	(void)sig;
+	fflush(stdout);
+	write(2, msg, sizeof msg - 1);
+	_exit(1);
+}
+
+static word *new_array(word n) {
+	word *a = _xi_alloc((n + 1) * (word)sizeof(word));
+	a[0] = n;
+	return a + 1;
+}
+
+#define RUNE_ERROR 0xFFFD
+
+// decode returns the code point at the start of the n > 0 bytes at s and
+// stores its length in *size. Invalid encodings decode to RUNE_ERROR with
+// length 1, as in Go.
+static word decode(const unsigned char *s, size_t n, size_t *size) {
+	unsigned char b = s[0];
+	unsigned char lo = 0x80, hi = 0xBF;
+	size_t len;
+	word r;
+
+	*size = 1;
+	if (b < 0x80)
+		return b;
+	if (0xC2 <= b && b <= 0xDF) {
+		len = 2;
+		r = b & 0x1F;
+	} else if (0xE0 <= b && b <= 0xEF) {
+		len = 3;
+		r = b & 0x0F;
+		if (b == 0xE0)
+			lo = 0xA0;
+		else if (b == 0xED)
+			hi = 0x9F;
+	} else if (0xF0 <= b && b <= 0xF4) {
+		len = 4;
+		r = b & 0x07;
+		if (b == 0xF0)
+			lo = 0x90;
+		else if (b == 0xF4)
+			hi = 0x8F;
+	} else {
+		return RUNE_ERROR;
+	}
+
+	if (n < len || s[1] < lo || s[1] > hi)
+		return RUNE_ERROR;
+	for (size_t i = 1; i < len; i++) {
+		if (i > 1 && (s[i] < 0x80 || s[i] > 0xBF))
+			return RUNE_ERROR;
+		r = r << 6 | (s[i] & 0x3F);
+	}
+	*size = len;
+	return r;
+}
+
+// string returns the Xi string of the n bytes at s.
+static word *string(const unsigned char *s, size_t n) {
+	size_t count = 0, size;
+	for (size_t i = 0; i < n; i += size) {
+		decode(s + i, n - i, &size);
+		count++;
+	}
+
+	word *a = new_array((word)count);
+	count = 0;
+	for (size_t i = 0; i < n; i += size)
+		a[count++] = decode(s + i, n - i, &size);
+	return a;
+}
+
+static void put_rune(word r) {
+	if (r < 0 || r > 0x10FFFF || (0xD800 <= r && r <= 0xDFFF))
+		r = RUNE_ERROR;
+	if (r < 0x80) {
+		putchar((int)r);
+	} else if (r < 0x800) {
+		putchar(0xC0 | (int)(r >> 6));
+		putchar(0x80 | (int)(r & 0x3F));
+	} else if (r < 0x10000) {
+		putchar(0xE0 | (int)(r >> 12));
+		putchar(0x80 | (int)(r >> 6 & 0x3F));
+		putchar(0x80 | (int)(r & 0x3F));
+	} else {
+		putchar(0xF0 | (int)(r >> 18));
+		putchar(0x80 | (int)(r >> 12 & 0x3F));
+		putchar(0x80 | (int)(r >> 6 & 0x3F));
+		putchar(0x80 | (int)(r & 0x3F));
+	}
+}
+
+// Standard input is buffered here rather than by stdio, because decoding
+// an invalid sequence may need to look ahead by more than one byte.
+static unsigned char in_buf[4096];
+static size_t in_pos, in_len;
+
+// available reads from standard input until at least want bytes are
+// buffered or the input ends, and returns the number of bytes buffered.
+static size_t available(size_t want) {
+	if (in_len - in_pos >= want)
+		return in_len - in_pos;
+
+	memmove(in_buf, in_buf + in_pos, in_len - in_pos);
+	in_len -= in_pos;
+	in_pos = 0;
+	while (in_len < want) {
+		ssize_t n = read(0, in_buf + in_len, sizeof in_buf - in_len);
+		if (n <= 0)
+			break;
+		in_len += (size_t)n;
+	}
+	return in_len;
+}
+
+void _Iprint_pai(word *s) {
+	for (word i = 0; i < s[-1]; i++)
+		put_rune(s[i]);
+}
+
+void _Iprintln_pai(word *s) {
+	_Iprint_pai(s);
+	putchar('\n');
+}
+
+word *_Ireadln_ai(void) {
+	unsigned char *line = NULL;
+	size_t len = 0, cap = 0;
+
+	fflush(stdout);
+	while (available(1) > 0) {
+		unsigned char c = in_buf[in_pos++];
+		if (c == '\n')
+			break;
+		if (len == cap) {
+			cap = cap ? 2 * cap : 64;
+			line = realloc(line, cap);
+			if (line == NULL)
+				out_of_memory();
+		}
+		line[len++] = c;
+	}
+	if (len > 0 && line[len - 1] == '\r')
+		len--;
+
+	word *s = string(line, len);
+	free(line);
+	return s;
+}
+
+word _Igetchar_i(void) {
+	size_t n, size;
+
+	fflush(stdout);
+	n = available(4);
+	if (n == 0)
+		return -1;
+	word r = decode(in_buf + in_pos, n, &size);
+	in_pos += size;
+	return r;
+}
+
+word _Ieof_b(void) {
+	fflush(stdout);
+	return available(1) == 0;
+}
+
+struct parse_result {
+	word n;
+	word ok;
+};
+
+struct parse_result _IparseInt_t2ibai(word *s) {
+	struct parse_result failed = {0, 0};
+	word len = s[-1], i = 0, n = 0;
+	int neg = 0;
+
+	if (len > 0 && s[0] == '-') {
+		neg = 1;
+		i++;
+	}
+	if (i == len)
+		return failed;
+
+	// Accumulate the negated value, which cannot overflow for the most
+	// negative integer.
+	for (; i < len; i++) {
+		if (s[i] < '0' || s[i] > '9')
+			return failed;
+		word d = s[i] - '0';
+		if (n < (INT64_MIN + d) / 10)
+			return failed;
+		n = n * 10 - d;
+	}
+	if (!neg) {
+		if (n == INT64_MIN)
+			return failed;
+		n = -n;
+	}
+
+	struct parse_result result = {n, 1};
+	return result;
+}
+
+word *_IunparseInt_aii(word n) {
+	char buf[24];
+	int len = snprintf(buf, sizeof buf, "%lld", (long long)n);
+	return string((const unsigned char *)buf, (size_t)len);
+}
+
+int main(int argc, char **argv) {
+	signal(SIGFPE, divide_by_zero);
+
+	word *args = new_array(argc - 1);
+	for (int i = 1; i < argc; i++)
+		args[i - 1] = (word)string((const unsigned char *)argv[i], strlen(argv[i]));
+
+	_Imain_paai(args);
+	return fflush(stdout) == 0 ? 0 : 1;
+}